@@ -1 +1,857 @@
-package torrentasaurus_rex
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"Torrentasaurus_Rex/internal/announce"
+	"Torrentasaurus_Rex/internal/choker"
+	"Torrentasaurus_Rex/internal/dht"
+	"Torrentasaurus_Rex/internal/exchange"
+	"Torrentasaurus_Rex/internal/extension"
+	"Torrentasaurus_Rex/internal/metadata"
+	"Torrentasaurus_Rex/internal/mse"
+	"Torrentasaurus_Rex/internal/peers"
+	"Torrentasaurus_Rex/internal/pex"
+	"Torrentasaurus_Rex/internal/resume"
+	"Torrentasaurus_Rex/internal/server"
+	"Torrentasaurus_Rex/internal/storage"
+	"Torrentasaurus_Rex/internal/torrent"
+	"Torrentasaurus_Rex/internal/tracker"
+	"Torrentasaurus_Rex/internal/utp"
+	"Torrentasaurus_Rex/internal/webseed"
+)
+
+// resumeInterval is how often download progress is saved to the resume file
+const resumeInterval = 30 * time.Second
+
+// clientVersion identifies us to peers in the extended handshake
+const clientVersion = "Torrentasaurus Rex"
+
+// dhtAnnounceInterval is how often a download is announced to the DHT. Nodes
+// forget announced peers after half an hour.
+const dhtAnnounceInterval = 15 * time.Minute
+
+// Exit codes that scripts can rely on
+const (
+	exitOK          = 0
+	exitFailure     = 1
+	exitUsage       = 2
+	exitMismatch    = 3
+	exitInterrupted = 130
+)
+
+const usage = `Usage: torrentasaurus-rex <command> [arguments]
+
+Commands:
+  download <file.torrent|magnet-uri> [-o <dir>] [-port <n>] [-slots <n>] [-seed]
+           [-dht=false] [-utp=false] [-bootstrap <host:port,...>]
+           [-encryption <policy>]
+                                       download the torrent into a directory
+  create <file|dir> [-o <file.torrent>] [-tracker <url,...>]...
+         [-webseed <url,...>] [-piece-length <bytes>] [-comment <text>]
+         [-private] [-source <text>] [-date=false]
+                                       hash a file or directory into a new
+                                       .torrent file; each -tracker adds a tier
+  info <file.torrent>                  print the torrent metadata
+  magnet <magnet-uri> [-o <file.torrent>] [-dht=false] [-utp=false]
+         [-bootstrap <host:port,...>] [-encryption <policy>]
+                                       fetch the metadata of a magnet link from
+                                       peers and save it as a .torrent file
+  verify <file.torrent> <dir>          check the data downloaded into a directory
+
+Peers are found through trackers and the mainline DHT, which listens on the
+same UDP port and remembers its routing table between runs.
+
+Peers are connected to over uTP on that UDP port, falling back to TCP, and
+encrypted according to the policy: disabled, preferred (the default,
+falling back to plaintext) or required.
+
+Exit codes:
+  0    success
+  1    runtime failure
+  2    invalid usage
+  3    verify found corrupt or missing pieces
+  130  interrupted
+`
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	code := run(ctx, os.Args[1:], os.Stdout, os.Stderr)
+	stop()
+	os.Exit(code)
+}
+
+// run dispatches a subcommand and returns the process exit code
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	if len(args) < 1 {
+		fmt.Fprint(stderr, usage)
+		return exitUsage
+	}
+
+	switch args[0] {
+	case "download":
+		return runDownload(ctx, args[1:], stdout, stderr)
+	case "create":
+		return runCreate(args[1:], stdout, stderr)
+	case "info":
+		return runInfo(args[1:], stdout, stderr)
+	case "magnet":
+		return runMagnet(ctx, args[1:], stdout, stderr)
+	case "verify":
+		return runVerify(args[1:], stdout, stderr)
+	case "help", "-h", "--help":
+		fmt.Fprint(stdout, usage)
+		return exitOK
+	default:
+		fmt.Fprintf(stderr, "unknown command %q\n\n%s", args[0], usage)
+		return exitUsage
+	}
+}
+
+// parseArgs parses flags that may appear before or after the positional
+// arguments and checks the number of positional arguments
+func parseArgs(fs *flag.FlagSet, args []string, want int) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	if len(positional) != want {
+		return nil, fmt.Errorf("expected %d arguments, got %d", want, len(positional))
+	}
+	return positional, nil
+}
+
+func runDownload(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("download", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	outDir := fs.String("o", ".", "directory to download into")
+	port := fs.Int("port", int(tracker.Port), "TCP port to accept peer connections on")
+	slots := fs.Int("slots", choker.DefaultSlots, "number of regular upload slots")
+	seed := fs.Bool("seed", false, "keep uploading after the download completes until interrupted")
+	useDHT := fs.Bool("dht", true, "find peers through the mainline DHT")
+	useUTP := fs.Bool("utp", true, "connect to peers over uTP before trying TCP")
+	bootstrap := fs.String("bootstrap", strings.Join(dht.DefaultBootstrapNodes, ","), "comma separated DHT nodes to join through")
+	encryption := fs.String("encryption", mse.Preferred.String(), "encryption policy: disabled, preferred or required")
+	positional, err := parseArgs(fs, args, 1)
+	if err != nil {
+		fmt.Fprintf(stderr, "download: %v\n", err)
+		return exitUsage
+	}
+	policy, err := mse.ParsePolicy(*encryption)
+	if err != nil {
+		fmt.Fprintf(stderr, "download: %v\n", err)
+		return exitUsage
+	}
+	if *port < 0 || *port > 65535 {
+		fmt.Fprintf(stderr, "download: invalid port %d\n", *port)
+		return exitUsage
+	}
+	if *slots < 0 {
+		fmt.Fprintf(stderr, "download: invalid number of upload slots %d\n", *slots)
+		return exitUsage
+	}
+
+	nw, stop := startNetwork(ctx, *port, *useDHT, *useUTP, *bootstrap)
+	defer stop()
+	nw.encryption = policy
+
+	tf, err := loadTorrent(ctx, positional[0], nw)
+	if err != nil {
+		fmt.Fprintf(stderr, "download: %v\n", err)
+		if errors.Is(err, context.Canceled) {
+			return exitInterrupted
+		}
+		return exitFailure
+	}
+
+	if err := download(ctx, &tf, *outDir, *port, *slots, *seed, nw); err != nil {
+		fmt.Fprintf(stderr, "download: %v\n", err)
+		if errors.Is(err, context.Canceled) {
+			return exitInterrupted
+		}
+		return exitFailure
+	}
+	fmt.Fprintf(stdout, "Downloaded %s to %s\n", tf.Name, *outDir)
+	return exitOK
+}
+
+// download asks the tracker, and the DHT when nw has a node and the torrent
+// is not private, for peers and downloads the torrent into outDir. Other
+// peers may download from us on port while it runs, and afterwards too when
+// seed is set. slots bounds the number of peers served at once.
+func download(ctx context.Context, tf *torrent.TorrentFile, outDir string, port, slots int, seed bool, nw *network) error {
+	peerID, err := peers.GeneratePeerID()
+	if err != nil {
+		return err
+	}
+	// Private torrents must not be announced to the DHT (BEP 27)
+	node := nw.node
+	if tf.Private {
+		node = nil
+	}
+
+	// The files are looked at before they are opened, which may resize them
+	resumePath := resume.Path(outDir, tf.InfoHash)
+	saved, err := resume.Load(resumePath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Printf("Ignoring resume file: %v", err)
+	}
+	files, err := resume.StatFiles(outDir, tf.Files)
+	if err != nil {
+		return err
+	}
+
+	out, err := storage.CreateFiles(outDir, tf.PieceLength, tf.Files)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	e := newExchange(tf)
+	e.PeerID = peerID
+	e.Output = out
+	e.Fast = true
+	e.Encryption = nw.encryption
+	e.Dial = nw.dial()
+	e.WebSeeds = webSeeds(tf)
+	addExtensions(e, tf)
+	if e.PEX != nil {
+		pexCtx, stopPEX := context.WithCancel(context.Background())
+		defer stopPEX()
+		go e.PEX.Run(pexCtx)
+	}
+
+	restored, err := e.Resume(saved, files)
+	if err != nil {
+		return err
+	}
+	if restored > 0 {
+		log.Printf("Resuming with %d of %d pieces", restored, tf.NumPieces())
+	}
+	checkpointCtx, stopCheckpoints := context.WithCancel(context.Background())
+	checkpointing := make(chan struct{})
+	go func() {
+		defer close(checkpointing)
+		for {
+			select {
+			case <-time.After(resumeInterval):
+				if err := saveResume(resumePath, e, out, outDir, tf); err != nil {
+					log.Printf("Failed to save resume file: %v", err)
+				}
+			case <-checkpointCtx.Done():
+				return
+			}
+		}
+	}()
+	defer func() {
+		stopCheckpoints()
+		<-checkpointing
+		if err := saveResume(resumePath, e, out, outDir, tf); err != nil {
+			log.Printf("Failed to save resume file: %v", err)
+		}
+	}()
+
+	req := tracker.NewAnnounceRequest(tf, peerID)
+	req.Port = uint16(port)
+	srv, err := server.Listen(fmt.Sprintf(":%d", port), peerID)
+	if err != nil {
+		log.Printf("Not accepting incoming peers: %v", err)
+	} else {
+		req.Port = uint16(srv.Port())
+		e.Extensions.Port = srv.Port()
+		srv.Extensions = true
+		srv.Fast = true
+		srv.V2 = tf.MetaVersion == 2
+		srv.Encryption = nw.encryption
+		if nw.sock != nil {
+			srv.AddListener(nw.sock)
+		}
+		// Peers of a hybrid torrent may come from either swarm
+		for _, infoHash := range tf.InfoHashes() {
+			srv.Register(infoHash, e)
+		}
+		e.Choker = choker.New(slots, func() bool {
+			_, _, left := e.Transferred()
+			return left == 0
+		})
+		serveCtx, stopServing := context.WithCancel(context.Background())
+		serving := make(chan struct{})
+		go func() {
+			defer close(serving)
+			go e.Choker.Run(serveCtx)
+			srv.Serve(serveCtx)
+		}()
+		defer func() {
+			stopServing()
+			<-serving
+		}()
+	}
+
+	trackers := announce.NewManager(announce.New(), tf.Tiers())
+	announcer := announce.NewAnnouncer(trackers, req, e)
+	first, err := announcer.Start(ctx)
+	switch {
+	case err == nil:
+		if first.Warning != "" {
+			log.Printf("Tracker warning: %s", first.Warning)
+		}
+		log.Printf("Tracker reports %d seeders and %d leechers", first.Seeders, first.Leechers)
+		e.AddPeers(first.Peers)
+	case ctx.Err() != nil || node == nil && len(e.WebSeeds) == 0:
+		return fmt.Errorf("failed to request peers: %w", err)
+	case !errors.Is(err, announce.ErrNoTrackers):
+		// The DHT or the web seeds may still provide the data, and the
+		// tracker is retried later
+		log.Printf("Failed to request peers: %v", err)
+	}
+
+	if !errors.Is(err, announce.ErrNoTrackers) {
+		announceCtx, stopAnnouncing := context.WithCancel(context.Background())
+		announcing := make(chan struct{})
+		go func() {
+			defer close(announcing)
+			announcer.Run(announceCtx, first, e.AddPeers)
+		}()
+		defer func() {
+			stopAnnouncing()
+			<-announcing
+		}()
+	}
+
+	if node != nil {
+		// The first lookup must finish before downloading, as the DHT may be
+		// the only source of peers
+		found, err := node.Announce(ctx, tf.InfoHash, int(req.Port))
+		if err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			log.Printf("Failed to look up peers in the DHT: %v", err)
+		} else {
+			log.Printf("DHT reports %d peers", len(found))
+			e.AddPeers(found)
+		}
+
+		dhtCtx, stopDHT := context.WithCancel(context.Background())
+		announcingDHT := make(chan struct{})
+		go func() {
+			defer close(announcingDHT)
+			announceDHT(dhtCtx, node, tf.InfoHash, int(req.Port), e.AddPeers)
+		}()
+		defer func() {
+			stopDHT()
+			<-announcingDHT
+		}()
+	}
+
+	if err := e.Download(ctx); err != nil {
+		return err
+	}
+	announcer.Completed()
+	if wasted := e.Wasted(); wasted > 0 {
+		log.Printf("Discarded %d bytes of duplicate blocks", wasted)
+	}
+	if err := out.Sync(); err != nil {
+		return err
+	}
+	if seed {
+		log.Printf("Seeding %s until interrupted", tf.Name)
+		<-ctx.Done()
+	}
+	return nil
+}
+
+// announceDHT periodically announces a torrent to the DHT and passes the
+// peers found to found until ctx is cancelled
+func announceDHT(ctx context.Context, node *dht.DHT, infoHash [20]byte, port int, found func([]peers.Peer)) {
+	for {
+		select {
+		case <-time.After(dhtAnnounceInterval):
+			list, err := node.Announce(ctx, infoHash, port)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("Failed to look up peers in the DHT: %v", err)
+				}
+				continue
+			}
+			found(list)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// network holds how peers are found and connected to
+type network struct {
+	// node is nil when the DHT is not used
+	node *dht.DHT
+	// sock carries uTP connections, and the DHT too when both are used. It
+	// is nil when uTP is not used.
+	sock       *utp.Socket
+	encryption mse.Policy
+}
+
+// dial returns how to connect to peers: over uTP first when it is used, and
+// over TCP otherwise
+func (nw *network) dial() mse.DialFunc {
+	if nw.sock == nil {
+		return nil
+	}
+	return nw.sock.DialPeer
+}
+
+// startNetwork opens the UDP port for uTP and the DHT as asked. The returned
+// function stops the DHT and closes the port.
+func startNetwork(ctx context.Context, port int, useDHT, useUTP bool, bootstrap string) (*network, func()) {
+	nw := &network{}
+	if useUTP {
+		sock, err := utp.Listen(fmt.Sprintf(":%d", port))
+		if err != nil {
+			log.Printf("Not using uTP: %v", err)
+		} else {
+			nw.sock = sock
+		}
+	}
+	stopDHT := func() {}
+	if useDHT {
+		nw.node, stopDHT = startDHT(ctx, port, nw.sock, bootstrap)
+	}
+	return nw, func() {
+		stopDHT()
+		if nw.sock != nil {
+			nw.sock.Close()
+		}
+	}
+}
+
+// startDHT runs a DHT node on the UDP port, or on the uTP socket when sock
+// is set, and joins the network through the nodes saved by the last run and
+// the comma separated bootstrap nodes. The returned function stops the node
+// and saves its routing table. A nil node is returned when the DHT is not
+// available.
+func startDHT(ctx context.Context, port int, sock *utp.Socket, bootstrap string) (*dht.DHT, func()) {
+	statePath := dhtStatePath()
+	var st *dht.State
+	if statePath != "" {
+		var err error
+		st, err = dht.LoadState(statePath)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("Ignoring DHT state: %v", err)
+		}
+	}
+	if st == nil {
+		id, err := dht.RandomID()
+		if err != nil {
+			log.Printf("Not using the DHT: %v", err)
+			return nil, func() {}
+		}
+		st = &dht.State{ID: id}
+	}
+
+	var node *dht.DHT
+	var err error
+	if sock != nil {
+		node, err = dht.New(sock.PacketConn(), st.ID)
+	} else {
+		node, err = dht.Listen(fmt.Sprintf(":%d", port), st.ID)
+	}
+	if err != nil {
+		log.Printf("Not using the DHT: %v", err)
+		return nil, func() {}
+	}
+	node.BootstrapNodes = splitList(bootstrap)
+	node.AddNodes(st.Nodes)
+
+	runCtx, stopRunning := context.WithCancel(context.Background())
+	running := make(chan struct{})
+	go func() {
+		defer close(running)
+		go node.Run(runCtx)
+		node.Serve(runCtx)
+	}()
+	if err := node.Bootstrap(ctx); err != nil {
+		log.Printf("%v", err)
+	}
+
+	return node, func() {
+		stopRunning()
+		<-running
+		if statePath == "" {
+			return
+		}
+		if err := dht.SaveState(statePath, node.State()); err != nil {
+			log.Printf("Failed to save DHT state: %v", err)
+		}
+	}
+}
+
+// dhtStatePath returns where the DHT routing table is kept between runs, or
+// an empty string when there is no cache directory
+func dhtStatePath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "torrentasaurus-rex", "dht.dat")
+}
+
+// saveResume records the pieces written so far. The files are synced before
+// they are looked at, so the recorded pieces are on disk when the saved
+// sizes and times still match on the next run.
+func saveResume(path string, e *exchange.Exchange, out *storage.Files, outDir string, tf *torrent.TorrentFile) error {
+	have := e.Have()
+	if err := out.Sync(); err != nil {
+		return err
+	}
+	files, err := resume.StatFiles(outDir, tf.Files)
+	if err != nil {
+		return err
+	}
+	return resume.Save(path, &resume.State{InfoHash: tf.InfoHash, Pieces: have, Files: files})
+}
+
+// loadTorrent opens a .torrent file, or fetches the metadata from peers when
+// given a magnet link
+func loadTorrent(ctx context.Context, arg string, nw *network) (torrent.TorrentFile, error) {
+	if !strings.HasPrefix(arg, "magnet:") {
+		return torrent.Open(arg)
+	}
+	m, err := torrent.ParseMagnet(arg)
+	if err != nil {
+		return torrent.TorrentFile{}, err
+	}
+	info, err := fetchMetadata(ctx, &m, nw)
+	if err != nil {
+		return torrent.TorrentFile{}, err
+	}
+	return m.TorrentFile(info)
+}
+
+// fetchMetadata downloads the info dictionary of a magnet link from the
+// peers it lists and those its trackers and the DHT know about
+func fetchMetadata(ctx context.Context, m *torrent.Magnet, nw *network) ([]byte, error) {
+	peerID, err := peers.GeneratePeerID()
+	if err != nil {
+		return nil, err
+	}
+
+	var found []peers.Peer
+	for _, addr := range m.Peers {
+		peer, err := parsePeer(addr)
+		if err != nil {
+			log.Printf("Ignoring peer %q: %v", addr, err)
+			continue
+		}
+		found = append(found, peer)
+	}
+	if len(m.Trackers) > 0 {
+		// The size of the torrent is unknown until the metadata arrives, but
+		// reporting nothing left would make us look like a seeder
+		req := tracker.AnnounceRequest{InfoHash: m.InfoHash, PeerID: peerID, Port: tracker.Port, Left: 1}
+		resp, err := announce.NewManager(announce.New(), m.Tiers()).Announce(ctx, req)
+		if err != nil {
+			if len(found) == 0 && nw.node == nil {
+				return nil, fmt.Errorf("failed to request peers: %w", err)
+			}
+			log.Printf("Failed to request peers: %v", err)
+		} else {
+			found = append(found, resp.Peers...)
+		}
+	}
+	if nw.node != nil {
+		list, err := nw.node.Peers(ctx, m.InfoHash)
+		if err != nil {
+			if len(found) == 0 {
+				return nil, fmt.Errorf("failed to look up peers in the DHT: %w", err)
+			}
+			log.Printf("Failed to look up peers in the DHT: %v", err)
+		} else {
+			found = append(found, list...)
+		}
+	}
+
+	log.Printf("Fetching metadata from %d peers", len(found))
+	return metadata.FetchFromPeers(ctx, found, m.InfoHash, peerID, nw.encryption, nw.dial())
+}
+
+// parsePeer parses the host:port address of a peer
+func parsePeer(addr string) (peers.Peer, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return peers.Peer{}, err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return peers.Peer{}, fmt.Errorf("invalid IP address %q", host)
+	}
+	n, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return peers.Peer{}, fmt.Errorf("invalid port %q", port)
+	}
+	return peers.Peer{IP: ip, Port: uint16(n)}, nil
+}
+
+func runMagnet(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("magnet", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	outPath := fs.String("o", "", "file to save the torrent to (default <name>.torrent)")
+	useDHT := fs.Bool("dht", true, "find peers through the mainline DHT")
+	useUTP := fs.Bool("utp", true, "connect to peers over uTP before trying TCP")
+	bootstrap := fs.String("bootstrap", strings.Join(dht.DefaultBootstrapNodes, ","), "comma separated DHT nodes to join through")
+	encryption := fs.String("encryption", mse.Preferred.String(), "encryption policy: disabled, preferred or required")
+	positional, err := parseArgs(fs, args, 1)
+	if err != nil {
+		fmt.Fprintf(stderr, "magnet: %v\n", err)
+		return exitUsage
+	}
+	policy, err := mse.ParsePolicy(*encryption)
+	if err != nil {
+		fmt.Fprintf(stderr, "magnet: %v\n", err)
+		return exitUsage
+	}
+
+	m, err := torrent.ParseMagnet(positional[0])
+	if err != nil {
+		fmt.Fprintf(stderr, "magnet: %v\n", err)
+		return exitUsage
+	}
+	nw, stop := startNetwork(ctx, int(tracker.Port), *useDHT, *useUTP, *bootstrap)
+	defer stop()
+	nw.encryption = policy
+	path := *outPath
+	info, err := fetchMetadata(ctx, &m, nw)
+	if err == nil {
+		path, err = saveMagnet(&m, info, path)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "magnet: %v\n", err)
+		if errors.Is(err, context.Canceled) {
+			return exitInterrupted
+		}
+		return exitFailure
+	}
+	fmt.Fprintf(stdout, "Saved %s\n", path)
+	return exitOK
+}
+
+// saveMagnet writes the torrent of a magnet link to path and returns the
+// path. The file is named after the torrent when path is empty.
+func saveMagnet(m *torrent.Magnet, info []byte, path string) (string, error) {
+	tf, err := m.TorrentFile(info)
+	if err != nil {
+		return "", err
+	}
+	data, err := m.MarshalTorrent(info)
+	if err != nil {
+		return "", err
+	}
+	if path == "" {
+		path = tf.Name + ".torrent"
+	}
+	return path, os.WriteFile(path, data, 0o644)
+}
+
+// tiersFlag collects a tier of comma separated tracker URLs per use of the
+// flag
+type tiersFlag [][]string
+
+func (f *tiersFlag) String() string {
+	return fmt.Sprint(*f)
+}
+
+func (f *tiersFlag) Set(value string) error {
+	*f = append(*f, splitList(value))
+	return nil
+}
+
+// splitList splits a comma separated list, dropping empty entries
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func runCreate(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("create", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	outPath := fs.String("o", "", "file to save the torrent to (default <name>.torrent)")
+	var tiers tiersFlag
+	fs.Var(&tiers, "tracker", "comma separated tracker URLs of a tier; repeat for more tiers")
+	webSeeds := fs.String("webseed", "", "comma separated URLs of HTTP mirrors")
+	pieceLength := fs.Int("piece-length", 0, "piece length in bytes, a power of two (default picked from the size)")
+	comment := fs.String("comment", "", "comment stored in the torrent")
+	private := fs.Bool("private", false, "only find peers through the trackers")
+	source := fs.String("source", "", "source tag, which changes the info hash")
+	date := fs.Bool("date", true, "record the creation date")
+	positional, err := parseArgs(fs, args, 1)
+	if err != nil {
+		fmt.Fprintf(stderr, "create: %v\n", err)
+		return exitUsage
+	}
+
+	opts := torrent.CreateOptions{
+		PieceLength:  *pieceLength,
+		AnnounceList: tiers,
+		WebSeeds:     splitList(*webSeeds),
+		Comment:      *comment,
+		CreatedBy:    clientVersion,
+		Private:      *private,
+		Source:       *source,
+	}
+	if *date {
+		opts.CreationDate = time.Now()
+	}
+	data, err := torrent.Create(positional[0], opts)
+	if err != nil {
+		fmt.Fprintf(stderr, "create: %v\n", err)
+		return exitFailure
+	}
+	path := *outPath
+	if path == "" {
+		path = filepath.Base(filepath.Clean(positional[0])) + ".torrent"
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		fmt.Fprintf(stderr, "create: %v\n", err)
+		return exitFailure
+	}
+	fmt.Fprintf(stdout, "Saved %s\n", path)
+	return exitOK
+}
+
+func runInfo(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("info", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	positional, err := parseArgs(fs, args, 1)
+	if err != nil {
+		fmt.Fprintf(stderr, "info: %v\n", err)
+		return exitUsage
+	}
+
+	tf, err := torrent.Open(positional[0])
+	if err != nil {
+		fmt.Fprintf(stderr, "info: %v\n", err)
+		return exitFailure
+	}
+
+	fmt.Fprintf(stdout, "Name:         %s\n", tf.Name)
+	fmt.Fprintf(stdout, "Size:         %d bytes\n", tf.Length)
+	fmt.Fprintf(stdout, "Pieces:       %d x %d bytes\n", tf.NumPieces(), tf.PieceLength)
+	fmt.Fprintf(stdout, "Info hash:    %x\n", tf.InfoHash)
+	if tf.MetaVersion == 2 {
+		fmt.Fprintf(stdout, "Info hash v2: %x\n", tf.InfoHashV2)
+	}
+	fmt.Fprintf(stdout, "Announce:     %s\n", tf.Announce)
+	for i, tier := range tf.AnnounceList {
+		fmt.Fprintf(stdout, "Tier %d:       %s\n", i+1, strings.Join(tier, ", "))
+	}
+	if len(tf.Files) > 1 {
+		fmt.Fprintf(stdout, "Files:\n")
+		for _, f := range tf.Files {
+			if f.Padding {
+				continue
+			}
+			fmt.Fprintf(stdout, "  %s (%d bytes)\n", filepath.Join(f.Path...), f.Length)
+		}
+	}
+	return exitOK
+}
+
+func runVerify(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	positional, err := parseArgs(fs, args, 2)
+	if err != nil {
+		fmt.Fprintf(stderr, "verify: %v\n", err)
+		return exitUsage
+	}
+
+	tf, err := torrent.Open(positional[0])
+	if err != nil {
+		fmt.Fprintf(stderr, "verify: %v\n", err)
+		return exitFailure
+	}
+
+	data, err := storage.OpenFiles(positional[1], tf.PieceLength, tf.Files)
+	if err != nil {
+		fmt.Fprintf(stderr, "verify: %v\n", err)
+		return exitFailure
+	}
+	defer data.Close()
+
+	bad, err := newExchange(&tf).Verify(data)
+	if err != nil {
+		fmt.Fprintf(stderr, "verify: %v\n", err)
+		return exitFailure
+	}
+	if len(bad) > 0 {
+		fmt.Fprintf(stdout, "%d of %d pieces failed verification\n", len(bad), tf.NumPieces())
+		return exitMismatch
+	}
+	fmt.Fprintf(stdout, "All %d pieces verified\n", tf.NumPieces())
+	return exitOK
+}
+
+// webSeeds returns the mirrors of a torrent, skipping those we can't use
+func webSeeds(tf *torrent.TorrentFile) []*webseed.Seed {
+	var seeds []*webseed.Seed
+	for _, u := range tf.WebSeeds {
+		if strings.HasPrefix(strings.ToLower(u), "ftp://") {
+			log.Printf("Skipping FTP web seed %s: only HTTP mirrors are supported", u)
+			continue
+		}
+		seed, err := webseed.New(u, tf.Files)
+		if err != nil {
+			log.Printf("Ignoring web seed: %v", err)
+			continue
+		}
+		seeds = append(seeds, seed)
+	}
+	return seeds
+}
+
+// newExchange fills an exchange with the metadata of a torrent
+func newExchange(tf *torrent.TorrentFile) *exchange.Exchange {
+	return &exchange.Exchange{
+		InfoHash:    tf.InfoHash,
+		PieceHashes: tf.PieceHashes,
+		PieceLength: tf.PieceLength,
+		Length:      tf.Length,
+		Name:        tf.Name,
+		PiecesV2:    tf.PiecesV2,
+	}
+}
+
+// addExtensions sets up the extension protocol of an exchange. Peer exchange
+// is left out for private torrents (BEP 27).
+func addExtensions(e *exchange.Exchange, tf *torrent.TorrentFile) {
+	e.Extensions = extension.NewRegistry()
+	e.Extensions.Version = clientVersion
+	if !tf.Private {
+		e.PEX = pex.New(e.AddPeers)
+		e.Extensions.Register(pex.Name, e.PEX)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"Torrentasaurus_Rex/internal/pex"
+	"Torrentasaurus_Rex/internal/torrent"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testTorrent = "../../internal/torrent/testdata/ubuntu-24.04-desktop-amd64.iso.torrent"
+
+func runCommand(args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(context.Background(), args, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestRunUsage(t *testing.T) {
+	code, _, stderr := runCommand()
+	assert.Equal(t, exitUsage, code)
+	assert.Contains(t, stderr, "Usage:")
+
+	code, _, stderr = runCommand("frobnicate")
+	assert.Equal(t, exitUsage, code)
+	assert.Contains(t, stderr, `unknown command "frobnicate"`)
+
+	code, _, _ = runCommand("verify", testTorrent)
+	assert.Equal(t, exitUsage, code)
+}
+
+func TestRunInfo(t *testing.T) {
+	code, stdout, _ := runCommand("info", testTorrent)
+	require.Equal(t, exitOK, code)
+	assert.Contains(t, stdout, "ubuntu-24.04-desktop-amd64.iso")
+	assert.Contains(t, stdout, "6114656256 bytes")
+	assert.Contains(t, stdout, "23326 x 262144 bytes")
+	assert.Contains(t, stdout, "https://torrent.ubuntu.com/announce")
+}
+
+func TestRunInfoMissingFile(t *testing.T) {
+	code, _, stderr := runCommand("info", "does-not-exist.torrent")
+	assert.Equal(t, exitFailure, code)
+	assert.Contains(t, stderr, "info:")
+}
+
+func TestRunVerifyMismatch(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "ubuntu-24.04-desktop-amd64.iso")
+	require.NoError(t, os.WriteFile(path, []byte("not the ubuntu image"), 0o644))
+
+	code, stdout, _ := runCommand("verify", testTorrent, dir)
+	assert.Equal(t, exitMismatch, code)
+	assert.Contains(t, stdout, "23326 of 23326 pieces failed verification")
+}
+
+func TestRunDownloadMissingFlagValue(t *testing.T) {
+	code, _, stderr := runCommand("download", "-o")
+	assert.Equal(t, exitUsage, code)
+	assert.Contains(t, stderr, "flag needs an argument")
+}
+
+func TestRunMagnetInvalidURI(t *testing.T) {
+	code, _, stderr := runCommand("magnet", "magnet:?dn=nothing")
+	assert.Equal(t, exitUsage, code)
+	assert.Contains(t, stderr, "magnet:")
+}
+
+func TestParsePeer(t *testing.T) {
+	peer, err := parsePeer("10.0.0.1:6881")
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.1:6881", peer.String())
+
+	peer, err = parsePeer("[::1]:51413")
+	require.NoError(t, err)
+	assert.Equal(t, "[::1]:51413", peer.String())
+
+	_, err = parsePeer("example.com:6881")
+	assert.Error(t, err)
+	_, err = parsePeer("10.0.0.1:70000")
+	assert.Error(t, err)
+}
+
+func TestRunCreate(t *testing.T) {
+	dir := t.TempDir()
+	data := filepath.Join(dir, "notes")
+	require.NoError(t, os.MkdirAll(data, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(data, "a.txt"), []byte("first"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(data, "b.txt"), []byte("second"), 0o644))
+	out := filepath.Join(dir, "notes.torrent")
+
+	code, stdout, stderr := runCommand("create", data, "-o", out,
+		"-tracker", "http://a.example/announce,http://b.example/announce", "-tracker", "udp://c.example:6969",
+		"-webseed", "http://mirror.example/", "-private")
+	require.Equal(t, exitOK, code, stderr)
+	assert.Contains(t, stdout, "Saved "+out)
+
+	code, stdout, _ = runCommand("info", out)
+	require.Equal(t, exitOK, code)
+	assert.Contains(t, stdout, "11 bytes")
+	assert.Contains(t, stdout, "Tier 1:       http://a.example/announce, http://b.example/announce")
+	assert.Contains(t, stdout, "Tier 2:       udp://c.example:6969")
+
+	code, stdout, _ = runCommand("verify", out, dir)
+	assert.Equal(t, exitOK, code)
+	assert.Contains(t, stdout, "All 1 pieces verified")
+
+	code, _, stderr = runCommand("create", data, "-o", out, "-piece-length", "1000")
+	assert.Equal(t, exitFailure, code)
+	assert.Contains(t, stderr, "invalid piece length")
+}
+
+func TestAddExtensionsPrivate(t *testing.T) {
+	tf := &torrent.TorrentFile{}
+	e := newExchange(tf)
+	addExtensions(e, tf)
+	assert.NotNil(t, e.PEX)
+	assert.Contains(t, e.Extensions.Handshake().M, pex.Name)
+
+	tf.Private = true
+	e = newExchange(tf)
+	addExtensions(e, tf)
+	assert.Nil(t, e.PEX)
+	assert.NotContains(t, e.Extensions.Handshake().M, pex.Name)
+}
+
+func TestRunDownloadFromWebSeedOnly(t *testing.T) {
+	dir := t.TempDir()
+	data := filepath.Join(dir, "notes.txt")
+	require.NoError(t, os.WriteFile(data, []byte("served by the mirror"), 0o644))
+	mirror := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	defer mirror.Close()
+
+	out := filepath.Join(dir, "notes.torrent")
+	code, _, stderr := runCommand("create", data, "-o", out,
+		"-tracker", "http://127.0.0.1:1/announce", "-webseed", mirror.URL+"/")
+	require.Equal(t, exitOK, code, stderr)
+
+	// The tracker refuses connections and there is no DHT
+	downloads := filepath.Join(dir, "downloads")
+	code, stdout, stderr := runCommand("download", out, "-o", downloads, "-port", "0", "-dht=false", "-utp=false")
+	require.Equal(t, exitOK, code, stderr)
+	assert.Contains(t, stdout, "Downloaded notes.txt")
+	got, err := os.ReadFile(filepath.Join(downloads, "notes.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "served by the mirror", string(got))
+}
@@ -0,0 +1,105 @@
+package announce
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	"Torrentasaurus_Rex/internal/peers"
+	"Torrentasaurus_Rex/internal/tracker"
+)
+
+// Response is the reply of a tracker to an announce, whatever its transport
+type Response struct {
+	Peers []peers.Peer
+	// Interval is the time the tracker wants between regular announces
+	Interval time.Duration
+	// MinInterval is the shortest time allowed between announces, if given
+	MinInterval time.Duration
+	// Seeders and Leechers count the peers with complete and incomplete data
+	Seeders  int
+	Leechers int
+	// TrackerID must be sent back to the tracker on later announces
+	TrackerID string
+	// Warning is a message the tracker attached to a successful announce
+	Warning string
+}
+
+// Client announces to trackers over HTTP(S) or UDP, picking the transport
+// from the scheme of the announce URL
+type Client struct {
+	udp *tracker.UDPClient
+}
+
+// The BEP 15 backoff would keep retrying a UDP tracker for about an hour,
+// while a Manager is better off failing over to the next tracker. The
+// timeouts are shortened so the exchange and one retransmission fit in
+// DefaultTrackerTimeout.
+const (
+	udpBaseTimeout = 5 * time.Second
+	maxUDPRetries  = 1
+)
+
+// New creates an announce client
+func New() *Client {
+	udp := tracker.NewUDPClient()
+	udp.BaseTimeout = udpBaseTimeout
+	udp.MaxRetries = maxUDPRetries
+	return &Client{udp: udp}
+}
+
+// Announce sends an announce to a single tracker. A tracker that rejects the
+// announce yields a *tracker.FailureError.
+func (c *Client) Announce(ctx context.Context, announceURL string, req tracker.AnnounceRequest) (*Response, error) {
+	u, err := url.Parse(announceURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse announce URL: %w", err)
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+		trackerURL, err := tracker.BuildAnnounceURL(announceURL, req)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := tracker.FetchHTTP(ctx, trackerURL)
+		if err != nil {
+			return nil, err
+		}
+		found, err := peers.FromTrackerResponse(resp.Peers, []byte(resp.Peers6))
+		if err != nil {
+			return nil, err
+		}
+		return &Response{
+			Peers:       found,
+			Interval:    time.Duration(resp.Interval) * time.Second,
+			MinInterval: time.Duration(resp.MinInterval) * time.Second,
+			Seeders:     resp.Complete,
+			Leechers:    resp.Incomplete,
+			TrackerID:   resp.TrackerID,
+			Warning:     resp.WarningMessage,
+		}, nil
+	case "udp":
+		resp, err := c.udp.Announce(ctx, announceURL, req)
+		if err != nil {
+			return nil, err
+		}
+		unmarshal := peers.Unmarshal
+		if resp.IPv6 {
+			unmarshal = peers.Unmarshal6
+		}
+		found, err := unmarshal(resp.Peers)
+		if err != nil {
+			return nil, err
+		}
+		return &Response{
+			Peers:    found,
+			Interval: time.Duration(resp.Interval) * time.Second,
+			Seeders:  resp.Seeders,
+			Leechers: resp.Leechers,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported tracker scheme %q", u.Scheme)
+	}
+}
@@ -0,0 +1,114 @@
+package announce
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"Torrentasaurus_Rex/internal/peers"
+	"Torrentasaurus_Rex/internal/tracker"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testRequest = tracker.AnnounceRequest{
+	InfoHash: [20]byte{1, 2, 3},
+	PeerID:   [20]byte{4, 5, 6},
+	Port:     6881,
+	Left:     100,
+}
+
+func TestAnnounceHTTP(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "100", r.URL.Query().Get("left"))
+		assert.Equal(t, "started", r.URL.Query().Get("event"))
+		w.Write([]byte("d8:completei5e10:incompletei2e8:intervali900e12:min intervali60e" +
+			"5:peers6:\x0a\x00\x00\x01\x1a\xe110:tracker id3:abc15:warning message4:slowe"))
+	}))
+	defer server.Close()
+
+	req := testRequest
+	req.Event = tracker.EventStarted
+	got, err := New().Announce(context.Background(), server.URL+"/announce", req)
+	require.NoError(t, err)
+	assert.Equal(t, &Response{
+		Peers:       []peers.Peer{{IP: net.IP{10, 0, 0, 1}, Port: 6881}},
+		Interval:    900 * time.Second,
+		MinInterval: time.Minute,
+		Seeders:     5,
+		Leechers:    2,
+		TrackerID:   "abc",
+		Warning:     "slow",
+	}, got)
+}
+
+// startUDPTracker runs a UDP tracker that ignores the first drop packets
+// and answers every announce with a single peer
+func startUDPTracker(t *testing.T, drop int) string {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if drop > 0 {
+				drop--
+				continue
+			}
+			resp := make([]byte, 16)
+			copy(resp[0:8], buf[8:16]) // action and transaction ID
+			if binary.BigEndian.Uint32(buf[8:12]) == 1 && n == 98 {
+				resp = append(resp[:8], 0, 0, 7, 8, 0, 0, 0, 0, 0, 0, 0, 1, 192, 168, 1, 2, 0x1A, 0xE2)
+			}
+			conn.WriteToUDP(resp, addr)
+		}
+	}()
+	return "udp://" + conn.LocalAddr().String()
+}
+
+func TestAnnounceUDP(t *testing.T) {
+	got, err := New().Announce(context.Background(), startUDPTracker(t, 0), testRequest)
+	require.NoError(t, err)
+	assert.Equal(t, []peers.Peer{{IP: net.IP{192, 168, 1, 2}, Port: 6882}}, got.Peers)
+	assert.Equal(t, 0x708*time.Second, got.Interval)
+	assert.Equal(t, 1, got.Seeders)
+}
+
+func TestManagerRetransmitsToUDPTracker(t *testing.T) {
+	// The default timeouts, scaled down, must leave room for a retransmission
+	client := New()
+	client.udp.BaseTimeout = udpBaseTimeout / 100
+	m := NewManager(client, [][]string{{startUDPTracker(t, 1)}})
+	m.TrackerTimeout = DefaultTrackerTimeout / 100
+
+	got, err := m.Announce(context.Background(), testRequest)
+	require.NoError(t, err)
+	assert.Equal(t, []peers.Peer{{IP: net.IP{192, 168, 1, 2}, Port: 6882}}, got.Peers)
+}
+
+func TestAnnounceHTTPFailureReason(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("d14:failure reason17:unregistered hashe"))
+	}))
+	defer server.Close()
+
+	_, err := New().Announce(context.Background(), server.URL, testRequest)
+	var failure *tracker.FailureError
+	require.ErrorAs(t, err, &failure)
+	assert.Equal(t, "unregistered hash", failure.Reason)
+}
+
+func TestAnnounceUnsupportedScheme(t *testing.T) {
+	_, err := New().Announce(context.Background(), "wss://tracker.example.com", testRequest)
+	assert.ErrorContains(t, err, "unsupported tracker scheme")
+}
@@ -0,0 +1,210 @@
+package announce
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"Torrentasaurus_Rex/internal/peers"
+	"Torrentasaurus_Rex/internal/tracker"
+)
+
+const (
+	// defaultInterval is used when a tracker doesn't send an interval
+	defaultInterval = 30 * time.Minute
+	// minRetryDelay is the first delay after a failed announce; it doubles
+	// with every consecutive failure up to maxRetryDelay
+	minRetryDelay = time.Minute
+	maxRetryDelay = 30 * time.Minute
+	// stopTimeout bounds the stopped announce sent on shutdown
+	stopTimeout = 5 * time.Second
+)
+
+// fired is a timer channel that has already fired
+var fired = func() <-chan time.Time {
+	c := make(chan time.Time)
+	close(c)
+	return c
+}()
+
+// Transfer reports the transfer counters of a download session
+type Transfer interface {
+	Transferred() (uploaded, downloaded, left int64)
+}
+
+// tierAnnouncer sends an announce to the trackers of a torrent
+type tierAnnouncer interface {
+	Announce(ctx context.Context, req tracker.AnnounceRequest) (*Response, error)
+}
+
+// Announcer keeps a torrent announced for the lifetime of a session. It
+// sends the started event, re-announces on the tracker interval with the
+// current transfer counters, reports completion and sends the stopped event
+// on shutdown.
+type Announcer struct {
+	trackers  tierAnnouncer
+	base      tracker.AnnounceRequest
+	transfer  Transfer
+	completed chan struct{}
+	after     func(time.Duration) <-chan time.Time
+
+	mu       sync.Mutex
+	seeders  int
+	leechers int
+	lastErr  error
+}
+
+// NewAnnouncer creates an announcer. The base request provides the info
+// hash, peer ID and port; the counters and event are filled in on every
+// announce. A random key is generated when the base request has none.
+func NewAnnouncer(trackers tierAnnouncer, base tracker.AnnounceRequest, transfer Transfer) *Announcer {
+	if base.Key == 0 {
+		var buf [4]byte
+		rand.Read(buf[:])
+		base.Key = binary.BigEndian.Uint32(buf[:])
+	}
+	return &Announcer{
+		trackers:  trackers,
+		base:      base,
+		transfer:  transfer,
+		completed: make(chan struct{}, 1),
+		after:     time.After,
+	}
+}
+
+// Start sends the started event and returns the tracker response
+func (a *Announcer) Start(ctx context.Context) (*Response, error) {
+	return a.announce(ctx, tracker.EventStarted)
+}
+
+// Run re-announces until ctx is cancelled and then sends the stopped event.
+// It starts from the response to Start, nil if Start failed, and passes the
+// peers of every later announce to found. The started event is sent again
+// until a tracker acknowledges it.
+func (a *Announcer) Run(ctx context.Context, first *Response, found func([]peers.Peer)) {
+	resp, failures := first, 0
+	startedPending, completedPending := first == nil, false
+	for {
+		var wait <-chan time.Time
+		if completedPending && !startedPending && failures == 0 {
+			// A completion reported before started was acknowledged goes
+			// out right after it
+			wait = fired
+		} else {
+			wait = a.after(nextDelay(resp, failures))
+		}
+		select {
+		case <-ctx.Done():
+			a.stop(!startedPending, completedPending)
+			return
+		case <-a.completed:
+			completedPending = true
+		case <-wait:
+		}
+
+		event := tracker.EventNone
+		switch {
+		case startedPending:
+			event = tracker.EventStarted
+		case completedPending:
+			event = tracker.EventCompleted
+		}
+
+		var err error
+		resp, err = a.announce(ctx, event)
+		if err != nil {
+			failures++
+			resp = nil
+			continue
+		}
+		failures = 0
+		switch event {
+		case tracker.EventStarted:
+			startedPending = false
+		case tracker.EventCompleted:
+			completedPending = false
+		}
+		if found != nil && len(resp.Peers) > 0 {
+			found(resp.Peers)
+		}
+	}
+}
+
+// Completed tells the announcer that the download finished, so the
+// completed event is sent right away
+func (a *Announcer) Completed() {
+	select {
+	case a.completed <- struct{}{}:
+	default:
+	}
+}
+
+// Swarm returns the number of seeders and leechers from the last successful announce
+func (a *Announcer) Swarm() (seeders, leechers int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.seeders, a.leechers
+}
+
+// LastError returns the error of the last announce, or nil if it succeeded
+func (a *Announcer) LastError() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.lastErr
+}
+
+// stop sends the stopped event with a fresh, short-lived context. A
+// completion not yet acknowledged, or reported just before shutdown, is
+// announced first. Trackers that never acknowledged the started event are
+// told nothing.
+func (a *Announcer) stop(started, completed bool) {
+	if !started {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), stopTimeout)
+	defer cancel()
+	select {
+	case <-a.completed:
+		completed = true
+	default:
+	}
+	if completed {
+		a.announce(ctx, tracker.EventCompleted)
+	}
+	a.announce(ctx, tracker.EventStopped)
+}
+
+// announce sends a single announce with the current transfer counters
+func (a *Announcer) announce(ctx context.Context, event tracker.Event) (*Response, error) {
+	req := a.base
+	req.Event = event
+	if a.transfer != nil {
+		req.Uploaded, req.Downloaded, req.Left = a.transfer.Transferred()
+	}
+
+	resp, err := a.trackers.Announce(ctx, req)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.lastErr = err
+	if err != nil {
+		return nil, err
+	}
+	a.seeders, a.leechers = resp.Seeders, resp.Leechers
+	return resp, nil
+}
+
+// nextDelay returns the time to wait before the next regular announce
+func nextDelay(resp *Response, failures int) time.Duration {
+	if resp == nil {
+		delay := minRetryDelay << min(failures, 5)
+		return min(delay, maxRetryDelay)
+	}
+	delay := resp.Interval
+	if delay <= 0 {
+		delay = defaultInterval
+	}
+	return max(delay, resp.MinInterval)
+}
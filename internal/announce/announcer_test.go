@@ -0,0 +1,269 @@
+package announce
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"Torrentasaurus_Rex/internal/peers"
+	"Torrentasaurus_Rex/internal/tracker"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// scriptedTrackers answers announces with a scripted list of results
+type scriptedTrackers struct {
+	mu       sync.Mutex
+	requests []tracker.AnnounceRequest
+	results  []error
+	resp     Response
+}
+
+func (s *scriptedTrackers) Announce(_ context.Context, req tracker.AnnounceRequest) (*Response, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, req)
+	if len(s.results) > 0 {
+		err := s.results[0]
+		s.results = s.results[1:]
+		if err != nil {
+			return nil, err
+		}
+	}
+	resp := s.resp
+	return &resp, nil
+}
+
+func (s *scriptedTrackers) events() []tracker.Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var events []tracker.Event
+	for _, req := range s.requests {
+		events = append(events, req.Event)
+	}
+	return events
+}
+
+// fakeTransfer reports fixed transfer counters
+type fakeTransfer struct{ up, down, left int64 }
+
+func (f fakeTransfer) Transferred() (int64, int64, int64) { return f.up, f.down, f.left }
+
+// manualClock replaces time.After and records the requested delays
+type manualClock struct {
+	mu     sync.Mutex
+	delays []time.Duration
+	fire   chan time.Time
+}
+
+func newManualClock() *manualClock {
+	return &manualClock{fire: make(chan time.Time)}
+}
+
+func (c *manualClock) after(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.delays = append(c.delays, d)
+	return c.fire
+}
+
+func (c *manualClock) recorded() []time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]time.Duration(nil), c.delays...)
+}
+
+func TestAnnouncerLifecycle(t *testing.T) {
+	trackers := &scriptedTrackers{resp: Response{
+		Interval:    10 * time.Minute,
+		MinInterval: 15 * time.Minute,
+		Seeders:     4,
+		Leechers:    9,
+		Peers:       []peers.Peer{peer(1)},
+	}}
+	clock := newManualClock()
+	base := tracker.AnnounceRequest{InfoHash: [20]byte{1}, Port: 6881, Key: 42}
+	a := NewAnnouncer(trackers, base, fakeTransfer{up: 10, down: 20, left: 30})
+	a.after = clock.after
+
+	first, err := a.Start(context.Background())
+	require.NoError(t, err)
+	seeders, leechers := a.Swarm()
+	assert.Equal(t, 4, seeders)
+	assert.Equal(t, 9, leechers)
+
+	var found [][]peers.Peer
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		a.Run(ctx, first, func(p []peers.Peer) { found = append(found, p) })
+	}()
+
+	clock.fire <- time.Now() // regular announce
+	a.Completed()
+	require.Eventually(t, func() bool { return len(trackers.events()) == 3 }, time.Second, time.Millisecond)
+	cancel()
+	<-done
+
+	assert.Equal(t, []tracker.Event{
+		tracker.EventStarted, tracker.EventNone, tracker.EventCompleted, tracker.EventStopped,
+	}, trackers.events())
+	// The min interval wins over a shorter interval
+	assert.Equal(t, 15*time.Minute, clock.recorded()[0])
+	assert.Len(t, found, 2)
+
+	req := trackers.requests[1]
+	assert.Equal(t, int64(10), req.Uploaded)
+	assert.Equal(t, int64(20), req.Downloaded)
+	assert.Equal(t, int64(30), req.Left)
+	assert.Equal(t, uint32(42), req.Key)
+}
+
+func TestAnnouncerRetriesFailures(t *testing.T) {
+	failure := &tracker.FailureError{Reason: "overloaded"}
+	trackers := &scriptedTrackers{results: []error{nil, failure, errors.New("timeout")}}
+	clock := newManualClock()
+	a := NewAnnouncer(trackers, tracker.AnnounceRequest{}, nil)
+	a.after = clock.after
+
+	first, err := a.Start(context.Background())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		a.Run(ctx, first, nil)
+	}()
+
+	clock.fire <- time.Now()
+	require.Eventually(t, func() bool {
+		var f *tracker.FailureError
+		return errors.As(a.LastError(), &f)
+	}, time.Second, time.Millisecond)
+	clock.fire <- time.Now()
+	clock.fire <- time.Now()
+	cancel()
+	<-done
+
+	assert.Equal(t, []time.Duration{defaultInterval, 2 * minRetryDelay, 4 * minRetryDelay, defaultInterval}, clock.recorded())
+	assert.NotZero(t, a.base.Key)
+}
+
+func TestAnnouncerCompletedBeforeStop(t *testing.T) {
+	trackers := &scriptedTrackers{}
+	a := NewAnnouncer(trackers, tracker.AnnounceRequest{}, nil)
+	a.after = newManualClock().after
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	a.Completed()
+	a.Run(ctx, &Response{}, nil)
+
+	assert.Equal(t, []tracker.Event{tracker.EventCompleted, tracker.EventStopped}, trackers.events())
+}
+
+func TestAnnouncerRetriesStarted(t *testing.T) {
+	trackers := &scriptedTrackers{results: []error{errors.New("timeout"), errors.New("timeout")}}
+	clock := newManualClock()
+	a := NewAnnouncer(trackers, tracker.AnnounceRequest{}, nil)
+	a.after = clock.after
+
+	first, err := a.Start(context.Background())
+	require.Error(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		a.Run(ctx, first, nil)
+	}()
+
+	clock.fire <- time.Now() // started fails again
+	clock.fire <- time.Now() // started succeeds
+	clock.fire <- time.Now() // regular announce
+	cancel()
+	<-done
+
+	assert.Equal(t, []tracker.Event{
+		tracker.EventStarted, tracker.EventStarted, tracker.EventStarted,
+		tracker.EventNone, tracker.EventStopped,
+	}, trackers.events())
+}
+
+func TestAnnouncerCompletedRightAfterStarted(t *testing.T) {
+	trackers := &scriptedTrackers{results: []error{errors.New("timeout")}}
+	clock := newManualClock()
+	a := NewAnnouncer(trackers, tracker.AnnounceRequest{}, nil)
+	a.after = clock.after
+
+	first, err := a.Start(context.Background())
+	require.Error(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		a.Run(ctx, first, nil)
+	}()
+
+	// The completion goes out without waiting for another interval
+	a.Completed()
+	require.Eventually(t, func() bool { return len(trackers.events()) == 3 }, time.Second, time.Millisecond)
+	cancel()
+	<-done
+
+	assert.Equal(t, []tracker.Event{
+		tracker.EventStarted, tracker.EventStarted, tracker.EventCompleted, tracker.EventStopped,
+	}, trackers.events())
+	assert.Len(t, clock.recorded(), 2, "no timer between started and completed")
+}
+
+func TestAnnouncerCompletedPendingAtStop(t *testing.T) {
+	trackers := &scriptedTrackers{results: []error{nil, errors.New("timeout")}}
+	clock := newManualClock()
+	a := NewAnnouncer(trackers, tracker.AnnounceRequest{}, nil)
+	a.after = clock.after
+
+	first, err := a.Start(context.Background())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		a.Run(ctx, first, nil)
+	}()
+
+	a.Completed()
+	require.Eventually(t, func() bool { return a.LastError() != nil }, time.Second, time.Millisecond)
+	cancel()
+	<-done
+
+	assert.Equal(t, []tracker.Event{
+		tracker.EventStarted, tracker.EventCompleted, tracker.EventCompleted, tracker.EventStopped,
+	}, trackers.events())
+}
+
+func TestAnnouncerNotStartedSendsNoStopped(t *testing.T) {
+	trackers := &scriptedTrackers{}
+	a := NewAnnouncer(trackers, tracker.AnnounceRequest{}, nil)
+	a.after = newManualClock().after
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	a.Run(ctx, nil, nil)
+
+	assert.Empty(t, trackers.events())
+}
+
+func TestNextDelay(t *testing.T) {
+	assert.Equal(t, defaultInterval, nextDelay(&Response{}, 0))
+	assert.Equal(t, 5*time.Minute, nextDelay(&Response{Interval: 5 * time.Minute}, 0))
+	assert.Equal(t, minRetryDelay, nextDelay(nil, 0))
+	assert.Equal(t, maxRetryDelay, nextDelay(nil, 10))
+}
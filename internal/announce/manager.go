@@ -0,0 +1,154 @@
+package announce
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"Torrentasaurus_Rex/internal/tracker"
+)
+
+// announcer sends an announce to a single tracker
+type announcer interface {
+	Announce(ctx context.Context, announceURL string, req tracker.AnnounceRequest) (*Response, error)
+}
+
+// ErrNoTrackers is returned when a torrent has no tracker to announce to
+var ErrNoTrackers = errors.New("no trackers to announce to")
+
+// DefaultTrackerTimeout bounds a single announce made by a Manager, so an
+// unresponsive tracker doesn't hold up failover to the next one
+const DefaultTrackerTimeout = 15 * time.Second
+
+// Manager announces to the tiers of a multi-tracker torrent (BEP 12). The
+// trackers within each tier are shuffled once. Trackers are tried in order
+// and a tracker that responds moves to the front of its tier, so it is tried
+// first next time.
+type Manager struct {
+	// MergeTiers makes Announce collect peers from the first responding
+	// tracker of every tier instead of stopping at the first tier that responds
+	MergeTiers bool
+	// TrackerTimeout bounds the announce to each tracker
+	TrackerTimeout time.Duration
+
+	client     announcer
+	mu         sync.Mutex
+	tiers      [][]string
+	trackerIDs map[string]string
+}
+
+// NewManager creates a tracker manager for the given tiers
+func NewManager(client announcer, tiers [][]string) *Manager {
+	m := &Manager{
+		TrackerTimeout: DefaultTrackerTimeout,
+		client:         client,
+		trackerIDs:     make(map[string]string),
+	}
+	for _, tier := range tiers {
+		if len(tier) == 0 {
+			continue
+		}
+		shuffled := append([]string(nil), tier...)
+		rand.Shuffle(len(shuffled), func(i, j int) {
+			shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+		})
+		m.tiers = append(m.tiers, shuffled)
+	}
+	return m
+}
+
+// Tiers returns the tiers in the order they are currently tried
+func (m *Manager) Tiers() [][]string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	tiers := make([][]string, len(m.tiers))
+	for i, tier := range m.tiers {
+		tiers[i] = append([]string(nil), tier...)
+	}
+	return tiers
+}
+
+// Announce walks the tiers in order until a tracker responds and returns its
+// response. With MergeTiers the peers of one tracker per tier are merged
+// into the response of the first one. An error is returned only when no
+// tracker responded.
+func (m *Manager) Announce(ctx context.Context, req tracker.AnnounceRequest) (*Response, error) {
+	tiers := m.Tiers()
+	if len(tiers) == 0 {
+		return nil, ErrNoTrackers
+	}
+
+	var (
+		merged *Response
+		seen   = make(map[string]bool)
+		errs   []error
+	)
+	for tierIndex, tier := range tiers {
+		for _, announceURL := range tier {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+
+			trackerCtx, cancel := context.WithTimeout(ctx, m.TrackerTimeout)
+			resp, err := m.client.Announce(trackerCtx, announceURL, m.withTrackerID(announceURL, req))
+			cancel()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", announceURL, err))
+				continue
+			}
+			m.promote(tierIndex, announceURL, resp.TrackerID)
+
+			found := resp.Peers
+			if merged == nil {
+				merged = resp
+				merged.Peers = nil
+			}
+			for _, p := range found {
+				if !seen[p.String()] {
+					seen[p.String()] = true
+					merged.Peers = append(merged.Peers, p)
+				}
+			}
+			break
+		}
+		if merged != nil && !m.MergeTiers {
+			break
+		}
+	}
+
+	if merged == nil {
+		return nil, errors.Join(errs...)
+	}
+	return merged, nil
+}
+
+// withTrackerID fills in the tracker id a tracker handed out earlier
+func (m *Manager) withTrackerID(announceURL string, req tracker.AnnounceRequest) tracker.AnnounceRequest {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if id, ok := m.trackerIDs[announceURL]; ok {
+		req.TrackerID = id
+	}
+	return req
+}
+
+// promote moves a responding tracker to the front of its tier and records
+// the tracker id it sent
+func (m *Manager) promote(tierIndex int, announceURL, trackerID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if trackerID != "" {
+		m.trackerIDs[announceURL] = trackerID
+	}
+	tier := m.tiers[tierIndex]
+	for i, u := range tier {
+		if u == announceURL {
+			copy(tier[1:i+1], tier[:i])
+			tier[0] = announceURL
+			return
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package announce
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"Torrentasaurus_Rex/internal/peers"
+	"Torrentasaurus_Rex/internal/tracker"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeAnnouncer answers announces from a fixed table of trackers
+type fakeAnnouncer struct {
+	mu         sync.Mutex
+	peers      map[string][]peers.Peer
+	calls      []string
+	requests   []tracker.AnnounceRequest
+	failing    map[string]bool
+	trackerIDs map[string]string
+	// hanging trackers never answer
+	hanging map[string]bool
+}
+
+func (f *fakeAnnouncer) Announce(ctx context.Context, announceURL string, req tracker.AnnounceRequest) (*Response, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, announceURL)
+	f.requests = append(f.requests, req)
+	if f.hanging[announceURL] {
+		f.mu.Unlock()
+		<-ctx.Done()
+		f.mu.Lock()
+		return nil, ctx.Err()
+	}
+	if f.failing[announceURL] {
+		return nil, errors.New("tracker down")
+	}
+	return &Response{Peers: f.peers[announceURL], TrackerID: f.trackerIDs[announceURL], Seeders: len(f.calls)}, nil
+}
+
+func peer(last byte) peers.Peer {
+	return peers.Peer{IP: net.IP{10, 0, 0, last}, Port: 6881}
+}
+
+func TestManagerFailover(t *testing.T) {
+	fake := &fakeAnnouncer{
+		peers:   map[string][]peers.Peer{"b": {peer(2)}, "c": {peer(3)}},
+		failing: map[string]bool{"a": true},
+	}
+	m := NewManager(fake, nil)
+	m.tiers = [][]string{{"a", "b"}, {"c"}}
+
+	got, err := m.Announce(context.Background(), tracker.AnnounceRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, []peers.Peer{peer(2)}, got.Peers)
+	assert.Equal(t, []string{"a", "b"}, fake.calls)
+
+	// The responding tracker moved to the front of its tier
+	assert.Equal(t, [][]string{{"b", "a"}, {"c"}}, m.Tiers())
+}
+
+func TestManagerTimesOutUnresponsiveTracker(t *testing.T) {
+	fake := &fakeAnnouncer{
+		peers:   map[string][]peers.Peer{"b": {peer(2)}},
+		hanging: map[string]bool{"a": true},
+	}
+	m := NewManager(fake, nil)
+	m.TrackerTimeout = 20 * time.Millisecond
+	m.tiers = [][]string{{"a"}, {"b"}}
+
+	start := time.Now()
+	got, err := m.Announce(context.Background(), tracker.AnnounceRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, []peers.Peer{peer(2)}, got.Peers)
+	assert.Equal(t, []string{"a", "b"}, fake.calls)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestManagerFallsBackToNextTier(t *testing.T) {
+	fake := &fakeAnnouncer{
+		peers:   map[string][]peers.Peer{"c": {peer(3)}},
+		failing: map[string]bool{"a": true, "b": true},
+	}
+	m := NewManager(fake, nil)
+	m.tiers = [][]string{{"a", "b"}, {"c"}}
+
+	got, err := m.Announce(context.Background(), tracker.AnnounceRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, []peers.Peer{peer(3)}, got.Peers)
+	assert.Equal(t, [][]string{{"a", "b"}, {"c"}}, m.Tiers())
+}
+
+func TestManagerMergeTiers(t *testing.T) {
+	fake := &fakeAnnouncer{
+		peers: map[string][]peers.Peer{
+			"a": {peer(1), peer(2)},
+			"b": {peer(9)},
+			"c": {peer(2), peer(3)},
+		},
+	}
+	m := NewManager(fake, nil)
+	m.tiers = [][]string{{"a", "b"}, {"c"}}
+	m.MergeTiers = true
+
+	got, err := m.Announce(context.Background(), tracker.AnnounceRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, []peers.Peer{peer(1), peer(2), peer(3)}, got.Peers)
+	assert.Equal(t, []string{"a", "c"}, fake.calls)
+	// Swarm statistics come from the first responding tracker
+	assert.Equal(t, 1, got.Seeders)
+}
+
+func TestManagerAllTrackersFail(t *testing.T) {
+	fake := &fakeAnnouncer{failing: map[string]bool{"a": true, "b": true}}
+	m := NewManager(fake, [][]string{{"a"}, {"b"}})
+
+	_, err := m.Announce(context.Background(), tracker.AnnounceRequest{})
+	assert.ErrorContains(t, err, "a: tracker down")
+	assert.ErrorContains(t, err, "b: tracker down")
+}
+
+func TestManagerNoTrackers(t *testing.T) {
+	m := NewManager(&fakeAnnouncer{}, [][]string{{}})
+	_, err := m.Announce(context.Background(), tracker.AnnounceRequest{})
+	assert.ErrorIs(t, err, ErrNoTrackers)
+}
+
+func TestNewManagerShufflesWithinTiers(t *testing.T) {
+	tiers := [][]string{{"a", "b", "c", "d"}, {"e"}}
+	m := NewManager(&fakeAnnouncer{}, tiers)
+
+	got := m.Tiers()
+	require.Len(t, got, 2)
+	assert.ElementsMatch(t, tiers[0], got[0])
+	assert.Equal(t, tiers[1], got[1])
+	// The caller's tiers are left untouched
+	assert.Equal(t, []string{"a", "b", "c", "d"}, tiers[0])
+}
+
+func TestManagerSendsTrackerID(t *testing.T) {
+	fake := &fakeAnnouncer{trackerIDs: map[string]string{"a": "id-from-a"}}
+	m := NewManager(fake, [][]string{{"a"}})
+
+	_, err := m.Announce(context.Background(), tracker.AnnounceRequest{})
+	require.NoError(t, err)
+	_, err = m.Announce(context.Background(), tracker.AnnounceRequest{})
+	require.NoError(t, err)
+
+	require.Len(t, fake.requests, 2)
+	assert.Empty(t, fake.requests[0].TrackerID)
+	assert.Equal(t, "id-from-a", fake.requests[1].TrackerID)
+}
@@ -0,0 +1,326 @@
+package bencode
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"reflect"
+)
+
+var rawMessageType = reflect.TypeOf(RawMessage(nil))
+
+// Unmarshal decodes the single bencode value in data into v. Trailing
+// bytes after the value are an error.
+func Unmarshal(data []byte, v any) error {
+	return unmarshal(NewDecoder(bytes.NewReader(data)), data, v)
+}
+
+// UnmarshalLenient is like Unmarshal but accepts non-canonical encodings.
+// It is meant for data from the network, where canonical form doesn't
+// matter.
+func UnmarshalLenient(data []byte, v any) error {
+	d := NewDecoder(bytes.NewReader(data))
+	d.AllowNonCanonical()
+	return unmarshal(d, data, v)
+}
+
+func unmarshal(d *Decoder, data []byte, v any) error {
+	if err := d.Decode(v); err != nil {
+		return err
+	}
+	if d.InputOffset() != int64(len(data)) {
+		return &SyntaxError{Offset: d.InputOffset(), Msg: "trailing data after value"}
+	}
+	return nil
+}
+
+// Decode reads the next bencode value from the stream and stores it in v.
+//
+// Integers decode into integer kinds, bools and interfaces (as int64).
+// Strings decode into strings, byte slices, byte arrays of matching length
+// and interfaces (as string). Lists decode into slices, arrays and
+// interfaces (as []any). Dictionaries decode into maps with string keys,
+// structs and interfaces (as map[string]any). Struct fields are matched by
+// their `bencode:"name"` tag or their Go name; unknown keys are skipped.
+// A RawMessage receives the exact encoding of the value.
+func (d *Decoder) Decode(v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return &InvalidUnmarshalError{Type: reflect.TypeOf(v)}
+	}
+	if err := d.decodeValue(rv.Elem()); err != nil {
+		if errors.Is(err, io.EOF) && len(d.stack) > 0 {
+			return &SyntaxError{Offset: d.offset, Msg: "unexpected end of data"}
+		}
+		return err
+	}
+	return nil
+}
+
+// decodeValue reads the next value into v
+func (d *Decoder) decodeValue(v reflect.Value) error {
+	if v.Type() == rawMessageType {
+		raw, err := d.readRaw()
+		if err != nil {
+			return err
+		}
+		v.SetBytes(raw)
+		return nil
+	}
+
+	tok, err := d.Token()
+	if err != nil {
+		return err
+	}
+	return d.decodeToken(tok, v)
+}
+
+// readRaw consumes the next value and returns a copy of its encoding
+func (d *Decoder) readRaw() (RawMessage, error) {
+	if d.capturing == 0 {
+		d.capture = d.capture[:0]
+	}
+	start := len(d.capture)
+	d.capturing++
+	err := d.skip()
+	d.capturing--
+	if err != nil {
+		return nil, err
+	}
+	return append(RawMessage(nil), d.capture[start:]...), nil
+}
+
+// skip consumes the next value without storing it
+func (d *Decoder) skip() error {
+	depth := 0
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch tok.Kind {
+		case ListStart, DictStart:
+			depth++
+		case End:
+			depth--
+		}
+		if depth <= 0 {
+			if depth < 0 {
+				return &SyntaxError{Offset: tok.Offset, Msg: "unexpected end marker"}
+			}
+			return nil
+		}
+	}
+}
+
+// decodeToken stores the value that starts with tok into v
+func (d *Decoder) decodeToken(tok Token, v reflect.Value) error {
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+
+	switch tok.Kind {
+	case Integer:
+		return d.decodeInteger(tok, v)
+	case String:
+		return d.decodeString(tok, v)
+	case ListStart:
+		return d.decodeList(tok, v)
+	case DictStart:
+		return d.decodeDict(tok, v)
+	default:
+		return &SyntaxError{Offset: tok.Offset, Msg: "unexpected end marker"}
+	}
+}
+
+func (d *Decoder) decodeInteger(tok Token, v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v.OverflowInt(tok.Int) {
+			return d.typeError("integer", v, tok)
+		}
+		v.SetInt(tok.Int)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if tok.Int < 0 || v.OverflowUint(uint64(tok.Int)) {
+			return d.typeError("integer", v, tok)
+		}
+		v.SetUint(uint64(tok.Int))
+	case reflect.Bool:
+		if tok.Int != 0 && tok.Int != 1 {
+			return d.typeError("integer", v, tok)
+		}
+		v.SetBool(tok.Int == 1)
+	case reflect.Interface:
+		if v.NumMethod() != 0 {
+			return d.typeError("integer", v, tok)
+		}
+		v.Set(reflect.ValueOf(tok.Int))
+	default:
+		return d.typeError("integer", v, tok)
+	}
+	return nil
+}
+
+func (d *Decoder) decodeString(tok Token, v reflect.Value) error {
+	switch {
+	case v.Kind() == reflect.String:
+		v.SetString(string(tok.Bytes))
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+		v.SetBytes(append([]byte(nil), tok.Bytes...))
+	case v.Kind() == reflect.Array && v.Type().Elem().Kind() == reflect.Uint8:
+		if v.Len() != len(tok.Bytes) {
+			return d.typeError("string of length "+itoa(len(tok.Bytes)), v, tok)
+		}
+		reflect.Copy(v, reflect.ValueOf(tok.Bytes))
+	case v.Kind() == reflect.Interface && v.NumMethod() == 0:
+		v.Set(reflect.ValueOf(string(tok.Bytes)))
+	default:
+		return d.typeError("string", v, tok)
+	}
+	return nil
+}
+
+func (d *Decoder) decodeList(tok Token, v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Slice:
+		v.Set(reflect.MakeSlice(v.Type(), 0, 0))
+		for d.More() {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			if err := d.decodeValue(elem); err != nil {
+				return err
+			}
+			v.Set(reflect.Append(v, elem))
+		}
+	case reflect.Array:
+		i := 0
+		for ; d.More(); i++ {
+			if i >= v.Len() {
+				return d.typeError("list longer than "+itoa(v.Len()), v, tok)
+			}
+			if err := d.decodeValue(v.Index(i)); err != nil {
+				return err
+			}
+		}
+		for ; i < v.Len(); i++ {
+			v.Index(i).SetZero()
+		}
+	case reflect.Interface:
+		if v.NumMethod() != 0 {
+			return d.typeError("list", v, tok)
+		}
+		list := []any{}
+		for d.More() {
+			var elem any
+			if err := d.decodeValue(reflect.ValueOf(&elem).Elem()); err != nil {
+				return err
+			}
+			list = append(list, elem)
+		}
+		v.Set(reflect.ValueOf(list))
+	default:
+		return d.typeError("list", v, tok)
+	}
+	return d.expectEnd()
+}
+
+func (d *Decoder) decodeDict(tok Token, v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return d.typeError("dictionary", v, tok)
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		for d.More() {
+			key, err := d.Token()
+			if err != nil {
+				return err
+			}
+			elem := reflect.New(v.Type().Elem()).Elem()
+			if err := d.decodeValue(elem); err != nil {
+				return err
+			}
+			v.SetMapIndex(reflect.ValueOf(string(key.Bytes)).Convert(v.Type().Key()), elem)
+		}
+	case reflect.Struct:
+		fields := cachedFields(v.Type())
+		for d.More() {
+			key, err := d.Token()
+			if err != nil {
+				return err
+			}
+			f, ok := fields.byName[string(key.Bytes)]
+			if !ok {
+				if err := d.skip(); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := d.decodeValue(fieldByIndex(v, f.index)); err != nil {
+				return err
+			}
+		}
+	case reflect.Interface:
+		if v.NumMethod() != 0 {
+			return d.typeError("dictionary", v, tok)
+		}
+		dict := map[string]any{}
+		for d.More() {
+			key, err := d.Token()
+			if err != nil {
+				return err
+			}
+			var elem any
+			if err := d.decodeValue(reflect.ValueOf(&elem).Elem()); err != nil {
+				return err
+			}
+			dict[string(key.Bytes)] = elem
+		}
+		v.Set(reflect.ValueOf(dict))
+	default:
+		return d.typeError("dictionary", v, tok)
+	}
+	return d.expectEnd()
+}
+
+// expectEnd consumes the end marker of a list or dictionary
+func (d *Decoder) expectEnd() error {
+	tok, err := d.Token()
+	if err != nil {
+		return err
+	}
+	if tok.Kind != End {
+		return &SyntaxError{Offset: tok.Offset, Msg: "expected end marker"}
+	}
+	return nil
+}
+
+func (d *Decoder) typeError(what string, v reflect.Value, tok Token) error {
+	return &UnmarshalTypeError{Value: what, Type: v.Type(), Offset: tok.Offset}
+}
+
+// fieldByIndex returns a struct field, allocating embedded pointers on the way
+func fieldByIndex(v reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+func itoa(n int) string {
+	if n > math.MaxInt32 {
+		return "many"
+	}
+	return string(appendInt(nil, int64(n)))
+}
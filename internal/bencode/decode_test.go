@@ -0,0 +1,116 @@
+package bencode
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type decodeInner struct {
+	Length int      `bencode:"length"`
+	Name   int      `bencode:"name,omitempty"`
+	Path   []string `bencode:"path"`
+}
+
+type decodeTarget struct {
+	Name    string        `bencode:"name"`
+	Private bool          `bencode:"private"`
+	Hash    [4]byte       `bencode:"hash"`
+	Data    []byte        `bencode:"data"`
+	Files   []decodeInner `bencode:"files"`
+	Info    RawMessage    `bencode:"info"`
+	Extra   map[string]int
+	Size    *int64 `bencode:"size"`
+	Skipped string `bencode:"-"`
+}
+
+func TestUnmarshalStruct(t *testing.T) {
+	data := []byte("d5:Extrad1:ai1e1:bi2ee4:data3:abc5:filesld6:lengthi5e4:pathl1:a1:beee" +
+		"4:hash4:wxyz4:infod1:xli1ei2eee4:name4:test7:privatei1e4:sizei99e7:unknownl1:xee")
+
+	var v decodeTarget
+	require.NoError(t, Unmarshal(data, &v))
+
+	size := int64(99)
+	expected := decodeTarget{
+		Name:    "test",
+		Private: true,
+		Hash:    [4]byte{'w', 'x', 'y', 'z'},
+		Data:    []byte("abc"),
+		Files:   []decodeInner{{Length: 5, Path: []string{"a", "b"}}},
+		Info:    RawMessage("d1:xli1ei2eee"),
+		Extra:   map[string]int{"a": 1, "b": 2},
+		Size:    &size,
+	}
+	assert.Equal(t, expected, v)
+}
+
+func TestUnmarshalInterface(t *testing.T) {
+	var v any
+	require.NoError(t, Unmarshal([]byte("d1:ali1e1:be1:bd1:ci-3eee"), &v))
+	expected := map[string]any{
+		"a": []any{int64(1), "b"},
+		"b": map[string]any{"c": int64(-3)},
+	}
+	assert.Equal(t, expected, v)
+}
+
+func TestUnmarshalTypeErrors(t *testing.T) {
+	tests := map[string]struct {
+		data   string
+		target any
+		offset int64
+	}{
+		"string into int":       {"4:spam", new(int), 0},
+		"negative into uint":    {"i-1e", new(uint), 0},
+		"overflow int8":         {"i300e", new(int8), 0},
+		"list into string":      {"le", new(string), 0},
+		"wrong length array":    {"3:abc", new([4]byte), 0},
+		"nested field mismatch": {"d6:lengthi1e4:name3:abce", &decodeInner{}, 18},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := Unmarshal([]byte(tt.data), tt.target)
+			var typeErr *UnmarshalTypeError
+			require.ErrorAs(t, err, &typeErr)
+			assert.Equal(t, tt.offset, typeErr.Offset)
+		})
+	}
+}
+
+func TestUnmarshalInvalidTarget(t *testing.T) {
+	var v int
+	var invalid *InvalidUnmarshalError
+	assert.ErrorAs(t, Unmarshal([]byte("i1e"), v), &invalid)
+	assert.ErrorAs(t, Unmarshal([]byte("i1e"), nil), &invalid)
+}
+
+func TestUnmarshalTrailingData(t *testing.T) {
+	var v int
+	err := Unmarshal([]byte("i1ei2e"), &v)
+	var syntaxErr *SyntaxError
+	require.ErrorAs(t, err, &syntaxErr)
+	assert.Equal(t, int64(3), syntaxErr.Offset)
+}
+
+func TestDecoderStream(t *testing.T) {
+	d := NewDecoder(bytes.NewReader([]byte("i1e4:spamli2ee")))
+
+	var n int
+	var s string
+	var l []int
+	require.NoError(t, d.Decode(&n))
+	require.NoError(t, d.Decode(&s))
+	require.NoError(t, d.Decode(&l))
+	assert.Equal(t, 1, n)
+	assert.Equal(t, "spam", s)
+	assert.Equal(t, []int{2}, l)
+}
+
+func TestDecodeRawMessageTopLevel(t *testing.T) {
+	var raw RawMessage
+	require.NoError(t, Unmarshal([]byte("d1:ai1ee"), &raw))
+	assert.Equal(t, RawMessage("d1:ai1ee"), raw)
+}
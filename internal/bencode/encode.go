@@ -0,0 +1,195 @@
+package bencode
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// Marshal returns the canonical bencode encoding of v.
+//
+// Integer kinds and bools encode as integers, strings, byte slices and byte
+// arrays as strings, other slices and arrays as lists, and maps with string
+// keys and structs as dictionaries with sorted keys. Struct fields tagged
+// `bencode:",omitempty"` are left out when they hold their zero value and
+// nil pointers are always left out. A RawMessage is written as is.
+func Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := encodeValue(&buf, reflect.ValueOf(v)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// An Encoder writes bencode values to an output stream
+type Encoder struct {
+	w io.Writer
+}
+
+// NewEncoder returns an encoder that writes to w
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Encode writes the bencode encoding of v to the stream
+func (e *Encoder) Encode(v any) error {
+	data, err := Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = e.w.Write(data)
+	return err
+}
+
+func encodeValue(buf *bytes.Buffer, v reflect.Value) error {
+	if !v.IsValid() {
+		return errors.New("bencode: cannot encode nil value")
+	}
+	if v.Type() == rawMessageType {
+		if v.Len() == 0 {
+			return errors.New("bencode: cannot encode empty RawMessage")
+		}
+		buf.Write(v.Bytes())
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return errors.New("bencode: cannot encode nil value")
+		}
+		return encodeValue(buf, v.Elem())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		writeInteger(buf, v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		buf.WriteByte('i')
+		buf.WriteString(strconv.FormatUint(v.Uint(), 10))
+		buf.WriteByte('e')
+	case reflect.Bool:
+		if v.Bool() {
+			writeInteger(buf, 1)
+		} else {
+			writeInteger(buf, 0)
+		}
+	case reflect.String:
+		writeString(buf, v.String())
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			writeString(buf, string(v.Bytes()))
+			return nil
+		}
+		return encodeList(buf, v)
+	case reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(b), v)
+			writeString(buf, string(b))
+			return nil
+		}
+		return encodeList(buf, v)
+	case reflect.Map:
+		return encodeMap(buf, v)
+	case reflect.Struct:
+		return encodeStruct(buf, v)
+	default:
+		return &UnsupportedTypeError{Type: v.Type()}
+	}
+	return nil
+}
+
+func encodeList(buf *bytes.Buffer, v reflect.Value) error {
+	buf.WriteByte('l')
+	for i := 0; i < v.Len(); i++ {
+		if err := encodeValue(buf, v.Index(i)); err != nil {
+			return err
+		}
+	}
+	buf.WriteByte('e')
+	return nil
+}
+
+func encodeMap(buf *bytes.Buffer, v reflect.Value) error {
+	if v.Type().Key().Kind() != reflect.String {
+		return &UnsupportedTypeError{Type: v.Type()}
+	}
+	keys := v.MapKeys()
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].String() < keys[j].String()
+	})
+
+	buf.WriteByte('d')
+	for _, key := range keys {
+		elem := v.MapIndex(key)
+		if isNil(elem) {
+			continue
+		}
+		writeString(buf, key.String())
+		if err := encodeValue(buf, elem); err != nil {
+			return err
+		}
+	}
+	buf.WriteByte('e')
+	return nil
+}
+
+func encodeStruct(buf *bytes.Buffer, v reflect.Value) error {
+	buf.WriteByte('d')
+	for _, f := range cachedFields(v.Type()).list {
+		fv, ok := fieldForEncoding(v, f.index)
+		if !ok || isNil(fv) || (f.omitEmpty && fv.IsZero()) {
+			continue
+		}
+		writeString(buf, f.name)
+		if err := encodeValue(buf, fv); err != nil {
+			return err
+		}
+	}
+	buf.WriteByte('e')
+	return nil
+}
+
+// fieldForEncoding returns a struct field, reporting false when it is
+// reached through a nil embedded pointer
+func fieldForEncoding(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+// isNil reports whether v is a nil pointer, nil interface or empty
+// RawMessage, which are left out of dictionaries
+func isNil(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		return v.IsNil()
+	case reflect.Slice:
+		return v.Type() == rawMessageType && v.Len() == 0
+	}
+	return false
+}
+
+func writeInteger(buf *bytes.Buffer, n int64) {
+	buf.WriteByte('i')
+	buf.Write(appendInt(nil, n))
+	buf.WriteByte('e')
+}
+
+func writeString(buf *bytes.Buffer, s string) {
+	buf.WriteString(strconv.Itoa(len(s)))
+	buf.WriteByte(':')
+	buf.WriteString(s)
+}
+
+func appendInt(dst []byte, n int64) []byte {
+	return strconv.AppendInt(dst, n, 10)
+}
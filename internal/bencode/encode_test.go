@@ -0,0 +1,78 @@
+package bencode
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type encodeTarget struct {
+	Zeta    int        `bencode:"zeta"`
+	Alpha   string     `bencode:"alpha"`
+	Omitted int        `bencode:"omitted,omitempty"`
+	Hash    [2]byte    `bencode:"hash"`
+	Raw     RawMessage `bencode:"raw,omitempty"`
+	Nested  *decodeInner
+	Nil     *decodeInner `bencode:"nil"`
+	Private bool         `bencode:"private"`
+	hidden  int
+}
+
+func TestMarshalStruct(t *testing.T) {
+	v := encodeTarget{
+		Zeta:   -5,
+		Alpha:  "a",
+		Hash:   [2]byte{'h', 'i'},
+		Raw:    RawMessage("li1ee"),
+		Nested: &decodeInner{Length: 3, Path: []string{"x"}},
+		hidden: 7,
+	}
+	data, err := Marshal(v)
+	require.NoError(t, err)
+	assert.Equal(t, "d6:Nestedd6:lengthi3e4:pathl1:xee5:alpha1:a4:hash2:hi7:privatei0e3:rawli1ee4:zetai-5ee", string(data))
+}
+
+func TestMarshalMapSortsKeys(t *testing.T) {
+	data, err := Marshal(map[string]any{"b": 1, "a": []byte("x"), "c": []any{"y", uint8(2)}})
+	require.NoError(t, err)
+	assert.Equal(t, "d1:a1:x1:bi1e1:cl1:yi2eee", string(data))
+}
+
+func TestMarshalErrors(t *testing.T) {
+	_, err := Marshal(1.5)
+	var unsupported *UnsupportedTypeError
+	assert.ErrorAs(t, err, &unsupported)
+
+	_, err = Marshal(map[int]int{1: 2})
+	assert.ErrorAs(t, err, &unsupported)
+
+	_, err = Marshal(nil)
+	assert.Error(t, err)
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	size := int64(42)
+	in := decodeTarget{
+		Name:  "round",
+		Hash:  [4]byte{1, 2, 3, 4},
+		Data:  []byte{0, 255},
+		Files: []decodeInner{{Length: 1, Path: []string{"p"}}},
+		Info:  RawMessage("i5e"),
+		Extra: map[string]int{"z": 26},
+		Size:  &size,
+	}
+	data, err := Marshal(in)
+	require.NoError(t, err)
+
+	var out decodeTarget
+	require.NoError(t, Unmarshal(data, &out))
+	assert.Equal(t, in, out)
+}
+
+func TestEncoder(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, NewEncoder(&buf).Encode([]int{1, 2}))
+	assert.Equal(t, "li1ei2ee", buf.String())
+}
@@ -0,0 +1,51 @@
+package bencode
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// SyntaxError describes malformed or non-canonical bencode data and where it was found
+type SyntaxError struct {
+	Offset int64
+	Msg    string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("bencode: %s at offset %d", e.Msg, e.Offset)
+}
+
+// UnmarshalTypeError describes a bencode value that can't be stored in a Go type
+type UnmarshalTypeError struct {
+	Value  string
+	Type   reflect.Type
+	Offset int64
+}
+
+func (e *UnmarshalTypeError) Error() string {
+	return fmt.Sprintf("bencode: cannot unmarshal %s into Go value of type %s at offset %d", e.Value, e.Type, e.Offset)
+}
+
+// InvalidUnmarshalError describes an invalid argument passed to Decode or Unmarshal
+type InvalidUnmarshalError struct {
+	Type reflect.Type
+}
+
+func (e *InvalidUnmarshalError) Error() string {
+	if e.Type == nil {
+		return "bencode: Unmarshal(nil)"
+	}
+	if e.Type.Kind() != reflect.Pointer {
+		return "bencode: Unmarshal(non-pointer " + e.Type.String() + ")"
+	}
+	return "bencode: Unmarshal(nil " + e.Type.String() + ")"
+}
+
+// UnsupportedTypeError describes a Go value that has no bencode representation
+type UnsupportedTypeError struct {
+	Type reflect.Type
+}
+
+func (e *UnsupportedTypeError) Error() string {
+	return "bencode: unsupported type: " + e.Type.String()
+}
@@ -0,0 +1,76 @@
+package bencode
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// field describes a struct field that maps to a dictionary key
+type field struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+// structFields lists the encodable fields of a struct type in key order
+type structFields struct {
+	list   []field
+	byName map[string]field
+}
+
+var fieldCache sync.Map // map[reflect.Type]*structFields
+
+// cachedFields returns the fields of a struct type, computing them once
+func cachedFields(t reflect.Type) *structFields {
+	if f, ok := fieldCache.Load(t); ok {
+		return f.(*structFields)
+	}
+	f, _ := fieldCache.LoadOrStore(t, typeFields(t))
+	return f.(*structFields)
+}
+
+func typeFields(t reflect.Type) *structFields {
+	fields := &structFields{byName: map[string]field{}}
+	collectFields(t, nil, fields)
+	sort.Slice(fields.list, func(i, j int) bool {
+		return fields.list[i].name < fields.list[j].name
+	})
+	return fields
+}
+
+// collectFields walks exported fields, flattening untagged embedded structs
+func collectFields(t reflect.Type, index []int, fields *structFields) {
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("bencode")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+
+		fieldIndex := append(append([]int(nil), index...), i)
+		ft := sf.Type
+		if ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+		if sf.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			collectFields(ft, fieldIndex, fields)
+			continue
+		}
+		if !sf.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		if _, dup := fields.byName[name]; dup {
+			continue
+		}
+
+		f := field{name: name, index: fieldIndex, omitEmpty: opts == "omitempty"}
+		fields.list = append(fields.list, f)
+		fields.byName[name] = f
+	}
+}
@@ -0,0 +1,44 @@
+package bencode
+
+import (
+	"bytes"
+	"errors"
+	"io"
+)
+
+// maxDepth limits how deeply lists and dictionaries may be nested
+const maxDepth = 256
+
+// RawMessage is the raw encoding of a single bencode value. It can be used
+// to delay decoding or to keep the exact bytes of a value, e.g. to hash them.
+type RawMessage []byte
+
+// DictValue returns the exact bytes of the value stored under key in the
+// dictionary that makes up data. It returns nil if the key is absent.
+func DictValue(data []byte, key string) (RawMessage, error) {
+	d := NewDecoder(bytes.NewReader(data))
+	tok, err := d.Token()
+	if errors.Is(err, io.EOF) {
+		return nil, &SyntaxError{Offset: 0, Msg: "expected dictionary"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if tok.Kind != DictStart {
+		return nil, &SyntaxError{Offset: tok.Offset, Msg: "expected dictionary"}
+	}
+
+	for d.More() {
+		k, err := d.Token()
+		if err != nil {
+			return nil, err
+		}
+		if string(k.Bytes) == key {
+			return d.readRaw()
+		}
+		if err := d.skip(); err != nil {
+			return nil, err
+		}
+	}
+	return nil, d.expectEnd()
+}
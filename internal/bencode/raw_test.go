@@ -0,0 +1,47 @@
+package bencode
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDictValue(t *testing.T) {
+	data := []byte("d8:announce3:url4:infod5:filesld6:lengthi5e4:pathl1:aeee4:name1:x7:privatei1eee")
+
+	info, err := DictValue(data, "info")
+	require.NoError(t, err)
+	assert.Equal(t, RawMessage("d5:filesld6:lengthi5e4:pathl1:aeee4:name1:x7:privatei1ee"), info)
+
+	announce, err := DictValue(data, "announce")
+	require.NoError(t, err)
+	assert.Equal(t, RawMessage("3:url"), announce)
+
+	missing, err := DictValue(data, "comment")
+	require.NoError(t, err)
+	assert.Nil(t, missing)
+}
+
+func TestDictValueErrors(t *testing.T) {
+	tests := map[string]struct {
+		data   string
+		offset int64
+	}{
+		"not a dictionary":       {"l4:infoe", 0},
+		"unterminated":           {"d1:ai1e", 7},
+		"string exceeds data":    {"d4:info10:abce", 14},
+		"invalid character":      {"d4:infox", 7},
+		"non-numeric length":     {"d4:infoa:xe", 7},
+		"unterminated integer":   {"d4:infoi12", 10},
+		"unterminated container": {"d4:infold", 9},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := DictValue([]byte(tt.data), "info")
+			var syntaxErr *SyntaxError
+			require.ErrorAs(t, err, &syntaxErr)
+			assert.Equal(t, tt.offset, syntaxErr.Offset)
+		})
+	}
+}
@@ -0,0 +1,311 @@
+package bencode
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// TokenKind identifies the kind of a bencode token
+type TokenKind uint8
+
+const (
+	// Integer is an integer value such as i42e
+	Integer TokenKind = iota
+	// String is a byte string such as 4:spam
+	String
+	// ListStart opens a list
+	ListStart
+	// DictStart opens a dictionary
+	DictStart
+	// End closes the innermost list or dictionary
+	End
+)
+
+// Token is a single lexical element of a bencode stream
+type Token struct {
+	Kind TokenKind
+	// Int holds the value of an Integer token
+	Int int64
+	// Bytes holds the contents of a String token
+	Bytes []byte
+	// Offset is the position of the first byte of the token in the stream
+	Offset int64
+}
+
+func (k TokenKind) String() string {
+	switch k {
+	case Integer:
+		return "integer"
+	case String:
+		return "string"
+	case ListStart:
+		return "list"
+	case DictStart:
+		return "dictionary"
+	case End:
+		return "end"
+	default:
+		return "TokenKind(" + strconv.Itoa(int(k)) + ")"
+	}
+}
+
+// container tracks an open list or dictionary
+type container struct {
+	dict bool
+	// expectKey is set while a dictionary waits for its next key
+	expectKey bool
+	// lastKey is the previous key of a dictionary, used to enforce key order
+	lastKey []byte
+	hasKey  bool
+}
+
+// A Decoder reads and validates bencode values from an input stream. By
+// default it only accepts the canonical encoding: integers and string
+// lengths without leading zeros, no negative zero, and dictionary keys in
+// strictly ascending order.
+type Decoder struct {
+	r      *bufio.Reader
+	offset int64
+	stack  []container
+	// lenient accepts non-canonical encodings
+	lenient bool
+
+	// capture records consumed bytes while capturing > 0
+	capture   []byte
+	capturing int
+}
+
+// NewDecoder returns a decoder that reads from r
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r)}
+}
+
+// AllowNonCanonical makes the decoder accept integers and string lengths
+// with leading zeros, negative zero, and dictionary keys out of order or
+// repeated, as sent by some peers and trackers. Later values of a repeated
+// key replace earlier ones.
+func (d *Decoder) AllowNonCanonical() {
+	d.lenient = true
+}
+
+// InputOffset returns the number of bytes consumed so far
+func (d *Decoder) InputOffset() int64 {
+	return d.offset
+}
+
+// More reports whether the current list or dictionary has another element
+func (d *Decoder) More() bool {
+	c, err := d.r.Peek(1)
+	return err == nil && c[0] != 'e'
+}
+
+// Token returns the next token in the stream. It returns io.EOF when the
+// stream ends cleanly between top-level values.
+func (d *Decoder) Token() (Token, error) {
+	start := d.offset
+	c, err := d.readByte()
+	if err != nil {
+		if errors.Is(err, io.EOF) && len(d.stack) == 0 {
+			return Token{}, io.EOF
+		}
+		return Token{}, d.unexpectedEOF(err)
+	}
+
+	var tok Token
+	switch {
+	case c == 'i':
+		tok, err = d.readInteger(start)
+	case c >= '0' && c <= '9':
+		tok, err = d.readString(start, c)
+	case c == 'l':
+		tok = Token{Kind: ListStart, Offset: start}
+	case c == 'd':
+		tok = Token{Kind: DictStart, Offset: start}
+	case c == 'e':
+		tok = Token{Kind: End, Offset: start}
+	default:
+		err = &SyntaxError{Offset: start, Msg: fmt.Sprintf("invalid character %q", c)}
+	}
+	if err != nil {
+		return Token{}, err
+	}
+
+	if err := d.advance(tok); err != nil {
+		return Token{}, err
+	}
+	return tok, nil
+}
+
+// advance validates a token against the open containers and updates them
+func (d *Decoder) advance(tok Token) error {
+	if tok.Kind == End {
+		if len(d.stack) == 0 {
+			return &SyntaxError{Offset: tok.Offset, Msg: "unexpected end marker"}
+		}
+		top := d.stack[len(d.stack)-1]
+		if top.dict && !top.expectKey {
+			return &SyntaxError{Offset: tok.Offset, Msg: "dictionary key without value"}
+		}
+		d.stack = d.stack[:len(d.stack)-1]
+		d.valueDone()
+		return nil
+	}
+
+	if n := len(d.stack); n > 0 && d.stack[n-1].dict && d.stack[n-1].expectKey {
+		top := &d.stack[n-1]
+		if tok.Kind != String {
+			return &SyntaxError{Offset: tok.Offset, Msg: "dictionary key must be a string"}
+		}
+		if !d.lenient && top.hasKey && bytes.Compare(top.lastKey, tok.Bytes) >= 0 {
+			return &SyntaxError{Offset: tok.Offset, Msg: fmt.Sprintf("dictionary key %q out of order", tok.Bytes)}
+		}
+		top.lastKey = append(top.lastKey[:0], tok.Bytes...)
+		top.hasKey = true
+		top.expectKey = false
+		return nil
+	}
+
+	switch tok.Kind {
+	case ListStart, DictStart:
+		if len(d.stack) >= maxDepth {
+			return &SyntaxError{Offset: tok.Offset, Msg: "nesting too deep"}
+		}
+		d.stack = append(d.stack, container{dict: tok.Kind == DictStart, expectKey: tok.Kind == DictStart})
+	default:
+		d.valueDone()
+	}
+	return nil
+}
+
+// valueDone marks the end of a value inside the innermost container
+func (d *Decoder) valueDone() {
+	if n := len(d.stack); n > 0 && d.stack[n-1].dict {
+		d.stack[n-1].expectKey = true
+	}
+}
+
+// readInteger reads the rest of an integer token after its 'i'
+func (d *Decoder) readInteger(start int64) (Token, error) {
+	digits, err := d.readUntil('e')
+	if err != nil {
+		return Token{}, err
+	}
+	if !d.validInteger(digits) {
+		return Token{}, &SyntaxError{Offset: start, Msg: fmt.Sprintf("invalid integer %q", digits)}
+	}
+	n, err := strconv.ParseInt(string(digits), 10, 64)
+	if err != nil {
+		return Token{}, &SyntaxError{Offset: start, Msg: fmt.Sprintf("integer %s out of range", digits)}
+	}
+	return Token{Kind: Integer, Int: n, Offset: start}, nil
+}
+
+// readString reads the rest of a string token after the first digit of its length
+func (d *Decoder) readString(start int64, first byte) (Token, error) {
+	rest, err := d.readUntil(':')
+	if err != nil {
+		return Token{}, err
+	}
+	digits := append([]byte{first}, rest...)
+	if !d.validInteger(digits) {
+		return Token{}, &SyntaxError{Offset: start, Msg: fmt.Sprintf("invalid string length %q", digits)}
+	}
+	length, err := strconv.ParseInt(string(digits), 10, 64)
+	if err != nil {
+		return Token{}, &SyntaxError{Offset: start, Msg: fmt.Sprintf("string length %s out of range", digits)}
+	}
+
+	var buf bytes.Buffer
+	buf.Grow(int(min(length, 1<<20)))
+	n, err := io.CopyN(&buf, d.r, length)
+	d.consumed(buf.Bytes())
+	if err != nil {
+		return Token{}, &SyntaxError{Offset: start + int64(len(digits)) + 1 + n, Msg: "unexpected end of data in string"}
+	}
+	return Token{Kind: String, Bytes: buf.Bytes(), Offset: start}, nil
+}
+
+// readUntil reads bytes up to and including delim and returns them without delim
+func (d *Decoder) readUntil(delim byte) ([]byte, error) {
+	var out []byte
+	for {
+		c, err := d.readByte()
+		if err != nil {
+			return nil, d.unexpectedEOF(err)
+		}
+		if c == delim {
+			return out, nil
+		}
+		if len(out) > 20 {
+			return nil, &SyntaxError{Offset: d.offset - 1, Msg: fmt.Sprintf("expected %q", delim)}
+		}
+		out = append(out, c)
+	}
+}
+
+func (d *Decoder) readByte() (byte, error) {
+	c, err := d.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	d.consumed([]byte{c})
+	return c, nil
+}
+
+// consumed advances the offset and records bytes while capturing
+func (d *Decoder) consumed(p []byte) {
+	d.offset += int64(len(p))
+	if d.capturing > 0 {
+		d.capture = append(d.capture, p...)
+	}
+}
+
+func (d *Decoder) unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return &SyntaxError{Offset: d.offset, Msg: "unexpected end of data"}
+	}
+	return err
+}
+
+// validInteger reports whether digits is a decimal integer the decoder accepts
+func (d *Decoder) validInteger(digits []byte) bool {
+	if d.lenient {
+		return isInteger(digits)
+	}
+	return isCanonicalInteger(digits)
+}
+
+// isInteger reports whether digits is a decimal integer, possibly negative
+func isInteger(digits []byte) bool {
+	if len(digits) > 0 && digits[0] == '-' {
+		digits = digits[1:]
+	}
+	if len(digits) == 0 {
+		return false
+	}
+	for _, c := range digits {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// isCanonicalInteger reports whether digits is a decimal integer without
+// leading zeros or negative zero
+func isCanonicalInteger(digits []byte) bool {
+	if !isInteger(digits) {
+		return false
+	}
+	if digits[0] == '-' {
+		digits = digits[1:]
+		if digits[0] == '0' {
+			return false
+		}
+	}
+	return digits[0] != '0' || len(digits) == 1
+}
@@ -0,0 +1,82 @@
+package bencode
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecoderTokens(t *testing.T) {
+	d := NewDecoder(bytes.NewReader([]byte("d3:bari-42e3:fooli0e4:spameei7e")))
+
+	expected := []Token{
+		{Kind: DictStart, Offset: 0},
+		{Kind: String, Bytes: []byte("bar"), Offset: 1},
+		{Kind: Integer, Int: -42, Offset: 6},
+		{Kind: String, Bytes: []byte("foo"), Offset: 11},
+		{Kind: ListStart, Offset: 16},
+		{Kind: Integer, Int: 0, Offset: 17},
+		{Kind: String, Bytes: []byte("spam"), Offset: 20},
+		{Kind: End, Offset: 26},
+		{Kind: End, Offset: 27},
+		{Kind: Integer, Int: 7, Offset: 28},
+	}
+	for _, want := range expected {
+		tok, err := d.Token()
+		require.NoError(t, err)
+		assert.Equal(t, want, tok)
+	}
+
+	_, err := d.Token()
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Equal(t, int64(31), d.InputOffset())
+}
+
+func TestDecoderRejectsNonCanonical(t *testing.T) {
+	tests := map[string]struct {
+		data   string
+		offset int64
+	}{
+		"leading zero integer":   {"i03e", 0},
+		"negative zero":          {"i-0e", 0},
+		"empty integer":          {"ie", 0},
+		"leading zero length":    {"04:spam", 0},
+		"unsorted keys":          {"d3:fooi1e3:bari2ee", 9},
+		"duplicate keys":         {"d3:fooi1e3:fooi2ee", 9},
+		"integer key":            {"di1ei2ee", 1},
+		"key without value":      {"d3:fooe", 6},
+		"unexpected end":         {"e", 0},
+		"truncated dictionary":   {"d3:foo", 6},
+		"integer out of range":   {"i99999999999999999999e", 0},
+		"invalid character":      {"x", 0},
+		"truncated string":       {"5:ab", 4},
+		"unterminated integer 2": {"i1", 2},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			var v any
+			err := Unmarshal([]byte(tt.data), &v)
+			var syntaxErr *SyntaxError
+			require.ErrorAs(t, err, &syntaxErr)
+			assert.Equal(t, tt.offset, syntaxErr.Offset)
+		})
+	}
+}
+
+func TestDecoderAllowNonCanonical(t *testing.T) {
+	var v map[string]any
+	require.NoError(t, UnmarshalLenient([]byte("d3:fooi03e3:bari-0e3:fooi2e3:baz04:spame"), &v))
+	assert.Equal(t, map[string]any{"foo": int64(2), "bar": int64(0), "baz": "spam"}, v)
+
+	for _, data := range []string{"ie", "i-e", "d3:fooe", "di1ei2ee"} {
+		assert.Error(t, UnmarshalLenient([]byte(data), &v), data)
+	}
+}
+
+func TestTokenKindString(t *testing.T) {
+	assert.Equal(t, "dictionary", DictStart.String())
+	assert.Equal(t, "TokenKind(9)", TokenKind(9).String())
+}
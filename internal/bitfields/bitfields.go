@@ -2,14 +2,30 @@ package bitfields
 
 import (
 	"Torrentasaurus_Rex/internal/message"
+	"errors"
 	"fmt"
 	"net"
+	"os"
 	"time"
 )
 
 // A Bitfield represents the pieces that a peer has
 type Bitfield []byte
 
+// New returns an empty bitfield for numPieces pieces
+func New(numPieces int) Bitfield {
+	return make(Bitfield, (numPieces+7)/8)
+}
+
+// Full returns a bitfield with all numPieces pieces set
+func Full(numPieces int) Bitfield {
+	bf := New(numPieces)
+	for i := range numPieces {
+		bf.SetPiece(i)
+	}
+	return bf
+}
+
 // HasPiece tells if a bitfields has a particular index set
 func (bf Bitfield) HasPiece(index int) bool {
 	if !bf.isValidIndex(index) {
@@ -28,23 +44,39 @@ func (bf Bitfield) SetPiece(index int) {
 	bf[byteIndex] |= 1 << uint(7-offset)
 }
 
-// RecvBitfield receives a bitfield from a connection
-func RecvBitfield(conn net.Conn) (Bitfield, error) {
+// RecvBitfield receives the pieces a peer has from the first message it
+// sends after the handshake. Peers without pieces may skip the bitfield, in
+// which case an empty bitfield is returned together with the message that
+// came instead; that message is nil when the peer stayed silent. fast accepts
+// the Have All and Have None messages of the Fast Extension.
+func RecvBitfield(conn net.Conn, numPieces int, fast bool) (Bitfield, *message.Message, error) {
 	conn.SetDeadline(time.Now().Add(5 * time.Second))
 	defer conn.SetDeadline(time.Time{}) // Disable the deadline
 
 	msg, err := message.Read(conn)
 	if err != nil {
-		return nil, err
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return New(numPieces), nil, nil
+		}
+		return nil, nil, err
 	}
-	if msg == nil {
-		return nil, fmt.Errorf("expected bitfields but got %v", msg)
+	if msg == nil { // keep-alive
+		return New(numPieces), nil, nil
 	}
-	if msg.ID != message.MsgBitfield {
-		return nil, fmt.Errorf("expected bitfields but got ID %d", msg.ID)
+	switch msg.ID {
+	case message.MsgBitfield:
+		return Bitfield(msg.Payload), nil, nil
+	case message.MsgHaveAll, message.MsgHaveNone:
+		if !fast {
+			return nil, nil, fmt.Errorf("unexpected message ID %d without the Fast Extension", msg.ID)
+		}
+		if msg.ID == message.MsgHaveAll {
+			return Full(numPieces), nil, nil
+		}
+		return New(numPieces), nil, nil
+	default:
+		return New(numPieces), msg, nil
 	}
-
-	return Bitfield(msg.Payload), nil
 }
 
 // isValidIndex checks if the index is within the bounds of the bitfield
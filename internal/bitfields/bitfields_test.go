@@ -1,9 +1,13 @@
 package bitfields
 
 import (
+	"net"
 	"testing"
 
+	"Torrentasaurus_Rex/internal/message"
+
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestHasPiece(t *testing.T) {
@@ -47,3 +51,60 @@ func TestSetPiece(t *testing.T) {
 		assert.Equal(t, test.outpt, bf)
 	}
 }
+
+func TestFull(t *testing.T) {
+	assert.Equal(t, Bitfield{0xff, 0b11100000}, Full(11))
+	assert.Equal(t, Bitfield{0, 0}, New(11))
+}
+
+func TestRecvBitfield(t *testing.T) {
+	tests := map[string]struct {
+		sent     *message.Message
+		fast     bool
+		bitfield Bitfield
+		first    *message.Message
+		fails    bool
+	}{
+		"bitfield": {
+			sent:     &message.Message{ID: message.MsgBitfield, Payload: []byte{0b10100000, 0}},
+			bitfield: Bitfield{0b10100000, 0},
+		},
+		"have all": {
+			sent:     &message.Message{ID: message.MsgHaveAll},
+			fast:     true,
+			bitfield: Bitfield{0xff, 0b11000000},
+		},
+		"have none": {
+			sent:     &message.Message{ID: message.MsgHaveNone},
+			fast:     true,
+			bitfield: Bitfield{0, 0},
+		},
+		"have all without fast extension": {
+			sent:  &message.Message{ID: message.MsgHaveAll},
+			fails: true,
+		},
+		"no bitfield": {
+			sent:     &message.Message{ID: message.MsgUnchoke},
+			bitfield: Bitfield{0, 0},
+			first:    &message.Message{ID: message.MsgUnchoke, Payload: []byte{}},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			defer server.Close()
+			go server.Write(test.sent.Serialize())
+
+			bf, first, err := RecvBitfield(client, 10, test.fast)
+			if test.fails {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.bitfield, bf)
+			assert.Equal(t, test.first, first)
+		})
+	}
+}
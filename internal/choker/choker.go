@@ -0,0 +1,205 @@
+package choker
+
+import (
+	"context"
+	"math/rand/v2"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultSlots is the number of regular upload slots
+	DefaultSlots = 4
+	// RechokeInterval is how often the regular slots are re-evaluated
+	RechokeInterval = 10 * time.Second
+	// OptimisticInterval is how often the optimistic unchoke rotates
+	OptimisticInterval = 30 * time.Second
+)
+
+// Peer is a connection we upload to
+type Peer interface {
+	// Interested tells whether the peer wants to download from us
+	Interested() bool
+	// Transferred reports the bytes uploaded to and downloaded from the peer
+	// so far
+	Transferred() (uploaded, downloaded int64)
+	// Choke and Unchoke send the matching message to the peer
+	Choke() error
+	Unchoke() error
+}
+
+// peerState is what the choker remembers about a peer between rounds
+type peerState struct {
+	choked     bool
+	uploaded   int64
+	downloaded int64
+	// rate is the number of bytes transferred during the last round
+	rate int64
+}
+
+// Choker decides which peers we upload to. Regular slots go to the interested
+// peers that gave us the most data during the last round, or that took the
+// most from us when seeding. One more slot rotates between the remaining
+// interested peers so newcomers get a chance to prove themselves.
+type Choker struct {
+	// Slots is the number of regular upload slots
+	Slots int
+	// Seeding reports whether we have the whole torrent; peers are then
+	// ranked by upload rate since they can't give us anything
+	Seeding func() bool
+
+	after func(time.Duration) <-chan time.Time
+	intn  func(n int) int
+
+	mu    sync.Mutex
+	peers map[Peer]*peerState
+	// order keeps the peers in the order they were added, so ties in rate
+	// go to the longest connected peer
+	order      []Peer
+	optimistic Peer
+	round      int
+}
+
+// New returns a choker with slots regular upload slots
+func New(slots int, seeding func() bool) *Choker {
+	return &Choker{
+		Slots:   slots,
+		Seeding: seeding,
+		after:   time.After,
+		intn:    rand.IntN,
+		peers:   make(map[Peer]*peerState),
+	}
+}
+
+// Add starts tracking a peer. Peers start out choked.
+func (c *Choker) Add(p Peer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.peers[p]; ok {
+		return
+	}
+	up, down := p.Transferred()
+	c.peers[p] = &peerState{choked: true, uploaded: up, downloaded: down}
+	c.order = append(c.order, p)
+}
+
+// Remove stops tracking a peer and frees its slot
+func (c *Choker) Remove(p Peer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.peers, p)
+	for i, q := range c.order {
+		if q == p {
+			c.order = append(c.order[:i], c.order[i+1:]...)
+			break
+		}
+	}
+	if c.optimistic == p {
+		c.optimistic = nil
+	}
+}
+
+// Choked tells whether the choker currently chokes a peer
+func (c *Choker) Choked(p Peer) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st, ok := c.peers[p]
+	return !ok || st.choked
+}
+
+// Run re-evaluates the slots every RechokeInterval and rotates the
+// optimistic unchoke every OptimisticInterval until the context is cancelled
+func (c *Choker) Run(ctx context.Context) {
+	rounds := int(OptimisticInterval / RechokeInterval)
+	for {
+		c.mu.Lock()
+		rotate := c.round%rounds == 0
+		c.round++
+		c.mu.Unlock()
+		c.Rechoke(rotate)
+
+		select {
+		case <-c.after(RechokeInterval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Rechoke runs a single round. When rotate is set, a new optimistic unchoke
+// is picked as well.
+func (c *Choker) Rechoke(rotate bool) {
+	c.mu.Lock()
+	seeding := c.Seeding != nil && c.Seeding()
+
+	var interested []Peer
+	for _, p := range c.order {
+		st := c.peers[p]
+		up, down := p.Transferred()
+		if seeding {
+			st.rate = up - st.uploaded
+		} else {
+			st.rate = down - st.downloaded
+		}
+		st.uploaded, st.downloaded = up, down
+		if p.Interested() {
+			interested = append(interested, p)
+		}
+	}
+	sort.SliceStable(interested, func(i, j int) bool {
+		return c.peers[interested[i]].rate > c.peers[interested[j]].rate
+	})
+
+	unchoke := make(map[Peer]bool, c.Slots+1)
+	for _, p := range interested {
+		if len(unchoke) == c.Slots {
+			break
+		}
+		unchoke[p] = true
+	}
+
+	// The optimistic slot is kept between rotations unless its peer earned a
+	// regular slot, lost interest or left
+	if c.optimistic != nil && (unchoke[c.optimistic] || !c.optimistic.Interested()) {
+		c.optimistic = nil
+	}
+	if rotate || c.optimistic == nil {
+		var candidates []Peer
+		for _, p := range interested {
+			if !unchoke[p] {
+				candidates = append(candidates, p)
+			}
+		}
+		c.optimistic = nil
+		if len(candidates) > 0 {
+			c.optimistic = candidates[c.intn(len(candidates))]
+		}
+	}
+	if c.optimistic != nil {
+		unchoke[c.optimistic] = true
+	}
+
+	var toChoke, toUnchoke []Peer
+	for _, p := range c.order {
+		st := c.peers[p]
+		switch {
+		case unchoke[p] && st.choked:
+			st.choked = false
+			toUnchoke = append(toUnchoke, p)
+		case !unchoke[p] && !st.choked:
+			st.choked = true
+			toChoke = append(toChoke, p)
+		}
+	}
+	c.mu.Unlock()
+
+	// Messages are sent without holding the lock; a failed send means the
+	// connection is going away and the peer will be removed
+	for _, p := range toChoke {
+		_ = p.Choke()
+	}
+	for _, p := range toUnchoke {
+		_ = p.Unchoke()
+	}
+}
@@ -0,0 +1,205 @@
+package choker
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakePeer records the choke state the choker sent to it
+type fakePeer struct {
+	mu         sync.Mutex
+	name       string
+	interested bool
+	uploaded   int64
+	downloaded int64
+	choked     bool
+	messages   int
+}
+
+func newFakePeer(name string, interested bool) *fakePeer {
+	return &fakePeer{name: name, interested: interested, choked: true}
+}
+
+func (p *fakePeer) Interested() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.interested
+}
+
+func (p *fakePeer) Transferred() (int64, int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.uploaded, p.downloaded
+}
+
+func (p *fakePeer) Choke() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.choked = true
+	p.messages++
+	return nil
+}
+
+func (p *fakePeer) Unchoke() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.choked = false
+	p.messages++
+	return nil
+}
+
+func (p *fakePeer) isChoked() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.choked
+}
+
+func (p *fakePeer) transfer(up, down int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.uploaded += up
+	p.downloaded += down
+}
+
+// manualClock replaces time.After so rounds run when the test says so
+type manualClock struct {
+	tick chan time.Time
+	wait chan time.Duration
+}
+
+func newManualClock() *manualClock {
+	return &manualClock{tick: make(chan time.Time), wait: make(chan time.Duration)}
+}
+
+func (c *manualClock) after(d time.Duration) <-chan time.Time {
+	c.wait <- d
+	return c.tick
+}
+
+// unchoked returns the names of the peers that are currently unchoked
+func unchoked(ps ...*fakePeer) []string {
+	var names []string
+	for _, p := range ps {
+		if !p.isChoked() {
+			names = append(names, p.name)
+		}
+	}
+	return names
+}
+
+func TestRechokeByDownloadRate(t *testing.T) {
+	a, b, c, d := newFakePeer("a", true), newFakePeer("b", true), newFakePeer("c", true), newFakePeer("d", false)
+	ch := New(2, nil)
+	ch.intn = func(n int) int { return 0 }
+	for _, p := range []*fakePeer{a, b, c, d} {
+		ch.Add(p)
+	}
+
+	a.transfer(0, 100)
+	b.transfer(0, 300)
+	c.transfer(0, 200)
+	d.transfer(0, 900)
+	ch.Rechoke(true)
+
+	// b and c have the best rates among the interested peers; a is the
+	// only candidate left for the optimistic slot
+	assert.Equal(t, []string{"a", "b", "c"}, unchoked(a, b, c, d))
+	assert.False(t, ch.Choked(a))
+	assert.True(t, ch.Choked(d))
+
+	// Rates are measured per round, not over the whole session
+	a.transfer(0, 1000)
+	ch.Rechoke(false)
+	assert.Equal(t, []string{"a", "b", "c"}, unchoked(a, b, c, d))
+	assert.True(t, ch.optimistic == Peer(b) || ch.optimistic == Peer(c))
+}
+
+func TestRechokeByUploadRateWhenSeeding(t *testing.T) {
+	a, b, c := newFakePeer("a", true), newFakePeer("b", true), newFakePeer("c", true)
+	ch := New(1, func() bool { return true })
+	ch.intn = func(n int) int { return n - 1 }
+	for _, p := range []*fakePeer{a, b, c} {
+		ch.Add(p)
+	}
+
+	a.transfer(500, 0)
+	b.transfer(10, 900)
+	c.transfer(20, 0)
+	ch.Rechoke(true)
+
+	assert.False(t, a.isChoked(), "best uploader keeps the regular slot")
+	assert.Equal(t, 2, len(unchoked(a, b, c)))
+}
+
+func TestRechokeChokesPeersThatLeaveTheSlots(t *testing.T) {
+	a, b := newFakePeer("a", true), newFakePeer("b", true)
+	ch := New(1, nil)
+	ch.intn = func(n int) int { return 0 }
+	ch.Add(a)
+	ch.Add(b)
+
+	a.transfer(0, 100)
+	ch.Rechoke(true)
+	assert.Equal(t, []string{"a", "b"}, unchoked(a, b))
+
+	b.mu.Lock()
+	b.interested = false
+	b.mu.Unlock()
+	ch.Rechoke(false)
+	assert.Equal(t, []string{"a"}, unchoked(a, b))
+
+	ch.Remove(a)
+	ch.Rechoke(false)
+	assert.True(t, ch.Choked(a))
+	assert.Equal(t, 2, b.messages, "b was unchoked then choked once")
+}
+
+func TestRunRotatesOptimisticUnchoke(t *testing.T) {
+	regular := newFakePeer("regular", true)
+	others := []*fakePeer{newFakePeer("x", true), newFakePeer("y", true), newFakePeer("z", true)}
+	clock := newManualClock()
+	ch := New(1, nil)
+	ch.after = clock.after
+	next := 0
+	ch.intn = func(n int) int {
+		next++
+		return (next - 1) % n
+	}
+	ch.Add(regular)
+	regular.transfer(0, 100)
+	for _, p := range others {
+		ch.Add(p)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ch.Run(ctx)
+	}()
+
+	var optimistic []Peer
+	for round := 0; round < 6; round++ {
+		assert.Equal(t, RechokeInterval, <-clock.wait)
+		ch.mu.Lock()
+		optimistic = append(optimistic, ch.optimistic)
+		ch.mu.Unlock()
+		regular.transfer(0, 100)
+		if round < 5 {
+			clock.tick <- time.Now()
+		}
+	}
+	cancel()
+	<-done
+
+	// The optimistic slot only changes every third round
+	assert.Equal(t, optimistic[0], optimistic[1])
+	assert.Equal(t, optimistic[0], optimistic[2])
+	assert.NotEqual(t, optimistic[2], optimistic[3])
+	assert.Equal(t, optimistic[3], optimistic[5])
+	assert.False(t, regular.isChoked())
+}
@@ -3,46 +3,122 @@ package client
 import (
 	"Torrentasaurus_Rex/internal/bitfields"
 	"Torrentasaurus_Rex/internal/handshake"
+	"Torrentasaurus_Rex/internal/message"
+	"Torrentasaurus_Rex/internal/mse"
 	"Torrentasaurus_Rex/internal/peers"
+	"context"
 	"net"
-	"time"
+	"sync"
 )
 
 type Client struct {
 	Conn     net.Conn
 	Choked   bool
 	Bitfield bitfields.Bitfield
+	// Extensions tells whether both sides announced the extension protocol
+	Extensions bool
+	// Fast tells whether both sides announced the Fast Extension
+	Fast bool
+	// V2 tells whether both sides announced BitTorrent v2, so hashes can
+	// be requested
+	V2       bool
 	peer     peers.Peer
 	infoHash [20]byte
 	peerID   [20]byte
+	// first is a message that arrived in place of the bitfield
+	first *message.Message
+	// writeMu lets other workers send cancels while this one sends requests
+	writeMu sync.Mutex
 }
 
-// New connects with a peer, completes a handshake, and receives a handshake
-// returns an err if any of those fail.
-func New(peer peers.Peer, peerID, infoHash [20]byte) (*Client, error) {
-	conn, err := net.DialTimeout("tcp", peer.String(), 3*time.Second)
+// New connects with a peer, sends our handshake req, and receives the peer's
+// handshake and the pieces it has. It returns an err if any of those fail.
+// The reserved bits of req announce the protocol extensions we support,
+// numPieces is the number of pieces of the torrent and encryption decides
+// whether the connection is encrypted. dial opens the connection, over TCP
+// when it is nil.
+func New(peer peers.Peer, req *handshake.Handshake, numPieces int, encryption mse.Policy, dial mse.DialFunc) (*Client, error) {
+	conn, err := mse.Dial(context.Background(), dial, peer.String(), req.InfoHash, encryption)
 	if err != nil {
 		return nil, err
 	}
 
-	_, err = handshake.CompleteHandshake(conn, infoHash, peerID)
+	res, err := handshake.Initiate(conn, req)
 	if err != nil {
 		conn.Close()
 		return nil, err
 	}
 
-	bf, err := bitfields.RecvBitfield(conn)
+	fast := req.SupportsFast() && res.SupportsFast()
+	bf, first, err := bitfields.RecvBitfield(conn, numPieces, fast)
 	if err != nil {
 		conn.Close()
 		return nil, err
 	}
 
 	return &Client{
-		Conn:     conn,
-		Choked:   true,
-		Bitfield: bf,
-		peer:     peer,
-		infoHash: infoHash,
-		peerID:   peerID,
+		Conn:       conn,
+		Choked:     true,
+		Bitfield:   bf,
+		Extensions: req.SupportsExtensions() && res.SupportsExtensions(),
+		Fast:       fast,
+		V2:         req.SupportsV2() && res.SupportsV2(),
+		peer:       peer,
+		infoHash:   req.InfoHash,
+		peerID:     req.PeerID,
+		first:      first,
 	}, nil
 }
+
+// Read reads and consumes a message from the connection
+func (c *Client) Read() (*message.Message, error) {
+	if msg := c.first; msg != nil {
+		c.first = nil
+		return msg, nil
+	}
+	return message.Read(c.Conn)
+}
+
+// SendRequest sends a Request message to the peer
+func (c *Client) SendRequest(index, begin, length int) error {
+	return c.Send(message.FormatRequest(index, begin, length))
+}
+
+// SendCancel sends a Cancel message for a previously requested block
+func (c *Client) SendCancel(index, begin, length int) error {
+	return c.Send(message.FormatCancel(index, begin, length))
+}
+
+// SendInterested sends an Interested message to the peer
+func (c *Client) SendInterested() error {
+	return c.Send(&message.Message{ID: message.MsgInterested})
+}
+
+// SendNotInterested sends a NotInterested message to the peer
+func (c *Client) SendNotInterested() error {
+	return c.Send(&message.Message{ID: message.MsgNotInterested})
+}
+
+// SendUnchoke sends an Unchoke message to the peer
+func (c *Client) SendUnchoke() error {
+	return c.Send(&message.Message{ID: message.MsgUnchoke})
+}
+
+// SendHave sends a Have message to the peer
+func (c *Client) SendHave(index int) error {
+	return c.Send(message.FormatHave(index))
+}
+
+// SendHashRequest asks the peer for hashes of the tree of a file
+func (c *Client) SendHashRequest(req message.HashRequest) error {
+	return c.Send(message.FormatHashRequest(req))
+}
+
+// Send serializes a message and writes it to the connection. It is safe
+// for concurrent use.
+func (c *Client) Send(msg *message.Message) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_, err := c.Conn.Write(msg.Serialize())
+	return err
+}
@@ -0,0 +1,450 @@
+// Package dht implements a node of the mainline DHT (BEP 5), which finds
+// peers for torrents without a tracker.
+package dht
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"Torrentasaurus_Rex/internal/peers"
+)
+
+const (
+	// queryTimeout bounds the wait for the answer to a query
+	queryTimeout = 2 * time.Second
+	// tokenRotation is how often the secret behind announce tokens changes.
+	// Tokens of the previous secret are still accepted.
+	tokenRotation = 5 * time.Minute
+	// peerTTL is how long an announced peer is kept
+	peerTTL = 30 * time.Minute
+	// maxValues bounds the peers returned for a get_peers query so the
+	// response fits in a datagram
+	maxValues = 50
+	// maxPacketSize is the largest datagram we read
+	maxPacketSize = 8192
+)
+
+// DefaultBootstrapNodes are well-known nodes to join the network through
+var DefaultBootstrapNodes = []string{
+	"router.bittorrent.com:6881",
+	"dht.transmissionbt.com:6881",
+	"router.utorrent.com:6881",
+}
+
+var ErrClosed = errors.New("dht closed")
+
+// DHT is a node of the DHT. It answers queries from other nodes while it
+// serves and looks up and announces peers for torrents.
+type DHT struct {
+	// BootstrapNodes lists host:port addresses of nodes to join the network through
+	BootstrapNodes []string
+
+	id      ID
+	conn    net.PacketConn
+	table   *table
+	timeout time.Duration
+	now     func() time.Time
+
+	mu      sync.Mutex
+	pending map[string]*transaction
+	nextTx  uint16
+	closed  bool
+	// secret and prevSecret generate the announce tokens we hand out
+	secret, prevSecret [16]byte
+	rotated            time.Time
+	// store holds the peers announced to us by info hash and address
+	store map[ID]map[string]storedPeer
+}
+
+// transaction is a query waiting for its answer
+type transaction struct {
+	addr *net.UDPAddr
+	res  chan *message
+}
+
+// storedPeer is a peer announced to us
+type storedPeer struct {
+	peer  peers.Peer
+	added time.Time
+}
+
+// Listen opens a UDP socket on addr for a node with the given ID
+func Listen(addr string, id ID) (*DHT, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	d, err := New(conn, id)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+// New runs a node with the given ID on conn, which may be shared with other
+// protocols such as uTP. Addresses read from conn must be UDP addresses.
+func New(conn net.PacketConn, id ID) (*DHT, error) {
+	d := &DHT{
+		BootstrapNodes: DefaultBootstrapNodes,
+		id:             id,
+		conn:           conn,
+		table:          newTable(id),
+		timeout:        queryTimeout,
+		now:            time.Now,
+		pending:        make(map[string]*transaction),
+		store:          make(map[ID]map[string]storedPeer),
+	}
+	if _, err := rand.Read(d.secret[:]); err != nil {
+		return nil, err
+	}
+	d.prevSecret = d.secret
+	d.rotated = d.now()
+	return d, nil
+}
+
+// ID returns the ID of the node
+func (d *DHT) ID() ID {
+	return d.id
+}
+
+// Addr returns the address the node listens on
+func (d *DHT) Addr() *net.UDPAddr {
+	return d.conn.LocalAddr().(*net.UDPAddr)
+}
+
+// Nodes returns the nodes in the routing table
+func (d *DHT) Nodes() []Node {
+	return d.table.nodes()
+}
+
+// AddNodes adds nodes to the routing table, for example the ones saved in an
+// earlier session
+func (d *DHT) AddNodes(nodes []Node) {
+	for _, n := range nodes {
+		d.table.insert(n)
+	}
+}
+
+// Serve reads and answers messages until the context is cancelled or the
+// node is closed
+func (d *DHT) Serve(ctx context.Context) error {
+	stop := context.AfterFunc(ctx, func() { d.Close() })
+	defer stop()
+
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, from, err := d.conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if d.isClosed() {
+				return ErrClosed
+			}
+			return err
+		}
+		addr, ok := from.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+		msg, err := decodeMessage(buf[:n])
+		if err != nil {
+			continue
+		}
+		switch msg.Y {
+		case typeQuery:
+			d.handleQuery(msg, addr)
+		default:
+			d.handleReply(msg, addr)
+		}
+	}
+}
+
+// Close closes the socket; pending queries fail
+func (d *DHT) Close() error {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return nil
+	}
+	d.closed = true
+	d.mu.Unlock()
+	return d.conn.Close()
+}
+
+func (d *DHT) isClosed() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.closed
+}
+
+// handleReply passes a response or error to the query waiting for it
+func (d *DHT) handleReply(msg *message, addr *net.UDPAddr) {
+	d.mu.Lock()
+	tx, ok := d.pending[msg.T]
+	if ok && tx.addr.IP.Equal(addr.IP) && tx.addr.Port == addr.Port {
+		delete(d.pending, msg.T)
+	} else {
+		ok = false
+	}
+	d.mu.Unlock()
+	if !ok {
+		return
+	}
+	if msg.Y == typeResponse {
+		d.table.insert(Node{ID: msg.senderID(), Addr: addr})
+	}
+	tx.res <- msg
+}
+
+// handleQuery answers a query from another node
+func (d *DHT) handleQuery(msg *message, addr *net.UDPAddr) {
+	d.table.insert(Node{ID: msg.senderID(), Addr: addr})
+
+	res := &response{ID: string(d.id[:])}
+	switch msg.Q {
+	case methodPing:
+	case methodFindNode:
+		var target ID
+		if len(msg.A.Target) != len(target) {
+			d.send(errorMessage(msg.T, ErrCodeProtocol, "invalid target"), addr)
+			return
+		}
+		copy(target[:], msg.A.Target)
+		res.Nodes = string(encodeNodes(d.table.closest(target, K)))
+	case methodGetPeers:
+		var infoHash ID
+		if len(msg.A.InfoHash) != len(infoHash) {
+			d.send(errorMessage(msg.T, ErrCodeProtocol, "invalid info_hash"), addr)
+			return
+		}
+		copy(infoHash[:], msg.A.InfoHash)
+		res.Token = d.token(addr.IP, d.currentSecret())
+		if values := d.storedPeers(infoHash); len(values) > 0 {
+			res.Values = values
+		} else {
+			res.Nodes = string(encodeNodes(d.table.closest(infoHash, K)))
+		}
+	case methodAnnouncePeer:
+		var infoHash ID
+		if len(msg.A.InfoHash) != len(infoHash) {
+			d.send(errorMessage(msg.T, ErrCodeProtocol, "invalid info_hash"), addr)
+			return
+		}
+		copy(infoHash[:], msg.A.InfoHash)
+		if !d.validToken(msg.A.Token, addr.IP) {
+			d.send(errorMessage(msg.T, ErrCodeProtocol, "bad token"), addr)
+			return
+		}
+		port := msg.A.Port
+		if msg.A.ImpliedPort == 1 {
+			port = addr.Port
+		}
+		if port <= 0 || port > 65535 {
+			d.send(errorMessage(msg.T, ErrCodeProtocol, "invalid port"), addr)
+			return
+		}
+		d.storePeer(infoHash, peers.Peer{IP: addr.IP, Port: uint16(port)})
+	default:
+		d.send(errorMessage(msg.T, ErrCodeMethodUnknown, "method unknown"), addr)
+		return
+	}
+	d.send(&message{T: msg.T, Y: typeResponse, R: res}, addr)
+}
+
+// send writes a message to addr
+func (d *DHT) send(msg *message, addr *net.UDPAddr) error {
+	data, err := encodeMessage(msg)
+	if err != nil {
+		return err
+	}
+	_, err = d.conn.WriteTo(data, addr)
+	return err
+}
+
+// query sends a query to addr and waits for the response
+func (d *DHT) query(ctx context.Context, addr *net.UDPAddr, method string, args *arguments) (*response, error) {
+	args.ID = string(d.id[:])
+	tx := &transaction{addr: addr, res: make(chan *message, 1)}
+
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return nil, ErrClosed
+	}
+	var t string
+	for {
+		d.nextTx++
+		t = string(binary.BigEndian.AppendUint16(nil, d.nextTx))
+		if _, taken := d.pending[t]; !taken {
+			break
+		}
+	}
+	d.pending[t] = tx
+	d.mu.Unlock()
+	defer func() {
+		d.mu.Lock()
+		delete(d.pending, t)
+		d.mu.Unlock()
+	}()
+
+	if err := d.send(&message{T: t, Y: typeQuery, Q: method, A: args}, addr); err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(d.timeout)
+	defer timer.Stop()
+	select {
+	case msg := <-tx.res:
+		if msg.Y == typeError {
+			return nil, msg.krpcError()
+		}
+		return msg.R, nil
+	case <-timer.C:
+		return nil, fmt.Errorf("%s query to %s timed out", method, addr)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Ping checks that a node is alive and returns its ID
+func (d *DHT) Ping(ctx context.Context, addr *net.UDPAddr) (ID, error) {
+	res, err := d.query(ctx, addr, methodPing, &arguments{})
+	if err != nil {
+		return ID{}, err
+	}
+	var id ID
+	copy(id[:], res.ID)
+	return id, nil
+}
+
+// FindNode asks a node for the nodes it knows closest to target
+func (d *DHT) FindNode(ctx context.Context, addr *net.UDPAddr, target ID) ([]Node, error) {
+	res, err := d.query(ctx, addr, methodFindNode, &arguments{Target: string(target[:])})
+	if err != nil {
+		return nil, err
+	}
+	return decodeNodes([]byte(res.Nodes))
+}
+
+// GetPeers asks a node for peers of a torrent. The node answers with the
+// peers it knows or with the nodes closest to the info hash, and with a
+// token for announcing ourselves to it.
+func (d *DHT) GetPeers(ctx context.Context, addr *net.UDPAddr, infoHash ID) (found []peers.Peer, nodes []Node, token string, err error) {
+	res, err := d.query(ctx, addr, methodGetPeers, &arguments{InfoHash: string(infoHash[:])})
+	if err != nil {
+		return nil, nil, "", err
+	}
+	for _, v := range res.Values {
+		list, err := peers.Unmarshal([]byte(v))
+		if err != nil {
+			continue
+		}
+		found = append(found, list...)
+	}
+	nodes, err = decodeNodes([]byte(res.Nodes))
+	if err != nil {
+		return nil, nil, "", err
+	}
+	return found, nodes, res.Token, nil
+}
+
+// AnnouncePeer tells a node that we accept connections for a torrent on
+// port. token must come from an earlier GetPeers to the same node.
+func (d *DHT) AnnouncePeer(ctx context.Context, addr *net.UDPAddr, infoHash ID, port int, token string) error {
+	_, err := d.query(ctx, addr, methodAnnouncePeer, &arguments{
+		InfoHash: string(infoHash[:]),
+		Port:     port,
+		Token:    token,
+	})
+	return err
+}
+
+// currentSecret returns the token secret, rotating it when it is due
+func (d *DHT) currentSecret() [16]byte {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.rotateSecret()
+	return d.secret
+}
+
+// rotateSecret replaces the secret once tokenRotation passed; the caller
+// must hold d.mu
+func (d *DHT) rotateSecret() {
+	if d.now().Sub(d.rotated) < tokenRotation {
+		return
+	}
+	d.prevSecret = d.secret
+	if _, err := rand.Read(d.secret[:]); err != nil {
+		log.Printf("Failed to rotate DHT token secret: %v", err)
+	}
+	d.rotated = d.now()
+}
+
+// token returns the announce token of an IP address for a secret
+func (d *DHT) token(ip net.IP, secret [16]byte) string {
+	h := sha1.New()
+	h.Write(secret[:])
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	h.Write(ip)
+	return string(h.Sum(nil)[:8])
+}
+
+// validToken tells whether a token was handed out to ip recently
+func (d *DHT) validToken(token string, ip net.IP) bool {
+	d.mu.Lock()
+	d.rotateSecret()
+	secret, prev := d.secret, d.prevSecret
+	d.mu.Unlock()
+	return token == d.token(ip, secret) || token == d.token(ip, prev)
+}
+
+// storePeer records a peer announced for a torrent
+func (d *DHT) storePeer(infoHash ID, p peers.Peer) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	stored, ok := d.store[infoHash]
+	if !ok {
+		stored = make(map[string]storedPeer)
+		d.store[infoHash] = stored
+	}
+	stored[p.String()] = storedPeer{peer: p, added: d.now()}
+}
+
+// storedPeers returns the announced peers of a torrent in compact form and
+// forgets the ones that expired
+func (d *DHT) storedPeers(infoHash ID) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var values []string
+	for key, sp := range d.store[infoHash] {
+		if d.now().Sub(sp.added) > peerTTL {
+			delete(d.store[infoHash], key)
+			continue
+		}
+		if len(values) < maxValues {
+			if compact := peers.Marshal([]peers.Peer{sp.peer}); len(compact) > 0 {
+				values = append(values, string(compact))
+			}
+		}
+	}
+	if len(d.store[infoHash]) == 0 {
+		delete(d.store, infoHash)
+	}
+	return values
+}
@@ -0,0 +1,176 @@
+package dht
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"Torrentasaurus_Rex/internal/utp"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startNode runs a node on loopback that bootstraps from the given nodes
+func startNode(t *testing.T, bootstrap ...*DHT) *DHT {
+	t.Helper()
+	id, err := RandomID()
+	require.NoError(t, err)
+	d, err := Listen("127.0.0.1:0", id)
+	require.NoError(t, err)
+	d.timeout = 200 * time.Millisecond
+	d.BootstrapNodes = nil
+	for _, b := range bootstrap {
+		d.BootstrapNodes = append(d.BootstrapNodes, b.Addr().String())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- d.Serve(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return d
+}
+
+// startNetwork starts n nodes that all join through the first one
+func startNetwork(t *testing.T, n int) []*DHT {
+	t.Helper()
+	nodes := []*DHT{startNode(t)}
+	for range n - 1 {
+		d := startNode(t, nodes[0])
+		require.NoError(t, d.Bootstrap(context.Background()))
+		nodes = append(nodes, d)
+	}
+	return nodes
+}
+
+func TestPingAndFindNode(t *testing.T) {
+	a, b := startNode(t), startNode(t)
+	ctx := context.Background()
+
+	id, err := a.Ping(ctx, b.Addr())
+	require.NoError(t, err)
+	assert.Equal(t, b.ID(), id)
+	// Both sides learned about each other
+	assert.Len(t, a.Nodes(), 1)
+	assert.Len(t, b.Nodes(), 1)
+
+	c := startNode(t)
+	_, err = c.Ping(ctx, b.Addr())
+	require.NoError(t, err)
+	nodes, err := a.FindNode(ctx, b.Addr(), c.ID())
+	require.NoError(t, err)
+	require.NotEmpty(t, nodes)
+	assert.Equal(t, c.ID(), nodes[0].ID)
+}
+
+func TestSharedSocket(t *testing.T) {
+	sock, err := utp.Listen("127.0.0.1:0")
+	require.NoError(t, err)
+	defer sock.Close()
+	id, err := RandomID()
+	require.NoError(t, err)
+	shared, err := New(sock.PacketConn(), id)
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- shared.Serve(ctx) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	other := startNode(t)
+	got, err := other.Ping(context.Background(), shared.Addr())
+	require.NoError(t, err)
+	assert.Equal(t, id, got)
+	got, err = shared.Ping(context.Background(), other.Addr())
+	require.NoError(t, err)
+	assert.Equal(t, other.ID(), got)
+}
+
+func TestAnnounceAndGetPeers(t *testing.T) {
+	nodes := startNetwork(t, 16)
+	ctx := context.Background()
+	infoHash, err := RandomID()
+	require.NoError(t, err)
+
+	found, err := nodes[3].Announce(ctx, infoHash, 51413)
+	require.NoError(t, err)
+	assert.Empty(t, found)
+
+	found, err = nodes[11].Peers(ctx, infoHash)
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+	assert.Equal(t, "127.0.0.1:51413", found[0].String())
+}
+
+func TestAnnouncePeerChecksToken(t *testing.T) {
+	a, b := startNode(t), startNode(t)
+	ctx := context.Background()
+	infoHash := ID{9}
+
+	err := a.AnnouncePeer(ctx, b.Addr(), infoHash, 6881, "forged")
+	var krpcErr *Error
+	require.True(t, errors.As(err, &krpcErr))
+	assert.Equal(t, ErrCodeProtocol, krpcErr.Code)
+
+	_, _, token, err := a.GetPeers(ctx, b.Addr(), infoHash)
+	require.NoError(t, err)
+	require.NoError(t, a.AnnouncePeer(ctx, b.Addr(), infoHash, 6881, token))
+	found, _, _, err := a.GetPeers(ctx, b.Addr(), infoHash)
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+	assert.Equal(t, uint16(6881), found[0].Port)
+
+	// Tokens stay valid for one rotation
+	b.mu.Lock()
+	b.rotated = b.rotated.Add(-tokenRotation)
+	b.mu.Unlock()
+	_, _, _, err = a.GetPeers(ctx, b.Addr(), infoHash)
+	require.NoError(t, err)
+	assert.NoError(t, a.AnnouncePeer(ctx, b.Addr(), infoHash, 6881, token))
+}
+
+func TestQueryTimeout(t *testing.T) {
+	a, b := startNode(t), startNode(t)
+	_, err := a.Ping(context.Background(), b.Addr())
+	require.NoError(t, err)
+	b.Close()
+
+	_, err = a.Ping(context.Background(), b.Addr())
+	assert.Error(t, err)
+}
+
+func TestBootstrapWithoutNodes(t *testing.T) {
+	d := startNode(t)
+	assert.ErrorIs(t, d.Bootstrap(context.Background()), ErrNoNodes)
+}
+
+func TestStatePersistence(t *testing.T) {
+	nodes := startNetwork(t, 4)
+	path := filepath.Join(t.TempDir(), "dht.dat")
+
+	_, err := LoadState(path)
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	st := nodes[1].State()
+	require.NotEmpty(t, st.Nodes)
+	require.NoError(t, SaveState(path, st))
+
+	loaded, err := LoadState(path)
+	require.NoError(t, err)
+	assert.Equal(t, nodes[1].ID(), loaded.ID)
+	assert.Len(t, loaded.Nodes, len(st.Nodes))
+
+	// A restarted node joins through the nodes it saved
+	restarted := startNode(t)
+	restarted.AddNodes(loaded.Nodes)
+	require.NoError(t, restarted.Bootstrap(context.Background()))
+	assert.GreaterOrEqual(t, len(restarted.Nodes()), len(nodes)-1)
+}
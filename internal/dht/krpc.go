@@ -0,0 +1,127 @@
+package dht
+
+import (
+	"errors"
+	"fmt"
+
+	"Torrentasaurus_Rex/internal/bencode"
+)
+
+// KRPC message types
+const (
+	typeQuery    = "q"
+	typeResponse = "r"
+	typeError    = "e"
+)
+
+// Query methods
+const (
+	methodPing         = "ping"
+	methodFindNode     = "find_node"
+	methodGetPeers     = "get_peers"
+	methodAnnouncePeer = "announce_peer"
+)
+
+// KRPC error codes
+const (
+	ErrCodeGeneric       = 201
+	ErrCodeServer        = 202
+	ErrCodeProtocol      = 203
+	ErrCodeMethodUnknown = 204
+)
+
+// Error is an error message returned by a node
+type Error struct {
+	Code    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("dht error %d: %s", e.Code, e.Message)
+}
+
+// message is a KRPC message: a query, a response or an error
+type message struct {
+	T string     `bencode:"t"`
+	Y string     `bencode:"y"`
+	Q string     `bencode:"q,omitempty"`
+	A *arguments `bencode:"a,omitempty"`
+	R *response  `bencode:"r,omitempty"`
+	E []any      `bencode:"e,omitempty"`
+}
+
+// arguments are the arguments of every query method
+type arguments struct {
+	ID          string `bencode:"id"`
+	Target      string `bencode:"target,omitempty"`
+	InfoHash    string `bencode:"info_hash,omitempty"`
+	Port        int    `bencode:"port,omitempty"`
+	Token       string `bencode:"token,omitempty"`
+	ImpliedPort int    `bencode:"implied_port,omitempty"`
+}
+
+// response holds the return values of every query method
+type response struct {
+	ID     string   `bencode:"id"`
+	Nodes  string   `bencode:"nodes,omitempty"`
+	Values []string `bencode:"values,omitempty"`
+	Token  string   `bencode:"token,omitempty"`
+}
+
+// decodeMessage parses and checks a KRPC message
+func decodeMessage(data []byte) (*message, error) {
+	var msg message
+	if err := bencode.UnmarshalLenient(data, &msg); err != nil {
+		return nil, fmt.Errorf("malformed KRPC message: %w", err)
+	}
+	switch msg.Y {
+	case typeQuery:
+		if msg.A == nil || len(msg.A.ID) != len(ID{}) {
+			return nil, errors.New("query without a valid node ID")
+		}
+	case typeResponse:
+		if msg.R == nil || len(msg.R.ID) != len(ID{}) {
+			return nil, errors.New("response without a valid node ID")
+		}
+	case typeError:
+	default:
+		return nil, fmt.Errorf("unknown KRPC message type %q", msg.Y)
+	}
+	return &msg, nil
+}
+
+// encodeMessage serializes a KRPC message
+func encodeMessage(msg *message) ([]byte, error) {
+	return bencode.Marshal(msg)
+}
+
+// errorMessage builds an error reply to the query with transaction id t
+func errorMessage(t string, code int, text string) *message {
+	return &message{T: t, Y: typeError, E: []any{code, text}}
+}
+
+// krpcError converts the e list of an error message
+func (msg *message) krpcError() error {
+	e := &Error{Code: ErrCodeGeneric, Message: "malformed error"}
+	if len(msg.E) == 2 {
+		if code, ok := msg.E[0].(int64); ok {
+			e.Code = int(code)
+		}
+		if text, ok := msg.E[1].(string); ok {
+			e.Message = text
+		}
+	}
+	return e
+}
+
+// senderID returns the ID of the node that sent a query or response
+func (msg *message) senderID() ID {
+	var id ID
+	switch {
+	case msg.A != nil:
+		copy(id[:], msg.A.ID)
+	case msg.R != nil:
+		copy(id[:], msg.R.ID)
+	}
+	return id
+}
@@ -0,0 +1,75 @@
+package dht
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageRoundTrip(t *testing.T) {
+	id := ID{1, 2, 3}
+	msg := &message{T: "aa", Y: typeQuery, Q: methodGetPeers, A: &arguments{ID: string(id[:]), InfoHash: string(make([]byte, 20))}}
+	data, err := encodeMessage(msg)
+	require.NoError(t, err)
+
+	decoded, err := decodeMessage(data)
+	require.NoError(t, err)
+	assert.Equal(t, msg, decoded)
+	assert.Equal(t, id, decoded.senderID())
+}
+
+func TestDecodeMessage(t *testing.T) {
+	tests := map[string]struct {
+		data  string
+		fails bool
+	}{
+		"ping query":       {data: "d1:ad2:id20:abcdefghij0123456789e1:q4:ping1:t2:aa1:y1:qe"},
+		"response":         {data: "d1:rd2:id20:mnopqrstuvwxyz123456e1:t2:aa1:y1:re"},
+		"error":            {data: "d1:eli201e23:A Generic Error Ocurrede1:t2:aa1:y1:ee"},
+		"short id":         {data: "d1:ad2:id3:abce1:q4:ping1:t2:aa1:y1:qe", fails: true},
+		"missing response": {data: "d1:t2:aa1:y1:re", fails: true},
+		"unknown type":     {data: "d1:t2:aa1:y1:xe", fails: true},
+		"not bencode":      {data: "garbage", fails: true},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := decodeMessage([]byte(test.data))
+			if test.fails {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestKRPCError(t *testing.T) {
+	data, err := encodeMessage(errorMessage("aa", ErrCodeMethodUnknown, "method unknown"))
+	require.NoError(t, err)
+	assert.Equal(t, "d1:eli204e14:method unknowne1:t2:aa1:y1:ee", string(data))
+
+	msg, err := decodeMessage(data)
+	require.NoError(t, err)
+	assert.Equal(t, &Error{Code: ErrCodeMethodUnknown, Message: "method unknown"}, msg.krpcError())
+}
+
+func TestCompactNodes(t *testing.T) {
+	nodes := []Node{
+		{ID: ID{1}, Addr: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 6881}},
+		{ID: ID{2}, Addr: &net.UDPAddr{IP: net.ParseIP("::1"), Port: 6882}},
+		{ID: ID{3}, Addr: &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 6883}},
+	}
+	buf := encodeNodes(nodes)
+	assert.Len(t, buf, 2*compactNodeSize)
+
+	decoded, err := decodeNodes(buf)
+	require.NoError(t, err)
+	require.Len(t, decoded, 2)
+	assert.Equal(t, ID{3}, decoded[1].ID)
+	assert.Equal(t, "10.0.0.1:6883", decoded[1].Addr.String())
+
+	_, err = decodeNodes(buf[1:])
+	assert.Error(t, err)
+}
@@ -0,0 +1,237 @@
+package dht
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"Torrentasaurus_Rex/internal/peers"
+)
+
+const (
+	// alpha is the number of queries a lookup keeps in flight
+	alpha = 3
+	// refreshInterval is how long a bucket may go without activity before
+	// it is refreshed with a lookup
+	refreshInterval = 15 * time.Minute
+)
+
+var ErrNoNodes = errors.New("no DHT nodes to query")
+
+// candidate is a node found during a lookup
+type candidate struct {
+	Node
+	queried   bool
+	responded bool
+	// token is the announce token returned by get_peers
+	token string
+}
+
+// lookupResult is the outcome of an iterative lookup
+type lookupResult struct {
+	peers []peers.Peer
+	// closest are the nodes closest to the target that answered, nearest
+	// first
+	closest []*candidate
+}
+
+// lookup walks the DHT towards target: it asks the closest nodes it knows
+// for closer ones until the K closest have answered. With getPeers it
+// collects the peers of the torrent target on the way.
+func (d *DHT) lookup(ctx context.Context, target ID, getPeers bool) (*lookupResult, error) {
+	start := d.table.closest(target, K)
+	if len(start) == 0 {
+		return nil, ErrNoNodes
+	}
+
+	var mu sync.Mutex
+	seen := make(map[string]bool)
+	found := make(map[string]bool)
+	var candidates []*candidate
+	result := &lookupResult{}
+	add := func(nodes []Node) {
+		for _, n := range nodes {
+			if n.ID == d.id || n.Addr.Port == 0 || seen[n.Addr.String()] {
+				continue
+			}
+			seen[n.Addr.String()] = true
+			candidates = append(candidates, &candidate{Node: n})
+		}
+		slices.SortFunc(candidates, func(a, b *candidate) int {
+			switch {
+			case closer(target, a.ID, b.ID):
+				return -1
+			case closer(target, b.ID, a.ID):
+				return 1
+			}
+			return 0
+		})
+	}
+	add(start)
+
+	for {
+		// The next round queries the closest nodes not asked yet, as long
+		// as they are among the K closest that may still answer
+		var round []*candidate
+		live := 0
+		for _, c := range candidates {
+			if live == K || len(round) == alpha {
+				break
+			}
+			if c.queried && !c.responded {
+				continue
+			}
+			live++
+			if !c.queried {
+				c.queried = true
+				round = append(round, c)
+			}
+		}
+		if len(round) == 0 {
+			break
+		}
+
+		var wg sync.WaitGroup
+		for _, c := range round {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				nodes, values, token, err := d.lookupQuery(ctx, c.Addr, target, getPeers)
+				if err != nil {
+					d.table.failed(c.ID)
+					return
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				c.responded = true
+				c.token = token
+				for _, p := range values {
+					if !found[p.String()] {
+						found[p.String()] = true
+						result.peers = append(result.peers, p)
+					}
+				}
+				add(nodes)
+			}()
+		}
+		wg.Wait()
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, c := range candidates {
+		if len(result.closest) == K {
+			break
+		}
+		if c.responded {
+			result.closest = append(result.closest, c)
+		}
+	}
+	return result, nil
+}
+
+// lookupQuery sends the query of a lookup step to a node
+func (d *DHT) lookupQuery(ctx context.Context, addr *net.UDPAddr, target ID, getPeers bool) ([]Node, []peers.Peer, string, error) {
+	if getPeers {
+		found, nodes, token, err := d.GetPeers(ctx, addr, target)
+		return nodes, found, token, err
+	}
+	nodes, err := d.FindNode(ctx, addr, target)
+	return nodes, nil, "", err
+}
+
+// Bootstrap joins the network: it pings the bootstrap nodes and then looks
+// up our own ID to fill the routing table with our neighbours
+func (d *DHT) Bootstrap(ctx context.Context) error {
+	var wg sync.WaitGroup
+	for _, host := range d.BootstrapNodes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			addr, err := net.ResolveUDPAddr("udp", host)
+			if err != nil {
+				log.Printf("Ignoring DHT bootstrap node %s: %v", host, err)
+				return
+			}
+			// A successful ping adds the node to the routing table
+			d.Ping(ctx, addr)
+		}()
+	}
+	wg.Wait()
+
+	if _, err := d.lookup(ctx, d.id, false); err != nil {
+		return fmt.Errorf("failed to bootstrap DHT: %w", err)
+	}
+	return nil
+}
+
+// Peers looks up the peers of a torrent
+func (d *DHT) Peers(ctx context.Context, infoHash [20]byte) ([]peers.Peer, error) {
+	res, err := d.lookup(ctx, infoHash, true)
+	if err != nil {
+		return nil, err
+	}
+	return res.peers, nil
+}
+
+// Announce looks up the peers of a torrent and announces that we accept
+// connections for it on port to the nodes closest to its info hash
+func (d *DHT) Announce(ctx context.Context, infoHash [20]byte, port int) ([]peers.Peer, error) {
+	res, err := d.lookup(ctx, infoHash, true)
+	if err != nil {
+		return nil, err
+	}
+	var wg sync.WaitGroup
+	for _, c := range res.closest {
+		if c.token == "" {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.AnnouncePeer(ctx, c.Addr, infoHash, port, c.token)
+		}()
+	}
+	wg.Wait()
+	return res.peers, nil
+}
+
+// Run keeps the routing table fresh until the context is cancelled: buckets
+// without activity for refreshInterval are refreshed with a lookup of a
+// random ID that falls into them
+func (d *DHT) Run(ctx context.Context) {
+	ticker := time.NewTicker(refreshInterval / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for _, i := range d.table.stale(d.now().Add(-refreshInterval)) {
+				d.lookup(ctx, randomIDInBucket(d.id, i), false)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// randomIDInBucket returns a random ID that shares exactly prefix leading
+// bits with self
+func randomIDInBucket(self ID, prefix int) ID {
+	id, err := RandomID()
+	if err != nil {
+		return self
+	}
+	for i := 0; i < prefix; i++ {
+		mask := byte(0x80) >> (i % 8)
+		id[i/8] = id[i/8]&^mask | self[i/8]&mask
+	}
+	mask := byte(0x80) >> (prefix % 8)
+	id[prefix/8] = id[prefix/8]&^mask | ^self[prefix/8]&mask
+	return id
+}
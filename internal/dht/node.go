@@ -0,0 +1,96 @@
+package dht
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"math/bits"
+	"net"
+)
+
+// compactNodeSize is the size of a node in compact form: its ID, an IPv4
+// address and a port
+const compactNodeSize = 20 + net.IPv4len + 2
+
+// ID identifies a node and, in the same space, a torrent by its info hash
+type ID [20]byte
+
+// RandomID returns a random node ID
+func RandomID() (ID, error) {
+	var id ID
+	if _, err := rand.Read(id[:]); err != nil {
+		return ID{}, errors.New("failed to generate node ID: " + err.Error())
+	}
+	return id, nil
+}
+
+func (id ID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// distance returns the XOR distance between two IDs
+func distance(a, b ID) ID {
+	var d ID
+	for i := range d {
+		d[i] = a[i] ^ b[i]
+	}
+	return d
+}
+
+// closer tells whether a is closer to target than b
+func closer(target, a, b ID) bool {
+	da, db := distance(target, a), distance(target, b)
+	return bytes.Compare(da[:], db[:]) < 0
+}
+
+// commonPrefixLen returns the number of leading bits two IDs share
+func commonPrefixLen(a, b ID) int {
+	d := distance(a, b)
+	for i, x := range d {
+		if x != 0 {
+			return i*8 + bits.LeadingZeros8(x)
+		}
+	}
+	return len(d) * 8
+}
+
+// Node is a DHT node we can send queries to
+type Node struct {
+	ID   ID
+	Addr *net.UDPAddr
+}
+
+// encodeNodes writes nodes in compact form; nodes without an IPv4 address
+// are left out
+func encodeNodes(nodes []Node) []byte {
+	buf := make([]byte, 0, len(nodes)*compactNodeSize)
+	for _, n := range nodes {
+		ip := n.Addr.IP.To4()
+		if ip == nil {
+			continue
+		}
+		buf = append(buf, n.ID[:]...)
+		buf = append(buf, ip...)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(n.Addr.Port))
+	}
+	return buf
+}
+
+// decodeNodes parses nodes in compact form
+func decodeNodes(buf []byte) ([]Node, error) {
+	if len(buf)%compactNodeSize != 0 {
+		return nil, errors.New("malformed compact nodes")
+	}
+	nodes := make([]Node, 0, len(buf)/compactNodeSize)
+	for off := 0; off < len(buf); off += compactNodeSize {
+		var n Node
+		copy(n.ID[:], buf[off:off+20])
+		ip := make(net.IP, net.IPv4len)
+		copy(ip, buf[off+20:off+24])
+		n.Addr = &net.UDPAddr{IP: ip, Port: int(binary.BigEndian.Uint16(buf[off+24 : off+26]))}
+		nodes = append(nodes, n)
+	}
+	return nodes, nil
+}
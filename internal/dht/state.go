@@ -0,0 +1,74 @@
+package dht
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"Torrentasaurus_Rex/internal/bencode"
+)
+
+// State is what a node remembers between runs: its ID, so other nodes keep
+// recognizing it, and the nodes of its routing table
+type State struct {
+	ID    ID
+	Nodes []Node
+}
+
+// bencodeState is the file format of a State
+type bencodeState struct {
+	ID    ID     `bencode:"id"`
+	Nodes []byte `bencode:"nodes"`
+}
+
+// State returns the ID and routing table of the node
+func (d *DHT) State() *State {
+	return &State{ID: d.id, Nodes: d.table.nodes()}
+}
+
+// LoadState reads a state file. The returned error wraps fs.ErrNotExist when
+// there is none.
+func LoadState(path string) (*State, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw bencodeState
+	if err := bencode.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse DHT state %s: %w", path, err)
+	}
+	nodes, err := decodeNodes(raw.Nodes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse DHT state %s: %w", path, err)
+	}
+	return &State{ID: raw.ID, Nodes: nodes}, nil
+}
+
+// SaveState writes a state file, replacing the old one atomically
+func SaveState(path string, st *State) error {
+	data, err := bencode.Marshal(bencodeState{ID: st.ID, Nodes: encodeNodes(st.Nodes)})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	// The data must reach the disk before the rename does
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,149 @@
+package dht
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// K is the size of a bucket and the number of nodes a lookup converges on
+	K = 8
+	// maxFailures is the number of unanswered queries after which a node
+	// may be replaced by a newcomer
+	maxFailures = 2
+)
+
+// entry is a node in the routing table
+type entry struct {
+	Node
+	lastSeen time.Time
+	failures int
+}
+
+// table is a Kademlia routing table. Nodes are kept in one bucket per
+// length of the prefix they share with our ID, so there is more room for
+// nodes close to us. Each bucket holds the least recently seen node first.
+type table struct {
+	self ID
+	now  func() time.Time
+
+	mu      sync.Mutex
+	buckets [len(ID{}) * 8][]*entry
+}
+
+func newTable(self ID) *table {
+	return &table{self: self, now: time.Now}
+}
+
+// bucket returns the index of the bucket for id, or -1 for our own ID
+func (t *table) bucket(id ID) int {
+	prefix := commonPrefixLen(t.self, id)
+	if prefix == len(t.buckets) {
+		return -1
+	}
+	return prefix
+}
+
+// insert records that a node was seen. A known node moves to the end of its
+// bucket. A new node takes a free slot or replaces a node that stopped
+// answering, and is dropped otherwise.
+func (t *table) insert(n Node) bool {
+	i := t.bucket(n.ID)
+	if i < 0 {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	b := t.buckets[i]
+	for j, e := range b {
+		if e.ID == n.ID {
+			e.Addr = n.Addr
+			e.lastSeen = t.now()
+			e.failures = 0
+			t.buckets[i] = append(slices.Delete(b, j, j+1), e)
+			return true
+		}
+	}
+	e := &entry{Node: n, lastSeen: t.now()}
+	if len(b) < K {
+		t.buckets[i] = append(b, e)
+		return true
+	}
+	for j, old := range b {
+		if old.failures >= maxFailures {
+			t.buckets[i] = append(slices.Delete(b, j, j+1), e)
+			return true
+		}
+	}
+	return false
+}
+
+// failed records that a node didn't answer a query
+func (t *table) failed(id ID) {
+	i := t.bucket(id)
+	if i < 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, e := range t.buckets[i] {
+		if e.ID == id {
+			e.failures++
+			return
+		}
+	}
+}
+
+// closest returns up to k good nodes ordered by distance to target
+func (t *table) closest(target ID, k int) []Node {
+	t.mu.Lock()
+	var nodes []Node
+	for _, b := range t.buckets {
+		for _, e := range b {
+			if e.failures < maxFailures {
+				nodes = append(nodes, e.Node)
+			}
+		}
+	}
+	t.mu.Unlock()
+
+	slices.SortFunc(nodes, func(a, b Node) int {
+		switch {
+		case closer(target, a.ID, b.ID):
+			return -1
+		case closer(target, b.ID, a.ID):
+			return 1
+		}
+		return 0
+	})
+	return nodes[:min(k, len(nodes))]
+}
+
+// nodes returns every node in the table
+func (t *table) nodes() []Node {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var nodes []Node
+	for _, b := range t.buckets {
+		for _, e := range b {
+			nodes = append(nodes, e.Node)
+		}
+	}
+	return nodes
+}
+
+// stale returns the indexes of non-empty buckets that saw no node since
+// before
+func (t *table) stale(before time.Time) []int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var stale []int
+	for i, b := range t.buckets {
+		if len(b) > 0 && b[len(b)-1].lastSeen.Before(before) {
+			stale = append(stale, i)
+		}
+	}
+	return stale
+}
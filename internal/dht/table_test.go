@@ -0,0 +1,80 @@
+package dht
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// idWithPrefix returns an ID sharing prefix leading bits with self and
+// differing in its last byte by n
+func idWithPrefix(self ID, prefix int, n byte) ID {
+	id := randomIDInBucket(self, prefix)
+	id[19] = n
+	return id
+}
+
+func testNode(id ID, port int) Node {
+	return Node{ID: id, Addr: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}}
+}
+
+func TestCommonPrefixLen(t *testing.T) {
+	assert.Equal(t, 160, commonPrefixLen(ID{1}, ID{1}))
+	assert.Equal(t, 0, commonPrefixLen(ID{0x80}, ID{}))
+	assert.Equal(t, 7, commonPrefixLen(ID{0x01}, ID{}))
+	assert.Equal(t, 12, commonPrefixLen(ID{0, 0x08}, ID{}))
+	for prefix := range 160 {
+		assert.Equal(t, prefix, commonPrefixLen(ID{0xaa}, randomIDInBucket(ID{0xaa}, prefix)))
+	}
+}
+
+func TestTableBucketLimit(t *testing.T) {
+	self := ID{}
+	tbl := newTable(self)
+	assert.False(t, tbl.insert(testNode(self, 1)))
+
+	for i := range K {
+		assert.True(t, tbl.insert(testNode(idWithPrefix(self, 0, byte(i)), i)))
+	}
+	newcomer := testNode(idWithPrefix(self, 0, 100), 100)
+	assert.False(t, tbl.insert(newcomer), "full bucket of good nodes")
+
+	// A node that stopped answering makes room
+	first := tbl.buckets[0][0].ID
+	for range maxFailures {
+		tbl.failed(first)
+	}
+	assert.True(t, tbl.insert(newcomer))
+	assert.Len(t, tbl.nodes(), K)
+	for _, n := range tbl.nodes() {
+		assert.NotEqual(t, first, n.ID)
+	}
+
+	// Other buckets are independent
+	assert.True(t, tbl.insert(testNode(idWithPrefix(self, 5, 1), 200)))
+}
+
+func TestTableInsertMovesKnownNodeToEnd(t *testing.T) {
+	self := ID{}
+	tbl := newTable(self)
+	a, b := idWithPrefix(self, 3, 1), idWithPrefix(self, 3, 2)
+	tbl.insert(testNode(a, 1))
+	tbl.insert(testNode(b, 2))
+	tbl.insert(testNode(a, 3))
+
+	bucket := tbl.buckets[3]
+	assert.Equal(t, b, bucket[0].ID)
+	assert.Equal(t, a, bucket[1].ID)
+	assert.Equal(t, 3, bucket[1].Addr.Port)
+}
+
+func TestTableClosest(t *testing.T) {
+	tbl := newTable(ID{})
+	for i := 1; i <= 20; i++ {
+		tbl.insert(testNode(ID{byte(i)}, i))
+	}
+	closest := tbl.closest(ID{0x10}, 3)
+	assert.Equal(t, []ID{{0x10}, {0x11}, {0x12}}, []ID{closest[0].ID, closest[1].ID, closest[2].ID})
+	assert.Len(t, tbl.closest(ID{}, 100), 20)
+}
@@ -6,6 +6,10 @@ func (e *Exchange) calculateBoundsForPiece(index int) (begin int, end int) {
 	if end > e.Length {
 		end = e.Length
 	}
+	// A piece of a v2-only torrent ends with its file
+	if len(e.PieceHashes) == 0 && index < len(e.PiecesV2) {
+		end = begin + e.PiecesV2[index].Length
+	}
 	return begin, end
 }
 
@@ -13,3 +17,24 @@ func (e *Exchange) calculatePieceSize(index int) int {
 	begin, end := e.calculateBoundsForPiece(index)
 	return end - begin
 }
+
+// numPieces returns the number of pieces of the torrent
+func (e *Exchange) numPieces() int {
+	if len(e.PieceHashes) > 0 {
+		return len(e.PieceHashes)
+	}
+	return len(e.PiecesV2)
+}
+
+// dataLength returns the number of bytes in the pieces, which for a
+// v2-only torrent leaves out the padding between files
+func (e *Exchange) dataLength() int {
+	if len(e.PieceHashes) > 0 {
+		return e.Length
+	}
+	length := 0
+	for _, p := range e.PiecesV2 {
+		length += p.Length
+	}
+	return length
+}
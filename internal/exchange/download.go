@@ -1 +1,544 @@
 package exchange
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"Torrentasaurus_Rex/internal/client"
+	"Torrentasaurus_Rex/internal/extension"
+	"Torrentasaurus_Rex/internal/handshake"
+	"Torrentasaurus_Rex/internal/message"
+	"Torrentasaurus_Rex/internal/peers"
+)
+
+const (
+	// MaxBlockSize is the largest number of bytes a request can ask for
+	MaxBlockSize = 16384
+	// MaxBacklog is the number of unfulfilled requests a client can have in its pipeline
+	MaxBacklog = 5
+	// pieceTimeout bounds the time a single piece download may take
+	pieceTimeout = 30 * time.Second
+	// idleRecheckInterval is how often a worker without work looks at the
+	// picker anyway, which catches changes the picker doesn't report, like
+	// the start of endgame
+	idleRecheckInterval = time.Second
+)
+
+var (
+	ErrNoOutput  = errors.New("exchange has no output")
+	ErrNoPeers   = errors.New("no peers left to download from")
+	ErrIntegrity = errors.New("piece failed integrity check")
+
+	// errChoked stops downloading a piece when the peer choked us and none
+	// of our requests for it are left
+	errChoked = errors.New("choked")
+)
+
+// pieceResult holds the verified data of a downloaded piece
+type pieceResult struct {
+	index int
+	buf   []byte
+}
+
+// Download fetches every piece from the peers, verifies it and writes it to
+// the output. It returns once all pieces are written, the context is
+// cancelled, or every peer connection has failed.
+func (e *Exchange) Download(ctx context.Context) error {
+	if e.Output == nil {
+		return ErrNoOutput
+	}
+	log.Printf("Starting download for %s", e.Name)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Pieces restored from an earlier run are not downloaded again
+	picker := NewPiecePicker(e.numPieces())
+	donePieces := 0
+	for index := range e.numPieces() {
+		if e.HasPiece(index) {
+			picker.Done(index)
+			donePieces++
+		}
+	}
+
+	results := make(chan *pieceResult)
+	s := newSession(ctx, picker, results)
+	e.mu.Lock()
+	e.session = s
+	s.addWebSeeds(e, e.WebSeeds)
+	s.addPeers(e, e.Peers)
+	e.mu.Unlock()
+	defer func() {
+		e.mu.Lock()
+		e.session = nil
+		e.mu.Unlock()
+	}()
+
+	for donePieces < e.numPieces() {
+		select {
+		case res := <-results:
+			if e.HasPiece(res.index) {
+				// A web seed and a peer both completed the piece
+				continue
+			}
+			if _, err := e.Output.WriteAt(res.index, res.buf, 0); err != nil {
+				return fmt.Errorf("failed to write piece #%d: %w", res.index, err)
+			}
+			if err := e.Output.MarkComplete(res.index); err != nil {
+				return fmt.Errorf("failed to complete piece #%d: %w", res.index, err)
+			}
+			e.completed.Add(int64(len(res.buf)))
+			e.markHave(res.index)
+			donePieces++
+
+			percent := float64(donePieces) / float64(e.numPieces()) * 100
+			log.Printf("(%0.2f%%) Downloaded piece #%d", percent, res.index)
+		case <-s.idle:
+			if s.activeWorkers() == 0 {
+				return fmt.Errorf("%w: %d of %d pieces missing", ErrNoPeers, e.numPieces()-donePieces, e.numPieces())
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// AddPeers adds peers to download from. While a download runs, a worker is
+// started for every peer that isn't connected yet.
+func (e *Exchange) AddPeers(found []peers.Peer) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.session != nil {
+		e.session.addPeers(e, found)
+		return
+	}
+	known := make(map[string]bool, len(e.Peers))
+	for _, p := range e.Peers {
+		known[p.String()] = true
+	}
+	for _, p := range found {
+		if !known[p.String()] {
+			known[p.String()] = true
+			e.Peers = append(e.Peers, p)
+		}
+	}
+}
+
+// Wasted reports the bytes downloaded more than once, mostly duplicate
+// blocks received during endgame
+func (e *Exchange) Wasted() int64 {
+	return e.wasted.Load()
+}
+
+// Transferred reports the bytes uploaded and downloaded in this session and
+// the bytes still missing
+func (e *Exchange) Transferred() (uploaded, downloaded, left int64) {
+	return e.uploaded.Load(), e.downloaded.Load(), int64(e.dataLength()) - e.completed.Load()
+}
+
+// worker downloads pieces from a single peer
+type worker struct {
+	e    *Exchange
+	s    *session
+	peer peers.Peer
+	c    *client.Client
+	// ext is the extension state of the connection, nil when the peer
+	// doesn't support the extension protocol
+	ext *extension.Peer
+	// received counts the bytes downloaded from the peer's host
+	received *atomic.Int64
+	// failed holds pieces that failed the integrity check; they are not
+	// asked from this peer again
+	failed map[int]bool
+	// allowed holds the pieces the peer lets us request while choked
+	allowed map[int]bool
+	// suggested holds the pieces the peer recommends, oldest first
+	suggested []int
+
+	msgs chan *message.Message
+	errs chan error
+	// wake is signalled when another worker cancels one of our requests or
+	// completes the piece we work on
+	wake chan struct{}
+}
+
+// startWorker connects to a peer and downloads the pieces the picker hands
+// out until the context is cancelled or the connection fails
+func (e *Exchange) startWorker(s *session, peer peers.Peer) {
+	// The worker's own context also stops its read loop when it gives up
+	ctx, cancel := context.WithCancel(s.ctx)
+	defer cancel()
+	req := &handshake.Handshake{
+		Pstr:     handshake.ProtocolName,
+		InfoHash: e.InfoHash,
+		PeerID:   e.PeerID,
+	}
+	if e.Extensions != nil {
+		req.SetExtensions()
+	}
+	if e.Fast {
+		req.SetFast()
+	}
+	if e.PiecesV2 != nil {
+		req.SetV2()
+	}
+	c, err := client.New(peer, req, e.numPieces(), e.Encryption, e.Dial)
+	if err != nil {
+		log.Printf("Could not handshake with %s: %v", peer, err)
+		return
+	}
+	defer c.Conn.Close()
+	stop := context.AfterFunc(ctx, func() { c.Conn.Close() })
+	defer stop()
+	log.Printf("Completed handshake with %s", peer)
+
+	w := &worker{
+		e:       e,
+		s:       s,
+		peer:    peer,
+		c:       c,
+		failed:  make(map[int]bool),
+		allowed: make(map[int]bool),
+		msgs:    make(chan *message.Message),
+		errs:    make(chan error, 1),
+		wake:    make(chan struct{}, 1),
+	}
+	e.mu.Lock()
+	w.received = e.receivedFrom(c.Conn.RemoteAddr())
+	e.mu.Unlock()
+
+	s.picker.AddBitfield(c.Bitfield)
+	defer func() { s.picker.RemoveBitfield(c.Bitfield) }()
+
+	if c.Extensions {
+		w.ext = e.Extensions.NewPeer(c.Send)
+		if err := w.ext.SendHandshake(); err != nil {
+			return
+		}
+	}
+	if e.PEX != nil {
+		remove := e.PEX.Add(w.ext, peer, true)
+		defer remove()
+	}
+
+	if err := c.SendUnchoke(); err != nil {
+		return
+	}
+	if err := c.SendInterested(); err != nil {
+		return
+	}
+
+	go w.readLoop(ctx)
+	if err := w.run(ctx); err != nil && ctx.Err() == nil {
+		log.Printf("Dropping %s: %v", peer, err)
+	}
+}
+
+// readLoop reads messages from the peer so the worker can wait for them and
+// for other workers at the same time
+func (w *worker) readLoop(ctx context.Context) {
+	for {
+		msg, err := w.c.Read()
+		if err != nil {
+			w.errs <- err
+			return
+		}
+		select {
+		case w.msgs <- msg:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// run downloads pieces until none are left
+func (w *worker) run(ctx context.Context) error {
+	// While choked, only the pieces the peer allows can be downloaded
+	wanted := func(index int) bool {
+		return w.c.Bitfield.HasPiece(index) && !w.failed[index] && (!w.c.Choked || w.allowed[index])
+	}
+
+	for {
+		// Taken before picking, so a change made meanwhile isn't missed
+		changed := w.s.picker.Changed()
+		p, endgame := w.next(wanted)
+		if p == nil {
+			if w.s.picker.Remaining() == 0 {
+				return nil
+			}
+			if err := w.waitForWork(ctx, changed); err != nil {
+				return err
+			}
+			continue
+		}
+
+		p.join(w.c, w.wake)
+		if err := w.download(ctx, p, endgame); err != nil {
+			// The piece goes back to the picker, keeping its blocks, so
+			// other peers can finish it
+			w.s.release(p, w.c)
+			if errors.Is(err, errChoked) {
+				continue
+			}
+			return err
+		}
+		if !p.claim() {
+			// Another worker completed the piece during endgame
+			p.leave(w.c)
+			continue
+		}
+		p.leave(w.c)
+		w.s.forget(p.index)
+
+		if err := w.e.checkPiece(p.index, p.buf); err != nil {
+			log.Printf("Piece #%d from %s: %v", p.index, w.peer, err)
+			w.failed[p.index] = true
+			w.s.picker.Abort(p.index, false)
+			continue
+		}
+
+		_ = w.c.SendHave(p.index)
+		select {
+		case w.s.results <- &pieceResult{index: p.index, buf: p.buf}:
+			w.s.picker.Done(p.index)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// next picks the piece to work on. Once every piece has been handed out,
+// endgame starts and pieces other workers are still downloading are
+// requested from this peer too.
+func (w *worker) next(wanted func(int) bool) (p *pieceProgress, endgame bool) {
+	for len(w.suggested) > 0 {
+		index := w.suggested[0]
+		w.suggested = w.suggested[1:]
+		if wanted(index) && w.s.picker.PickIndex(index) {
+			return w.s.progress(index, w.e.calculatePieceSize(index)), false
+		}
+	}
+	if index, ok := w.s.picker.Pick(wanted); ok {
+		return w.s.progress(index, w.e.calculatePieceSize(index)), false
+	}
+	if w.s.picker.Pending() > 0 {
+		return nil, false
+	}
+	p = w.s.endgamePiece(w.c, wanted)
+	return p, p != nil
+}
+
+// waitForWork waits while the peer has no piece we can download from it:
+// until the peer unchokes us or announces a piece, or a piece is put back or
+// done elsewhere. Other messages that arrive meanwhile are still handled.
+func (w *worker) waitForWork(ctx context.Context, changed <-chan struct{}) error {
+	timer := time.NewTimer(idleRecheckInterval)
+	defer timer.Stop()
+	for {
+		select {
+		case msg := <-w.msgs:
+			if err := w.handle(nil, msg); err != nil {
+				return err
+			}
+			if msg != nil {
+				switch msg.ID {
+				case message.MsgUnchoke, message.MsgHave, message.MsgAllowedFast, message.MsgSuggest:
+					return nil
+				}
+			}
+		case <-changed:
+			return nil
+		case err := <-w.errs:
+			return err
+		case <-timer.C:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// download pipelines requests for the blocks of the piece that are still
+// missing until every block arrived, from this peer or another one. It
+// returns errChoked when the peer choked us and won't answer any of our
+// requests for the piece.
+func (w *worker) download(ctx context.Context, p *pieceProgress, endgame bool) error {
+	// A timeout helps get unresponsive peers unstuck.
+	timeout := time.NewTimer(pieceTimeout)
+	defer timeout.Stop()
+	if err := w.requestLeaves(p); err != nil {
+		return err
+	}
+
+	for !p.complete() {
+		// If unchoked, or the piece is allowed fast, send requests until we
+		// have enough unfulfilled requests
+		if !w.c.Choked || w.allowed[p.index] {
+			for _, block := range p.nextRequests(w.c, endgame) {
+				begin, length := p.blockBounds(block)
+				if err := w.c.SendRequest(p.index, begin, length); err != nil {
+					return err
+				}
+			}
+		}
+
+		select {
+		case msg := <-w.msgs:
+			if err := w.handle(p, msg); err != nil {
+				return err
+			}
+		case err := <-w.errs:
+			return err
+		case <-w.wake:
+		case <-timeout.C:
+			return fmt.Errorf("timed out downloading piece #%d", p.index)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if w.c.Choked && !w.allowed[p.index] && !p.requested(w.c) {
+			return errChoked
+		}
+	}
+	return nil
+}
+
+// handle updates the state of the connection and of the piece in progress
+// for a single message. p is nil while the worker has no piece.
+func (w *worker) handle(p *pieceProgress, msg *message.Message) error {
+	if msg == nil { // keep-alive
+		return nil
+	}
+
+	switch msg.ID {
+	case message.MsgUnchoke:
+		w.c.Choked = false
+	case message.MsgChoke:
+		w.c.Choked = true
+		// Without the Fast Extension a choke discards every pending request.
+		// With it, the peer rejects those it won't answer.
+		if p != nil && !w.c.Fast {
+			p.choked(w.c)
+		}
+	case message.MsgHave:
+		index, err := message.ParseHave(msg)
+		if err != nil {
+			return err
+		}
+		if !w.c.Bitfield.HasPiece(index) {
+			w.c.Bitfield.SetPiece(index)
+			w.s.picker.AddHave(index)
+		}
+	case message.MsgPiece:
+		return w.receive(p, msg)
+	case message.MsgReject, message.MsgAllowedFast, message.MsgSuggest, message.MsgHaveAll, message.MsgHaveNone:
+		if !w.c.Fast {
+			return fmt.Errorf("unexpected message ID %d without the Fast Extension", msg.ID)
+		}
+		return w.handleFast(p, msg)
+	case message.MsgExtended:
+		if w.ext != nil {
+			return w.ext.Handle(msg.Payload)
+		}
+	case message.MsgHashes:
+		return w.receiveHashes(p, msg)
+	}
+	return nil
+}
+
+// handleFast handles the messages of the Fast Extension
+func (w *worker) handleFast(p *pieceProgress, msg *message.Message) error {
+	switch msg.ID {
+	case message.MsgReject:
+		index, begin, _, err := message.ParseReject(msg)
+		if err != nil {
+			return err
+		}
+		// The block can be requested again, from this peer or another one
+		if p != nil && index == p.index {
+			p.rejected(w.c, begin)
+		}
+	case message.MsgAllowedFast:
+		index, err := message.ParseAllowedFast(msg)
+		if err != nil {
+			return err
+		}
+		if index >= 0 && index < w.e.numPieces() {
+			w.allowed[index] = true
+		}
+	case message.MsgSuggest:
+		index, err := message.ParseSuggest(msg)
+		if err != nil {
+			return err
+		}
+		if index >= 0 && index < w.e.numPieces() {
+			if len(w.suggested) == maxSuggestions {
+				w.suggested = w.suggested[1:]
+			}
+			w.suggested = append(w.suggested, index)
+		}
+	default:
+		// Have All and Have None may only replace the bitfield
+		return fmt.Errorf("unexpected message ID %d after the bitfield", msg.ID)
+	}
+	return nil
+}
+
+// receive stores a block and cancels the duplicate requests other workers
+// sent for it during endgame
+func (w *worker) receive(p *pieceProgress, msg *message.Message) error {
+	if len(msg.Payload) < 8 {
+		return fmt.Errorf("payload too short. %d < 8", len(msg.Payload))
+	}
+	index := int(binary.BigEndian.Uint32(msg.Payload[0:4]))
+	begin := int(binary.BigEndian.Uint32(msg.Payload[4:8]))
+	if p == nil || index != p.index {
+		// A block for a piece we moved on from, sent before our cancel arrived
+		w.count(len(msg.Payload)-8, true)
+		return nil
+	}
+
+	n, duplicate, cancels, err := p.receive(w.c, msg)
+	if err != nil {
+		return err
+	}
+	w.count(n, duplicate)
+	for _, cancel := range cancels {
+		if cancel.client != nil {
+			_ = cancel.client.SendCancel(index, begin, n)
+		}
+		wakeUp(cancel.wake)
+	}
+	return nil
+}
+
+// count records downloaded bytes; wasted bytes are blocks we already had
+func (w *worker) count(n int, wasted bool) {
+	w.e.downloaded.Add(int64(n))
+	w.received.Add(int64(n))
+	if wasted {
+		w.e.wasted.Add(int64(n))
+	}
+}
+
+// checkPiece compares the SHA-1 of a downloaded piece with its expected
+// hash, or for a v2-only torrent checks it against the tree of its file
+func (e *Exchange) checkPiece(index int, buf []byte) error {
+	var ok bool
+	if len(e.PieceHashes) > 0 {
+		ok = sha1.Sum(buf) == e.PieceHashes[index]
+	} else {
+		ok = e.PiecesV2[index].Verify(buf)
+	}
+	if !ok {
+		return fmt.Errorf("%w: index %d", ErrIntegrity, index)
+	}
+	return nil
+}
@@ -0,0 +1,394 @@
+package exchange
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/binary"
+	"io"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"Torrentasaurus_Rex/internal/client"
+	"Torrentasaurus_Rex/internal/handshake"
+	"Torrentasaurus_Rex/internal/merkle"
+	"Torrentasaurus_Rex/internal/message"
+	"Torrentasaurus_Rex/internal/peers"
+	"Torrentasaurus_Rex/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// output returns the data an exchange created by newTestExchange wrote
+func output(e *Exchange) []byte {
+	return e.Output.(*storage.Memory).Bytes()
+}
+
+// fakeSeeder serves every piece of data to anyone that connects to it
+type fakeSeeder struct {
+	ln       net.Listener
+	infoHash [20]byte
+	data     []byte
+	pieceLen int
+	corrupt  bool
+	// stall makes the seeder swallow requests without answering
+	stall bool
+	// fast makes the seeder use the Fast Extension: it sends Have All and
+	// keeps peers choked, serving only the allowed pieces and rejecting
+	// other requests
+	fast    bool
+	allowed []int
+	// chokeOnce makes an unchoking seeder choke the peer and reject the
+	// first requests of the first piece before unchoking it again
+	chokeOnce bool
+	// v2 makes the seeder announce BitTorrent v2 and answer requests for
+	// the block hashes of data, taken as a single file
+	v2 bool
+
+	mu           sync.Mutex
+	requests     int
+	cancels      int
+	rejects      int
+	hashRequests int
+}
+
+// counts returns the number of requests and cancels the seeder received
+func (s *fakeSeeder) counts() (requests, cancels int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests, s.cancels
+}
+
+func startFakeSeeder(t *testing.T, infoHash [20]byte, data []byte, pieceLen int, corrupt bool) (*fakeSeeder, peers.Peer) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := &fakeSeeder{ln: ln, infoHash: infoHash, data: data, pieceLen: pieceLen, corrupt: corrupt}
+	go s.serve()
+	t.Cleanup(func() { ln.Close() })
+
+	addr := ln.Addr().(*net.TCPAddr)
+	return s, peers.Peer{IP: addr.IP, Port: uint16(addr.Port)}
+}
+
+func (s *fakeSeeder) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeSeeder) handle(conn net.Conn) {
+	defer conn.Close()
+
+	hs := make([]byte, 1+len(handshake.ProtocolName)+handshake.FixedHeaderSize)
+	if _, err := io.ReadFull(conn, hs); err != nil {
+		return
+	}
+	s.mu.Lock()
+	fast, chokeOnce, v2 := s.fast, s.chokeOnce, s.v2
+	s.mu.Unlock()
+	reserved := hs[1+len(handshake.ProtocolName) : 1+len(handshake.ProtocolName)+handshake.ReservedBytesSize]
+	clear(reserved)
+	if fast || chokeOnce {
+		reserved[7] = 0x04
+	}
+	if v2 {
+		reserved[7] |= 0x10
+	}
+	copy(hs[1+len(handshake.ProtocolName)+handshake.ReservedBytesSize:], s.infoHash[:])
+	if _, err := conn.Write(hs); err != nil {
+		return
+	}
+
+	numPieces := (len(s.data) + s.pieceLen - 1) / s.pieceLen
+	if fast {
+		conn.Write((&message.Message{ID: message.MsgHaveAll}).Serialize())
+		for _, index := range s.allowed {
+			conn.Write(message.FormatAllowedFast(index).Serialize())
+		}
+	} else {
+		bf := make([]byte, (numPieces+7)/8)
+		for i := 0; i < numPieces; i++ {
+			bf[i/8] |= 1 << uint(7-i%8)
+		}
+		conn.Write((&message.Message{ID: message.MsgBitfield, Payload: bf}).Serialize())
+		conn.Write((&message.Message{ID: message.MsgUnchoke}).Serialize())
+	}
+	blocksPerPiece := (s.pieceLen + MaxBlockSize - 1) / MaxBlockSize
+
+	for {
+		msg, err := message.Read(conn)
+		if err != nil {
+			return
+		}
+		if msg == nil {
+			continue
+		}
+		s.mu.Lock()
+		switch msg.ID {
+		case message.MsgRequest:
+			s.requests++
+		case message.MsgCancel:
+			s.cancels++
+		}
+		stall := s.stall
+		s.mu.Unlock()
+		if msg.ID == message.MsgHashRequest && v2 {
+			s.serveLeaves(conn, msg)
+			continue
+		}
+		if msg.ID != message.MsgRequest || stall {
+			continue
+		}
+		index := int(binary.BigEndian.Uint32(msg.Payload[0:4]))
+		begin := int(binary.BigEndian.Uint32(msg.Payload[4:8]))
+		length := int(binary.BigEndian.Uint32(msg.Payload[8:12]))
+		if (fast && !slices.Contains(s.allowed, index)) || (chokeOnce && s.rejects < blocksPerPiece) {
+			if chokeOnce && s.rejects == 0 {
+				conn.Write((&message.Message{ID: message.MsgChoke}).Serialize())
+			}
+			conn.Write(message.FormatReject(index, begin, length).Serialize())
+			s.mu.Lock()
+			s.rejects++
+			s.mu.Unlock()
+			if chokeOnce && s.rejects == blocksPerPiece {
+				conn.Write((&message.Message{ID: message.MsgUnchoke}).Serialize())
+			}
+			continue
+		}
+
+		offset := index*s.pieceLen + begin
+		payload := make([]byte, 8+length)
+		copy(payload[0:8], msg.Payload[0:8])
+		copy(payload[8:], s.data[offset:offset+length])
+		if s.corrupt {
+			payload[8] ^= 0xff
+		}
+		conn.Write((&message.Message{ID: message.MsgPiece, Payload: payload}).Serialize())
+	}
+}
+
+// serveLeaves answers a request for block hashes of the data
+func (s *fakeSeeder) serveLeaves(conn net.Conn, msg *message.Message) {
+	s.mu.Lock()
+	s.hashRequests++
+	s.mu.Unlock()
+	req, err := message.ParseHashRequest(msg)
+	if err != nil {
+		return
+	}
+	leaves := merkle.BlockHashes(s.data)
+	hashes := make([][32]byte, req.Length)
+	for i := range hashes {
+		if req.Index+i < len(leaves) {
+			hashes[i] = leaves[req.Index+i]
+		}
+	}
+	conn.Write(message.FormatHashes(req, hashes).Serialize())
+}
+
+func newTestExchange(data []byte, pieceLen int) *Exchange {
+	var hashes [][20]byte
+	for begin := 0; begin < len(data); begin += pieceLen {
+		end := begin + pieceLen
+		if end > len(data) {
+			end = len(data)
+		}
+		hashes = append(hashes, sha1.Sum(data[begin:end]))
+	}
+	return &Exchange{
+		PeerID:      [20]byte{1, 2, 3},
+		InfoHash:    [20]byte{4, 5, 6},
+		PieceHashes: hashes,
+		PieceLength: pieceLen,
+		Length:      len(data),
+		Name:        "test",
+		Output:      storage.NewMemory(pieceLen, len(data)),
+	}
+}
+
+func testData(size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	return data
+}
+
+func TestDownload(t *testing.T) {
+	data := testData(3*MaxBlockSize*2 + 1234)
+	e := newTestExchange(data, 2*MaxBlockSize)
+	_, peer1 := startFakeSeeder(t, e.InfoHash, data, e.PieceLength, false)
+	_, peer2 := startFakeSeeder(t, e.InfoHash, data, e.PieceLength, false)
+	e.Peers = []peers.Peer{peer1, peer2}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	require.NoError(t, e.Download(ctx))
+	assert.Equal(t, data, output(e))
+}
+
+func TestDownloadRequeuesCorruptPieces(t *testing.T) {
+	data := testData(4 * MaxBlockSize)
+	e := newTestExchange(data, MaxBlockSize)
+	_, bad := startFakeSeeder(t, e.InfoHash, data, e.PieceLength, true)
+	_, good := startFakeSeeder(t, e.InfoHash, data, e.PieceLength, false)
+	e.Peers = []peers.Peer{bad, good}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	require.NoError(t, e.Download(ctx))
+	assert.Equal(t, data, output(e))
+}
+
+func TestDownloadEndgame(t *testing.T) {
+	data := testData(4 * MaxBlockSize)
+	e := newTestExchange(data, MaxBlockSize)
+	slow, slowPeer := startFakeSeeder(t, e.InfoHash, data, e.PieceLength, false)
+	slow.mu.Lock()
+	slow.stall = true
+	slow.mu.Unlock()
+	_, fast := startFakeSeeder(t, e.InfoHash, data, e.PieceLength, false)
+	e.Peers = []peers.Peer{slowPeer}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- e.Download(ctx) }()
+
+	// Once the stalled peer holds a piece, the fast one joins and takes over
+	// that piece in endgame, well before the piece timeout
+	require.Eventually(t, func() bool {
+		requests, _ := slow.counts()
+		return requests > 0
+	}, 5*time.Second, time.Millisecond)
+	e.AddPeers([]peers.Peer{fast})
+
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(pieceTimeout / 2):
+		t.Fatal("download stalled on the slow peer")
+	}
+	assert.Equal(t, data, output(e))
+	assert.Eventually(t, func() bool {
+		_, cancels := slow.counts()
+		return cancels > 0
+	}, time.Second, time.Millisecond, "duplicate requests to the slow peer are cancelled")
+}
+
+func TestDownloadWhileChoked(t *testing.T) {
+	data := testData(4 * MaxBlockSize)
+	e := newTestExchange(data, MaxBlockSize)
+	e.Fast = true
+	seeder, peer := startFakeSeeder(t, e.InfoHash, data, e.PieceLength, false)
+	seeder.mu.Lock()
+	seeder.fast = true
+	seeder.allowed = []int{0, 1, 2, 3}
+	seeder.mu.Unlock()
+	e.Peers = []peers.Peer{peer}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	require.NoError(t, e.Download(ctx))
+	assert.Equal(t, data, output(e))
+	seeder.mu.Lock()
+	defer seeder.mu.Unlock()
+	assert.Zero(t, seeder.rejects)
+}
+
+func TestDownloadOnlyRequestsAllowedPiecesWhileChoked(t *testing.T) {
+	data := testData(4 * MaxBlockSize)
+	e := newTestExchange(data, MaxBlockSize)
+	e.Fast = true
+	choking, chokingPeer := startFakeSeeder(t, e.InfoHash, data, e.PieceLength, false)
+	choking.mu.Lock()
+	choking.fast = true
+	choking.allowed = []int{2}
+	choking.mu.Unlock()
+	_, peer := startFakeSeeder(t, e.InfoHash, data, e.PieceLength, false)
+	e.Peers = []peers.Peer{chokingPeer, peer}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	require.NoError(t, e.Download(ctx))
+	assert.Equal(t, data, output(e))
+	choking.mu.Lock()
+	defer choking.mu.Unlock()
+	assert.Zero(t, choking.rejects)
+}
+
+func TestDownloadRejectedRequests(t *testing.T) {
+	data := testData(4 * MaxBlockSize)
+	e := newTestExchange(data, 2*MaxBlockSize)
+	e.Fast = true
+	seeder, peer := startFakeSeeder(t, e.InfoHash, data, e.PieceLength, false)
+	seeder.mu.Lock()
+	seeder.chokeOnce = true
+	seeder.mu.Unlock()
+	e.Peers = []peers.Peer{peer}
+
+	// The rejected piece goes back to the picker and is downloaded once the
+	// peer unchokes us again, well before the piece timeout
+	ctx, cancel := context.WithTimeout(context.Background(), pieceTimeout/2)
+	defer cancel()
+	require.NoError(t, e.Download(ctx))
+	assert.Equal(t, data, output(e))
+	requests, _ := seeder.counts()
+	seeder.mu.Lock()
+	defer seeder.mu.Unlock()
+	assert.Equal(t, 2, seeder.rejects)
+	assert.Equal(t, 6, requests)
+}
+
+func TestDownloadCountsWastedBlocks(t *testing.T) {
+	data := testData(2 * MaxBlockSize)
+	e := newTestExchange(data, 2*MaxBlockSize)
+	s := newSession(context.Background(), NewPiecePicker(1), nil)
+	w := &worker{e: e, s: s, c: &client.Client{}, received: new(atomic.Int64)}
+	p := newPieceProgress(0, 2*MaxBlockSize)
+	block := message.FormatPiece(0, 0, data[:MaxBlockSize])
+
+	// The same block twice, then one for a piece the worker moved on from
+	require.NoError(t, w.receive(p, block))
+	require.NoError(t, w.receive(p, block))
+	require.NoError(t, w.receive(nil, message.FormatPiece(0, MaxBlockSize, data[MaxBlockSize:])))
+	assert.Equal(t, int64(2*MaxBlockSize), e.Wasted())
+	assert.False(t, p.complete())
+}
+
+func TestDownloadNoPeers(t *testing.T) {
+	data := testData(MaxBlockSize)
+	e := newTestExchange(data, MaxBlockSize)
+	e.Peers = []peers.Peer{{IP: net.IPv4(127, 0, 0, 1), Port: 1}}
+
+	err := e.Download(context.Background())
+	assert.ErrorIs(t, err, ErrNoPeers)
+}
+
+func TestDownloadNoOutput(t *testing.T) {
+	e := &Exchange{}
+	assert.ErrorIs(t, e.Download(context.Background()), ErrNoOutput)
+}
+
+func TestCheckPiece(t *testing.T) {
+	buf := []byte("piece data")
+	e := &Exchange{PieceHashes: [][20]byte{{}, sha1.Sum(buf)}}
+	assert.NoError(t, e.checkPiece(1, buf))
+	assert.ErrorIs(t, e.checkPiece(1, []byte("other data")), ErrIntegrity)
+
+	// Without SHA-1 hashes, pieces are checked against the tree of their file
+	root := merkle.Root(buf)
+	e = &Exchange{PiecesV2: []merkle.Piece{{Root: root, Hash: root, Leaves: 1, Length: len(buf)}}}
+	assert.NoError(t, e.checkPiece(0, buf))
+	assert.ErrorIs(t, e.checkPiece(0, []byte("other data")), ErrIntegrity)
+}
@@ -1,6 +1,18 @@
 package exchange
 
-import "Torrentasaurus_Rex/internal/peers"
+import (
+	"Torrentasaurus_Rex/internal/bitfields"
+	"Torrentasaurus_Rex/internal/choker"
+	"Torrentasaurus_Rex/internal/extension"
+	"Torrentasaurus_Rex/internal/merkle"
+	"Torrentasaurus_Rex/internal/mse"
+	"Torrentasaurus_Rex/internal/peers"
+	"Torrentasaurus_Rex/internal/pex"
+	"Torrentasaurus_Rex/internal/storage"
+	"Torrentasaurus_Rex/internal/webseed"
+	"sync"
+	"sync/atomic"
+)
 
 // Exchange holds data required to download a torrent from a list of peers
 type Exchange struct {
@@ -11,4 +23,50 @@ type Exchange struct {
 	PieceLength int
 	Length      int
 	Name        string
+	// Output stores every verified piece; pieces are read back from it when
+	// uploading to other peers
+	Output storage.Backend
+	// Choker picks the peers we upload to. Without one, every interested
+	// peer is unchoked.
+	Choker *choker.Choker
+	// Extensions runs the extension protocol with peers that support it.
+	// Without a registry the protocol is not announced.
+	Extensions *extension.Registry
+	// PEX learns about the connections of the exchange and tells peers
+	// about them. Its ut_pex handler must be registered with Extensions.
+	PEX *pex.Swarm
+	// Fast announces the Fast Extension (BEP 6), which lets choked peers
+	// download a few pieces and makes requests that won't be answered
+	// rejected explicitly
+	Fast bool
+	// Encryption decides whether connections to peers are encrypted
+	Encryption mse.Policy
+	// Dial opens connections to peers, for example over uTP. Without it
+	// peers are dialled over TCP.
+	Dial mse.DialFunc
+	// WebSeeds are HTTP mirrors (BEP 19) downloaded from alongside the
+	// peers
+	WebSeeds []*webseed.Seed
+	// PiecesV2 verifies pieces with the hash trees of their files (BEP 52).
+	// Without PieceHashes, pieces are checked against it alone. Either way
+	// BitTorrent v2 is announced, and the leaf hashes requested from peers
+	// let bad blocks be caught as they arrive.
+	PiecesV2 []merkle.Piece
+
+	mu      sync.Mutex
+	session *session
+	// have marks the pieces verified and written to the output
+	have    bitfields.Bitfield
+	uploads map[*upload]struct{}
+	// received counts the bytes downloaded from each host
+	received map[string]*atomic.Int64
+	// trees holds the hash trees of the files by pieces root, built from
+	// PiecesV2 to answer hash requests
+	trees map[[32]byte]*merkle.Tree
+
+	// transfer counters reported to trackers
+	uploaded   atomic.Int64
+	downloaded atomic.Int64
+	completed  atomic.Int64
+	wasted     atomic.Int64
 }
@@ -0,0 +1,42 @@
+package exchange
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"net"
+)
+
+// AllowedFastSetSize is the number of pieces a choked peer may request from us
+const AllowedFastSetSize = 10
+
+// maxSuggestions bounds the Suggest hints kept for a peer
+const maxSuggestions = 16
+
+// allowedFastSet computes the pieces a peer at ip may download while choked,
+// as specified by BEP 6. The set only depends on the /24 network of the peer,
+// so reconnecting doesn't get it more pieces. There is none for IPv6 peers.
+func allowedFastSet(ip net.IP, infoHash [20]byte, numPieces, k int) []int {
+	ip4 := ip.To4()
+	if ip4 == nil || numPieces == 0 {
+		return nil
+	}
+	k = min(k, numPieces)
+
+	x := make([]byte, 0, 4+len(infoHash))
+	x = append(x, ip4[0], ip4[1], ip4[2], 0)
+	x = append(x, infoHash[:]...)
+	set := make([]int, 0, k)
+	seen := make(map[int]bool, k)
+	for len(set) < k {
+		sum := sha1.Sum(x)
+		x = sum[:]
+		for i := 0; i < 5 && len(set) < k; i++ {
+			index := int(binary.BigEndian.Uint32(x[i*4:]) % uint32(numPieces))
+			if !seen[index] {
+				seen[index] = true
+				set = append(set, index)
+			}
+		}
+	}
+	return set
+}
@@ -0,0 +1,24 @@
+package exchange
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAllowedFastSet(t *testing.T) {
+	// The example from BEP 6
+	var infoHash [20]byte
+	copy(infoHash[:], bytes.Repeat([]byte{0xaa}, 20))
+	ip := net.ParseIP("80.4.4.200")
+
+	assert.Equal(t, []int{1059, 431, 808, 1217, 287, 376, 1188}, allowedFastSet(ip, infoHash, 1313, 7))
+	assert.Equal(t, []int{1059, 431, 808, 1217, 287, 376, 1188, 353, 508}, allowedFastSet(ip, infoHash, 1313, 9))
+	// Hosts of the same /24 network share the set
+	assert.Equal(t, allowedFastSet(ip, infoHash, 1313, 9), allowedFastSet(net.ParseIP("80.4.4.1"), infoHash, 1313, 9))
+
+	assert.ElementsMatch(t, []int{0, 1, 2}, allowedFastSet(ip, infoHash, 3, AllowedFastSetSize))
+	assert.Empty(t, allowedFastSet(net.ParseIP("2001:db8::1"), infoHash, 1313, 7))
+}
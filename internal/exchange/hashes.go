@@ -0,0 +1,91 @@
+package exchange
+
+import (
+	"fmt"
+
+	"Torrentasaurus_Rex/internal/merkle"
+	"Torrentasaurus_Rex/internal/message"
+)
+
+// maxHashRequest is the largest number of hashes requested or served at
+// once, as other clients reject longer requests
+const maxHashRequest = 512
+
+// leafRequest asks for the hashes of the blocks of a piece
+func leafRequest(piece merkle.Piece) message.HashRequest {
+	return message.HashRequest{PiecesRoot: piece.Root, Index: piece.Block, Length: piece.Leaves}
+}
+
+// requestLeaves asks a v2 peer for the hashes of the blocks of a piece,
+// unless they are known already or the piece is a single block
+func (w *worker) requestLeaves(p *pieceProgress) error {
+	if !w.c.V2 || w.e.PiecesV2 == nil || p.hasLeaves() {
+		return nil
+	}
+	piece := w.e.PiecesV2[p.index]
+	if piece.Leaves < 2 || piece.Leaves > maxHashRequest {
+		return nil
+	}
+	return w.c.SendHashRequest(leafRequest(piece))
+}
+
+// receiveHashes stores the hashes of the blocks of the piece in progress.
+// Hashes that don't add up to the piece hash get the peer dropped.
+func (w *worker) receiveHashes(p *pieceProgress, msg *message.Message) error {
+	req, hashes, err := message.ParseHashes(msg)
+	if err != nil {
+		return err
+	}
+	if p == nil || w.e.PiecesV2 == nil {
+		return nil
+	}
+	piece := w.e.PiecesV2[p.index]
+	if req != leafRequest(piece) {
+		// Hashes for a piece we moved on from
+		return nil
+	}
+	if !piece.VerifyLeaves(hashes) {
+		return fmt.Errorf("%w: hashes of piece #%d", ErrIntegrity, p.index)
+	}
+	p.setLeaves(hashes, piece.Length)
+	return nil
+}
+
+// serveHashes answers a hash request from the trees of the files. Requests
+// for hashes below the piece layer, which would need the data hashed, are
+// rejected like those for unknown files.
+func (e *Exchange) serveHashes(u *upload, msg *message.Message) error {
+	req, err := message.ParseHashRequest(msg)
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	tree := e.hashTrees()[req.PiecesRoot]
+	e.mu.Unlock()
+	if tree != nil && req.Length <= maxHashRequest {
+		if hashes, ok := tree.Hashes(req.BaseLayer, req.Index, req.Length, req.ProofLayers); ok {
+			return u.send(message.FormatHashes(req, hashes))
+		}
+	}
+	return u.send(message.FormatHashReject(req))
+}
+
+// hashTrees builds the trees of the files from PiecesV2 on first use; the
+// caller must hold e.mu
+func (e *Exchange) hashTrees() map[[32]byte]*merkle.Tree {
+	if e.trees != nil {
+		return e.trees
+	}
+	e.trees = make(map[[32]byte]*merkle.Tree)
+	// The pieces of a file are consecutive and the first covers its first
+	// block
+	for begin := 0; begin < len(e.PiecesV2); {
+		end := begin + 1
+		for end < len(e.PiecesV2) && e.PiecesV2[end].Block != 0 {
+			end++
+		}
+		e.trees[e.PiecesV2[begin].Root] = merkle.PieceTree(e.PiecesV2[begin:end])
+		begin = end
+	}
+	return e.trees
+}
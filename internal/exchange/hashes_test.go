@@ -0,0 +1,123 @@
+package exchange
+
+import (
+	"context"
+	"testing"
+
+	"Torrentasaurus_Rex/internal/merkle"
+	"Torrentasaurus_Rex/internal/message"
+	"Torrentasaurus_Rex/internal/peers"
+	"Torrentasaurus_Rex/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newV2TestExchange creates an exchange for a v2-only torrent holding data
+// as a single file
+func newV2TestExchange(data []byte, pieceLen int) *Exchange {
+	root := merkle.Root(data)
+	var pieces []merkle.Piece
+	leaves := pieceLen / merkle.BlockSize
+	for begin := 0; begin < len(data); begin += pieceLen {
+		piece := data[begin:min(begin+pieceLen, len(data))]
+		pieces = append(pieces, merkle.Piece{
+			Root:   root,
+			Hash:   merkle.RootOf(merkle.BlockHashes(piece), 0, leaves),
+			Block:  begin / merkle.BlockSize,
+			Leaves: leaves,
+			Length: len(piece),
+		})
+	}
+	return &Exchange{
+		PeerID:      [20]byte{1, 2, 3},
+		InfoHash:    [20]byte{4, 5, 6},
+		PiecesV2:    pieces,
+		PieceLength: pieceLen,
+		Length:      len(data),
+		Name:        "test",
+		Output:      storage.NewMemory(pieceLen, len(data)),
+	}
+}
+
+func TestDownloadV2(t *testing.T) {
+	data := testData(5*MaxBlockSize + 300)
+	e := newV2TestExchange(data, 2*MaxBlockSize)
+	seeder, peer := startFakeSeeder(t, e.InfoHash, data, e.PieceLength, false)
+	seeder.mu.Lock()
+	seeder.v2 = true
+	seeder.mu.Unlock()
+	e.Peers = []peers.Peer{peer}
+
+	require.NoError(t, e.Download(context.Background()))
+	assert.Equal(t, data, output(e))
+	seeder.mu.Lock()
+	defer seeder.mu.Unlock()
+	assert.Equal(t, 3, seeder.hashRequests, "one request for the blocks of each piece")
+	_, _, left := e.Transferred()
+	assert.Zero(t, left)
+}
+
+func TestDownloadV2DropsPeerSendingBadBlocks(t *testing.T) {
+	data := testData(8 * MaxBlockSize)
+	e := newV2TestExchange(data, 4*MaxBlockSize)
+	bad, badPeer := startFakeSeeder(t, e.InfoHash, data, e.PieceLength, true)
+	bad.mu.Lock()
+	bad.v2 = true
+	bad.mu.Unlock()
+	_, goodPeer := startFakeSeeder(t, e.InfoHash, data, e.PieceLength, false)
+	e.Peers = []peers.Peer{badPeer, goodPeer}
+
+	require.NoError(t, e.Download(context.Background()))
+	assert.Equal(t, data, output(e))
+	requests, _ := bad.counts()
+	assert.LessOrEqual(t, requests, MaxBacklog, "the first bad block drops the peer")
+}
+
+func TestPieceProgressLeaves(t *testing.T) {
+	data := testData(2 * MaxBlockSize)
+	// testData repeats itself every 256 bytes
+	data[MaxBlockSize] ^= 0xff
+	e := newV2TestExchange(data, 2*MaxBlockSize)
+	p := newPieceProgress(0, len(data))
+
+	// A bad block received before the hashes is dropped once they arrive
+	p.received[0] = true
+	p.downloaded = MaxBlockSize
+	p.setLeaves(merkle.BlockHashes(data), e.PiecesV2[0].Length)
+	assert.False(t, p.received[0])
+	assert.Zero(t, p.downloaded)
+
+	assert.True(t, p.verifyBlock(1, data[MaxBlockSize:]))
+	assert.False(t, p.verifyBlock(1, data[:MaxBlockSize]))
+}
+
+func TestServeConnHashes(t *testing.T) {
+	data := testData(5 * MaxBlockSize)
+	e := newV2TestExchange(data, 2*MaxBlockSize)
+	root := e.PiecesV2[0].Root
+	conn, _ := startServing(t, e, nil)
+	require.Equal(t, message.MsgBitfield, readMsg(t, conn).ID)
+
+	// The piece layer with the uncles up to the root
+	req := message.HashRequest{PiecesRoot: root, BaseLayer: 1, Index: 2, Length: 2, ProofLayers: 5}
+	_, err := conn.Write(message.FormatHashRequest(req).Serialize())
+	require.NoError(t, err)
+	got, hashes, err := message.ParseHashes(readMsg(t, conn))
+	require.NoError(t, err)
+	assert.Equal(t, req, got)
+	assert.Equal(t, e.PiecesV2[2].Hash, hashes[0])
+	assert.True(t, merkle.VerifyProof(root, 1, 2, 2, hashes))
+
+	// Leaves aren't kept, and unknown files have no hashes at all
+	for _, req := range []message.HashRequest{
+		{PiecesRoot: root, BaseLayer: 0, Index: 0, Length: 2},
+		{PiecesRoot: [32]byte{1}, BaseLayer: 1, Index: 0, Length: 2},
+	} {
+		_, err := conn.Write(message.FormatHashRequest(req).Serialize())
+		require.NoError(t, err)
+		rejected, err := message.ParseHashReject(readMsg(t, conn))
+		require.NoError(t, err)
+		assert.Equal(t, req, rejected)
+	}
+}
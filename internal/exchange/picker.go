@@ -0,0 +1,226 @@
+package exchange
+
+import (
+	"math/rand/v2"
+	"sync"
+
+	"Torrentasaurus_Rex/internal/bitfields"
+)
+
+// RandomFirstPieces is the number of pieces picked at random before switching
+// to rarest first. Rare pieces are slow to get, while any complete piece
+// can be uploaded to others right away.
+const RandomFirstPieces = 4
+
+// pieceState is the download state of a single piece
+type pieceState int
+
+const (
+	piecePending pieceState = iota
+	pieceActive
+	pieceDone
+)
+
+// PiecePicker decides which piece to download next. It counts how many
+// connected peers have each piece and prefers, in order: pieces that were
+// partially downloaded before, random pieces until RandomFirstPieces are
+// done, and then the rarest pieces. Ties are broken at random.
+type PiecePicker struct {
+	mu           sync.Mutex
+	availability []int
+	state        []pieceState
+	partial      []bool
+	done         int
+	// changed is closed and replaced when a piece is put back or done
+	changed chan struct{}
+
+	intn func(n int) int
+}
+
+// NewPiecePicker returns a picker for a torrent with numPieces pieces
+func NewPiecePicker(numPieces int) *PiecePicker {
+	return &PiecePicker{
+		availability: make([]int, numPieces),
+		state:        make([]pieceState, numPieces),
+		partial:      make([]bool, numPieces),
+		changed:      make(chan struct{}),
+		intn:         rand.IntN,
+	}
+}
+
+// AddBitfield counts the pieces of a newly connected peer
+func (p *PiecePicker) AddBitfield(bf bitfields.Bitfield) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i := range p.availability {
+		if bf.HasPiece(i) {
+			p.availability[i]++
+		}
+	}
+}
+
+// RemoveBitfield forgets the pieces of a peer that disconnected
+func (p *PiecePicker) RemoveBitfield(bf bitfields.Bitfield) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i := range p.availability {
+		if bf.HasPiece(i) && p.availability[i] > 0 {
+			p.availability[i]--
+		}
+	}
+}
+
+// AddHave counts a piece a peer announced with a Have message. It must only
+// be called for pieces that weren't set in the peer's bitfield yet.
+func (p *PiecePicker) AddHave(index int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if index >= 0 && index < len(p.availability) {
+		p.availability[index]++
+	}
+}
+
+// Pick chooses a piece for which has returns true, usually the HasPiece
+// method of the peer's bitfield, and marks it as being downloaded. It
+// returns false when the peer has nothing we still need.
+func (p *PiecePicker) Pick(has func(index int) bool) (int, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var candidates []int
+	for i, st := range p.state {
+		if st == piecePending && p.partial[i] && has(i) {
+			candidates = append(candidates, i)
+		}
+	}
+	if len(candidates) == 0 {
+		for i, st := range p.state {
+			if st == piecePending && has(i) {
+				candidates = append(candidates, i)
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return 0, false
+	}
+
+	if p.done >= RandomFirstPieces {
+		candidates = p.rarest(candidates)
+	}
+	index := candidates[p.intn(len(candidates))]
+	p.state[index] = pieceActive
+	return index, true
+}
+
+// PickIndex marks a given piece, for example one a peer suggested, as being
+// downloaded. It returns false when the piece isn't pending.
+func (p *PiecePicker) PickIndex(index int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if index < 0 || index >= len(p.state) || p.state[index] != piecePending {
+		return false
+	}
+	p.state[index] = pieceActive
+	return true
+}
+
+// PickRange chooses up to max consecutive pending pieces for a source that
+// has every piece, such as a web seed, and marks them as being downloaded.
+// The range starts at the rarest pending piece, so peers are left with the
+// pieces they can share among themselves. It returns false when no piece is
+// pending.
+func (p *PiecePicker) PickRange(max int) (first, n int, ok bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var candidates []int
+	for i, st := range p.state {
+		if st == piecePending {
+			candidates = append(candidates, i)
+		}
+	}
+	if len(candidates) == 0 {
+		return 0, 0, false
+	}
+	candidates = p.rarest(candidates)
+	first = candidates[p.intn(len(candidates))]
+	for n < max && first+n < len(p.state) && p.state[first+n] == piecePending {
+		p.state[first+n] = pieceActive
+		n++
+	}
+	return first, n, true
+}
+
+// rarest keeps the candidates with the lowest availability
+func (p *PiecePicker) rarest(candidates []int) []int {
+	lowest := -1
+	var rarest []int
+	for _, i := range candidates {
+		switch {
+		case lowest == -1 || p.availability[i] < lowest:
+			lowest = p.availability[i]
+			rarest = append(rarest[:0], i)
+		case p.availability[i] == lowest:
+			rarest = append(rarest, i)
+		}
+	}
+	return rarest
+}
+
+// Done records that a piece was downloaded and verified
+func (p *PiecePicker) Done(index int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.state[index] != pieceDone {
+		p.state[index] = pieceDone
+		p.partial[index] = false
+		p.done++
+		p.notify()
+	}
+}
+
+// Abort puts a piece back so it can be picked again. partial tells whether
+// some of its blocks were kept, which makes it preferred over fresh pieces.
+func (p *PiecePicker) Abort(index int, partial bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.state[index] == pieceActive {
+		p.state[index] = piecePending
+		p.partial[index] = partial
+		p.notify()
+	}
+}
+
+// Changed returns a channel that is closed the next time a piece is put
+// back or done, so workers without anything to pick know to look again
+func (p *PiecePicker) Changed() <-chan struct{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.changed
+}
+
+// notify wakes those waiting on Changed
+func (p *PiecePicker) notify() {
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+// Pending returns the number of pieces that aren't done or being downloaded
+func (p *PiecePicker) Pending() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pending := 0
+	for _, st := range p.state {
+		if st == piecePending {
+			pending++
+		}
+	}
+	return pending
+}
+
+// Remaining returns the number of pieces that aren't done yet
+func (p *PiecePicker) Remaining() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.state) - p.done
+}
@@ -0,0 +1,187 @@
+package exchange
+
+import (
+	"testing"
+
+	"Torrentasaurus_Rex/internal/bitfields"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fullBitfield returns a bitfield with every piece below n set
+func fullBitfield(n int) bitfields.Bitfield {
+	bf := make(bitfields.Bitfield, (n+7)/8)
+	for i := 0; i < n; i++ {
+		bf.SetPiece(i)
+	}
+	return bf
+}
+
+// finishRandomFirst completes pieces so the picker switches to rarest first
+func finishRandomFirst(t *testing.T, p *PiecePicker, n int) {
+	t.Helper()
+	for i := 0; i < RandomFirstPieces; i++ {
+		index, ok := p.Pick(fullBitfield(n).HasPiece)
+		require.True(t, ok)
+		p.Done(index)
+	}
+}
+
+func TestPickerRarestFirst(t *testing.T) {
+	const n = 8
+	p := NewPiecePicker(n)
+	p.intn = func(int) int { return 0 }
+	p.AddBitfield(fullBitfield(n))
+	finishRandomFirst(t, p, n)
+
+	// A second peer has everything but piece 6, and a third one announces
+	// piece 7 later, so 6 is the rarest piece left
+	most := fullBitfield(n)
+	most[0] &^= 1 << 1
+	p.AddBitfield(most)
+	p.AddHave(7)
+
+	index, ok := p.Pick(fullBitfield(n).HasPiece)
+	require.True(t, ok)
+	assert.Equal(t, 6, index)
+
+	p.RemoveBitfield(most)
+	index, ok = p.Pick(fullBitfield(n).HasPiece)
+	require.True(t, ok)
+	assert.Equal(t, 4, index, "pieces 4 and 5 are the rarest once the peer left")
+}
+
+func TestPickerBreaksTiesAtRandom(t *testing.T) {
+	const n = 8
+	p := NewPiecePicker(n)
+	p.AddBitfield(fullBitfield(n))
+	var sizes []int
+	p.intn = func(k int) int {
+		sizes = append(sizes, k)
+		return k - 1
+	}
+	finishRandomFirst(t, p, n)
+
+	index, ok := p.Pick(fullBitfield(n).HasPiece)
+	require.True(t, ok)
+	assert.Equal(t, []int{8, 7, 6, 5, 4}, sizes, "every equally rare piece is a candidate")
+	assert.Equal(t, 7-RandomFirstPieces, index)
+}
+
+func TestPickerRandomFirstIgnoresRarity(t *testing.T) {
+	const n = 4
+	p := NewPiecePicker(n)
+	common := fullBitfield(n)
+	p.AddBitfield(common)
+	p.AddBitfield(common)
+	p.AddHave(0)
+	var sizes []int
+	p.intn = func(k int) int {
+		sizes = append(sizes, k)
+		return 0
+	}
+
+	_, ok := p.Pick(common.HasPiece)
+	require.True(t, ok)
+	assert.Equal(t, []int{4}, sizes)
+}
+
+func TestPickerPrefersPartialPieces(t *testing.T) {
+	const n = 8
+	p := NewPiecePicker(n)
+	p.intn = func(int) int { return 0 }
+	all := fullBitfield(n).HasPiece
+
+	first, ok := p.Pick(all)
+	require.True(t, ok)
+	second, ok := p.Pick(all)
+	require.True(t, ok)
+	p.Abort(first, false)
+	p.Abort(second, true)
+
+	index, ok := p.Pick(all)
+	require.True(t, ok)
+	assert.Equal(t, second, index)
+}
+
+func TestPickerOnlyPicksWhatThePeerHas(t *testing.T) {
+	p := NewPiecePicker(3)
+	bf := make(bitfields.Bitfield, 1)
+	bf.SetPiece(1)
+
+	index, ok := p.Pick(bf.HasPiece)
+	require.True(t, ok)
+	assert.Equal(t, 1, index)
+
+	_, ok = p.Pick(bf.HasPiece)
+	assert.False(t, ok, "piece 1 is already being downloaded")
+
+	p.Done(1)
+	assert.Equal(t, 2, p.Remaining())
+}
+
+func TestPickerPickIndex(t *testing.T) {
+	p := NewPiecePicker(3)
+	assert.True(t, p.PickIndex(2))
+	assert.False(t, p.PickIndex(2), "piece 2 is already being downloaded")
+	assert.False(t, p.PickIndex(3))
+
+	p.Done(2)
+	assert.False(t, p.PickIndex(2))
+	assert.Equal(t, 2, p.Pending())
+}
+
+func TestPickerPickRange(t *testing.T) {
+	p := NewPiecePicker(8)
+	p.intn = func(int) int { return 0 }
+	// Pieces 0 and 1 are common among peers, and 5 is being downloaded
+	bf := make(bitfields.Bitfield, 1)
+	bf.SetPiece(0)
+	bf.SetPiece(1)
+	p.AddBitfield(bf)
+	require.True(t, p.PickIndex(5))
+
+	first, n, ok := p.PickRange(4)
+	require.True(t, ok)
+	assert.Equal(t, 2, first)
+	assert.Equal(t, 3, n, "the range stops before a piece in progress")
+
+	first, n, ok = p.PickRange(4)
+	require.True(t, ok)
+	assert.Equal(t, 6, first)
+	assert.Equal(t, 2, n, "the range stops at the last piece")
+
+	first, n, ok = p.PickRange(1)
+	require.True(t, ok)
+	assert.Equal(t, []int{0, 1}, []int{first, n})
+	p.PickIndex(1)
+	_, _, ok = p.PickRange(4)
+	assert.False(t, ok)
+}
+
+func TestPickerChanged(t *testing.T) {
+	p := NewPiecePicker(2)
+	changed := p.Changed()
+	index, ok := p.Pick(func(int) bool { return true })
+	require.True(t, ok)
+	assert.False(t, isClosed(changed), "picking a piece is not a change")
+
+	p.Abort(index, false)
+	assert.True(t, isClosed(changed))
+	changed = p.Changed()
+	assert.False(t, isClosed(changed))
+
+	require.True(t, p.PickIndex(index))
+	p.Done(index)
+	assert.True(t, isClosed(changed))
+}
+
+func isClosed(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
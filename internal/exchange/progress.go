@@ -0,0 +1,295 @@
+package exchange
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sync"
+
+	"Torrentasaurus_Rex/internal/client"
+	"Torrentasaurus_Rex/internal/merkle"
+	"Torrentasaurus_Rex/internal/message"
+)
+
+// pieceProgress tracks the blocks of a piece while they are in flight. It is
+// shared by every worker downloading the piece: usually a single one, but
+// several during endgame. It also outlives a failed attempt so another peer
+// can fill in the missing blocks.
+type pieceProgress struct {
+	index int
+
+	mu         sync.Mutex
+	buf        []byte
+	received   []bool
+	downloaded int
+	// leaves holds the hashes of the blocks of a v2 piece once a peer sent
+	// them, so bad blocks are caught as they arrive. They cover the first
+	// covered bytes; the rest of a hybrid piece is padding.
+	leaves  [][merkle.HashSize]byte
+	covered int
+	// requests holds the blocks each connection asked for and is woken
+	// through when those requests are cancelled
+	requests map[*client.Client]*blockRequests
+	finished bool
+}
+
+// blockRequests are the outstanding requests of one connection for a piece
+type blockRequests struct {
+	pending []bool
+	wake    chan struct{}
+}
+
+// blockCancel is a duplicate request that has to be cancelled
+type blockCancel struct {
+	client *client.Client
+	wake   chan struct{}
+}
+
+func newPieceProgress(index, length int) *pieceProgress {
+	return &pieceProgress{
+		index:    index,
+		buf:      make([]byte, length),
+		received: make([]bool, (length+MaxBlockSize-1)/MaxBlockSize),
+		requests: make(map[*client.Client]*blockRequests),
+	}
+}
+
+// blockBounds returns the offset and length of a block within the piece
+func (p *pieceProgress) blockBounds(block int) (begin, length int) {
+	begin = block * MaxBlockSize
+	return begin, min(MaxBlockSize, len(p.buf)-begin)
+}
+
+// join registers a connection working on the piece. wake is signalled when
+// the piece completes or one of its requests is cancelled.
+func (p *pieceProgress) join(c *client.Client, wake chan struct{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.requests[c] = &blockRequests{pending: make([]bool, len(p.received)), wake: wake}
+}
+
+// leave unregisters a connection and returns the number still working on
+// the piece
+func (p *pieceProgress) leave(c *client.Client) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.requests, c)
+	return len(p.requests)
+}
+
+// workers returns the number of connections working on the piece
+func (p *pieceProgress) workers() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.requests)
+}
+
+// joined tells whether a connection already works on the piece
+func (p *pieceProgress) joined(c *client.Client) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.requests[c]
+	return ok
+}
+
+// nextRequests marks the blocks c should request next, keeping at most
+// MaxBacklog requests outstanding. Outside endgame, blocks requested from
+// another connection are left alone.
+func (p *pieceProgress) nextRequests(c *client.Client, endgame bool) []int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	reqs := p.requests[c]
+	backlog := 0
+	for _, pending := range reqs.pending {
+		if pending {
+			backlog++
+		}
+	}
+
+	var blocks []int
+	for block := range p.received {
+		if backlog >= MaxBacklog {
+			break
+		}
+		if p.received[block] || reqs.pending[block] || (!endgame && p.requestedElsewhere(c, block)) {
+			continue
+		}
+		reqs.pending[block] = true
+		backlog++
+		blocks = append(blocks, block)
+	}
+	return blocks
+}
+
+// requestedElsewhere tells whether another connection asked for a block; the
+// caller must hold p.mu
+func (p *pieceProgress) requestedElsewhere(c *client.Client, block int) bool {
+	for other, reqs := range p.requests {
+		if other != c && reqs.pending[block] {
+			return true
+		}
+	}
+	return false
+}
+
+// choked drops the outstanding requests of c, which a choke discards
+func (p *pieceProgress) choked(c *client.Client) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if reqs, ok := p.requests[c]; ok {
+		clear(reqs.pending)
+	}
+}
+
+// rejected drops the request of c for the block at offset begin, which the
+// peer won't answer
+func (p *pieceProgress) rejected(c *client.Client, begin int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	block := begin / MaxBlockSize
+	if reqs, ok := p.requests[c]; ok && begin%MaxBlockSize == 0 && block < len(reqs.pending) {
+		reqs.pending[block] = false
+	}
+}
+
+// requested tells whether c has requests for the piece outstanding
+func (p *pieceProgress) requested(c *client.Client) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if reqs, ok := p.requests[c]; ok {
+		for _, pending := range reqs.pending {
+			if pending {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// receive stores a block that arrived on c. It reports the block length,
+// whether the block was a duplicate, and the other connections whose request
+// for the same block must now be cancelled.
+func (p *pieceProgress) receive(c *client.Client, msg *message.Message) (n int, duplicate bool, cancels []blockCancel, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(msg.Payload) < 8 {
+		return 0, false, nil, fmt.Errorf("payload too short. %d < 8", len(msg.Payload))
+	}
+	begin := int(binary.BigEndian.Uint32(msg.Payload[4:8]))
+	block := begin / MaxBlockSize
+	if begin%MaxBlockSize != 0 || block >= len(p.received) {
+		return 0, false, nil, fmt.Errorf("unexpected block at offset %d of piece #%d", begin, p.index)
+	}
+	if _, length := p.blockBounds(block); len(msg.Payload)-8 != length {
+		return 0, false, nil, fmt.Errorf("unexpected block length %d at offset %d of piece #%d", len(msg.Payload)-8, begin, p.index)
+	}
+
+	if reqs, ok := p.requests[c]; ok {
+		reqs.pending[block] = false
+	}
+	if p.received[block] {
+		return len(msg.Payload) - 8, true, nil, nil
+	}
+	if !p.verifyBlock(block, msg.Payload[8:]) {
+		return 0, false, nil, fmt.Errorf("%w: block at offset %d of piece #%d", ErrIntegrity, begin, p.index)
+	}
+	n, err = message.ParsePiece(p.index, p.buf, msg)
+	if err != nil {
+		return 0, false, nil, err
+	}
+	p.received[block] = true
+	p.downloaded += n
+
+	complete := p.downloaded == len(p.buf)
+	for other, reqs := range p.requests {
+		if other == c {
+			continue
+		}
+		if reqs.pending[block] {
+			reqs.pending[block] = false
+			cancels = append(cancels, blockCancel{client: other, wake: reqs.wake})
+		} else if complete {
+			cancels = append(cancels, blockCancel{wake: reqs.wake})
+		}
+	}
+	return n, false, cancels, nil
+}
+
+// hasLeaves tells whether the hashes of the blocks are known
+func (p *pieceProgress) hasLeaves() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.leaves != nil
+}
+
+// setLeaves stores the verified hashes of the blocks of the first covered
+// bytes of the piece. Blocks that arrived before them and don't match are
+// dropped so they are downloaded again.
+func (p *pieceProgress) setLeaves(leaves [][merkle.HashSize]byte, covered int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.leaves != nil {
+		return
+	}
+	p.leaves, p.covered = leaves, covered
+	for block, ok := range p.received {
+		begin, length := p.blockBounds(block)
+		if ok && !p.verifyBlock(block, p.buf[begin:begin+length]) {
+			p.received[block] = false
+			p.downloaded -= length
+		}
+	}
+}
+
+// verifyBlock checks a block against its leaf hash when it is known; the
+// caller must hold p.mu
+func (p *pieceProgress) verifyBlock(block int, data []byte) bool {
+	begin := block * MaxBlockSize
+	if p.leaves == nil || begin >= p.covered {
+		return true
+	}
+	return sha256.Sum256(data[:min(len(data), p.covered-begin)]) == p.leaves[block]
+}
+
+// complete tells whether every block of the piece arrived
+func (p *pieceProgress) complete() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.downloaded == len(p.buf)
+}
+
+// claim lets exactly one of the workers that saw the piece complete hand it
+// on for verification
+func (p *pieceProgress) claim() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.downloaded != len(p.buf) || p.finished {
+		return false
+	}
+	p.finished = true
+	return true
+}
+
+// finish marks a piece that another source completed, so that workers still
+// downloading it don't hand it on
+func (p *pieceProgress) finish() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.finished = true
+}
+
+// hasData tells whether any block was kept
+func (p *pieceProgress) hasData() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.downloaded > 0
+}
+
+// wakeUp signals a worker without blocking
+func wakeUp(wake chan struct{}) {
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,52 @@
+package exchange
+
+import (
+	"fmt"
+
+	"Torrentasaurus_Rex/internal/bitfields"
+	"Torrentasaurus_Rex/internal/resume"
+)
+
+// Resume marks the pieces already present in the output so Download skips
+// them. The saved state is trusted when the files are unchanged since it was
+// written; otherwise every piece of the existing files is hashed again.
+// It returns the number of pieces restored.
+func (e *Exchange) Resume(saved *resume.State, files []resume.FileStat) (int, error) {
+	var restored []int
+	switch {
+	case saved != nil && saved.Matches(e.InfoHash, files):
+		pieces := bitfields.Bitfield(saved.Pieces)
+		for index := range e.numPieces() {
+			if pieces.HasPiece(index) {
+				restored = append(restored, index)
+			}
+		}
+	case resume.AnyExisting(files):
+		if e.Output == nil {
+			return 0, ErrNoOutput
+		}
+		bad, err := e.Verify(e.Output)
+		if err != nil {
+			return 0, fmt.Errorf("failed to rehash existing data: %w", err)
+		}
+		isBad := make(map[int]bool, len(bad))
+		for _, index := range bad {
+			isBad[index] = true
+		}
+		for index := range e.numPieces() {
+			if !isBad[index] {
+				restored = append(restored, index)
+			}
+		}
+	}
+	e.MarkHave(restored...)
+	return len(restored), nil
+}
+
+// Have returns a copy of the bitfield of the pieces written to the output
+func (e *Exchange) Have() bitfields.Bitfield {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.ensureHave()
+	return append(bitfields.Bitfield(nil), e.have...)
+}
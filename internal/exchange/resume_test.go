@@ -0,0 +1,78 @@
+package exchange
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"Torrentasaurus_Rex/internal/peers"
+	"Torrentasaurus_Rex/internal/resume"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResumeTrustsMatchingState(t *testing.T) {
+	data := testData(3 * MaxBlockSize)
+	e := newTestExchange(data, MaxBlockSize)
+	files := []resume.FileStat{{Path: "test", Size: int64(len(data)), ModTime: 42}}
+	saved := &resume.State{InfoHash: e.InfoHash, Pieces: []byte{0b10100000}, Files: files}
+
+	// The output is empty, so the pieces can only come from the saved state
+	restored, err := e.Resume(saved, files)
+	require.NoError(t, err)
+	assert.Equal(t, 2, restored)
+	assert.True(t, e.HasPiece(0))
+	assert.False(t, e.HasPiece(1))
+	assert.True(t, e.HasPiece(2))
+	assert.Equal(t, []byte{0b10100000}, []byte(e.Have()))
+
+	_, _, left := e.Transferred()
+	assert.Equal(t, int64(MaxBlockSize), left)
+}
+
+func TestResumeRehashesChangedFiles(t *testing.T) {
+	data := testData(3 * MaxBlockSize)
+	e := newTestExchange(data, MaxBlockSize)
+	copy(output(e), data[:2*MaxBlockSize])
+	output(e)[MaxBlockSize] ^= 0xff
+
+	saved := &resume.State{
+		InfoHash: e.InfoHash,
+		Pieces:   []byte{0b11100000},
+		Files:    []resume.FileStat{{Path: "test", Size: int64(len(data)), ModTime: 42}},
+	}
+	files := []resume.FileStat{{Path: "test", Size: int64(len(data)), ModTime: 43}}
+
+	restored, err := e.Resume(saved, files)
+	require.NoError(t, err)
+	assert.Equal(t, 1, restored)
+	assert.Equal(t, []byte{0b10000000}, []byte(e.Have()))
+}
+
+func TestResumeSkipsHashingWithoutFiles(t *testing.T) {
+	data := testData(MaxBlockSize)
+	e := newTestExchange(data, MaxBlockSize)
+	copy(output(e), data)
+
+	restored, err := e.Resume(nil, []resume.FileStat{{Path: "test", Size: -1}})
+	require.NoError(t, err)
+	assert.Equal(t, 0, restored)
+}
+
+func TestDownloadSkipsRestoredPieces(t *testing.T) {
+	data := testData(4 * MaxBlockSize)
+	e := newTestExchange(data, MaxBlockSize)
+	copy(output(e), data[:3*MaxBlockSize])
+	e.MarkHave(0, 1, 2)
+	seeder, peer := startFakeSeeder(t, e.InfoHash, data, e.PieceLength, false)
+	e.Peers = []peers.Peer{peer}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	require.NoError(t, e.Download(ctx))
+	assert.Equal(t, data, output(e))
+
+	requests, _ := seeder.counts()
+	assert.Equal(t, 1, requests)
+}
@@ -0,0 +1,358 @@
+package exchange
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"Torrentasaurus_Rex/internal/bitfields"
+	"Torrentasaurus_Rex/internal/extension"
+	"Torrentasaurus_Rex/internal/handshake"
+	"Torrentasaurus_Rex/internal/message"
+	"Torrentasaurus_Rex/internal/peers"
+)
+
+const (
+	// MaxRequestLength is the largest block a peer may request from us
+	MaxRequestLength = 128 * 1024
+	// uploadIdleTimeout drops peers that stay silent for too long. Peers are
+	// expected to send a keep-alive every two minutes.
+	uploadIdleTimeout = 3 * time.Minute
+)
+
+var ErrBadRequest = errors.New("invalid block request")
+
+// upload is the state of a peer that connected to us to download. It is
+// shared by the serving goroutine, Have broadcasts and the choker.
+type upload struct {
+	conn net.Conn
+	addr string
+	// writeMu serializes writes to the connection
+	writeMu    sync.Mutex
+	choked     atomic.Bool
+	interested atomic.Bool
+	uploaded   atomic.Int64
+	// received counts the bytes our download workers got from the same host
+	received *atomic.Int64
+	// fast tells whether both sides announced the Fast Extension
+	fast bool
+	// allowed holds the pieces the peer may request while choked
+	allowed map[int]bool
+}
+
+// ServeConn uploads pieces to a peer that already completed the handshake hs.
+// It sends our bitfield, unchokes the peer once it is interested and answers
+// its block requests from the output until the connection fails or the
+// context is cancelled. With the Fast Extension, the peer may also download
+// its allowed fast pieces while choked, and requests that won't be answered
+// are rejected.
+func (e *Exchange) ServeConn(ctx context.Context, conn net.Conn, hs *handshake.Handshake) error {
+	if e.Output == nil {
+		return ErrNoOutput
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	// The bitfield must be the first message, so Have broadcasts wait for
+	// it by blocking on the write lock
+	u := &upload{conn: conn, addr: conn.RemoteAddr().String()}
+	u.fast = e.Fast && hs != nil && hs.SupportsFast()
+	u.choked.Store(true)
+	u.writeMu.Lock()
+	bf := e.registerUpload(u)
+	defer e.unregisterUpload(u)
+	err := u.write(e.bitfieldMessage(bf, u.fast))
+	u.writeMu.Unlock()
+	if err != nil {
+		return err
+	}
+	if u.fast {
+		if err := e.sendAllowedFast(u, bf); err != nil {
+			return err
+		}
+	}
+	var ext *extension.Peer
+	if e.Extensions != nil && hs != nil && hs.SupportsExtensions() {
+		ext = e.Extensions.NewPeer(u.send)
+		if err := ext.SendHandshake(); err != nil {
+			return err
+		}
+	}
+	if e.PEX != nil {
+		// The port the peer accepts connections on comes with its extended
+		// handshake
+		if host, _, err := net.SplitHostPort(u.addr); err == nil {
+			if ip := net.ParseIP(host); ip != nil {
+				remove := e.PEX.Add(ext, peers.Peer{IP: ip}, false)
+				defer remove()
+			}
+		}
+	}
+	if e.Choker != nil {
+		e.Choker.Add(u)
+		defer e.Choker.Remove(u)
+	}
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(uploadIdleTimeout))
+		msg, err := message.Read(conn)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		if msg == nil { // keep-alive
+			continue
+		}
+
+		switch msg.ID {
+		case message.MsgInterested:
+			u.interested.Store(true)
+			// Without a choker every interested peer gets a slot
+			if e.Choker == nil {
+				if err := u.Unchoke(); err != nil {
+					return err
+				}
+			}
+		case message.MsgNotInterested:
+			u.interested.Store(false)
+			if e.Choker == nil {
+				if err := u.Choke(); err != nil {
+					return err
+				}
+			}
+		case message.MsgRequest:
+			if err := e.serveRequest(u, msg); err != nil {
+				log.Printf("Dropping upload to %s: %v", u.addr, err)
+				return err
+			}
+		case message.MsgCancel:
+			// Requests are answered as soon as they arrive, so there is
+			// never a queued block left to cancel.
+		case message.MsgExtended:
+			if ext != nil {
+				if err := ext.Handle(msg.Payload); err != nil {
+					return err
+				}
+			}
+		case message.MsgHashRequest:
+			if err := e.serveHashes(u, msg); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// bitfieldMessage announces the pieces we have. With the Fast Extension,
+// Have All and Have None replace the bitfield when they fit.
+func (e *Exchange) bitfieldMessage(bf bitfields.Bitfield, fast bool) *message.Message {
+	if fast {
+		all, none := true, true
+		for index := range e.numPieces() {
+			if bf.HasPiece(index) {
+				none = false
+			} else {
+				all = false
+			}
+		}
+		switch {
+		case all:
+			return &message.Message{ID: message.MsgHaveAll}
+		case none:
+			return &message.Message{ID: message.MsgHaveNone}
+		}
+	}
+	return &message.Message{ID: message.MsgBitfield, Payload: bf}
+}
+
+// sendAllowedFast tells the peer which of the pieces we have it may
+// download while choked
+func (e *Exchange) sendAllowedFast(u *upload, bf bitfields.Bitfield) error {
+	host, _, err := net.SplitHostPort(u.addr)
+	if err != nil {
+		return nil
+	}
+	u.allowed = make(map[int]bool)
+	for _, index := range allowedFastSet(net.ParseIP(host), e.InfoHash, e.numPieces(), AllowedFastSetSize) {
+		u.allowed[index] = true
+		if bf.HasPiece(index) {
+			if err := u.send(message.FormatAllowedFast(index)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// serveRequest answers a block request with the block read from the output
+func (e *Exchange) serveRequest(u *upload, msg *message.Message) error {
+	index, begin, length, err := message.ParseRequest(msg)
+	if err != nil {
+		return err
+	}
+	if u.choked.Load() && !u.allowed[index] {
+		// Requests sent before a choke arrived are dropped silently, unless
+		// the peer expects them to be rejected
+		if u.fast {
+			return u.send(message.FormatReject(index, begin, length))
+		}
+		return nil
+	}
+	if index < 0 || index >= e.numPieces() {
+		return fmt.Errorf("%w: piece index %d out of range", ErrBadRequest, index)
+	}
+	if length <= 0 || length > MaxRequestLength {
+		return fmt.Errorf("%w: length %d", ErrBadRequest, length)
+	}
+	if begin < 0 || begin+length > e.calculatePieceSize(index) {
+		return fmt.Errorf("%w: block [%d:%d] outside piece #%d", ErrBadRequest, begin, begin+length, index)
+	}
+	if !e.HasPiece(index) {
+		if u.fast {
+			return u.send(message.FormatReject(index, begin, length))
+		}
+		return fmt.Errorf("%w: piece #%d not available", ErrBadRequest, index)
+	}
+
+	block := make([]byte, length)
+	if _, err := e.Output.ReadAt(index, block, int64(begin)); err != nil {
+		return fmt.Errorf("failed to read piece #%d: %w", index, err)
+	}
+	if err := u.send(message.FormatPiece(index, begin, block)); err != nil {
+		return err
+	}
+	e.uploaded.Add(int64(length))
+	u.uploaded.Add(int64(length))
+	return nil
+}
+
+// HasPiece tells whether a piece was verified and written to the output
+func (e *Exchange) HasPiece(index int) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.have.HasPiece(index)
+}
+
+// MarkHave records pieces that are already present in the output, for
+// example after verifying data from an earlier session, so they can be
+// uploaded. The remaining byte count reported to trackers is reduced
+// accordingly.
+func (e *Exchange) MarkHave(indexes ...int) {
+	for _, index := range indexes {
+		if index < 0 || index >= e.numPieces() || e.HasPiece(index) {
+			continue
+		}
+		e.completed.Add(int64(e.calculatePieceSize(index)))
+		e.markHave(index)
+	}
+}
+
+// markHave sets a piece in our bitfield and announces it to every peer we
+// upload to
+func (e *Exchange) markHave(index int) {
+	e.mu.Lock()
+	e.ensureHave()
+	e.have.SetPiece(index)
+	targets := make([]*upload, 0, len(e.uploads))
+	for u := range e.uploads {
+		targets = append(targets, u)
+	}
+	e.mu.Unlock()
+
+	for _, u := range targets {
+		_ = u.send(message.FormatHave(index))
+	}
+}
+
+// registerUpload tracks a serving peer and returns a copy of our bitfield
+// taken at the same moment, so no Have is lost in between
+func (e *Exchange) registerUpload(u *upload) bitfields.Bitfield {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.ensureHave()
+	if e.uploads == nil {
+		e.uploads = make(map[*upload]struct{})
+	}
+	e.uploads[u] = struct{}{}
+	u.received = e.receivedFrom(u.conn.RemoteAddr())
+	return append(bitfields.Bitfield(nil), e.have...)
+}
+
+func (e *Exchange) unregisterUpload(u *upload) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.uploads, u)
+}
+
+// receivedFrom returns the counter of bytes downloaded from a host; the
+// caller must hold e.mu
+func (e *Exchange) receivedFrom(addr net.Addr) *atomic.Int64 {
+	host := addr.String()
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if e.received == nil {
+		e.received = make(map[string]*atomic.Int64)
+	}
+	counter, ok := e.received[host]
+	if !ok {
+		counter = new(atomic.Int64)
+		e.received[host] = counter
+	}
+	return counter
+}
+
+// ensureHave allocates the bitfield; the caller must hold e.mu
+func (e *Exchange) ensureHave() {
+	if e.have == nil {
+		e.have = make(bitfields.Bitfield, (e.numPieces()+7)/8)
+	}
+}
+
+// Interested tells whether the peer wants to download from us
+func (u *upload) Interested() bool {
+	return u.interested.Load()
+}
+
+// Transferred reports the bytes uploaded to the peer and downloaded from
+// its host
+func (u *upload) Transferred() (uploaded, downloaded int64) {
+	return u.uploaded.Load(), u.received.Load()
+}
+
+// Choke stops serving the peer's requests
+func (u *upload) Choke() error {
+	if u.choked.Swap(true) {
+		return nil
+	}
+	return u.send(&message.Message{ID: message.MsgChoke})
+}
+
+// Unchoke lets the peer request blocks from us
+func (u *upload) Unchoke() error {
+	if !u.choked.Swap(false) {
+		return nil
+	}
+	return u.send(&message.Message{ID: message.MsgUnchoke})
+}
+
+// send serializes a message and writes it to the peer
+func (u *upload) send(msg *message.Message) error {
+	u.writeMu.Lock()
+	defer u.writeMu.Unlock()
+	return u.write(msg)
+}
+
+// write sends a message; the caller must hold u.writeMu
+func (u *upload) write(msg *message.Message) error {
+	u.conn.SetWriteDeadline(time.Now().Add(pieceTimeout))
+	_, err := u.conn.Write(msg.Serialize())
+	return err
+}
@@ -0,0 +1,333 @@
+package exchange
+
+import (
+	"context"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"Torrentasaurus_Rex/internal/bitfields"
+	"Torrentasaurus_Rex/internal/choker"
+	"Torrentasaurus_Rex/internal/extension"
+	"Torrentasaurus_Rex/internal/handshake"
+	"Torrentasaurus_Rex/internal/message"
+	"Torrentasaurus_Rex/internal/peers"
+	"Torrentasaurus_Rex/internal/pex"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startServing runs ServeConn for a peer that sent hs on one end of a pipe
+// and returns the other end
+func startServing(t *testing.T, e *Exchange, hs *handshake.Handshake) (net.Conn, <-chan error) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	local, remote := net.Pipe()
+	done := make(chan error, 1)
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		done <- e.ServeConn(ctx, remote, hs)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		local.Close()
+		<-finished
+	})
+	return local, done
+}
+
+func readMsg(t *testing.T, conn net.Conn) *message.Message {
+	t.Helper()
+	msg, err := message.Read(conn)
+	require.NoError(t, err)
+	require.NotNil(t, msg)
+	return msg
+}
+
+func TestServeConn(t *testing.T) {
+	data := testData(3*MaxBlockSize + 100)
+	e := newTestExchange(data, 2*MaxBlockSize)
+	copy(output(e), data)
+	e.MarkHave(0, 1)
+
+	conn, _ := startServing(t, e, nil)
+
+	msg := readMsg(t, conn)
+	require.Equal(t, message.MsgBitfield, msg.ID)
+	bf := bitfields.Bitfield(msg.Payload)
+	assert.True(t, bf.HasPiece(0))
+	assert.True(t, bf.HasPiece(1))
+
+	_, err := conn.Write((&message.Message{ID: message.MsgInterested}).Serialize())
+	require.NoError(t, err)
+	assert.Equal(t, message.MsgUnchoke, readMsg(t, conn).ID)
+
+	_, err = conn.Write(message.FormatRequest(1, 0, MaxBlockSize+100).Serialize())
+	require.NoError(t, err)
+	msg = readMsg(t, conn)
+	require.Equal(t, message.MsgPiece, msg.ID)
+	buf := make([]byte, MaxBlockSize+100)
+	n, err := message.ParsePiece(1, buf, msg)
+	require.NoError(t, err)
+	assert.Equal(t, MaxBlockSize+100, n)
+	assert.Equal(t, data[2*MaxBlockSize:], buf)
+
+	assert.Eventually(t, func() bool {
+		uploaded, _, _ := e.Transferred()
+		return uploaded == MaxBlockSize+100
+	}, time.Second, time.Millisecond)
+	_, _, left := e.Transferred()
+	assert.Equal(t, int64(0), left)
+}
+
+func TestServeConnBroadcastsHave(t *testing.T) {
+	data := testData(2 * MaxBlockSize)
+	e := newTestExchange(data, MaxBlockSize)
+	conn, _ := startServing(t, e, nil)
+
+	msg := readMsg(t, conn)
+	require.Equal(t, message.MsgBitfield, msg.ID)
+	assert.False(t, bitfields.Bitfield(msg.Payload).HasPiece(1))
+
+	go e.markHave(1)
+	msg = readMsg(t, conn)
+	index, err := message.ParseHave(msg)
+	require.NoError(t, err)
+	assert.Equal(t, 1, index)
+}
+
+func TestServeConnRejectsBadRequests(t *testing.T) {
+	data := testData(2 * MaxBlockSize)
+	tests := map[string]*message.Message{
+		"missing piece":   message.FormatRequest(1, 0, MaxBlockSize),
+		"unknown piece":   message.FormatRequest(7, 0, MaxBlockSize),
+		"past piece end":  message.FormatRequest(0, 100, MaxBlockSize),
+		"oversized block": message.FormatRequest(0, 0, MaxRequestLength+1),
+	}
+	for name, req := range tests {
+		t.Run(name, func(t *testing.T) {
+			e := newTestExchange(data, MaxBlockSize)
+			e.MarkHave(0)
+			conn, done := startServing(t, e, nil)
+
+			readMsg(t, conn)
+			conn.Write((&message.Message{ID: message.MsgInterested}).Serialize())
+			readMsg(t, conn)
+			conn.Write(req.Serialize())
+
+			assert.ErrorIs(t, <-done, ErrBadRequest)
+		})
+	}
+}
+
+func TestServeConnWaitsForChoker(t *testing.T) {
+	data := testData(2 * MaxBlockSize)
+	e := newTestExchange(data, MaxBlockSize)
+	copy(output(e), data)
+	e.MarkHave(0, 1)
+	e.Choker = choker.New(1, func() bool { return true })
+	conn, _ := startServing(t, e, nil)
+
+	readMsg(t, conn)
+	_, err := conn.Write((&message.Message{ID: message.MsgInterested}).Serialize())
+	require.NoError(t, err)
+
+	// Requests made while choked are ignored
+	_, err = conn.Write(message.FormatRequest(0, 0, MaxBlockSize).Serialize())
+	require.NoError(t, err)
+
+	go func() {
+		assert.Eventually(t, func() bool {
+			e.mu.Lock()
+			defer e.mu.Unlock()
+			for u := range e.uploads {
+				if u.Interested() {
+					return true
+				}
+			}
+			return false
+		}, time.Second, time.Millisecond)
+		e.Choker.Rechoke(true)
+	}()
+	assert.Equal(t, message.MsgUnchoke, readMsg(t, conn).ID)
+
+	_, err = conn.Write(message.FormatRequest(1, 0, MaxBlockSize).Serialize())
+	require.NoError(t, err)
+	msg := readMsg(t, conn)
+	require.Equal(t, message.MsgPiece, msg.ID)
+	buf := make([]byte, MaxBlockSize)
+	_, err = message.ParsePiece(1, buf, msg)
+	require.NoError(t, err)
+	assert.Equal(t, data[MaxBlockSize:], buf)
+}
+
+func TestServeConnExtensions(t *testing.T) {
+	data := testData(MaxBlockSize)
+	e := newTestExchange(data, MaxBlockSize)
+	e.Extensions = extension.NewRegistry()
+	e.Extensions.Version = "test"
+	got := make(chan string, 1)
+	e.Extensions.Register("ut_echo", extension.HandlerFunc(func(p *extension.Peer, payload []byte) error {
+		got <- string(payload)
+		return p.Send("ut_echo", payload)
+	}))
+
+	hs := &handshake.Handshake{Pstr: handshake.ProtocolName, InfoHash: e.InfoHash}
+	hs.SetExtensions()
+	conn, _ := startServing(t, e, hs)
+
+	assert.Equal(t, message.MsgBitfield, readMsg(t, conn).ID)
+	msg := readMsg(t, conn)
+	require.Equal(t, message.MsgExtended, msg.ID)
+	remote := extension.NewRegistry()
+	remote.Register("ut_echo", extension.HandlerFunc(func(p *extension.Peer, payload []byte) error {
+		got <- "echo " + string(payload)
+		return nil
+	}))
+	ext := remote.NewPeer(func(msg *message.Message) error {
+		_, err := conn.Write(msg.Serialize())
+		return err
+	})
+	require.NoError(t, ext.Handle(msg.Payload))
+	hsRemote, ok := ext.Remote()
+	require.True(t, ok)
+	assert.Equal(t, "test", hsRemote.V)
+
+	require.NoError(t, ext.SendHandshake())
+	require.NoError(t, ext.Send("ut_echo", []byte("hi")))
+	assert.Equal(t, "hi", <-got)
+	require.NoError(t, ext.Handle(readMsg(t, conn).Payload))
+	assert.Equal(t, "echo hi", <-got)
+}
+
+func TestServeConnPEX(t *testing.T) {
+	data := testData(MaxBlockSize)
+	e := newTestExchange(data, MaxBlockSize)
+	e.Extensions = extension.NewRegistry()
+	e.PEX = pex.New(e.AddPeers)
+	e.Extensions.Register(pex.Name, e.PEX)
+
+	hs := &handshake.Handshake{Pstr: handshake.ProtocolName, InfoHash: e.InfoHash}
+	hs.SetExtensions()
+	conn, _ := startServing(t, e, hs)
+	assert.Equal(t, message.MsgBitfield, readMsg(t, conn).ID)
+
+	remote := extension.NewRegistry()
+	remote.Port = 7000
+	remote.Register(pex.Name, extension.HandlerFunc(func(*extension.Peer, []byte) error { return nil }))
+	ext := remote.NewPeer(func(msg *message.Message) error {
+		_, err := conn.Write(msg.Serialize())
+		return err
+	})
+	require.NoError(t, ext.Handle(readMsg(t, conn).Payload))
+	require.NoError(t, ext.SendHandshake())
+
+	msg := pex.Message{Added: []peers.Peer{{IP: net.IPv4(10, 0, 0, 1).To4(), Port: 6881}}}
+	payload, err := msg.Marshal()
+	require.NoError(t, err)
+	require.NoError(t, ext.Send(pex.Name, payload))
+
+	assert.Eventually(t, func() bool {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		return len(e.Peers) == 1 && e.Peers[0].String() == "10.0.0.1:6881"
+	}, time.Second, time.Millisecond)
+}
+
+// startServingTCP is startServing over a loopback TCP connection, for tests
+// that depend on the address of the peer
+func startServingTCP(t *testing.T, e *Exchange, hs *handshake.Handshake) net.Conn {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	local, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	remote, err := ln.Accept()
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		e.ServeConn(ctx, remote, hs)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		local.Close()
+		<-finished
+	})
+	return local
+}
+
+func TestServeConnFastBitfield(t *testing.T) {
+	data := testData(2 * MaxBlockSize)
+	hs := &handshake.Handshake{Pstr: handshake.ProtocolName}
+	hs.SetFast()
+	tests := map[string]struct {
+		fast bool
+		have []int
+		want *message.Message
+	}{
+		"have none":      {fast: true, want: &message.Message{ID: message.MsgHaveNone, Payload: []byte{}}},
+		"have all":       {fast: true, have: []int{0, 1}, want: &message.Message{ID: message.MsgHaveAll, Payload: []byte{}}},
+		"some pieces":    {fast: true, have: []int{1}, want: &message.Message{ID: message.MsgBitfield, Payload: []byte{0b01000000}}},
+		"not negotiated": {have: []int{0, 1}, want: &message.Message{ID: message.MsgBitfield, Payload: []byte{0b11000000}}},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			e := newTestExchange(data, MaxBlockSize)
+			e.Fast = test.fast
+			e.MarkHave(test.have...)
+			conn, _ := startServing(t, e, hs)
+			assert.Equal(t, test.want, readMsg(t, conn))
+		})
+	}
+}
+
+func TestServeConnAllowedFast(t *testing.T) {
+	const numPieces = 20
+	data := testData(numPieces * MaxBlockSize)
+	e := newTestExchange(data, MaxBlockSize)
+	e.Fast = true
+	copy(output(e), data)
+
+	allowed := allowedFastSet(net.IPv4(127, 0, 0, 1), e.InfoHash, numPieces, AllowedFastSetSize)
+	require.Len(t, allowed, AllowedFastSetSize)
+	missing, notAllowed := allowed[0], -1
+	for index := range numPieces {
+		if index != missing {
+			e.MarkHave(index)
+		}
+		if notAllowed == -1 && !slices.Contains(allowed, index) {
+			notAllowed = index
+		}
+	}
+
+	hs := &handshake.Handshake{Pstr: handshake.ProtocolName}
+	hs.SetFast()
+	conn := startServingTCP(t, e, hs)
+	assert.Equal(t, message.MsgBitfield, readMsg(t, conn).ID)
+	// Only the allowed pieces we have are announced
+	for _, index := range allowed[1:] {
+		got, err := message.ParseAllowedFast(readMsg(t, conn))
+		require.NoError(t, err)
+		assert.Equal(t, index, got)
+	}
+
+	// While choked, allowed pieces are served and other requests rejected
+	_, err := conn.Write(message.FormatRequest(allowed[1], 0, MaxBlockSize).Serialize())
+	require.NoError(t, err)
+	assert.Equal(t, message.MsgPiece, readMsg(t, conn).ID)
+
+	for _, index := range []int{notAllowed, missing} {
+		_, err = conn.Write(message.FormatRequest(index, 0, MaxBlockSize).Serialize())
+		require.NoError(t, err)
+		got, begin, length, err := message.ParseReject(readMsg(t, conn))
+		require.NoError(t, err)
+		assert.Equal(t, []int{index, 0, MaxBlockSize}, []int{got, begin, length})
+	}
+}
@@ -0,0 +1,175 @@
+package exchange
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"Torrentasaurus_Rex/internal/client"
+	"Torrentasaurus_Rex/internal/peers"
+	"Torrentasaurus_Rex/internal/webseed"
+)
+
+// session holds the state shared by the workers of a running download
+type session struct {
+	ctx     context.Context
+	picker  *PiecePicker
+	results chan *pieceResult
+
+	mu     sync.Mutex
+	known  map[string]bool
+	active int
+	// pieces holds the progress of the pieces being downloaded and the
+	// blocks kept from interrupted attempts
+	pieces  map[int]*pieceProgress
+	endgame bool
+	// idle is signalled whenever the number of active workers drops to zero
+	idle chan struct{}
+}
+
+func newSession(ctx context.Context, picker *PiecePicker, results chan *pieceResult) *session {
+	return &session{
+		ctx:     ctx,
+		picker:  picker,
+		results: results,
+		known:   make(map[string]bool),
+		pieces:  make(map[int]*pieceProgress),
+		idle:    make(chan struct{}, 1),
+	}
+}
+
+// addPeers starts a worker for every peer that wasn't seen before
+func (s *session) addPeers(e *Exchange, found []peers.Peer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range found {
+		if s.known[p.String()] {
+			continue
+		}
+		s.known[p.String()] = true
+		s.active++
+		go func(p peers.Peer) {
+			defer s.workerDone()
+			e.startWorker(s, p)
+		}(p)
+	}
+	if s.active == 0 {
+		s.signalIdle()
+	}
+}
+
+// addWebSeeds starts a worker for every web seed. Web seeds count as
+// workers, so a download doesn't fail for lack of peers while they run.
+func (s *session) addWebSeeds(e *Exchange, seeds []*webseed.Seed) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, seed := range seeds {
+		s.active++
+		go func(seed *webseed.Seed) {
+			defer s.workerDone()
+			e.runWebSeed(s, seed)
+		}(seed)
+	}
+}
+
+// workerDone records that a worker exited
+func (s *session) workerDone() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.active--
+	if s.active == 0 {
+		s.signalIdle()
+	}
+}
+
+// progress returns the progress of a piece picked for download, creating it
+// unless blocks were kept from an earlier attempt
+func (s *session) progress(index, length int) *pieceProgress {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.pieces[index]
+	if !ok {
+		p = newPieceProgress(index, length)
+		s.pieces[index] = p
+	}
+	return p
+}
+
+// endgamePiece returns an unfinished piece that c doesn't work on yet and
+// that wanted accepts, preferring the one with the fewest workers. It is
+// only used once the picker has handed out every piece.
+func (s *session) endgamePiece(c *client.Client, wanted func(int) bool) *pieceProgress {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.endgame {
+		s.endgame = true
+		log.Printf("Entering endgame with %d pieces in flight", len(s.pieces))
+	}
+	var best *pieceProgress
+	bestWorkers := 0
+	for index, p := range s.pieces {
+		if !wanted(index) || p.complete() || p.joined(c) {
+			continue
+		}
+		if n := p.workers(); best == nil || n < bestWorkers {
+			best, bestWorkers = p, n
+		}
+	}
+	return best
+}
+
+// release is called when c stops working on a piece without completing it.
+// The last worker to leave puts the piece back into the picker, keeping its
+// blocks if there are any.
+func (s *session) release(p *pieceProgress, c *client.Client) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if p.leave(c) > 0 {
+		return
+	}
+	kept := p.hasData()
+	if !kept {
+		delete(s.pieces, p.index)
+	}
+	s.picker.Abort(p.index, kept)
+}
+
+// abort puts a piece that wasn't completed back into the picker, keeping
+// the blocks peers downloaded earlier
+func (s *session) abort(index int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, kept := s.pieces[index]
+	s.picker.Abort(index, kept)
+}
+
+// finish drops the progress of a piece completed without the peer workers,
+// which stop handing it on
+func (s *session) finish(index int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if p, ok := s.pieces[index]; ok {
+		p.finish()
+		delete(s.pieces, index)
+	}
+}
+
+// forget drops the progress of a piece that is done or has to start over
+func (s *session) forget(index int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.pieces, index)
+}
+
+func (s *session) activeWorkers() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.active
+}
+
+func (s *session) signalIdle() {
+	select {
+	case s.idle <- struct{}{}:
+	default:
+	}
+}
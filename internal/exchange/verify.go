@@ -0,0 +1,28 @@
+package exchange
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"Torrentasaurus_Rex/internal/storage"
+)
+
+// Verify hashes every piece read from the backend and returns the indexes of
+// the pieces that don't match their expected hash. Pieces that can't be read
+// in full count as mismatches.
+func (e *Exchange) Verify(b storage.Backend) ([]int, error) {
+	var bad []int
+	buf := make([]byte, e.PieceLength)
+	for index := range e.numPieces() {
+		begin, end := e.calculateBoundsForPiece(index)
+		n, err := b.ReadAt(index, buf[:end-begin], 0)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("failed to read piece #%d: %w", index, err)
+		}
+		if n != end-begin || e.checkPiece(index, buf[:n]) != nil {
+			bad = append(bad, index)
+		}
+	}
+	return bad, nil
+}
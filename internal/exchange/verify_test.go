@@ -0,0 +1,48 @@
+package exchange
+
+import (
+	"testing"
+
+	"Torrentasaurus_Rex/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerify(t *testing.T) {
+	data := testData(1000)
+	e := newTestExchange(data, 100)
+
+	bad, err := e.Verify(memoryWith(e, data))
+	require.NoError(t, err)
+	assert.Empty(t, bad)
+}
+
+func TestVerifyCorruptPieces(t *testing.T) {
+	data := testData(1000)
+	e := newTestExchange(data, 100)
+
+	corrupt := append([]byte(nil), data...)
+	corrupt[150] ^= 0xff
+	corrupt[999] ^= 0xff
+
+	bad, err := e.Verify(memoryWith(e, corrupt))
+	require.NoError(t, err)
+	assert.Equal(t, []int{1, 9}, bad)
+}
+
+func TestVerifyShortData(t *testing.T) {
+	data := testData(1000)
+	e := newTestExchange(data, 100)
+
+	bad, err := e.Verify(memoryWith(e, data[:850]))
+	require.NoError(t, err)
+	assert.Equal(t, []int{8, 9}, bad)
+}
+
+// memoryWith returns a memory backend for the exchange holding data
+func memoryWith(e *Exchange, data []byte) storage.Backend {
+	m := storage.NewMemory(e.PieceLength, e.Length)
+	copy(m.Bytes(), data)
+	return m
+}
@@ -0,0 +1,101 @@
+package exchange
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"Torrentasaurus_Rex/internal/webseed"
+)
+
+const (
+	// webSeedRangeSize is the amount of data asked from a web seed at once
+	webSeedRangeSize = 4 << 20
+	// webSeedPollInterval is how often an idle web seed checks for pieces
+	// that peers gave up on
+	webSeedPollInterval = time.Second
+)
+
+// runWebSeed downloads ranges of pieces from a web seed until none are
+// left, the context is cancelled, or the mirror failed too often
+func (e *Exchange) runWebSeed(s *session, seed *webseed.Seed) {
+	maxPieces := max(1, webSeedRangeSize/e.PieceLength)
+	for {
+		first, n, ok := s.picker.PickRange(maxPieces)
+		if !ok {
+			if s.picker.Remaining() == 0 || !sleep(s.ctx, webSeedPollInterval) {
+				return
+			}
+			continue
+		}
+
+		err := e.fetchRange(s, seed, first, n)
+		if err == nil {
+			seed.Succeeded()
+			continue
+		}
+		if s.ctx.Err() != nil {
+			return
+		}
+		log.Printf("Web seed %s: %v", seed.URL, err)
+		wait, ok := seed.Failed()
+		if !ok {
+			log.Printf("Dropping web seed %s", seed.URL)
+			return
+		}
+		if !sleep(s.ctx, wait) {
+			return
+		}
+	}
+}
+
+// fetchRange downloads n consecutive pieces from a web seed and hands on
+// those that pass the integrity check. The others go back to the picker.
+func (e *Exchange) fetchRange(s *session, seed *webseed.Seed, first, n int) error {
+	begin, _ := e.calculateBoundsForPiece(first)
+	_, end := e.calculateBoundsForPiece(first + n - 1)
+	buf := make([]byte, end-begin)
+	if err := seed.ReadAt(s.ctx, buf, begin); err != nil {
+		for index := first; index < first+n; index++ {
+			s.abort(index)
+		}
+		return err
+	}
+	e.downloaded.Add(int64(len(buf)))
+
+	var bad int
+	for index := first; index < first+n; index++ {
+		pieceBegin, pieceEnd := e.calculateBoundsForPiece(index)
+		piece := buf[pieceBegin-begin : pieceEnd-begin]
+		if err := e.checkPiece(index, piece); err != nil {
+			e.wasted.Add(int64(len(piece)))
+			s.abort(index)
+			bad++
+			continue
+		}
+		s.finish(index)
+		select {
+		case s.results <- &pieceResult{index: index, buf: piece}:
+			s.picker.Done(index)
+		case <-s.ctx.Done():
+			return s.ctx.Err()
+		}
+	}
+	if bad > 0 {
+		return fmt.Errorf("%w: %d of %d pieces", ErrIntegrity, bad, n)
+	}
+	return nil
+}
+
+// sleep waits for d and returns false when the context is cancelled first
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
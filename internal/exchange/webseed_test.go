@@ -0,0 +1,119 @@
+package exchange
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"Torrentasaurus_Rex/internal/peers"
+	"Torrentasaurus_Rex/internal/torrent"
+	"Torrentasaurus_Rex/internal/webseed"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startWebSeed serves data over HTTP with Range support and returns a web
+// seed for it along with the number of requests the server received
+func startWebSeed(t *testing.T, e *Exchange, data []byte) (*webseed.Seed, *atomic.Int64) {
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+	t.Cleanup(server.Close)
+
+	files := []torrent.FileEntry{{Path: []string{e.Name}, Length: e.Length}}
+	seed, err := webseed.New(server.URL+"/"+e.Name, files)
+	require.NoError(t, err)
+	seed.RetryDelay = 10 * time.Millisecond
+	return seed, &requests
+}
+
+func TestDownloadFromWebSeed(t *testing.T) {
+	data := testData(5*MaxBlockSize + 100)
+	e := newTestExchange(data, MaxBlockSize)
+	seed, _ := startWebSeed(t, e, data)
+	e.WebSeeds = []*webseed.Seed{seed}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	require.NoError(t, e.Download(ctx))
+	assert.Equal(t, data, output(e))
+	_, downloaded, left := e.Transferred()
+	assert.Equal(t, int64(len(data)), downloaded)
+	assert.Zero(t, left)
+}
+
+func TestDownloadFromWebSeedAndPeers(t *testing.T) {
+	data := testData(8 * MaxBlockSize)
+	e := newTestExchange(data, MaxBlockSize)
+	seed, _ := startWebSeed(t, e, data)
+	_, peer := startFakeSeeder(t, e.InfoHash, data, e.PieceLength, false)
+	e.WebSeeds = []*webseed.Seed{seed}
+	e.Peers = []peers.Peer{peer}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	require.NoError(t, e.Download(ctx))
+	assert.Equal(t, data, output(e))
+}
+
+func TestDownloadDropsBadWebSeed(t *testing.T) {
+	data := testData(4 * MaxBlockSize)
+	e := newTestExchange(data, MaxBlockSize)
+	corrupt := bytes.Clone(data)
+	for begin := 0; begin < len(corrupt); begin += e.PieceLength {
+		corrupt[begin] ^= 0xff
+	}
+	bad, badRequests := startWebSeed(t, e, corrupt)
+	bad.MaxFailures = 2
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// The bad mirror alone can't complete the download
+	e.WebSeeds = []*webseed.Seed{bad}
+	assert.ErrorIs(t, e.Download(ctx), ErrNoPeers)
+	assert.Equal(t, int64(2), badRequests.Load(), "the mirror is dropped after MaxFailures")
+
+	good, _ := startWebSeed(t, e, data)
+	e.WebSeeds = []*webseed.Seed{good}
+	require.NoError(t, e.Download(ctx))
+	assert.Equal(t, data, output(e))
+}
+
+func TestFetchRangeKeepsGoodPieces(t *testing.T) {
+	data := testData(4 * MaxBlockSize)
+	e := newTestExchange(data, MaxBlockSize)
+	corrupt := bytes.Clone(data)
+	corrupt[MaxBlockSize] ^= 0xff
+	seed, _ := startWebSeed(t, e, corrupt)
+
+	picker := NewPiecePicker(4)
+	picker.intn = func(int) int { return 0 }
+	results := make(chan *pieceResult, 4)
+	s := newSession(context.Background(), picker, results)
+	first, n, ok := picker.PickRange(4)
+	require.True(t, ok)
+	require.Equal(t, []int{0, 4}, []int{first, n})
+
+	err := e.fetchRange(s, seed, first, n)
+	assert.ErrorIs(t, err, ErrIntegrity)
+	close(results)
+	var got []int
+	for res := range results {
+		got = append(got, res.index)
+		begin, end := e.calculateBoundsForPiece(res.index)
+		assert.Equal(t, data[begin:end], res.buf)
+	}
+	assert.Equal(t, []int{0, 2, 3}, got)
+	assert.Equal(t, 1, picker.Pending(), "the bad piece goes back to the picker")
+	assert.Equal(t, int64(MaxBlockSize), e.Wasted())
+}
@@ -0,0 +1,221 @@
+// Package extension implements the extension protocol (BEP 10): the
+// extended handshake that maps extension names to message IDs and the
+// dispatch of extended messages to the extensions registered by name.
+package extension
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"Torrentasaurus_Rex/internal/bencode"
+	"Torrentasaurus_Rex/internal/message"
+)
+
+// HandshakeID is the extended message ID of the extended handshake
+const HandshakeID = 0
+
+// ErrNotSupported is returned when sending a message of an extension the
+// peer didn't announce
+var ErrNotSupported = errors.New("extension not supported by peer")
+
+// Handshake is the dictionary of the extended handshake
+type Handshake struct {
+	// M maps extension names to the message IDs the sender wants to receive
+	// them with. An ID of 0 disables the extension.
+	M map[string]int `bencode:"m"`
+	// V is the client name and version
+	V string `bencode:"v,omitempty"`
+	// P is the TCP port the sender listens on
+	P int `bencode:"p,omitempty"`
+	// Reqq is the number of outstanding requests the sender accepts
+	Reqq int `bencode:"reqq,omitempty"`
+	// MetadataSize is the size of the info dictionary (BEP 9)
+	MetadataSize int `bencode:"metadata_size,omitempty"`
+}
+
+// Handler handles the messages of one extension. payload is the message
+// without the extended message ID.
+type Handler interface {
+	HandleExtended(p *Peer, payload []byte) error
+}
+
+// HandlerFunc adapts a function to a Handler
+type HandlerFunc func(p *Peer, payload []byte) error
+
+// HandleExtended calls f
+func (f HandlerFunc) HandleExtended(p *Peer, payload []byte) error {
+	return f(p, payload)
+}
+
+// Registry holds the extensions we support and the fields of our extended
+// handshake. Extensions must be registered before peers are created.
+type Registry struct {
+	// Version, Port, Reqq and MetadataSize fill the matching fields of our
+	// handshake when set
+	Version      string
+	Port         int
+	Reqq         int
+	MetadataSize int
+
+	names    []string
+	handlers []Handler
+}
+
+// NewRegistry creates a registry without extensions
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds an extension and returns the message ID peers must use to
+// send its messages to us. IDs are assigned in registration order.
+func (r *Registry) Register(name string, h Handler) byte {
+	for i, registered := range r.names {
+		if registered == name {
+			r.handlers[i] = h
+			return byte(i + 1)
+		}
+	}
+	r.names = append(r.names, name)
+	r.handlers = append(r.handlers, h)
+	return byte(len(r.names))
+}
+
+// Handshake returns our extended handshake
+func (r *Registry) Handshake() Handshake {
+	m := make(map[string]int, len(r.names))
+	for i, name := range r.names {
+		m[name] = i + 1
+	}
+	return Handshake{M: m, V: r.Version, P: r.Port, Reqq: r.Reqq, MetadataSize: r.MetadataSize}
+}
+
+// handler returns the extension registered under a local message ID
+func (r *Registry) handler(id byte) Handler {
+	if id == HandshakeID || int(id) > len(r.handlers) {
+		return nil
+	}
+	return r.handlers[id-1]
+}
+
+// Peer is the extension state of a connection to a peer that supports the
+// extension protocol
+type Peer struct {
+	r    *Registry
+	send func(*message.Message) error
+
+	mu     sync.Mutex
+	remote *Handshake
+}
+
+// NewPeer creates the extension state of a connection. send writes a
+// message to the connection and must be safe for concurrent use.
+func (r *Registry) NewPeer(send func(*message.Message) error) *Peer {
+	return &Peer{r: r, send: send}
+}
+
+// SendHandshake sends our extended handshake to the peer
+func (p *Peer) SendHandshake() error {
+	payload, err := bencode.Marshal(p.r.Handshake())
+	if err != nil {
+		return err
+	}
+	return p.send(Format(HandshakeID, payload))
+}
+
+// Handle processes the payload of an extended message from the peer. The
+// extended handshake is recorded; other messages go to the extension they
+// were sent for. Messages for unknown extensions are ignored.
+func (p *Peer) Handle(payload []byte) error {
+	if len(payload) == 0 {
+		return errors.New("empty extended message")
+	}
+	id, payload := payload[0], payload[1:]
+	if id == HandshakeID {
+		return p.handleHandshake(payload)
+	}
+	if h := p.r.handler(id); h != nil {
+		return h.HandleExtended(p, payload)
+	}
+	return nil
+}
+
+// handleHandshake records the peer's extended handshake. A later handshake
+// updates the earlier one, so extensions can be enabled or disabled.
+func (p *Peer) handleHandshake(payload []byte) error {
+	var hs Handshake
+	if err := bencode.UnmarshalLenient(payload, &hs); err != nil {
+		return fmt.Errorf("malformed extended handshake: %w", err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.remote == nil {
+		p.remote = &Handshake{M: make(map[string]int)}
+	}
+	for name, id := range hs.M {
+		if id <= 0 || id > 255 {
+			delete(p.remote.M, name)
+			continue
+		}
+		p.remote.M[name] = id
+	}
+	if hs.V != "" {
+		p.remote.V = hs.V
+	}
+	if hs.P != 0 {
+		p.remote.P = hs.P
+	}
+	if hs.Reqq != 0 {
+		p.remote.Reqq = hs.Reqq
+	}
+	if hs.MetadataSize != 0 {
+		p.remote.MetadataSize = hs.MetadataSize
+	}
+	return nil
+}
+
+// Remote returns the peer's extended handshake and whether it arrived yet
+func (p *Peer) Remote() (Handshake, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.remote == nil {
+		return Handshake{}, false
+	}
+	hs := *p.remote
+	hs.M = make(map[string]int, len(p.remote.M))
+	for name, id := range p.remote.M {
+		hs.M[name] = id
+	}
+	return hs, true
+}
+
+// Supports tells whether the peer announced an extension
+func (p *Peer) Supports(name string) bool {
+	_, ok := p.remoteID(name)
+	return ok
+}
+
+// Send sends a message of an extension with the ID the peer asked for
+func (p *Peer) Send(name string, payload []byte) error {
+	id, ok := p.remoteID(name)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNotSupported, name)
+	}
+	return p.send(Format(id, payload))
+}
+
+func (p *Peer) remoteID(name string) (byte, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.remote == nil {
+		return 0, false
+	}
+	id, ok := p.remote.M[name]
+	return byte(id), ok
+}
+
+// Format builds an extended message with the given extended message ID
+func Format(id byte, payload []byte) *message.Message {
+	return &message.Message{ID: message.MsgExtended, Payload: append([]byte{id}, payload...)}
+}
@@ -0,0 +1,97 @@
+package extension
+
+import (
+	"testing"
+
+	"Torrentasaurus_Rex/internal/bencode"
+	"Torrentasaurus_Rex/internal/message"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pipe connects two peers so that messages sent by one are handled by the
+// other
+func pipe(t *testing.T, a, b *Registry) (*Peer, *Peer) {
+	var pa, pb *Peer
+	deliver := func(to **Peer) func(*message.Message) error {
+		return func(msg *message.Message) error {
+			require.Equal(t, message.MsgExtended, msg.ID)
+			return (*to).Handle(msg.Payload)
+		}
+	}
+	pa = a.NewPeer(deliver(&pb))
+	pb = b.NewPeer(deliver(&pa))
+	return pa, pb
+}
+
+func TestRegistryHandshake(t *testing.T) {
+	r := NewRegistry()
+	r.Version = "Torrentasaurus Rex"
+	r.Port = 6881
+	r.Reqq = 250
+	assert.Equal(t, byte(1), r.Register("ut_metadata", HandlerFunc(nil)))
+	assert.Equal(t, byte(2), r.Register("ut_pex", HandlerFunc(nil)))
+	assert.Equal(t, byte(1), r.Register("ut_metadata", HandlerFunc(nil)))
+
+	data, err := bencode.Marshal(r.Handshake())
+	require.NoError(t, err)
+	assert.Equal(t, "d1:md11:ut_metadatai1e6:ut_pexi2ee1:pi6881e4:reqqi250e1:v18:Torrentasaurus Rexe", string(data))
+}
+
+func TestPeerDispatch(t *testing.T) {
+	var got []string
+	a := NewRegistry()
+	a.Register("ut_pex", HandlerFunc(func(p *Peer, payload []byte) error {
+		got = append(got, "pex:"+string(payload))
+		return nil
+	}))
+	a.Register("ut_metadata", HandlerFunc(func(p *Peer, payload []byte) error {
+		got = append(got, "metadata:"+string(payload))
+		return p.Send("ut_metadata", []byte("reply"))
+	}))
+	b := NewRegistry()
+	b.MetadataSize = 1234
+	b.Register("ut_metadata", HandlerFunc(func(p *Peer, payload []byte) error {
+		got = append(got, "b metadata:"+string(payload))
+		return nil
+	}))
+	pa, pb := pipe(t, a, b)
+
+	_, ok := pa.Remote()
+	assert.False(t, ok)
+	assert.ErrorIs(t, pb.Send("ut_metadata", []byte("x")), ErrNotSupported)
+
+	require.NoError(t, pa.SendHandshake())
+	require.NoError(t, pb.SendHandshake())
+	remote, ok := pa.Remote()
+	require.True(t, ok)
+	assert.Equal(t, 1234, remote.MetadataSize)
+	assert.True(t, pa.Supports("ut_metadata"))
+	assert.False(t, pa.Supports("ut_pex"))
+	assert.True(t, pb.Supports("ut_pex"))
+
+	require.NoError(t, pb.Send("ut_pex", []byte("peers")))
+	require.NoError(t, pb.Send("ut_metadata", []byte("request")))
+	assert.ErrorIs(t, pa.Send("ut_pex", nil), ErrNotSupported)
+	assert.Equal(t, []string{"pex:peers", "metadata:request", "b metadata:reply"}, got)
+
+	// Unknown IDs are ignored
+	assert.NoError(t, pa.Handle([]byte{9, 'x'}))
+	assert.Error(t, pa.Handle(nil))
+}
+
+func TestPeerHandshakeUpdate(t *testing.T) {
+	p := NewRegistry().NewPeer(func(*message.Message) error { return nil })
+	require.NoError(t, p.Handle([]byte("\x00d1:md11:ut_metadatai3e6:ut_pexi1ee1:v3:abce")))
+	assert.True(t, p.Supports("ut_pex"))
+
+	require.NoError(t, p.Handle([]byte("\x00d1:md6:ut_pexi0eee")))
+	assert.False(t, p.Supports("ut_pex"))
+	remote, ok := p.Remote()
+	require.True(t, ok)
+	assert.Equal(t, map[string]int{"ut_metadata": 3}, remote.M)
+	assert.Equal(t, "abc", remote.V)
+
+	assert.Error(t, p.Handle([]byte("\x00not bencode")))
+}
@@ -2,6 +2,7 @@ package handshake
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -16,23 +17,72 @@ const (
 	FixedHeaderSize   = ReservedBytesSize + InfoHashSize + PeerIDSize
 )
 
+// extensionProtocolBit is the reserved bit that announces support for the
+// extension protocol (BEP 10): bit 20 counted from the right
+const extensionProtocolByte, extensionProtocolBit = 5, 0x10
+
+// fastExtensionBit is the reserved bit that announces support for the Fast
+// Extension (BEP 6): the third bit counted from the right
+const fastExtensionByte, fastExtensionBit = 7, 0x04
+
+// v2Bit is the reserved bit that announces support for BitTorrent v2
+// (BEP 52): the fifth bit counted from the right
+const v2Byte, v2Bit = 7, 0x10
+
 // A Handshake is a special message that a peer uses to identify itself
 type Handshake struct {
-	Pstr     string
+	Pstr string
+	// Reserved advertises protocol extensions
+	Reserved [ReservedBytesSize]byte
 	InfoHash [20]byte
 	PeerID   [20]byte
 }
 
+// SupportsExtensions tells whether the extension protocol bit is set
+func (h *Handshake) SupportsExtensions() bool {
+	return h.Reserved[extensionProtocolByte]&extensionProtocolBit != 0
+}
+
+// SetExtensions sets the extension protocol bit
+func (h *Handshake) SetExtensions() {
+	h.Reserved[extensionProtocolByte] |= extensionProtocolBit
+}
+
+// SupportsFast tells whether the Fast Extension bit is set
+func (h *Handshake) SupportsFast() bool {
+	return h.Reserved[fastExtensionByte]&fastExtensionBit != 0
+}
+
+// SetFast sets the Fast Extension bit
+func (h *Handshake) SetFast() {
+	h.Reserved[fastExtensionByte] |= fastExtensionBit
+}
+
+// SupportsV2 tells whether the BitTorrent v2 bit is set
+func (h *Handshake) SupportsV2() bool {
+	return h.Reserved[v2Byte]&v2Bit != 0
+}
+
+// SetV2 sets the BitTorrent v2 bit
+func (h *Handshake) SetV2() {
+	h.Reserved[v2Byte] |= v2Bit
+}
+
 // CompleteHandshake performs the handshake process with the peer
 func CompleteHandshake(conn net.Conn, infohash, peerID [InfoHashSize]byte) (*Handshake, error) {
-	conn.SetDeadline(time.Now().Add(3 * time.Second))
-	defer conn.SetDeadline(time.Time{}) // Disable the deadline
-
-	req := &Handshake{
+	return Initiate(conn, &Handshake{
 		Pstr:     ProtocolName,
 		InfoHash: infohash,
 		PeerID:   peerID,
-	}
+	})
+}
+
+// Initiate sends our handshake req and reads the peer's answer, which must
+// be for the same infohash
+func Initiate(conn net.Conn, req *Handshake) (*Handshake, error) {
+	conn.SetDeadline(time.Now().Add(3 * time.Second))
+	defer conn.SetDeadline(time.Time{}) // Disable the deadline
+
 	_, err := conn.Write(req.serialize())
 	if err != nil {
 		return nil, fmt.Errorf("failed to write handshake: %w", err)
@@ -42,19 +92,54 @@ func CompleteHandshake(conn net.Conn, infohash, peerID [InfoHashSize]byte) (*Han
 	if err != nil {
 		return nil, fmt.Errorf("failed to read handshake: %w", err)
 	}
-	if !bytes.Equal(res.InfoHash[:], infohash[:]) {
-		return nil, fmt.Errorf("expected infohash %x but got %x", res.InfoHash, infohash)
+	if !bytes.Equal(res.InfoHash[:], req.InfoHash[:]) {
+		return nil, fmt.Errorf("expected infohash %x but got %x", req.InfoHash, res.InfoHash)
 	}
 	return res, nil
 }
 
+// ErrUnknownInfoHash is returned when a peer asks for a torrent we don't serve
+var ErrUnknownInfoHash = errors.New("unknown infohash")
+
+// AcceptHandshake performs the handshake as the receiving side of an
+// incoming connection. It reads the peer's handshake, checks that known
+// accepts the requested infohash and answers with our own handshake.
+func AcceptHandshake(conn net.Conn, peerID [PeerIDSize]byte, known func(infoHash [InfoHashSize]byte) bool) (*Handshake, error) {
+	return Accept(conn, &Handshake{PeerID: peerID}, known)
+}
+
+// Accept is like AcceptHandshake but answers with res, which carries our
+// peer ID and reserved bits. The protocol and infohash of res are filled in.
+func Accept(conn net.Conn, res *Handshake, known func(infoHash [InfoHashSize]byte) bool) (*Handshake, error) {
+	conn.SetDeadline(time.Now().Add(3 * time.Second))
+	defer conn.SetDeadline(time.Time{}) // Disable the deadline
+
+	req, err := read(conn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read handshake: %w", err)
+	}
+	if req.Pstr != ProtocolName {
+		return nil, fmt.Errorf("unexpected protocol %q", req.Pstr)
+	}
+	if !known(req.InfoHash) {
+		return nil, fmt.Errorf("%w %x", ErrUnknownInfoHash, req.InfoHash)
+	}
+
+	res.Pstr = ProtocolName
+	res.InfoHash = req.InfoHash
+	if _, err := conn.Write(res.serialize()); err != nil {
+		return nil, fmt.Errorf("failed to write handshake: %w", err)
+	}
+	return req, nil
+}
+
 // serialize serializes the handshake to a buffer
 func (h *Handshake) serialize() []byte {
 	buf := make([]byte, len(h.Pstr)+1+FixedHeaderSize)
 	buf[0] = byte(len(h.Pstr))
 	curr := 1
 	curr += copy(buf[curr:], h.Pstr)
-	curr += copy(buf[curr:], make([]byte, ReservedBytesSize))
+	curr += copy(buf[curr:], h.Reserved[:])
 	curr += copy(buf[curr:], h.InfoHash[:])
 	curr += copy(buf[curr:], h.PeerID[:])
 	return buf
@@ -79,13 +164,16 @@ func read(r io.Reader) (*Handshake, error) {
 		return nil, err
 	}
 
+	var reserved [ReservedBytesSize]byte
 	var infoHash, peerID [InfoHashSize]byte
 
+	copy(reserved[:], handshakeBuf[pstrlen:pstrlen+ReservedBytesSize])
 	copy(infoHash[:], handshakeBuf[pstrlen+ReservedBytesSize:pstrlen+ReservedBytesSize+InfoHashSize])
 	copy(peerID[:], handshakeBuf[pstrlen+ReservedBytesSize+InfoHashSize:])
 
 	h := Handshake{
 		Pstr:     string(handshakeBuf[0:pstrlen]),
+		Reserved: reserved,
 		InfoHash: infoHash,
 		PeerID:   peerID,
 	}
@@ -123,3 +123,109 @@ func TestCompleteHandshake(t *testing.T) {
 		}
 	}
 }
+
+func TestAcceptHandshake(t *testing.T) {
+	infoHash := [InfoHashSize]byte{134, 212, 200, 0, 36, 164, 105, 190, 76, 80, 188, 90, 16, 44, 247, 23, 128, 49, 0, 116}
+	clientPeerID := [PeerIDSize]byte{1, 2, 3}
+	serverPeerID := [PeerIDSize]byte{4, 5, 6}
+	known := func(h [InfoHashSize]byte) bool { return h == infoHash }
+
+	clientConn, serverConn := createClientAndServer(t)
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	type result struct {
+		h   *Handshake
+		err error
+	}
+	initiated := make(chan result, 1)
+	go func() {
+		h, err := CompleteHandshake(clientConn, infoHash, clientPeerID)
+		initiated <- result{h, err}
+	}()
+
+	accepted, err := AcceptHandshake(serverConn, serverPeerID, known)
+	require.NoError(t, err)
+	assert.Equal(t, clientPeerID, accepted.PeerID)
+	assert.Equal(t, infoHash, accepted.InfoHash)
+
+	res := <-initiated
+	require.NoError(t, res.err)
+	assert.Equal(t, serverPeerID, res.h.PeerID)
+}
+
+func TestAcceptHandshakeUnknownInfoHash(t *testing.T) {
+	clientConn, serverConn := createClientAndServer(t)
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	req := &Handshake{Pstr: ProtocolName, InfoHash: [InfoHashSize]byte{9}, PeerID: [PeerIDSize]byte{1}}
+	clientConn.Write(req.serialize())
+
+	_, err := AcceptHandshake(serverConn, [PeerIDSize]byte{2}, func([InfoHashSize]byte) bool { return false })
+	assert.ErrorIs(t, err, ErrUnknownInfoHash)
+}
+
+func TestReservedExtensionBit(t *testing.T) {
+	h := &Handshake{Pstr: ProtocolName, InfoHash: [InfoHashSize]byte{1}, PeerID: [PeerIDSize]byte{2}}
+	assert.False(t, h.SupportsExtensions())
+	h.SetExtensions()
+	assert.Equal(t, [ReservedBytesSize]byte{0, 0, 0, 0, 0, 0x10, 0, 0}, h.Reserved)
+
+	parsed, err := read(bytes.NewReader(h.serialize()))
+	require.NoError(t, err)
+	assert.True(t, parsed.SupportsExtensions())
+	assert.Equal(t, h, parsed)
+}
+
+func TestReservedFastBit(t *testing.T) {
+	h := &Handshake{Pstr: ProtocolName}
+	assert.False(t, h.SupportsFast())
+	h.SetFast()
+	h.SetExtensions()
+	assert.Equal(t, [ReservedBytesSize]byte{0, 0, 0, 0, 0, 0x10, 0, 0x04}, h.Reserved)
+
+	parsed, err := read(bytes.NewReader(h.serialize()))
+	require.NoError(t, err)
+	assert.True(t, parsed.SupportsFast())
+	assert.True(t, parsed.SupportsExtensions())
+}
+
+func TestReservedV2Bit(t *testing.T) {
+	h := &Handshake{Pstr: ProtocolName}
+	assert.False(t, h.SupportsV2())
+	h.SetV2()
+	h.SetFast()
+	assert.Equal(t, [ReservedBytesSize]byte{0, 0, 0, 0, 0, 0, 0, 0x14}, h.Reserved)
+
+	parsed, err := read(bytes.NewReader(h.serialize()))
+	require.NoError(t, err)
+	assert.True(t, parsed.SupportsV2())
+	assert.True(t, parsed.SupportsFast())
+}
+
+func TestAcceptWithExtensions(t *testing.T) {
+	infoHash := [InfoHashSize]byte{7}
+	clientConn, serverConn := createClientAndServer(t)
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	initiated := make(chan *Handshake, 1)
+	go func() {
+		req := &Handshake{Pstr: ProtocolName, InfoHash: infoHash, PeerID: [PeerIDSize]byte{1}}
+		req.SetExtensions()
+		h, _ := Initiate(clientConn, req)
+		initiated <- h
+	}()
+
+	res := &Handshake{PeerID: [PeerIDSize]byte{2}}
+	res.SetExtensions()
+	req, err := Accept(serverConn, res, func([InfoHashSize]byte) bool { return true })
+	require.NoError(t, err)
+	assert.True(t, req.SupportsExtensions())
+
+	h := <-initiated
+	require.NotNil(t, h)
+	assert.True(t, h.SupportsExtensions())
+	assert.Equal(t, infoHash, h.InfoHash)
+}
@@ -0,0 +1,227 @@
+// Package merkle implements the SHA-256 hash trees of BitTorrent v2 (BEP 52).
+// Every file has its own tree whose leaves are the hashes of its 16 KiB
+// blocks. The leaves are padded with zero hashes up to a power of two, so
+// the tree is complete.
+package merkle
+
+import (
+	"crypto/sha256"
+	"math/bits"
+)
+
+const (
+	// BlockSize is the amount of data covered by a leaf
+	BlockSize = 16 << 10
+	// HashSize is the size of the hashes of the tree
+	HashSize = sha256.Size
+)
+
+// pads holds the roots of subtrees of zero leaves by height
+var pads [64][HashSize]byte
+
+func init() {
+	for h := 1; h < len(pads); h++ {
+		pads[h] = parent(pads[h-1], pads[h-1])
+	}
+}
+
+func parent(left, right [HashSize]byte) [HashSize]byte {
+	var buf [2 * HashSize]byte
+	copy(buf[:HashSize], left[:])
+	copy(buf[HashSize:], right[:])
+	return sha256.Sum256(buf[:])
+}
+
+// nextPow2 rounds n up to a power of two
+func nextPow2(n int) int {
+	if n <= 1 {
+		return 1
+	}
+	return 1 << bits.Len(uint(n-1))
+}
+
+// log2 returns the logarithm of a power of two
+func log2(n int) int {
+	return bits.TrailingZeros(uint(n))
+}
+
+// NumLeaves returns the number of leaves of the tree of a file: its blocks
+// rounded up to a power of two
+func NumLeaves(length int) int {
+	return nextPow2((length + BlockSize - 1) / BlockSize)
+}
+
+// BlockHashes returns the hashes of the blocks of data, the leaves of its tree
+func BlockHashes(data []byte) [][HashSize]byte {
+	hashes := make([][HashSize]byte, 0, (len(data)+BlockSize-1)/BlockSize)
+	for begin := 0; begin < len(data); begin += BlockSize {
+		hashes = append(hashes, sha256.Sum256(data[begin:min(begin+BlockSize, len(data))]))
+	}
+	return hashes
+}
+
+// RootOf returns the root of a subtree whose bottom layer, at height layer
+// above the leaves, holds the hashes followed by padding up to width nodes.
+// width must be a power of two no smaller than the number of hashes.
+func RootOf(hashes [][HashSize]byte, layer, width int) [HashSize]byte {
+	level := hashes
+	for ; width > 1; width /= 2 {
+		if len(level) == 0 {
+			return pads[layer+log2(width)]
+		}
+		next := make([][HashSize]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			right := pads[layer]
+			if i+1 < len(level) {
+				right = level[i+1]
+			}
+			next = append(next, parent(level[i], right))
+		}
+		level = next
+		layer++
+	}
+	if len(level) == 0 {
+		return pads[layer]
+	}
+	return level[0]
+}
+
+// Root returns the pieces root of a file, the root of its tree
+func Root(data []byte) [HashSize]byte {
+	return RootOf(BlockHashes(data), 0, NumLeaves(len(data)))
+}
+
+// PieceLayer returns the height of the piece layer for pieces of
+// pieceLength bytes, a power of two of at least BlockSize
+func PieceLayer(pieceLength int) int {
+	return log2(pieceLength / BlockSize)
+}
+
+// PieceLayerRoot returns the pieces root of a file from the hashes of its
+// piece layer
+func PieceLayerRoot(hashes [][HashSize]byte, pieceLength int) [HashSize]byte {
+	return RootOf(hashes, PieceLayer(pieceLength), nextPow2(len(hashes)))
+}
+
+// VerifyProof checks hashes received in a hashes message against the root
+// of a tree: length hashes of layer starting at index, followed by the
+// uncle hashes up to the root
+func VerifyProof(root [HashSize]byte, layer, index, length int, hashes [][HashSize]byte) bool {
+	if length < 1 || length&(length-1) != 0 || index%length != 0 || len(hashes) < length {
+		return false
+	}
+	node := RootOf(hashes[:length], layer, length)
+	pos := index / length
+	for _, uncle := range hashes[length:] {
+		if pos%2 == 0 {
+			node = parent(node, uncle)
+		} else {
+			node = parent(uncle, node)
+		}
+		pos /= 2
+	}
+	return pos == 0 && node == root
+}
+
+// Piece tells how a piece of a v2 torrent is verified. Pieces never span
+// files, so each covers a subtree of the tree of its file.
+type Piece struct {
+	// Root is the pieces root of the file
+	Root [HashSize]byte
+	// Hash is the root of the subtree of the piece: a hash of the piece
+	// layer, or the pieces root for a file no longer than a piece
+	Hash [HashSize]byte
+	// Block is the index of the first block of the piece within the file
+	Block int
+	// Leaves is the width of the subtree, including zero leaves past the
+	// end of the file
+	Leaves int
+	// Length is the number of bytes of the piece
+	Length int
+}
+
+// Verify tells whether data is the content of the piece
+func (p Piece) Verify(data []byte) bool {
+	return len(data) == p.Length && RootOf(BlockHashes(data), 0, p.Leaves) == p.Hash
+}
+
+// VerifyLeaves tells whether hashes are the leaves of the subtree of the
+// piece, so they can be used to check blocks one at a time
+func (p Piece) VerifyLeaves(hashes [][HashSize]byte) bool {
+	return len(hashes) == p.Leaves && RootOf(hashes, 0, p.Leaves) == p.Hash
+}
+
+// Tree holds the layers of the tree of a file from a base layer up to the
+// root, for example from the piece layer found in the metainfo
+type Tree struct {
+	base int
+	// layers[i] holds the hashes of layer base+i without padding
+	layers [][][HashSize]byte
+}
+
+// NewTree builds the tree of a file with the given number of leaves above
+// the hashes of layer base
+func NewTree(hashes [][HashSize]byte, base, leaves int) *Tree {
+	t := &Tree{base: base, layers: [][][HashSize]byte{hashes}}
+	level := hashes
+	for width, layer := max(leaves>>base, 1), base; width > 1; width, layer = width/2, layer+1 {
+		next := make([][HashSize]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			right := pads[layer]
+			if i+1 < len(level) {
+				right = level[i+1]
+			}
+			next = append(next, parent(level[i], right))
+		}
+		t.layers = append(t.layers, next)
+		level = next
+	}
+	return t
+}
+
+// PieceTree builds the tree of a file above the subtrees of its pieces,
+// given in order
+func PieceTree(pieces []Piece) *Tree {
+	hashes := make([][HashSize]byte, len(pieces))
+	for i, p := range pieces {
+		hashes[i] = p.Hash
+	}
+	return NewTree(hashes, log2(pieces[0].Leaves), nextPow2(len(pieces))*pieces[0].Leaves)
+}
+
+// Root returns the root of the tree
+func (t *Tree) Root() [HashSize]byte {
+	return t.at(len(t.layers)-1, 0)
+}
+
+// at returns a node, padding included
+func (t *Tree) at(l, i int) [HashSize]byte {
+	if i < len(t.layers[l]) {
+		return t.layers[l][i]
+	}
+	return pads[t.base+l]
+}
+
+// Hashes returns length hashes of layer starting at index, followed by up
+// to proofLayers uncle hashes on the way to the root, as sent in a hashes
+// message. It returns false when the tree doesn't hold the layer or the
+// range is invalid.
+func (t *Tree) Hashes(layer, index, length, proofLayers int) ([][HashSize]byte, bool) {
+	l := layer - t.base
+	if l < 0 || l >= len(t.layers) || length < 1 || length&(length-1) != 0 || index < 0 || index%length != 0 {
+		return nil, false
+	}
+	if width := 1 << (len(t.layers) - 1 - l); index+length > width {
+		return nil, false
+	}
+	hashes := make([][HashSize]byte, 0, length+proofLayers)
+	for i := index; i < index+length; i++ {
+		hashes = append(hashes, t.at(l, i))
+	}
+	h, pos := l+log2(length), index/length
+	for ; proofLayers > 0 && h < len(t.layers)-1; proofLayers-- {
+		hashes = append(hashes, t.at(h, pos^1))
+		h, pos = h+1, pos/2
+	}
+	return hashes, true
+}
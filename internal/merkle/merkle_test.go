@@ -0,0 +1,90 @@
+package merkle
+
+import (
+	"crypto/sha256"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testData(size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i*13 + i/251)
+	}
+	return data
+}
+
+func TestRoot(t *testing.T) {
+	small := testData(100)
+	assert.Equal(t, sha256.Sum256(small), Root(small), "a single block is its own root")
+
+	data := testData(2*BlockSize + 10)
+	h := BlockHashes(data)
+	require.Len(t, h, 3)
+	var zero [HashSize]byte
+	assert.Equal(t, parent(parent(h[0], h[1]), parent(h[2], zero)), Root(data))
+	assert.Equal(t, 4, NumLeaves(len(data)))
+}
+
+func TestPieceLayer(t *testing.T) {
+	// Five blocks in pieces of two; the last piece is padded with a zero leaf
+	data := testData(4*BlockSize + 1)
+	h := BlockHashes(data)
+	layer := [][HashSize]byte{RootOf(h[0:2], 0, 2), RootOf(h[2:4], 0, 2), RootOf(h[4:], 0, 2)}
+	assert.Equal(t, Root(data), RootOf(layer, 1, 4), "the padding above the piece layer is a zero subtree")
+	assert.Equal(t, Root(data), PieceLayerRoot(layer, 2*BlockSize))
+
+	last := Piece{Root: Root(data), Hash: layer[2], Block: 4, Leaves: 2, Length: 1}
+	assert.True(t, last.Verify(data[4*BlockSize:]))
+	assert.False(t, last.Verify([]byte{data[len(data)-1] + 1}))
+	assert.True(t, last.VerifyLeaves([][HashSize]byte{h[4], {}}))
+	assert.False(t, last.VerifyLeaves(h[4:]))
+}
+
+func TestTreeHashes(t *testing.T) {
+	data := testData(6*BlockSize + 5)
+	leaves := BlockHashes(data)
+	root := Root(data)
+
+	tree := NewTree(leaves, 0, NumLeaves(len(data)))
+	assert.Equal(t, root, tree.Root())
+
+	// Two leaves with the uncles up to the root
+	hashes, ok := tree.Hashes(0, 2, 2, 10)
+	require.True(t, ok)
+	assert.Len(t, hashes, 4)
+	assert.Equal(t, leaves[2:4], hashes[:2])
+	assert.True(t, VerifyProof(root, 0, 2, 2, hashes))
+	assert.False(t, VerifyProof(root, 0, 0, 2, hashes), "hashes for a different index")
+
+	// The padded end of a layer is served too
+	hashes, ok = tree.Hashes(0, 6, 2, 2)
+	require.True(t, ok)
+	assert.True(t, VerifyProof(root, 0, 6, 2, hashes))
+
+	// A tree built from a piece layer of pieces of two blocks
+	var layer [][HashSize]byte
+	for i := 0; i < len(leaves); i += 2 {
+		layer = append(layer, RootOf(leaves[i:min(i+2, len(leaves))], 0, 2))
+	}
+	pieces := NewTree(layer, 1, NumLeaves(len(data)))
+	assert.Equal(t, root, pieces.Root())
+	hashes, ok = pieces.Hashes(1, 0, 4, 0)
+	require.True(t, ok)
+	assert.Equal(t, root, RootOf(hashes, 1, 4))
+
+	var v2Pieces []Piece
+	for _, h := range layer {
+		v2Pieces = append(v2Pieces, Piece{Root: root, Hash: h, Leaves: 2})
+	}
+	assert.Equal(t, root, PieceTree(v2Pieces).Root())
+
+	_, ok = pieces.Hashes(0, 0, 2, 0)
+	assert.False(t, ok, "leaves below the base layer are unknown")
+	_, ok = pieces.Hashes(1, 1, 2, 0)
+	assert.False(t, ok, "index not a multiple of length")
+	_, ok = pieces.Hashes(1, 0, 8, 0)
+	assert.False(t, ok, "range past the end of the layer")
+}
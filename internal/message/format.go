@@ -0,0 +1,87 @@
+package message
+
+import "encoding/binary"
+
+// FormatRequest creates a REQUEST message
+func FormatRequest(index, begin, length int) *Message {
+	payload := make([]byte, 12)
+	binary.BigEndian.PutUint32(payload[0:4], uint32(index))
+	binary.BigEndian.PutUint32(payload[4:8], uint32(begin))
+	binary.BigEndian.PutUint32(payload[8:12], uint32(length))
+	return &Message{ID: MsgRequest, Payload: payload}
+}
+
+// FormatCancel creates a CANCEL message for a previously requested block
+func FormatCancel(index, begin, length int) *Message {
+	msg := FormatRequest(index, begin, length)
+	msg.ID = MsgCancel
+	return msg
+}
+
+// FormatHave creates a HAVE message
+func FormatHave(index int) *Message {
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint32(payload, uint32(index))
+	return &Message{ID: MsgHave, Payload: payload}
+}
+
+// FormatPiece creates a PIECE message carrying a block of a piece
+func FormatPiece(index, begin int, block []byte) *Message {
+	payload := make([]byte, 8+len(block))
+	binary.BigEndian.PutUint32(payload[0:4], uint32(index))
+	binary.BigEndian.PutUint32(payload[4:8], uint32(begin))
+	copy(payload[8:], block)
+	return &Message{ID: MsgPiece, Payload: payload}
+}
+
+// FormatReject creates a REJECT message for a request that won't be answered
+func FormatReject(index, begin, length int) *Message {
+	msg := FormatRequest(index, begin, length)
+	msg.ID = MsgReject
+	return msg
+}
+
+// FormatSuggest creates a SUGGEST message
+func FormatSuggest(index int) *Message {
+	msg := FormatHave(index)
+	msg.ID = MsgSuggest
+	return msg
+}
+
+// FormatAllowedFast creates an ALLOWED FAST message
+func FormatAllowedFast(index int) *Message {
+	msg := FormatHave(index)
+	msg.ID = MsgAllowedFast
+	return msg
+}
+
+// FormatHashRequest creates a HASH REQUEST message
+func FormatHashRequest(req HashRequest) *Message {
+	return &Message{ID: MsgHashRequest, Payload: hashRequestPayload(req, 0)}
+}
+
+// FormatHashes creates a HASHES message answering a hash request
+func FormatHashes(req HashRequest, hashes [][32]byte) *Message {
+	payload := hashRequestPayload(req, len(hashes))
+	for _, h := range hashes {
+		payload = append(payload, h[:]...)
+	}
+	return &Message{ID: MsgHashes, Payload: payload}
+}
+
+// FormatHashReject creates a HASH REJECT message for a hash request that
+// won't be answered
+func FormatHashReject(req HashRequest) *Message {
+	return &Message{ID: MsgHashReject, Payload: hashRequestPayload(req, 0)}
+}
+
+// hashRequestPayload encodes a hash request, leaving room for n hashes
+func hashRequestPayload(req HashRequest, n int) []byte {
+	payload := make([]byte, hashRequestSize, hashRequestSize+n*32)
+	copy(payload[0:32], req.PiecesRoot[:])
+	binary.BigEndian.PutUint32(payload[32:36], uint32(req.BaseLayer))
+	binary.BigEndian.PutUint32(payload[36:40], uint32(req.Index))
+	binary.BigEndian.PutUint32(payload[40:44], uint32(req.Length))
+	binary.BigEndian.PutUint32(payload[44:48], uint32(req.ProofLayers))
+	return payload
+}
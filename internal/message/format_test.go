@@ -0,0 +1,116 @@
+package message
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatRequest(t *testing.T) {
+	msg := FormatRequest(4, 567, 4321)
+	expected := &Message{
+		ID: MsgRequest,
+		Payload: []byte{
+			0x00, 0x00, 0x00, 0x04, // Index
+			0x00, 0x00, 0x02, 0x37, // Begin
+			0x00, 0x00, 0x10, 0xe1, // Length
+		},
+	}
+	assert.Equal(t, expected, msg)
+}
+
+func TestFormatCancel(t *testing.T) {
+	msg := FormatCancel(4, 567, 4321)
+	expected := &Message{
+		ID: MsgCancel,
+		Payload: []byte{
+			0x00, 0x00, 0x00, 0x04, // Index
+			0x00, 0x00, 0x02, 0x37, // Begin
+			0x00, 0x00, 0x10, 0xe1, // Length
+		},
+	}
+	assert.Equal(t, expected, msg)
+}
+
+func TestFormatHave(t *testing.T) {
+	msg := FormatHave(4)
+	expected := &Message{
+		ID:      MsgHave,
+		Payload: []byte{0x00, 0x00, 0x00, 0x04},
+	}
+	assert.Equal(t, expected, msg)
+}
+
+func TestFormatHaveRoundTrip(t *testing.T) {
+	index, err := ParseHave(FormatHave(1234))
+	assert.NoError(t, err)
+	assert.Equal(t, 1234, index)
+}
+
+func TestFormatPiece(t *testing.T) {
+	msg := FormatPiece(4, 567, []byte("data"))
+	expected := &Message{
+		ID: MsgPiece,
+		Payload: []byte{
+			0x00, 0x00, 0x00, 0x04, // Index
+			0x00, 0x00, 0x02, 0x37, // Begin
+			'd', 'a', 't', 'a', // Block
+		},
+	}
+	assert.Equal(t, expected, msg)
+
+	buf := make([]byte, 600)
+	n, err := ParsePiece(4, buf, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, n)
+	assert.Equal(t, []byte("data"), buf[567:571])
+}
+
+func TestFormatFastMessages(t *testing.T) {
+	reject := FormatReject(4, 567, 4321)
+	assert.Equal(t, MsgReject, reject.ID)
+	index, begin, length, err := ParseReject(reject)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{4, 567, 4321}, []int{index, begin, length})
+
+	suggest := FormatSuggest(7)
+	assert.Equal(t, &Message{ID: MsgSuggest, Payload: []byte{0, 0, 0, 7}}, suggest)
+	index, err = ParseSuggest(suggest)
+	assert.NoError(t, err)
+	assert.Equal(t, 7, index)
+
+	allowed := FormatAllowedFast(1313)
+	index, err = ParseAllowedFast(allowed)
+	assert.NoError(t, err)
+	assert.Equal(t, 1313, index)
+
+	_, err = ParseAllowedFast(suggest)
+	assert.ErrorIs(t, err, ErrInvalidMessageID)
+}
+
+func TestFormatHashMessages(t *testing.T) {
+	req := HashRequest{PiecesRoot: [32]byte{1, 2, 3}, BaseLayer: 0, Index: 4, Length: 2, ProofLayers: 3}
+
+	parsed, err := ParseHashRequest(FormatHashRequest(req))
+	assert.NoError(t, err)
+	assert.Equal(t, req, parsed)
+
+	parsed, err = ParseHashReject(FormatHashReject(req))
+	assert.NoError(t, err)
+	assert.Equal(t, req, parsed)
+
+	hashes := [][32]byte{{7}, {8}, {9}}
+	parsed, got, err := ParseHashes(FormatHashes(req, hashes))
+	assert.NoError(t, err)
+	assert.Equal(t, req, parsed)
+	assert.Equal(t, hashes, got)
+
+	_, err = ParseHashRequest(FormatHashReject(req))
+	assert.ErrorIs(t, err, ErrInvalidMessageID)
+	msg := FormatHashes(req, hashes)
+	msg.Payload = msg.Payload[:len(msg.Payload)-1]
+	_, _, err = ParseHashes(msg)
+	assert.ErrorIs(t, err, ErrPayloadLength)
+	_, err = ParseHashRequest(&Message{ID: MsgHashRequest, Payload: make([]byte, 20)})
+	assert.ErrorIs(t, err, ErrPayloadLength)
+}
@@ -21,6 +21,24 @@ const (
 	MsgPiece messageID = 7
 	// MsgCancel cancels a request
 	MsgCancel messageID = 8
+	// MsgSuggest recommends a piece to download (BEP 6)
+	MsgSuggest messageID = 13
+	// MsgHaveAll replaces the bitfield of a peer that has every piece (BEP 6)
+	MsgHaveAll messageID = 14
+	// MsgHaveNone replaces the bitfield of a peer that has no piece (BEP 6)
+	MsgHaveNone messageID = 15
+	// MsgReject tells that a request won't be answered (BEP 6)
+	MsgReject messageID = 16
+	// MsgAllowedFast lets the receiver request a piece while choked (BEP 6)
+	MsgAllowedFast messageID = 17
+	// MsgExtended carries a message of the extension protocol (BEP 10)
+	MsgExtended messageID = 20
+	// MsgHashRequest asks for hashes of the tree of a file (BEP 52)
+	MsgHashRequest messageID = 21
+	// MsgHashes delivers hashes of the tree of a file (BEP 52)
+	MsgHashes messageID = 22
+	// MsgHashReject tells that a hash request won't be answered (BEP 52)
+	MsgHashReject messageID = 23
 )
 
 // Message stores ID and payload of a message
@@ -28,3 +46,14 @@ type Message struct {
 	ID      messageID
 	Payload []byte
 }
+
+// HashRequest identifies hashes of the tree of a file (BEP 52): Length
+// hashes of layer BaseLayer starting at Index, followed by the uncle hashes
+// of ProofLayers layers above them
+type HashRequest struct {
+	PiecesRoot  [32]byte
+	BaseLayer   int
+	Index       int
+	Length      int
+	ProofLayers int
+}
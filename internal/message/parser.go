@@ -95,7 +95,22 @@ func ParsePiece(index int, buf []byte, msg *Message) (int, error) {
 
 // ParseHave parses a HAVE message
 func ParseHave(msg *Message) (int, error) {
-	if err := validateMessageID(MsgHave, msg.ID); err != nil {
+	return parseIndex(MsgHave, msg)
+}
+
+// ParseSuggest parses a SUGGEST message
+func ParseSuggest(msg *Message) (int, error) {
+	return parseIndex(MsgSuggest, msg)
+}
+
+// ParseAllowedFast parses an ALLOWED FAST message
+func ParseAllowedFast(msg *Message) (int, error) {
+	return parseIndex(MsgAllowedFast, msg)
+}
+
+// parseIndex parses a message whose payload is a single piece index
+func parseIndex(id messageID, msg *Message) (int, error) {
+	if err := validateMessageID(id, msg.ID); err != nil {
 		return 0, err
 	}
 	if err := validatePayloadLengthEqual(4, len(msg.Payload)); err != nil {
@@ -104,3 +119,83 @@ func ParseHave(msg *Message) (int, error) {
 	index := int(binary.BigEndian.Uint32(msg.Payload))
 	return index, nil
 }
+
+// ParseRequest parses a REQUEST message
+func ParseRequest(msg *Message) (index, begin, length int, err error) {
+	return parseBlock(MsgRequest, msg)
+}
+
+// ParseReject parses a REJECT message
+func ParseReject(msg *Message) (index, begin, length int, err error) {
+	return parseBlock(MsgReject, msg)
+}
+
+// parseBlock parses a message whose payload is the index, offset and length
+// of a block
+func parseBlock(id messageID, msg *Message) (index, begin, length int, err error) {
+	if err := validateMessageID(id, msg.ID); err != nil {
+		return 0, 0, 0, err
+	}
+	if err := validatePayloadLengthEqual(12, len(msg.Payload)); err != nil {
+		return 0, 0, 0, err
+	}
+	index = int(binary.BigEndian.Uint32(msg.Payload[0:4]))
+	begin = int(binary.BigEndian.Uint32(msg.Payload[4:8]))
+	length = int(binary.BigEndian.Uint32(msg.Payload[8:12]))
+	return index, begin, length, nil
+}
+
+// hashRequestSize is the size of the payload of a hash request
+const hashRequestSize = 48
+
+// ParseHashRequest parses a HASH REQUEST message
+func ParseHashRequest(msg *Message) (HashRequest, error) {
+	if err := validateMessageID(MsgHashRequest, msg.ID); err != nil {
+		return HashRequest{}, err
+	}
+	if err := validatePayloadLengthEqual(hashRequestSize, len(msg.Payload)); err != nil {
+		return HashRequest{}, err
+	}
+	return parseHashRequest(msg.Payload), nil
+}
+
+// ParseHashes parses a HASHES message into the request it answers and the
+// hashes it carries
+func ParseHashes(msg *Message) (HashRequest, [][32]byte, error) {
+	if err := validateMessageID(MsgHashes, msg.ID); err != nil {
+		return HashRequest{}, nil, err
+	}
+	if err := validatePayloadLengthLess(hashRequestSize, len(msg.Payload)); err != nil {
+		return HashRequest{}, nil, err
+	}
+	data := msg.Payload[hashRequestSize:]
+	if len(data)%32 != 0 {
+		return HashRequest{}, nil, fmt.Errorf("%w: %d bytes of hashes", ErrPayloadLength, len(data))
+	}
+	hashes := make([][32]byte, len(data)/32)
+	for i := range hashes {
+		copy(hashes[i][:], data[i*32:])
+	}
+	return parseHashRequest(msg.Payload), hashes, nil
+}
+
+// ParseHashReject parses a HASH REJECT message
+func ParseHashReject(msg *Message) (HashRequest, error) {
+	if err := validateMessageID(MsgHashReject, msg.ID); err != nil {
+		return HashRequest{}, err
+	}
+	if err := validatePayloadLengthEqual(hashRequestSize, len(msg.Payload)); err != nil {
+		return HashRequest{}, err
+	}
+	return parseHashRequest(msg.Payload), nil
+}
+
+func parseHashRequest(payload []byte) HashRequest {
+	var req HashRequest
+	copy(req.PiecesRoot[:], payload[0:32])
+	req.BaseLayer = int(binary.BigEndian.Uint32(payload[32:36]))
+	req.Index = int(binary.BigEndian.Uint32(payload[36:40]))
+	req.Length = int(binary.BigEndian.Uint32(payload[40:44]))
+	req.ProofLayers = int(binary.BigEndian.Uint32(payload[44:48]))
+	return req
+}
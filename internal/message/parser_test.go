@@ -265,3 +265,17 @@ func TestParseHave(t *testing.T) {
 		})
 	}
 }
+
+func TestParseRequest(t *testing.T) {
+	index, begin, length, err := ParseRequest(FormatRequest(3, 16384, 1024))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, index)
+	assert.Equal(t, 16384, begin)
+	assert.Equal(t, 1024, length)
+
+	_, _, _, err = ParseRequest(&Message{ID: MsgHave, Payload: make([]byte, 12)})
+	assert.Equal(t, fmt.Errorf("%w: expected %d, got %d", ErrInvalidMessageID, MsgRequest, MsgHave), err)
+
+	_, _, _, err = ParseRequest(&Message{ID: MsgRequest, Payload: make([]byte, 8)})
+	assert.Equal(t, fmt.Errorf("%w: %d != %d", ErrPayloadLength, 8, 12), err)
+}
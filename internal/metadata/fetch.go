@@ -0,0 +1,203 @@
+package metadata
+
+import (
+	"context"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"Torrentasaurus_Rex/internal/extension"
+	"Torrentasaurus_Rex/internal/handshake"
+	"Torrentasaurus_Rex/internal/message"
+	"Torrentasaurus_Rex/internal/mse"
+	"Torrentasaurus_Rex/internal/peers"
+)
+
+const (
+	// fetchTimeout bounds a whole metadata download from a single peer
+	fetchTimeout = 30 * time.Second
+	// maxParallelFetches bounds the peers asked for the metadata at once
+	maxParallelFetches = 8
+)
+
+// Fetch downloads the info dictionary with the given info hash from a peer
+// and checks it against the hash. encryption decides whether the connection
+// is encrypted, and dial opens it, over TCP when it is nil.
+func Fetch(ctx context.Context, peer peers.Peer, infoHash, peerID [20]byte, encryption mse.Policy, dial mse.DialFunc) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+
+	conn, err := mse.Dial(ctx, dial, peer.String(), infoHash, encryption)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	info, err := fetch(conn, infoHash, peerID)
+	if err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return info, err
+}
+
+// fetcher assembles the metadata pieces a peer sends
+type fetcher struct {
+	info     []byte
+	received []bool
+	left     int
+}
+
+// HandleExtended stores a metadata piece
+func (f *fetcher) HandleExtended(p *extension.Peer, payload []byte) error {
+	msg, data, err := parseMetadataMsg(payload)
+	if err != nil {
+		return err
+	}
+	switch msg.MsgType {
+	case msgReject:
+		return ErrRejected
+	case msgData:
+	default:
+		return nil
+	}
+	if f.info == nil {
+		return errors.New("unrequested metadata piece")
+	}
+	if msg.Piece < 0 || msg.Piece >= len(f.received) || len(data) != pieceLength(len(f.info), msg.Piece) {
+		return fmt.Errorf("unexpected metadata piece #%d of %d bytes", msg.Piece, len(data))
+	}
+	if !f.received[msg.Piece] {
+		copy(f.info[msg.Piece*BlockSize:], data)
+		f.received[msg.Piece] = true
+		f.left--
+	}
+	return nil
+}
+
+// fetch runs the metadata exchange on an established connection
+func fetch(conn net.Conn, infoHash, peerID [20]byte) ([]byte, error) {
+	req := &handshake.Handshake{Pstr: handshake.ProtocolName, InfoHash: infoHash, PeerID: peerID}
+	req.SetExtensions()
+	res, err := handshake.Initiate(conn, req)
+	if err != nil {
+		return nil, err
+	}
+	if !res.SupportsExtensions() {
+		return nil, ErrNotSupported
+	}
+
+	f := &fetcher{}
+	registry := extension.NewRegistry()
+	registry.Register(Name, f)
+	ext := registry.NewPeer(func(msg *message.Message) error {
+		_, err := conn.Write(msg.Serialize())
+		return err
+	})
+	if err := ext.SendHandshake(); err != nil {
+		return nil, err
+	}
+
+	for f.info == nil || f.left > 0 {
+		msg, err := message.Read(conn)
+		if err != nil {
+			return nil, err
+		}
+		// Everything but extended messages, such as the bitfield, is skipped
+		if msg == nil || msg.ID != message.MsgExtended {
+			continue
+		}
+		if err := ext.Handle(msg.Payload); err != nil {
+			return nil, err
+		}
+		if f.info != nil {
+			continue
+		}
+		remote, ok := ext.Remote()
+		if !ok {
+			continue
+		}
+		if err := f.request(ext, remote.MetadataSize); err != nil {
+			return nil, err
+		}
+	}
+
+	if sha1.Sum(f.info) != infoHash {
+		return nil, errors.New("metadata does not match info hash")
+	}
+	return f.info, nil
+}
+
+// request asks the peer for every piece of the metadata once its extended
+// handshake told us the size
+func (f *fetcher) request(ext *extension.Peer, size int) error {
+	if !ext.Supports(Name) {
+		return ErrNotSupported
+	}
+	if size <= 0 || size > MaxSize {
+		return fmt.Errorf("peer announced invalid metadata size %d", size)
+	}
+	f.info = make([]byte, size)
+	f.received = make([]bool, numPieces(size))
+	f.left = len(f.received)
+	for i := range f.received {
+		payload, err := formatMetadataMsg(metadataMsg{MsgType: msgRequest, Piece: i}, nil)
+		if err != nil {
+			return err
+		}
+		if err := ext.Send(Name, payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FetchFromPeers asks up to maxParallelFetches peers for the metadata at
+// once and returns the first copy that checks out. The other fetches are
+// cancelled then.
+func FetchFromPeers(ctx context.Context, list []peers.Peer, infoHash, peerID [20]byte, encryption mse.Policy, dial mse.DialFunc) ([]byte, error) {
+	if len(list) == 0 {
+		return nil, errors.New("no peers to fetch metadata from")
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		info []byte
+		err  error
+	}
+	queue := make(chan peers.Peer, len(list))
+	for _, peer := range list {
+		queue <- peer
+	}
+	close(queue)
+	results := make(chan result, len(list))
+	for range min(maxParallelFetches, len(list)) {
+		go func() {
+			for peer := range queue {
+				if ctx.Err() != nil {
+					results <- result{err: ctx.Err()}
+					continue
+				}
+				info, err := Fetch(ctx, peer, infoHash, peerID, encryption, dial)
+				if err != nil {
+					err = fmt.Errorf("%s: %w", peer, err)
+				}
+				results <- result{info, err}
+			}
+		}()
+	}
+
+	var errs []error
+	for range list {
+		res := <-results
+		if res.err == nil {
+			return res.info, nil
+		}
+		errs = append(errs, res.err)
+	}
+	return nil, fmt.Errorf("failed to fetch metadata: %w", errors.Join(errs...))
+}
@@ -0,0 +1,233 @@
+package metadata
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"Torrentasaurus_Rex/internal/bencode"
+	"Torrentasaurus_Rex/internal/extension"
+	"Torrentasaurus_Rex/internal/handshake"
+	"Torrentasaurus_Rex/internal/message"
+	"Torrentasaurus_Rex/internal/mse"
+	"Torrentasaurus_Rex/internal/peers"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakePeer serves metadata over ut_metadata to a single connection
+type fakePeer struct {
+	info       []byte
+	extensions bool
+	reject     bool
+	// encrypted makes the peer refuse plaintext connections
+	encrypted bool
+}
+
+func (f *fakePeer) serve(t *testing.T, conn net.Conn) {
+	defer conn.Close()
+	if f.encrypted {
+		c, _, err := mse.Accept(conn, mse.Required, func() [][20]byte { return [][20]byte{sha1.Sum(f.info)} })
+		if err != nil {
+			return
+		}
+		conn = c
+	}
+	hs := make([]byte, 1+len(handshake.ProtocolName)+handshake.FixedHeaderSize)
+	if _, err := io.ReadFull(conn, hs); err != nil {
+		return
+	}
+	if f.extensions {
+		hs[1+len(handshake.ProtocolName)+5] |= 0x10
+	} else {
+		hs[1+len(handshake.ProtocolName)+5] = 0
+	}
+	conn.Write(hs)
+	// A bitfield before the extension handshake must be skipped
+	conn.Write((&message.Message{ID: message.MsgBitfield, Payload: []byte{0xff}}).Serialize())
+
+	registry := extension.NewRegistry()
+	registry.MetadataSize = len(f.info)
+	registry.Register("ut_pex", extension.HandlerFunc(nil))
+	registry.Register(Name, extension.HandlerFunc(func(p *extension.Peer, payload []byte) error {
+		req, _, err := parseMetadataMsg(payload)
+		if err != nil {
+			return err
+		}
+		if f.reject {
+			payload, _ = formatMetadataMsg(metadataMsg{MsgType: msgReject, Piece: req.Piece}, nil)
+		} else {
+			begin := req.Piece * BlockSize
+			block := f.info[begin : begin+pieceLength(len(f.info), req.Piece)]
+			payload, _ = formatMetadataMsg(metadataMsg{MsgType: msgData, Piece: req.Piece, TotalSize: len(f.info)}, block)
+		}
+		return p.Send(Name, payload)
+	}))
+	ext := registry.NewPeer(func(msg *message.Message) error {
+		_, err := conn.Write(msg.Serialize())
+		return err
+	})
+	require.NoError(t, ext.SendHandshake())
+
+	for {
+		msg, err := message.Read(conn)
+		if err != nil {
+			return
+		}
+		if msg == nil || msg.ID != message.MsgExtended {
+			continue
+		}
+		if err := ext.Handle(msg.Payload); err != nil {
+			return
+		}
+	}
+}
+
+func startFakePeer(t *testing.T, f *fakePeer) peers.Peer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(t, conn)
+		}
+	}()
+	addr := ln.Addr().(*net.TCPAddr)
+	return peers.Peer{IP: addr.IP, Port: uint16(addr.Port)}
+}
+
+// testInfo returns an info dictionary spanning several metadata pieces
+func testInfo(t *testing.T) []byte {
+	pieces := bytes.Repeat([]byte("01234567890123456789"), 2000)
+	info, err := bencode.Marshal(map[string]any{
+		"length":       len(pieces) / 20 * 16384,
+		"name":         "file",
+		"piece length": 16384,
+		"pieces":       string(pieces),
+	})
+	require.NoError(t, err)
+	require.Greater(t, len(info), 2*BlockSize)
+	return info
+}
+
+func TestFetch(t *testing.T) {
+	info := testInfo(t)
+	peer := startFakePeer(t, &fakePeer{info: info, extensions: true})
+
+	got, err := Fetch(context.Background(), peer, sha1.Sum(info), [20]byte{'c'}, mse.Disabled, nil)
+	require.NoError(t, err)
+	assert.Equal(t, info, got)
+}
+
+func TestFetchEncrypted(t *testing.T) {
+	info := testInfo(t)
+	peer := startFakePeer(t, &fakePeer{info: info, extensions: true, encrypted: true})
+
+	got, err := Fetch(context.Background(), peer, sha1.Sum(info), [20]byte{'c'}, mse.Preferred, nil)
+	require.NoError(t, err)
+	assert.Equal(t, info, got)
+
+	_, err = Fetch(context.Background(), peer, sha1.Sum(info), [20]byte{'c'}, mse.Disabled, nil)
+	assert.Error(t, err)
+}
+
+func TestFetchFailures(t *testing.T) {
+	info := testInfo(t)
+	tests := map[string]struct {
+		peer     *fakePeer
+		infoHash [20]byte
+		err      error
+	}{
+		"no extensions": {peer: &fakePeer{info: info}, infoHash: sha1.Sum(info), err: ErrNotSupported},
+		"rejected":      {peer: &fakePeer{info: info, extensions: true, reject: true}, infoHash: sha1.Sum(info), err: ErrRejected},
+		"wrong hash":    {peer: &fakePeer{info: append(bytes.Clone(info[:len(info)-1]), '!'), extensions: true}, infoHash: sha1.Sum(info)},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			peer := startFakePeer(t, test.peer)
+			_, err := Fetch(context.Background(), peer, test.infoHash, [20]byte{'c'}, mse.Disabled, nil)
+			require.Error(t, err)
+			if test.err != nil {
+				assert.ErrorIs(t, err, test.err)
+			}
+		})
+	}
+}
+
+func TestFetchFromPeers(t *testing.T) {
+	info := testInfo(t)
+	bad := startFakePeer(t, &fakePeer{info: info})
+	good := startFakePeer(t, &fakePeer{info: info, extensions: true})
+
+	got, err := FetchFromPeers(context.Background(), []peers.Peer{bad, good}, sha1.Sum(info), [20]byte{'c'}, mse.Disabled, nil)
+	require.NoError(t, err)
+	assert.Equal(t, info, got)
+
+	_, err = FetchFromPeers(context.Background(), []peers.Peer{bad}, sha1.Sum(info), [20]byte{'c'}, mse.Disabled, nil)
+	assert.ErrorIs(t, err, ErrNotSupported)
+}
+
+func TestFetchFromPeersBoundsDials(t *testing.T) {
+	info := testInfo(t)
+	good := startFakePeer(t, &fakePeer{info: info, extensions: true})
+	list := []peers.Peer{good}
+	for i := range 50 {
+		list = append(list, peers.Peer{IP: net.IP{10, 0, 0, byte(i)}, Port: 1})
+	}
+
+	// Dials to the other peers hang until their fetch is cancelled
+	var mu sync.Mutex
+	dials, inFlight, maxInFlight := 0, 0, 0
+	dial := func(ctx context.Context, addr string) (net.Conn, error) {
+		mu.Lock()
+		dials++
+		inFlight++
+		maxInFlight = max(maxInFlight, inFlight)
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+		}()
+		if addr == good.String() {
+			var d net.Dialer
+			return d.DialContext(ctx, "tcp", addr)
+		}
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	got, err := FetchFromPeers(context.Background(), list, sha1.Sum(info), [20]byte{'c'}, mse.Disabled, dial)
+	require.NoError(t, err)
+	assert.Equal(t, info, got)
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return inFlight == 0
+	}, time.Second, time.Millisecond, "the other fetches are cancelled")
+	mu.Lock()
+	defer mu.Unlock()
+	assert.LessOrEqual(t, maxInFlight, maxParallelFetches)
+	assert.LessOrEqual(t, dials, maxParallelFetches+1)
+}
+
+func TestParseMetadataMsg(t *testing.T) {
+	payload := []byte("d8:msg_typei1e5:piecei2e10:total_sizei" + strconv.Itoa(3*BlockSize) + "eexyz")
+	msg, data, err := parseMetadataMsg(payload)
+	require.NoError(t, err)
+	assert.Equal(t, metadataMsg{MsgType: msgData, Piece: 2, TotalSize: 3 * BlockSize}, msg)
+	assert.Equal(t, []byte("xyz"), data)
+}
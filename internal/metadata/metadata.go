@@ -0,0 +1,74 @@
+// Package metadata downloads the info dictionary of a torrent from peers
+// with the ut_metadata extension (BEP 9), which is all a magnet link needs.
+package metadata
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"Torrentasaurus_Rex/internal/bencode"
+)
+
+const (
+	// Name is the name ut_metadata is registered under in the extended
+	// handshake
+	Name = "ut_metadata"
+	// BlockSize is the size of every metadata piece but the last
+	BlockSize = 16384
+	// MaxSize bounds the metadata size a peer may announce
+	MaxSize = 16 << 20
+)
+
+// The ut_metadata message types
+const (
+	msgRequest = 0
+	msgData    = 1
+	msgReject  = 2
+)
+
+var (
+	// ErrNotSupported is returned for peers that don't offer ut_metadata
+	ErrNotSupported = errors.New("peer does not support ut_metadata")
+	// ErrRejected is returned when a peer refuses to send a metadata piece
+	ErrRejected = errors.New("peer rejected metadata request")
+)
+
+// metadataMsg is the dictionary that starts every ut_metadata message. Data
+// messages carry the piece right after it.
+type metadataMsg struct {
+	MsgType   int `bencode:"msg_type"`
+	Piece     int `bencode:"piece"`
+	TotalSize int `bencode:"total_size,omitempty"`
+}
+
+// formatMetadataMsg encodes a ut_metadata message followed by data
+func formatMetadataMsg(msg metadataMsg, data []byte) ([]byte, error) {
+	payload, err := bencode.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	return append(payload, data...), nil
+}
+
+// parseMetadataMsg decodes a ut_metadata message and returns the data that
+// follows its dictionary
+func parseMetadataMsg(payload []byte) (metadataMsg, []byte, error) {
+	var msg metadataMsg
+	d := bencode.NewDecoder(bytes.NewReader(payload))
+	d.AllowNonCanonical()
+	if err := d.Decode(&msg); err != nil {
+		return msg, nil, fmt.Errorf("malformed ut_metadata message: %w", err)
+	}
+	return msg, payload[d.InputOffset():], nil
+}
+
+// numPieces returns the number of metadata pieces of size bytes
+func numPieces(size int) int {
+	return (size + BlockSize - 1) / BlockSize
+}
+
+// pieceLength returns the length of metadata piece i
+func pieceLength(size, i int) int {
+	return min(BlockSize, size-i*BlockSize)
+}
@@ -0,0 +1,184 @@
+package mse
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// handshakeTimeout bounds the key exchange
+const handshakeTimeout = 10 * time.Second
+
+// plaintextPrefix starts every plaintext BitTorrent handshake
+var plaintextPrefix = []byte("\x13BitTorrent protocol")
+
+// Initiate runs the key exchange on an outgoing connection to a peer of the
+// torrent with the given info hash, offering the crypto methods in provide.
+// It returns the connection to use from then on and the method the peer
+// selected.
+func Initiate(c net.Conn, infoHash [20]byte, provide CryptoMethod) (net.Conn, CryptoMethod, error) {
+	c.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer c.SetDeadline(time.Time{}) // Disable the deadline
+
+	private, ya, err := newKey()
+	if err != nil {
+		return nil, 0, err
+	}
+	padA, err := randomPad()
+	if err != nil {
+		return nil, 0, err
+	}
+	if _, err := c.Write(append(ya, padA...)); err != nil {
+		return nil, 0, fmt.Errorf("failed to write public key: %w", err)
+	}
+
+	br := bufio.NewReader(c)
+	yb := make([]byte, keySize)
+	if _, err := io.ReadFull(br, yb); err != nil {
+		return nil, 0, fmt.Errorf("failed to read public key: %w", err)
+	}
+	s := secret(yb, private)
+	enc := newCipher("keyA", s, infoHash)
+	dec := newCipher("keyB", s, infoHash)
+
+	// The hashes let the receiver find the end of our padding and the info
+	// hash we want, and the rest is encrypted: VC, crypto_provide, an empty
+	// PadC and an empty initial payload
+	var msg bytes.Buffer
+	msg.Write(hash([]byte("req1"), s))
+	msg.Write(xor(hash([]byte("req2"), infoHash[:]), hash([]byte("req3"), s)))
+	plain := make([]byte, 0, len(vc)+8)
+	plain = append(plain, vc...)
+	plain = binary.BigEndian.AppendUint32(plain, uint32(provide))
+	plain = binary.BigEndian.AppendUint16(plain, 0)
+	plain = binary.BigEndian.AppendUint16(plain, 0)
+	enc.XORKeyStream(plain, plain)
+	msg.Write(plain)
+	if _, err := c.Write(msg.Bytes()); err != nil {
+		return nil, 0, fmt.Errorf("failed to write crypto request: %w", err)
+	}
+
+	// The answer starts with VC once the padding of the receiver ends
+	pattern := make([]byte, len(vc))
+	dec.XORKeyStream(pattern, vc)
+	if err := synchronize(br, pattern); err != nil {
+		return nil, 0, err
+	}
+	buf, err := readEncrypted(br, dec, 4)
+	if err != nil {
+		return nil, 0, err
+	}
+	selected := CryptoMethod(binary.BigEndian.Uint32(buf))
+	if _, err := readPadding(br, dec, maxPad); err != nil {
+		return nil, 0, err
+	}
+	switch {
+	case selected == RC4 && provide&RC4 != 0:
+		return newConn(c, br, dec, enc, nil), selected, nil
+	case selected == Plaintext && provide&Plaintext != 0:
+		return newConn(c, br, nil, nil, nil), selected, nil
+	}
+	return nil, 0, fmt.Errorf("%w: peer selected %#x", ErrNoCryptoMethod, uint32(selected))
+}
+
+// Accept runs the key exchange on an incoming connection. The info hash the
+// peer asks for must be one of infoHashes. A plaintext BitTorrent handshake
+// is recognized and let through unless the policy requires encryption.
+func Accept(c net.Conn, policy Policy, infoHashes func() [][20]byte) (net.Conn, CryptoMethod, error) {
+	c.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer c.SetDeadline(time.Time{}) // Disable the deadline
+
+	br := bufio.NewReader(c)
+	prefix, err := br.Peek(len(plaintextPrefix))
+	if err != nil {
+		return nil, 0, err
+	}
+	if bytes.Equal(prefix, plaintextPrefix) {
+		if policy == Required {
+			return nil, 0, ErrPlaintext
+		}
+		return newConn(c, br, nil, nil, nil), Plaintext, nil
+	}
+	if policy == Disabled {
+		return nil, 0, ErrEncrypted
+	}
+
+	ya := make([]byte, keySize)
+	if _, err := io.ReadFull(br, ya); err != nil {
+		return nil, 0, fmt.Errorf("failed to read public key: %w", err)
+	}
+	private, yb, err := newKey()
+	if err != nil {
+		return nil, 0, err
+	}
+	padB, err := randomPad()
+	if err != nil {
+		return nil, 0, err
+	}
+	if _, err := c.Write(append(yb, padB...)); err != nil {
+		return nil, 0, fmt.Errorf("failed to write public key: %w", err)
+	}
+	s := secret(ya, private)
+
+	if err := synchronize(br, hash([]byte("req1"), s)); err != nil {
+		return nil, 0, err
+	}
+	buf := make([]byte, 20)
+	if _, err := io.ReadFull(br, buf); err != nil {
+		return nil, 0, err
+	}
+	infoHash, ok := findSKEY(xor(buf, hash([]byte("req3"), s)), infoHashes())
+	if !ok {
+		return nil, 0, ErrUnknownSKEY
+	}
+	dec := newCipher("keyA", s, infoHash)
+	enc := newCipher("keyB", s, infoHash)
+
+	buf, err = readEncrypted(br, dec, len(vc)+4)
+	if err != nil {
+		return nil, 0, err
+	}
+	if !bytes.Equal(buf[:len(vc)], vc) {
+		return nil, 0, fmt.Errorf("%w: invalid verification constant", ErrSync)
+	}
+	provided := CryptoMethod(binary.BigEndian.Uint32(buf[len(vc):]))
+	if _, err := readPadding(br, dec, maxPad); err != nil {
+		return nil, 0, err
+	}
+	initial, err := readPadding(br, dec, 1<<16)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	selected, err := policy.choose(provided)
+	if err != nil {
+		return nil, 0, err
+	}
+	reply := make([]byte, 0, len(vc)+6)
+	reply = append(reply, vc...)
+	reply = binary.BigEndian.AppendUint32(reply, uint32(selected))
+	reply = binary.BigEndian.AppendUint16(reply, 0)
+	enc.XORKeyStream(reply, reply)
+	if _, err := c.Write(reply); err != nil {
+		return nil, 0, fmt.Errorf("failed to write crypto select: %w", err)
+	}
+
+	if selected == Plaintext {
+		return newConn(c, br, nil, nil, initial), selected, nil
+	}
+	return newConn(c, br, dec, enc, initial), selected, nil
+}
+
+// findSKEY returns the info hash whose HASH('req2', SKEY) the peer sent
+func findSKEY(req2 []byte, infoHashes [][20]byte) ([20]byte, bool) {
+	for _, infoHash := range infoHashes {
+		if bytes.Equal(hash([]byte("req2"), infoHash[:]), req2) {
+			return infoHash, true
+		}
+	}
+	return [20]byte{}, false
+}
@@ -0,0 +1,195 @@
+// Package mse implements Message Stream Encryption, also known as Protocol
+// Encryption: a Diffie-Hellman key exchange followed by RC4 obfuscation of
+// the BitTorrent stream, so it can't be recognized by its plaintext
+// handshake.
+package mse
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rc4"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"sync"
+)
+
+// CryptoMethod is a bit set of the ways the stream may be protected once the
+// key exchange is done
+type CryptoMethod uint32
+
+const (
+	// Plaintext sends the stream in the clear after the key exchange
+	Plaintext CryptoMethod = 0x01
+	// RC4 encrypts the whole stream
+	RC4 CryptoMethod = 0x02
+)
+
+const (
+	// keySize is the length of the Diffie-Hellman public keys and secret
+	keySize = 96
+	// maxPad is the longest random padding either side may send
+	maxPad = 512
+	// discard is the number of RC4 keystream bytes thrown away, as the
+	// first ones are weak
+	discard = 1024
+)
+
+var (
+	ErrNoCryptoMethod = errors.New("no common encryption method")
+	ErrSync           = errors.New("failed to synchronize encrypted stream")
+	ErrUnknownSKEY    = errors.New("encrypted handshake for unknown info hash")
+	ErrPlaintext      = errors.New("plaintext connections are not allowed")
+	ErrEncrypted      = errors.New("encrypted connections are disabled")
+)
+
+// prime is the 768 bit modulus of the key exchange; the generator is 2
+var prime, _ = new(big.Int).SetString("FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD129024E088A67CC74020BBEA63B139B22514A08798E3404DDEF9519B3CD3A431B302B0A6DF25F14374FE1356D6D51C245E485B576625E7EC6F44C42E9A63A36210000000000090563", 16)
+
+var generator = big.NewInt(2)
+
+// vc is the verification constant that marks the start of the encrypted part
+var vc = make([]byte, 8)
+
+// newKey generates a private key and returns it with its public key
+func newKey() (private *big.Int, public []byte, err error) {
+	buf := make([]byte, 20)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, nil, err
+	}
+	private = new(big.Int).SetBytes(buf)
+	return private, pad(new(big.Int).Exp(generator, private, prime)), nil
+}
+
+// secret computes the shared secret from the public key of the peer
+func secret(public []byte, private *big.Int) []byte {
+	return pad(new(big.Int).Exp(new(big.Int).SetBytes(public), private, prime))
+}
+
+// pad returns n as a big-endian number of keySize bytes
+func pad(n *big.Int) []byte {
+	return n.FillBytes(make([]byte, keySize))
+}
+
+// hash returns the SHA-1 of the concatenated parts
+func hash(parts ...[]byte) []byte {
+	h := sha1.New()
+	for _, p := range parts {
+		h.Write(p)
+	}
+	return h.Sum(nil)
+}
+
+// xor returns a XOR b for slices of the same length
+func xor(a, b []byte) []byte {
+	out := make([]byte, len(a))
+	for i := range a {
+		out[i] = a[i] ^ b[i]
+	}
+	return out
+}
+
+// newCipher returns the RC4 stream of one direction, "keyA" for the
+// initiator and "keyB" for the receiver
+func newCipher(name string, s []byte, skey [20]byte) *rc4.Cipher {
+	c, _ := rc4.NewCipher(hash([]byte(name), s, skey[:]))
+	buf := make([]byte, discard)
+	c.XORKeyStream(buf, buf)
+	return c
+}
+
+// randomPad returns up to maxPad random bytes
+func randomPad() ([]byte, error) {
+	var n [2]byte
+	if _, err := rand.Read(n[:]); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, (int(n[0])<<8|int(n[1]))%(maxPad+1))
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// synchronize consumes the stream up to and including pattern, which must
+// follow at most maxPad bytes of padding
+func synchronize(r *bufio.Reader, pattern []byte) error {
+	window := make([]byte, 0, maxPad+len(pattern))
+	for len(window) < cap(window) {
+		b, err := r.ReadByte()
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrSync, err)
+		}
+		window = append(window, b)
+		if bytes.HasSuffix(window, pattern) {
+			return nil
+		}
+	}
+	return ErrSync
+}
+
+// readEncrypted reads n bytes and decrypts them
+func readEncrypted(r io.Reader, dec *rc4.Cipher, n int) ([]byte, error) {
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	dec.XORKeyStream(buf, buf)
+	return buf, nil
+}
+
+// readPadding reads and decrypts a 2 byte length followed by that many bytes
+func readPadding(r io.Reader, dec *rc4.Cipher, limit int) ([]byte, error) {
+	buf, err := readEncrypted(r, dec, 2)
+	if err != nil {
+		return nil, err
+	}
+	n := int(buf[0])<<8 | int(buf[1])
+	if n > limit {
+		return nil, fmt.Errorf("%w: %d bytes of padding", ErrSync, n)
+	}
+	return readEncrypted(r, dec, n)
+}
+
+// conn is a connection after the key exchange. Reads go through the buffer
+// used during the exchange, and through RC4 when it was selected.
+type conn struct {
+	net.Conn
+	r io.Reader
+
+	mu  sync.Mutex
+	enc *rc4.Cipher
+}
+
+// newConn wraps a connection. dec and enc are nil for plaintext streams, and
+// initial holds data that was already received and decrypted.
+func newConn(c net.Conn, br *bufio.Reader, dec, enc *rc4.Cipher, initial []byte) *conn {
+	var r io.Reader = br
+	if dec != nil {
+		r = cipher.StreamReader{S: dec, R: br}
+	}
+	if len(initial) > 0 {
+		r = io.MultiReader(bytes.NewReader(initial), r)
+	}
+	return &conn{Conn: c, r: r, enc: enc}
+}
+
+func (c *conn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func (c *conn) Write(b []byte) (int, error) {
+	if c.enc == nil {
+		return c.Conn.Write(b)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	buf := make([]byte, len(b))
+	c.enc.XORKeyStream(buf, b)
+	return c.Conn.Write(buf)
+}
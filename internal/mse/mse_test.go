@@ -0,0 +1,201 @@
+package mse
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testInfoHash = [20]byte{1, 2, 3}
+
+// connPair returns both ends of a loopback TCP connection
+func connPair(t *testing.T) (client, server net.Conn) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	client, err = net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	server, err = ln.Accept()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client, server
+}
+
+func knownHashes() [][20]byte {
+	return [][20]byte{{9}, testInfoHash}
+}
+
+type accepted struct {
+	conn   net.Conn
+	method CryptoMethod
+	err    error
+}
+
+// startAccept runs Accept on the server end of a connection, which is
+// closed when the key exchange fails
+func startAccept(server net.Conn, policy Policy) <-chan accepted {
+	done := make(chan accepted, 1)
+	go func() {
+		c, method, err := Accept(server, policy, knownHashes)
+		if err != nil {
+			server.Close()
+		}
+		done <- accepted{c, method, err}
+	}()
+	return done
+}
+
+// exchange checks that data flows both ways over established connections
+func exchange(t *testing.T, a, b net.Conn) {
+	t.Helper()
+	go a.Write([]byte("ping from initiator"))
+	buf := make([]byte, len("ping from initiator"))
+	_, err := io.ReadFull(b, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "ping from initiator", string(buf))
+
+	go b.Write([]byte("pong"))
+	buf = make([]byte, 4)
+	_, err = io.ReadFull(a, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "pong", string(buf))
+}
+
+func TestHandshake(t *testing.T) {
+	tests := map[string]struct {
+		provide CryptoMethod
+		policy  Policy
+		method  CryptoMethod
+	}{
+		"rc4 preferred":        {provide: RC4 | Plaintext, policy: Preferred, method: RC4},
+		"rc4 required":         {provide: RC4, policy: Required, method: RC4},
+		"plaintext after keys": {provide: Plaintext, policy: Preferred, method: Plaintext},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			client, server := connPair(t)
+			done := startAccept(server, test.policy)
+
+			c, method, err := Initiate(client, testInfoHash, test.provide)
+			require.NoError(t, err)
+			assert.Equal(t, test.method, method)
+			res := <-done
+			require.NoError(t, res.err)
+			assert.Equal(t, test.method, res.method)
+
+			exchange(t, c, res.conn)
+		})
+	}
+}
+
+func TestHandshakeEncryptsStream(t *testing.T) {
+	client, server := connPair(t)
+	done := startAccept(server, Required)
+	c, _, err := Initiate(client, testInfoHash, RC4)
+	require.NoError(t, err)
+	require.NoError(t, (<-done).err)
+
+	plain := []byte("\x13BitTorrent protocol")
+	go c.Write(plain)
+	buf := make([]byte, len(plain))
+	_, err = io.ReadFull(server, buf)
+	require.NoError(t, err)
+	assert.NotEqual(t, plain, buf)
+}
+
+func TestHandshakeFailures(t *testing.T) {
+	t.Run("required without rc4", func(t *testing.T) {
+		client, server := connPair(t)
+		done := startAccept(server, Required)
+		Initiate(client, testInfoHash, Plaintext)
+		assert.ErrorIs(t, (<-done).err, ErrNoCryptoMethod)
+	})
+	t.Run("unknown info hash", func(t *testing.T) {
+		client, server := connPair(t)
+		done := startAccept(server, Preferred)
+		Initiate(client, [20]byte{7}, RC4)
+		assert.ErrorIs(t, (<-done).err, ErrUnknownSKEY)
+	})
+	t.Run("disabled", func(t *testing.T) {
+		client, server := connPair(t)
+		done := startAccept(server, Disabled)
+		go Initiate(client, testInfoHash, RC4)
+		assert.ErrorIs(t, (<-done).err, ErrEncrypted)
+	})
+}
+
+func TestAcceptPlaintextHandshake(t *testing.T) {
+	hs := append([]byte("\x13BitTorrent protocol"), make([]byte, 48)...)
+
+	client, server := connPair(t)
+	done := startAccept(server, Preferred)
+	_, err := client.Write(hs)
+	require.NoError(t, err)
+	res := <-done
+	require.NoError(t, res.err)
+	assert.Equal(t, Plaintext, res.method)
+	buf := make([]byte, len(hs))
+	_, err = io.ReadFull(res.conn, buf)
+	require.NoError(t, err)
+	assert.Equal(t, hs, buf, "the peeked bytes are read again")
+
+	client, server = connPair(t)
+	done = startAccept(server, Required)
+	_, err = client.Write(hs)
+	require.NoError(t, err)
+	assert.ErrorIs(t, (<-done).err, ErrPlaintext)
+}
+
+func TestDial(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	// The peer only speaks plaintext and hangs up on anything else
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				buf := make([]byte, len(plaintextPrefix))
+				if _, err := io.ReadFull(c, buf); err != nil || string(buf) != string(plaintextPrefix) {
+					return
+				}
+				c.Write([]byte("welcome"))
+			}()
+		}
+	}()
+
+	_, err = Dial(context.Background(), nil, ln.Addr().String(), testInfoHash, Required)
+	assert.Error(t, err)
+
+	c, err := Dial(context.Background(), nil, ln.Addr().String(), testInfoHash, Preferred)
+	require.NoError(t, err)
+	defer c.Close()
+	_, err = c.Write(plaintextPrefix)
+	require.NoError(t, err)
+	buf := make([]byte, len("welcome"))
+	_, err = io.ReadFull(c, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "welcome", string(buf))
+}
+
+func TestParsePolicy(t *testing.T) {
+	for _, p := range []Policy{Disabled, Preferred, Required} {
+		parsed, err := ParsePolicy(p.String())
+		require.NoError(t, err)
+		assert.Equal(t, p, parsed)
+	}
+	_, err := ParsePolicy("sometimes")
+	assert.Error(t, err)
+}
@@ -0,0 +1,94 @@
+package mse
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+// dialTimeout bounds each connection attempt of Dial
+const dialTimeout = 3 * time.Second
+
+// DialFunc opens a connection to a peer
+type DialFunc func(ctx context.Context, addr string) (net.Conn, error)
+
+// Policy decides whether connections are encrypted
+type Policy int
+
+const (
+	// Disabled only uses plaintext connections
+	Disabled Policy = iota
+	// Preferred encrypts connections when the peer supports it and falls
+	// back to plaintext otherwise
+	Preferred
+	// Required only uses encrypted connections
+	Required
+)
+
+var policyNames = []string{"disabled", "preferred", "required"}
+
+// ParsePolicy parses the name of a policy
+func ParsePolicy(name string) (Policy, error) {
+	for i, n := range policyNames {
+		if n == name {
+			return Policy(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown encryption policy %q", name)
+}
+
+func (p Policy) String() string {
+	if p < 0 || int(p) >= len(policyNames) {
+		return fmt.Sprintf("Policy(%d)", int(p))
+	}
+	return policyNames[p]
+}
+
+// provide returns the crypto methods we offer when initiating a connection
+func (p Policy) provide() CryptoMethod {
+	if p == Required {
+		return RC4
+	}
+	return RC4 | Plaintext
+}
+
+// choose picks the crypto method for a connection among those provided by
+// the peer, preferring RC4
+func (p Policy) choose(provided CryptoMethod) (CryptoMethod, error) {
+	switch {
+	case provided&RC4 != 0:
+		return RC4, nil
+	case provided&Plaintext != 0 && p != Required:
+		return Plaintext, nil
+	}
+	return 0, fmt.Errorf("%w: peer provides %#x", ErrNoCryptoMethod, uint32(provided))
+}
+
+// Dial connects to a peer of the torrent with the given info hash through
+// dial, or over TCP when dial is nil, and runs the key exchange as the policy
+// says. With Preferred, a peer that doesn't complete the key exchange is
+// dialled again for a plaintext connection.
+func Dial(ctx context.Context, dial DialFunc, addr string, infoHash [20]byte, policy Policy) (net.Conn, error) {
+	if dial == nil {
+		dial = dialTCP
+	}
+	c, err := dial(ctx, addr)
+	if err != nil || policy == Disabled {
+		return c, err
+	}
+	enc, _, err := Initiate(c, infoHash, policy.provide())
+	if err == nil {
+		return enc, nil
+	}
+	c.Close()
+	if policy == Required {
+		return nil, err
+	}
+	return dial(ctx, addr)
+}
+
+func dialTCP(ctx context.Context, addr string) (net.Conn, error) {
+	d := net.Dialer{Timeout: dialTimeout}
+	return d.DialContext(ctx, "tcp", addr)
+}
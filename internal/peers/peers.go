@@ -11,17 +11,27 @@ import (
 type Peer struct {
 	IP   net.IP
 	Port uint16
+	// ID is the peer ID announced by the tracker, zero when unknown
+	ID [20]byte
 }
 
 func (p Peer) String() string {
 	return net.JoinHostPort(p.IP.String(), strconv.Itoa(int(p.Port)))
 }
 
+// Unmarshal decodes IPv4 peers in compact form
 func Unmarshal(peersBin []byte) ([]Peer, error) {
-	const (
-		peerSize = 6 // 4 bytes for IP, 2 bytes for port
-		ipSize   = 4
-	)
+	return unmarshalCompact(peersBin, net.IPv4len)
+}
+
+// Unmarshal6 decodes IPv6 peers in compact form (BEP 7)
+func Unmarshal6(peersBin []byte) ([]Peer, error) {
+	return unmarshalCompact(peersBin, net.IPv6len)
+}
+
+// unmarshalCompact decodes peers stored as an IP address followed by a 2 byte port
+func unmarshalCompact(peersBin []byte, ipSize int) ([]Peer, error) {
+	peerSize := ipSize + 2 // IP address, 2 bytes for port
 
 	if len(peersBin)%peerSize != 0 {
 		return nil, errors.New("received malformed peers")
@@ -43,6 +53,38 @@ func Unmarshal(peersBin []byte) ([]Peer, error) {
 	return peers, nil
 }
 
+// Marshal encodes the IPv4 peers of a list in compact form; other peers are
+// left out
+func Marshal(list []Peer) []byte {
+	return marshalCompact(list, net.IPv4len)
+}
+
+// Marshal6 encodes the IPv6 peers of a list in compact form (BEP 7); other
+// peers are left out
+func Marshal6(list []Peer) []byte {
+	return marshalCompact(list, net.IPv6len)
+}
+
+// marshalCompact encodes the peers whose IP address has ipSize bytes
+func marshalCompact(list []Peer, ipSize int) []byte {
+	var buf []byte
+	for _, p := range list {
+		ip := p.IP.To4()
+		if ipSize == net.IPv6len {
+			if ip != nil {
+				continue
+			}
+			ip = p.IP.To16()
+		}
+		if len(ip) != ipSize {
+			continue
+		}
+		buf = append(buf, ip...)
+		buf = binary.BigEndian.AppendUint16(buf, p.Port)
+	}
+	return buf
+}
+
 func GeneratePeerID() ([20]byte, error) {
 	var peerID [20]byte
 
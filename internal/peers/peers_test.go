@@ -81,3 +81,21 @@ func TestGeneratePeerID(t *testing.T) {
 	// Check that peerID is not empty (it has at least one non-zero byte)
 	assert.NotEqual(t, [20]byte{}, peerID, "peerID should not be empty")
 }
+
+func TestMarshal(t *testing.T) {
+	list := []Peer{
+		{IP: net.IPv4(192, 168, 0, 1), Port: 6881},
+		{IP: net.ParseIP("2001:db8::1"), Port: 6881},
+		{IP: net.IPv4(10, 0, 0, 2).To4(), Port: 51413},
+	}
+
+	v4 := Marshal(list)
+	assert.Equal(t, []byte{192, 168, 0, 1, 0x1A, 0xE1, 10, 0, 0, 2, 0xC8, 0xD5}, v4)
+	v6 := Marshal6(list)
+	assert.Len(t, v6, 18)
+
+	decoded, err := Unmarshal6(v6)
+	assert.NoError(t, err)
+	assert.Equal(t, "[2001:db8::1]:6881", decoded[0].String())
+	assert.Empty(t, Marshal(nil))
+}
@@ -6,7 +6,8 @@ import (
 	"net/http/httptest"
 	"testing"
 
-	"github.com/jackpal/bencode-go"
+	"Torrentasaurus_Rex/internal/bencode"
+
 	"github.com/stretchr/testify/assert"
 )
 
@@ -21,7 +22,7 @@ func TestRequestSuccess(t *testing.T) {
 			Peers: string([]byte{192, 168, 0, 1, 0x1A, 0xE1, 10, 0, 0, 2, 0x1A, 0xE1}),
 		}
 		w.WriteHeader(http.StatusOK)
-		err := bencode.Marshal(w, response)
+		err := bencode.NewEncoder(w).Encode(response)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
@@ -62,3 +63,18 @@ func TestRequestMalformedResponse(t *testing.T) {
 	assert.Error(t, err)
 	assert.Nil(t, peers)
 }
+
+func TestRequestDictionaryModel(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("d8:intervali900e5:peersld2:ip9:127.0.0.14:porti6881eee6:peers618:" +
+			"\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01\x1a\xe2e"))
+	}))
+	defer server.Close()
+
+	peers, err := Request(server.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, []Peer{
+		{IP: net.IPv4(127, 0, 0, 1).To4(), Port: 6881},
+		{IP: net.IPv6loopback, Port: 6882},
+	}, peers)
+}
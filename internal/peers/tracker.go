@@ -0,0 +1,100 @@
+package peers
+
+import (
+	"fmt"
+	"net"
+
+	"Torrentasaurus_Rex/internal/bencode"
+)
+
+// dictPeer is a peer in the non-compact, dictionary model of a tracker response
+type dictPeer struct {
+	IP     string `bencode:"ip"`
+	Port   int    `bencode:"port"`
+	PeerID string `bencode:"peer id"`
+}
+
+// FromTrackerResponse decodes the peers of a tracker response. The "peers"
+// value may be a compact string of IPv4 peers or a list of dictionaries;
+// "peers6" holds compact IPv6 peers. Peers listed more than once are
+// returned once, keeping the peer ID if any entry had one.
+func FromTrackerResponse(peers bencode.RawMessage, peers6 []byte) ([]Peer, error) {
+	var found []Peer
+
+	if len(peers) > 0 {
+		switch c := peers[0]; {
+		case c >= '0' && c <= '9':
+			var compact []byte
+			if err := bencode.UnmarshalLenient(peers, &compact); err != nil {
+				return nil, err
+			}
+			list, err := Unmarshal(compact)
+			if err != nil {
+				return nil, err
+			}
+			found = append(found, list...)
+		case c == 'l':
+			list, err := unmarshalDicts(peers)
+			if err != nil {
+				return nil, err
+			}
+			found = append(found, list...)
+		default:
+			return nil, fmt.Errorf("unexpected peers value starting with %q", c)
+		}
+	}
+
+	if len(peers6) > 0 {
+		list, err := Unmarshal6(peers6)
+		if err != nil {
+			return nil, err
+		}
+		found = append(found, list...)
+	}
+
+	return dedupe(found), nil
+}
+
+// unmarshalDicts decodes a list of peer dictionaries. Entries without a
+// literal IP address or with an invalid port are skipped.
+func unmarshalDicts(data bencode.RawMessage) ([]Peer, error) {
+	var dicts []dictPeer
+	if err := bencode.UnmarshalLenient(data, &dicts); err != nil {
+		return nil, err
+	}
+
+	var found []Peer
+	for _, d := range dicts {
+		ip := net.ParseIP(d.IP)
+		if ip == nil || d.Port <= 0 || d.Port > 0xffff {
+			continue
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+		p := Peer{IP: ip, Port: uint16(d.Port)}
+		if len(d.PeerID) == len(p.ID) {
+			copy(p.ID[:], d.PeerID)
+		}
+		found = append(found, p)
+	}
+	return found, nil
+}
+
+// dedupe removes repeated peers, keeping the first occurrence and any known peer ID
+func dedupe(list []Peer) []Peer {
+	index := make(map[string]int, len(list))
+	unique := make([]Peer, 0, len(list))
+	for _, p := range list {
+		key := p.String()
+		if i, ok := index[key]; ok {
+			if unique[i].ID == ([20]byte{}) {
+				unique[i].ID = p.ID
+			}
+			continue
+		}
+		index[key] = len(unique)
+		unique = append(unique, p)
+	}
+	return unique
+}
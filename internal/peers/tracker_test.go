@@ -0,0 +1,78 @@
+package peers
+
+import (
+	"net"
+	"testing"
+
+	"Torrentasaurus_Rex/internal/bencode"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnmarshal6(t *testing.T) {
+	peersBin := []byte{
+		0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0x1A, 0xE1, // [2001:db8::1]:6881
+	}
+	result, err := Unmarshal6(peersBin)
+	require.NoError(t, err)
+	require.Len(t, result, 1)
+	assert.Equal(t, "[2001:db8::1]:6881", result[0].String())
+
+	_, err = Unmarshal6(peersBin[:17])
+	assert.Error(t, err)
+}
+
+func TestFromTrackerResponseCompact(t *testing.T) {
+	raw := bencode.RawMessage("6:\xc0\xa8\x00\x01\x1a\xe1")
+	peers6 := []byte{0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2, 0x1A, 0xE2}
+
+	result, err := FromTrackerResponse(raw, peers6)
+	require.NoError(t, err)
+	require.Len(t, result, 2)
+	assert.Equal(t, "192.168.0.1:6881", result[0].String())
+	assert.Equal(t, "[2001:db8::2]:6882", result[1].String())
+}
+
+func TestFromTrackerResponseDictionaries(t *testing.T) {
+	raw := bencode.RawMessage("l" +
+		"d2:ip8:10.0.0.17:peer id20:AAAAAAAAAAAAAAAAAAAA4:porti6881ee" +
+		"d2:ip11:2001:db8::54:porti51413ee" +
+		"d2:ip15:tracker.invalid4:porti1ee" +
+		"d2:ip8:10.0.0.24:porti0ee" +
+		"e")
+
+	result, err := FromTrackerResponse(raw, nil)
+	require.NoError(t, err)
+
+	var id [20]byte
+	copy(id[:], "AAAAAAAAAAAAAAAAAAAA")
+	assert.Equal(t, []Peer{
+		{IP: net.IP{10, 0, 0, 1}, Port: 6881, ID: id},
+		{IP: net.ParseIP("2001:db8::5"), Port: 51413},
+	}, result)
+}
+
+func TestFromTrackerResponseDedupes(t *testing.T) {
+	raw := bencode.RawMessage("l" +
+		"d2:ip8:10.0.0.14:porti6881ee" +
+		"d2:ip8:10.0.0.17:peer id20:BBBBBBBBBBBBBBBBBBBB4:porti6881ee" +
+		"e")
+
+	result, err := FromTrackerResponse(raw, nil)
+	require.NoError(t, err)
+	require.Len(t, result, 1)
+	assert.Equal(t, byte('B'), result[0].ID[0])
+}
+
+func TestFromTrackerResponseInvalid(t *testing.T) {
+	_, err := FromTrackerResponse(bencode.RawMessage("i5e"), nil)
+	assert.Error(t, err)
+
+	_, err = FromTrackerResponse(bencode.RawMessage("5:\x01\x02\x03\x04\x05"), nil)
+	assert.Error(t, err)
+
+	result, err := FromTrackerResponse(nil, nil)
+	require.NoError(t, err)
+	assert.Empty(t, result)
+}
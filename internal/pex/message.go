@@ -0,0 +1,104 @@
+package pex
+
+import (
+	"fmt"
+
+	"Torrentasaurus_Rex/internal/bencode"
+	"Torrentasaurus_Rex/internal/peers"
+)
+
+// Flags describe an added peer in added.f and added6.f
+const (
+	FlagEncryption = 0x01
+	FlagSeed       = 0x02
+	FlagUTP        = 0x04
+	FlagHolepunch  = 0x08
+	// FlagReachable marks peers we connected to ourselves
+	FlagReachable = 0x10
+)
+
+// Message is a ut_pex message: the peers the sender connected to and
+// disconnected from since its previous message
+type Message struct {
+	Added []peers.Peer
+	// AddedFlags holds the flags of each added peer
+	AddedFlags []byte
+	Dropped    []peers.Peer
+}
+
+// bencodeMessage is the wire form of a Message, with IPv4 and IPv6 peers
+// in separate compact lists
+type bencodeMessage struct {
+	Added    []byte `bencode:"added"`
+	AddedF   []byte `bencode:"added.f"`
+	Added6   []byte `bencode:"added6,omitempty"`
+	Added6F  []byte `bencode:"added6.f,omitempty"`
+	Dropped  []byte `bencode:"dropped"`
+	Dropped6 []byte `bencode:"dropped6,omitempty"`
+}
+
+// ParseMessage decodes a ut_pex message. Missing flags read as zero.
+func ParseMessage(payload []byte) (Message, error) {
+	var raw bencodeMessage
+	if err := bencode.UnmarshalLenient(payload, &raw); err != nil {
+		return Message{}, fmt.Errorf("malformed ut_pex message: %w", err)
+	}
+
+	var m Message
+	for _, list := range []struct {
+		compact []byte
+		flags   []byte
+		decode  func([]byte) ([]peers.Peer, error)
+	}{
+		{raw.Added, raw.AddedF, peers.Unmarshal},
+		{raw.Added6, raw.Added6F, peers.Unmarshal6},
+	} {
+		added, err := list.decode(list.compact)
+		if err != nil {
+			return Message{}, fmt.Errorf("malformed ut_pex added peers: %w", err)
+		}
+		for i, p := range added {
+			var flags byte
+			if i < len(list.flags) {
+				flags = list.flags[i]
+			}
+			m.Added = append(m.Added, p)
+			m.AddedFlags = append(m.AddedFlags, flags)
+		}
+	}
+	for _, list := range []struct {
+		compact []byte
+		decode  func([]byte) ([]peers.Peer, error)
+	}{
+		{raw.Dropped, peers.Unmarshal},
+		{raw.Dropped6, peers.Unmarshal6},
+	} {
+		dropped, err := list.decode(list.compact)
+		if err != nil {
+			return Message{}, fmt.Errorf("malformed ut_pex dropped peers: %w", err)
+		}
+		m.Dropped = append(m.Dropped, dropped...)
+	}
+	return m, nil
+}
+
+// Marshal encodes the message
+func (m *Message) Marshal() ([]byte, error) {
+	var raw bencodeMessage
+	for i, p := range m.Added {
+		var flags byte
+		if i < len(m.AddedFlags) {
+			flags = m.AddedFlags[i]
+		}
+		if p.IP.To4() != nil {
+			raw.Added = append(raw.Added, peers.Marshal([]peers.Peer{p})...)
+			raw.AddedF = append(raw.AddedF, flags)
+		} else if len(p.IP) == 16 {
+			raw.Added6 = append(raw.Added6, peers.Marshal6([]peers.Peer{p})...)
+			raw.Added6F = append(raw.Added6F, flags)
+		}
+	}
+	raw.Dropped = peers.Marshal(m.Dropped)
+	raw.Dropped6 = peers.Marshal6(m.Dropped)
+	return bencode.Marshal(raw)
+}
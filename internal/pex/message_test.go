@@ -0,0 +1,46 @@
+package pex
+
+import (
+	"net"
+	"testing"
+
+	"Torrentasaurus_Rex/internal/peers"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageRoundTrip(t *testing.T) {
+	msg := Message{
+		Added: []peers.Peer{
+			{IP: net.IPv4(10, 0, 0, 1).To4(), Port: 6881},
+			{IP: net.ParseIP("2001:db8::1"), Port: 6882},
+		},
+		AddedFlags: []byte{FlagReachable, FlagSeed},
+		Dropped:    []peers.Peer{{IP: net.IPv4(10, 0, 0, 2).To4(), Port: 6883}},
+	}
+	payload, err := msg.Marshal()
+	require.NoError(t, err)
+
+	parsed, err := ParseMessage(payload)
+	require.NoError(t, err)
+	require.Len(t, parsed.Added, 2)
+	assert.Equal(t, "10.0.0.1:6881", parsed.Added[0].String())
+	assert.Equal(t, "[2001:db8::1]:6882", parsed.Added[1].String())
+	assert.Equal(t, []byte{FlagReachable, FlagSeed}, parsed.AddedFlags)
+	require.Len(t, parsed.Dropped, 1)
+	assert.Equal(t, "10.0.0.2:6883", parsed.Dropped[0].String())
+}
+
+func TestParseMessage(t *testing.T) {
+	// Flags may be missing
+	parsed, err := ParseMessage([]byte("d5:added6:\x0a\x00\x00\x01\x1a\xe17:dropped0:e"))
+	require.NoError(t, err)
+	require.Len(t, parsed.Added, 1)
+	assert.Equal(t, []byte{0}, parsed.AddedFlags)
+
+	_, err = ParseMessage([]byte("d5:added5:\x0a\x00\x00\x01\x1ae"))
+	assert.Error(t, err)
+	_, err = ParseMessage([]byte("not bencode"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,222 @@
+// Package pex implements peer exchange (ut_pex): connected peers tell each
+// other about the peers they know, so the swarm can be found without a
+// tracker.
+package pex
+
+import (
+	"context"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"Torrentasaurus_Rex/internal/extension"
+	"Torrentasaurus_Rex/internal/peers"
+)
+
+const (
+	// Name is the name ut_pex is registered under in the extended handshake
+	Name = "ut_pex"
+	// Interval is the time between two messages to the same peer
+	Interval = time.Minute
+	// MaxPeers bounds the added and the dropped peers of a single message
+	MaxPeers = 50
+	// minReceiveInterval is the shortest time accepted between two messages
+	// from the same peer, a little less than Interval to allow for jitter.
+	// Messages that come sooner are ignored.
+	minReceiveInterval = Interval - 5*time.Second
+)
+
+// Swarm tracks the peers we are connected to and exchanges them with the
+// connected peers that support ut_pex. It handles the ut_pex messages of
+// every connection.
+type Swarm struct {
+	// found receives the peers other peers told us about
+	found func([]peers.Peer)
+	after func(time.Duration) <-chan time.Time
+	now   func() time.Time
+
+	mu    sync.Mutex
+	conns map[*conn]struct{}
+	// received holds when each peer last sent a message we accepted
+	received map[*extension.Peer]time.Time
+}
+
+// conn is a connection that counts as part of the swarm
+type conn struct {
+	ext *extension.Peer
+	// addr is the address the peer accepts connections on. A zero port is
+	// filled in from its extended handshake.
+	addr     peers.Peer
+	outgoing bool
+	// sent holds the peers the other side was last told about
+	sent map[string]peers.Peer
+}
+
+// New creates a swarm that passes the peers it learns about to found
+func New(found func([]peers.Peer)) *Swarm {
+	return &Swarm{
+		found:    found,
+		after:    time.After,
+		now:      time.Now,
+		conns:    make(map[*conn]struct{}),
+		received: make(map[*extension.Peer]time.Time),
+	}
+}
+
+// Add records a connection to a peer and returns a function that removes it
+// again once the connection closes. ext is the extension state used to
+// exchange peers and may be nil for peers without the extension protocol.
+// addr is where the peer accepts connections; for incoming connections the
+// port may be zero and is then taken from the peer's extended handshake.
+// outgoing tells whether we opened the connection.
+func (s *Swarm) Add(ext *extension.Peer, addr peers.Peer, outgoing bool) (remove func()) {
+	c := &conn{ext: ext, addr: addr, outgoing: outgoing, sent: make(map[string]peers.Peer)}
+	s.mu.Lock()
+	s.conns[c] = struct{}{}
+	s.mu.Unlock()
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.conns, c)
+		delete(s.received, ext)
+	}
+}
+
+// HandleExtended passes the peers added in a ut_pex message on. Messages
+// that follow the previous one from the same peer too closely are ignored,
+// so a peer can't flood us with addresses.
+func (s *Swarm) HandleExtended(p *extension.Peer, payload []byte) error {
+	msg, err := ParseMessage(payload)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	now := s.now()
+	last, ok := s.received[p]
+	if ok && now.Sub(last) < minReceiveInterval {
+		s.mu.Unlock()
+		return nil
+	}
+	s.received[p] = now
+	s.mu.Unlock()
+
+	var added []peers.Peer
+	for _, peer := range msg.Added {
+		if len(added) == MaxPeers {
+			break
+		}
+		if peer.Port == 0 || peer.IP.IsUnspecified() {
+			continue
+		}
+		added = append(added, peer)
+	}
+	if len(added) > 0 {
+		s.found(added)
+	}
+	return nil
+}
+
+// Run sends every connection the changes to the swarm once per Interval
+// until the context is cancelled
+func (s *Swarm) Run(ctx context.Context) {
+	for {
+		select {
+		case <-s.after(Interval):
+			s.Broadcast()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Broadcast sends every connection that supports ut_pex the peers we
+// connected to and disconnected from since its previous message
+func (s *Swarm) Broadcast() {
+	s.mu.Lock()
+	current := make(map[string]*conn, len(s.conns))
+	for c := range s.conns {
+		if addr, ok := c.address(); ok {
+			current[addr.String()] = c
+		}
+	}
+	type delivery struct {
+		ext *extension.Peer
+		msg Message
+	}
+	var deliveries []delivery
+	for c := range s.conns {
+		if c.ext == nil || !c.ext.Supports(Name) {
+			continue
+		}
+		msg := c.delta(current)
+		if len(msg.Added) > 0 || len(msg.Dropped) > 0 {
+			deliveries = append(deliveries, delivery{c.ext, msg})
+		}
+	}
+	s.mu.Unlock()
+
+	for _, d := range deliveries {
+		payload, err := d.msg.Marshal()
+		if err != nil {
+			log.Printf("Failed to encode ut_pex message: %v", err)
+			continue
+		}
+		_ = d.ext.Send(Name, payload)
+	}
+}
+
+// delta computes the next message for the connection from the current
+// swarm and records it as sent; the caller must hold s.mu
+func (c *conn) delta(current map[string]*conn) Message {
+	self, _ := c.address()
+	var msg Message
+	for _, key := range sortedKeys(current) {
+		if len(msg.Added) == MaxPeers {
+			break
+		}
+		other := current[key]
+		if _, ok := c.sent[key]; ok || key == self.String() {
+			continue
+		}
+		addr, _ := other.address()
+		var flags byte
+		if other.outgoing {
+			flags |= FlagReachable
+		}
+		msg.Added = append(msg.Added, addr)
+		msg.AddedFlags = append(msg.AddedFlags, flags)
+		c.sent[key] = addr
+	}
+	for _, key := range sortedKeys(c.sent) {
+		if len(msg.Dropped) == MaxPeers {
+			break
+		}
+		if _, ok := current[key]; ok {
+			continue
+		}
+		msg.Dropped = append(msg.Dropped, c.sent[key])
+		delete(c.sent, key)
+	}
+	return msg
+}
+
+// address returns where the peer accepts connections, if known
+func (c *conn) address() (peers.Peer, bool) {
+	addr := c.addr
+	if addr.Port == 0 && c.ext != nil {
+		if hs, ok := c.ext.Remote(); ok && hs.P > 0 && hs.P <= 65535 {
+			addr.Port = uint16(hs.P)
+		}
+	}
+	return addr, addr.Port != 0 && addr.IP != nil && !addr.IP.IsUnspecified()
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
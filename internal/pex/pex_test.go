@@ -0,0 +1,144 @@
+package pex
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"Torrentasaurus_Rex/internal/extension"
+	"Torrentasaurus_Rex/internal/message"
+	"Torrentasaurus_Rex/internal/peers"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// remote is the other side of a connection; it records the ut_pex messages
+// it receives
+type remote struct {
+	ext      *extension.Peer
+	received []Message
+}
+
+// connect creates the extension state of a connection to a peer that
+// supports ut_pex and completes the extended handshakes
+func connect(t *testing.T, s *Swarm) (*extension.Peer, *remote) {
+	t.Helper()
+	r := &remote{}
+	ours := extension.NewRegistry()
+	ours.Register(Name, s)
+	theirs := extension.NewRegistry()
+	theirs.Register(Name, extension.HandlerFunc(func(p *extension.Peer, payload []byte) error {
+		msg, err := ParseMessage(payload)
+		require.NoError(t, err)
+		r.received = append(r.received, msg)
+		return nil
+	}))
+
+	var local *extension.Peer
+	local = ours.NewPeer(func(msg *message.Message) error { return r.ext.Handle(msg.Payload) })
+	r.ext = theirs.NewPeer(func(msg *message.Message) error { return local.Handle(msg.Payload) })
+	require.NoError(t, local.SendHandshake())
+	require.NoError(t, r.ext.SendHandshake())
+	return local, r
+}
+
+func peerAt(i int) peers.Peer {
+	return peers.Peer{IP: net.IPv4(10, 0, byte(i/256), byte(i%256)).To4(), Port: 6881}
+}
+
+func TestSwarmBroadcastDeltas(t *testing.T) {
+	s := New(func([]peers.Peer) {})
+	ext, r := connect(t, s)
+	s.Add(ext, peerAt(1), true)
+	s.Add(nil, peerAt(2), true)
+	remove := s.Add(nil, peerAt(3), false)
+
+	s.Broadcast()
+	require.Len(t, r.received, 1)
+	assert.Equal(t, []peers.Peer{peerAt(2), peerAt(3)}, r.received[0].Added)
+	assert.Equal(t, []byte{FlagReachable, 0}, r.received[0].AddedFlags)
+
+	// Nothing changed, so nothing is sent
+	s.Broadcast()
+	assert.Len(t, r.received, 1)
+
+	remove()
+	s.Add(nil, peerAt(4), true)
+	s.Broadcast()
+	require.Len(t, r.received, 2)
+	assert.Equal(t, []peers.Peer{peerAt(4)}, r.received[1].Added)
+	assert.Equal(t, []peers.Peer{peerAt(3)}, r.received[1].Dropped)
+}
+
+func TestSwarmBroadcastLimit(t *testing.T) {
+	s := New(func([]peers.Peer) {})
+	ext, r := connect(t, s)
+	s.Add(ext, peerAt(0), true)
+	for i := 1; i <= MaxPeers+10; i++ {
+		s.Add(nil, peerAt(i), true)
+	}
+
+	s.Broadcast()
+	s.Broadcast()
+	require.Len(t, r.received, 2)
+	assert.Len(t, r.received[0].Added, MaxPeers)
+	assert.Len(t, r.received[1].Added, 10)
+}
+
+func TestSwarmIncomingPortFromHandshake(t *testing.T) {
+	s := New(func([]peers.Peer) {})
+	ext, r := connect(t, s)
+	s.Add(ext, peerAt(1), true)
+
+	in := extension.NewRegistry().NewPeer(func(*message.Message) error { return nil })
+	s.Add(in, peers.Peer{IP: net.IPv4(10, 1, 0, 1).To4()}, false)
+
+	// The incoming peer is left out until its handshake tells its port
+	s.Broadcast()
+	assert.Empty(t, r.received)
+
+	require.NoError(t, in.Handle([]byte("\x00d1:mde1:pi7000ee")))
+	s.Broadcast()
+	require.Len(t, r.received, 1)
+	assert.Equal(t, "10.1.0.1:7000", r.received[0].Added[0].String())
+}
+
+func TestSwarmHandleExtended(t *testing.T) {
+	var found []peers.Peer
+	s := New(func(list []peers.Peer) { found = append(found, list...) })
+	_, r := connect(t, s)
+
+	msg := Message{Added: []peers.Peer{{IP: net.IPv4(10, 0, 0, 9).To4()}}}
+	for i := range MaxPeers + 5 {
+		msg.Added = append(msg.Added, peerAt(i))
+	}
+	payload, err := msg.Marshal()
+	require.NoError(t, err)
+	require.NoError(t, r.ext.Send(Name, payload))
+
+	assert.Len(t, found, MaxPeers)
+	assert.Equal(t, peerAt(0), found[0])
+}
+
+func TestSwarmHandleExtendedRateLimit(t *testing.T) {
+	var found []peers.Peer
+	s := New(func(list []peers.Peer) { found = append(found, list...) })
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	_, r := connect(t, s)
+
+	send := func(i int) {
+		msg := Message{Added: []peers.Peer{peerAt(i)}}
+		payload, err := msg.Marshal()
+		require.NoError(t, err)
+		require.NoError(t, r.ext.Send(Name, payload))
+	}
+	send(1)
+	now = now.Add(10 * time.Second)
+	send(2) // too soon, ignored
+	now = now.Add(Interval)
+	send(3)
+
+	assert.Equal(t, []peers.Peer{peerAt(1), peerAt(3)}, found)
+}
@@ -0,0 +1,120 @@
+package resume
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"Torrentasaurus_Rex/internal/bencode"
+	"Torrentasaurus_Rex/internal/torrent"
+)
+
+// Dir is the directory under the download directory that holds resume files
+const Dir = ".torrentasaurus"
+
+// FileStat records the size and modification time of a downloaded file
+type FileStat struct {
+	Path string `bencode:"path"`
+	// Size is -1 when the file doesn't exist
+	Size    int64 `bencode:"size"`
+	ModTime int64 `bencode:"mtime"`
+}
+
+// State is what a download remembers between runs: the pieces that were
+// verified and written, and how the files looked at that moment
+type State struct {
+	InfoHash [20]byte   `bencode:"info hash"`
+	Pieces   []byte     `bencode:"pieces"`
+	Files    []FileStat `bencode:"files"`
+}
+
+// Path returns the location of the resume file of a torrent under root
+func Path(root string, infoHash [20]byte) string {
+	return filepath.Join(root, Dir, fmt.Sprintf("%x.resume", infoHash))
+}
+
+// Load reads a resume file. The returned error wraps fs.ErrNotExist when
+// there is none.
+func Load(path string) (*State, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var st State
+	if err := bencode.Unmarshal(data, &st); err != nil {
+		return nil, fmt.Errorf("failed to parse resume file %s: %w", path, err)
+	}
+	return &st, nil
+}
+
+// Save writes a resume file. The old file is replaced atomically so an
+// interrupted save never leaves a truncated state behind.
+func Save(path string, st *State) error {
+	data, err := bencode.Marshal(st)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// StatFiles records the current size and modification time of the files of
+// a torrent under root. Padding files, which are never stored, are recorded
+// as missing.
+func StatFiles(root string, entries []torrent.FileEntry) ([]FileStat, error) {
+	stats := make([]FileStat, len(entries))
+	for i, entry := range entries {
+		stats[i] = FileStat{Path: filepath.Join(entry.Path...), Size: -1}
+		if entry.Padding {
+			continue
+		}
+		info, err := os.Stat(entry.LocalPath(root))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		stats[i].Size = info.Size()
+		stats[i].ModTime = info.ModTime().UnixNano()
+	}
+	return stats, nil
+}
+
+// Matches tells whether the state belongs to the torrent and the files are
+// unchanged since it was saved, in which case its pieces can be trusted
+// without hashing them again
+func (st *State) Matches(infoHash [20]byte, files []FileStat) bool {
+	return st.InfoHash == infoHash && slices.Equal(st.Files, files)
+}
+
+// AnyExisting tells whether any of the files exists with data in it
+func AnyExisting(files []FileStat) bool {
+	for _, f := range files {
+		if f.Size > 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,76 @@
+package resume
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"Torrentasaurus_Rex/internal/torrent"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSaveAndLoad(t *testing.T) {
+	root := t.TempDir()
+	path := Path(root, [20]byte{0xab})
+	assert.Equal(t, filepath.Join(root, Dir, "ab00000000000000000000000000000000000000.resume"), path)
+
+	_, err := Load(path)
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	st := &State{
+		InfoHash: [20]byte{0xab},
+		Pieces:   []byte{0b10100000},
+		Files:    []FileStat{{Path: "a.txt", Size: 10, ModTime: 1234}, {Path: "b.txt", Size: -1}},
+	}
+	require.NoError(t, Save(path, st))
+	loaded, err := Load(path)
+	require.NoError(t, err)
+	assert.Equal(t, st, loaded)
+
+	// Saving again replaces the file and leaves no temporary file behind
+	st.Pieces = []byte{0b11100000}
+	require.NoError(t, Save(path, st))
+	entries, err := os.ReadDir(filepath.Join(root, Dir))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestLoadCorrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "x.resume")
+	require.NoError(t, os.WriteFile(path, []byte("d5:piece"), 0o644))
+	_, err := Load(path)
+	assert.Error(t, err)
+}
+
+func TestStatFilesAndMatches(t *testing.T) {
+	root := t.TempDir()
+	entries := []torrent.FileEntry{
+		{Path: []string{"dir", "a.txt"}, Length: 3},
+		{Path: []string{"dir", "b.txt"}, Length: 3, Offset: 3},
+	}
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "dir"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "dir", "a.txt"), []byte("abc"), 0o644))
+
+	files, err := StatFiles(root, entries)
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+	assert.Equal(t, filepath.Join("dir", "a.txt"), files[0].Path)
+	assert.Equal(t, int64(3), files[0].Size)
+	assert.Equal(t, int64(-1), files[1].Size)
+	assert.True(t, AnyExisting(files))
+	assert.False(t, AnyExisting(files[1:]))
+
+	st := &State{InfoHash: [20]byte{1}, Files: files}
+	assert.True(t, st.Matches([20]byte{1}, files))
+	assert.False(t, st.Matches([20]byte{2}, files))
+
+	later := time.Now().Add(time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(root, "dir", "a.txt"), later, later))
+	changed, err := StatFiles(root, entries)
+	require.NoError(t, err)
+	assert.False(t, st.Matches([20]byte{1}, changed))
+}
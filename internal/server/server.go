@@ -0,0 +1,241 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+
+	"Torrentasaurus_Rex/internal/handshake"
+	"Torrentasaurus_Rex/internal/mse"
+)
+
+// DefaultMaxConns bounds the number of incoming connections served at once
+const DefaultMaxConns = 50
+
+var ErrServerClosed = errors.New("server closed")
+
+// Handler serves a connection routed to a torrent after its handshake. hs
+// is the handshake the peer sent.
+type Handler interface {
+	ServeConn(ctx context.Context, conn net.Conn, hs *handshake.Handshake) error
+}
+
+// Server accepts incoming peer connections and hands each one to the handler
+// registered for the info hash the peer asked for
+type Server struct {
+	// MaxConns is the number of connections served at once. Connections over
+	// the limit are closed right away.
+	MaxConns int
+	// Extensions advertises the extension protocol (BEP 10) in our handshake
+	Extensions bool
+	// Fast advertises the Fast Extension (BEP 6) in our handshake
+	Fast bool
+	// V2 advertises BitTorrent v2 (BEP 52) in our handshake
+	V2 bool
+	// Encryption decides whether encrypted and plaintext connections are
+	// accepted. Unless it is disabled, both kinds are told apart by their
+	// first bytes.
+	Encryption mse.Policy
+
+	ln     net.Listener
+	peerID [20]byte
+	// others are further listeners, such as a uTP socket
+	others []net.Listener
+
+	mu       sync.Mutex
+	handlers map[[20]byte]Handler
+	conns    int
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+// Listen opens a TCP listener on addr that answers handshakes with peerID
+func Listen(addr string, peerID [20]byte) (*Server, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	return &Server{
+		MaxConns: DefaultMaxConns,
+		ln:       ln,
+		peerID:   peerID,
+		handlers: make(map[[20]byte]Handler),
+	}, nil
+}
+
+// Addr returns the address the server listens on
+func (s *Server) Addr() net.Addr {
+	return s.ln.Addr()
+}
+
+// Port returns the TCP port the server listens on
+func (s *Server) Port() int {
+	return s.ln.Addr().(*net.TCPAddr).Port
+}
+
+// AddListener accepts connections from ln too, for example uTP connections.
+// It must be called before Serve, and ln is closed along with the server.
+func (s *Server) AddListener(ln net.Listener) {
+	s.others = append(s.others, ln)
+}
+
+// Register routes connections for infoHash to h
+func (s *Server) Register(infoHash [20]byte, h Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[infoHash] = h
+}
+
+// Unregister stops accepting connections for infoHash. Connections that are
+// already being served are not affected.
+func (s *Server) Unregister(infoHash [20]byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.handlers, infoHash)
+}
+
+// Serve accepts connections until the context is cancelled or the server is
+// closed. It waits for every served connection to finish before returning.
+func (s *Server) Serve(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(ctx, func() { s.Close() })
+	defer stop()
+	defer s.wg.Wait()
+
+	for _, ln := range s.others {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.accept(ctx, ln)
+		}()
+	}
+	err := s.accept(ctx, s.ln)
+	// The other listeners stop with the main one
+	s.Close()
+	return err
+}
+
+// accept serves the connections of a listener until it fails
+func (s *Server) accept(ctx context.Context, ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if s.isClosed() {
+				return ErrServerClosed
+			}
+			return err
+		}
+		if !s.acquire() {
+			conn.Close()
+			continue
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer s.release()
+			s.handle(ctx, conn)
+		}()
+	}
+}
+
+// Close stops the listener. Serve returns once the served connections finish.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.mu.Unlock()
+	for _, ln := range s.others {
+		ln.Close()
+	}
+	return s.ln.Close()
+}
+
+// handle completes the handshake and passes the connection to its handler
+func (s *Server) handle(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	if s.Encryption != mse.Disabled {
+		c, _, err := mse.Accept(conn, s.Encryption, s.infoHashes)
+		if err != nil {
+			log.Printf("Rejected connection from %s: %v", conn.RemoteAddr(), err)
+			return
+		}
+		conn = c
+	}
+
+	res := &handshake.Handshake{PeerID: s.peerID}
+	if s.Extensions {
+		res.SetExtensions()
+	}
+	if s.Fast {
+		res.SetFast()
+	}
+	if s.V2 {
+		res.SetV2()
+	}
+	var h Handler
+	hs, err := handshake.Accept(conn, res, func(infoHash [20]byte) bool {
+		h = s.handler(infoHash)
+		return h != nil
+	})
+	if err != nil {
+		log.Printf("Rejected connection from %s: %v", conn.RemoteAddr(), err)
+		return
+	}
+	log.Printf("Accepted connection from %s", conn.RemoteAddr())
+	if err := h.ServeConn(ctx, conn, hs); err != nil && ctx.Err() == nil {
+		log.Printf("Connection from %s closed: %v", conn.RemoteAddr(), err)
+	}
+}
+
+func (s *Server) handler(infoHash [20]byte) Handler {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.handlers[infoHash]
+}
+
+// infoHashes returns the torrents we serve
+func (s *Server) infoHashes() [][20]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hashes := make([][20]byte, 0, len(s.handlers))
+	for infoHash := range s.handlers {
+		hashes = append(hashes, infoHash)
+	}
+	return hashes
+}
+
+func (s *Server) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// acquire reserves a connection slot if the limit allows it
+func (s *Server) acquire() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.MaxConns > 0 && s.conns >= s.MaxConns {
+		return false
+	}
+	s.conns++
+	return true
+}
+
+func (s *Server) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conns--
+}
@@ -0,0 +1,239 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"Torrentasaurus_Rex/internal/handshake"
+	"Torrentasaurus_Rex/internal/mse"
+	"Torrentasaurus_Rex/internal/utp"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// echoHandler echoes everything it reads back to the peer
+type echoHandler struct {
+	served chan struct{}
+}
+
+func (h *echoHandler) ServeConn(ctx context.Context, conn net.Conn, hs *handshake.Handshake) error {
+	h.served <- struct{}{}
+	buf := make([]byte, 64)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return err
+		}
+		if _, err := conn.Write(buf[:n]); err != nil {
+			return err
+		}
+	}
+}
+
+func startServer(t *testing.T) (*Server, context.CancelFunc) {
+	t.Helper()
+	s, err := Listen("127.0.0.1:0", [20]byte{'s'})
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return s, cancel
+}
+
+func dial(t *testing.T, s *Server) net.Conn {
+	t.Helper()
+	conn, err := net.DialTimeout("tcp", s.Addr().String(), time.Second)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestServeRoutesByInfoHash(t *testing.T) {
+	s, _ := startServer(t)
+	infoHash := [20]byte{1, 2, 3}
+	h := &echoHandler{served: make(chan struct{}, 1)}
+	s.Register(infoHash, h)
+
+	conn := dial(t, s)
+	res, err := handshake.CompleteHandshake(conn, infoHash, [20]byte{'c'})
+	require.NoError(t, err)
+	assert.Equal(t, [20]byte{'s'}, res.PeerID)
+	<-h.served
+
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = conn.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(buf))
+}
+
+func TestServeRejectsUnknownInfoHash(t *testing.T) {
+	s, _ := startServer(t)
+	s.Register([20]byte{1}, &echoHandler{served: make(chan struct{}, 1)})
+	s.Unregister([20]byte{1})
+
+	conn := dial(t, s)
+	_, err := handshake.CompleteHandshake(conn, [20]byte{1}, [20]byte{'c'})
+	assert.Error(t, err)
+}
+
+func TestServeLimitsConnections(t *testing.T) {
+	s, _ := startServer(t)
+	s.mu.Lock()
+	s.MaxConns = 1
+	s.mu.Unlock()
+	infoHash := [20]byte{1}
+	h := &echoHandler{served: make(chan struct{}, 2)}
+	s.Register(infoHash, h)
+
+	first := dial(t, s)
+	_, err := handshake.CompleteHandshake(first, infoHash, [20]byte{'c'})
+	require.NoError(t, err)
+	<-h.served
+
+	second := dial(t, s)
+	_, err = handshake.CompleteHandshake(second, infoHash, [20]byte{'d'})
+	assert.Error(t, err)
+}
+
+func TestServeStopsOnCancel(t *testing.T) {
+	s, err := Listen("127.0.0.1:0", [20]byte{'s'})
+	require.NoError(t, err)
+	infoHash := [20]byte{1}
+	h := &echoHandler{served: make(chan struct{}, 1)}
+	s.Register(infoHash, h)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(ctx) }()
+
+	conn := dial(t, s)
+	_, err = handshake.CompleteHandshake(conn, infoHash, [20]byte{'c'})
+	require.NoError(t, err)
+	<-h.served
+
+	cancel()
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(time.Second):
+		t.Fatal("Serve did not return after cancel")
+	}
+}
+
+func TestServeAdvertisesExtensions(t *testing.T) {
+	s, err := Listen("127.0.0.1:0", [20]byte{'s'})
+	require.NoError(t, err)
+	s.Extensions = true
+	s.Fast = true
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	infoHash := [20]byte{1}
+	h := &echoHandler{served: make(chan struct{}, 1)}
+	s.Register(infoHash, h)
+
+	conn := dial(t, s)
+	req := &handshake.Handshake{Pstr: handshake.ProtocolName, InfoHash: infoHash, PeerID: [20]byte{'c'}}
+	req.SetExtensions()
+	res, err := handshake.Initiate(conn, req)
+	require.NoError(t, err)
+	assert.True(t, res.SupportsExtensions())
+	assert.True(t, res.SupportsFast())
+	<-h.served
+}
+
+func TestServeEncryption(t *testing.T) {
+	tests := map[string]struct {
+		policy  mse.Policy
+		encrypt bool
+		ok      bool
+	}{
+		"preferred accepts encrypted": {policy: mse.Preferred, encrypt: true, ok: true},
+		"preferred accepts plaintext": {policy: mse.Preferred, ok: true},
+		"required rejects plaintext":  {policy: mse.Required},
+		"disabled accepts plaintext":  {policy: mse.Disabled, ok: true},
+		"required accepts encrypted":  {policy: mse.Required, encrypt: true, ok: true},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			s, err := Listen("127.0.0.1:0", [20]byte{'s'})
+			require.NoError(t, err)
+			s.Encryption = test.policy
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error, 1)
+			go func() { done <- s.Serve(ctx) }()
+			t.Cleanup(func() {
+				cancel()
+				<-done
+			})
+			infoHash := [20]byte{1}
+			s.Register(infoHash, &echoHandler{served: make(chan struct{}, 1)})
+
+			conn := dial(t, s)
+			if test.encrypt {
+				conn, _, err = mse.Initiate(conn, infoHash, mse.RC4)
+				require.NoError(t, err)
+			}
+			_, err = handshake.CompleteHandshake(conn, infoHash, [20]byte{'c'})
+			if !test.ok {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			_, err = conn.Write([]byte("hello"))
+			require.NoError(t, err)
+			buf := make([]byte, 5)
+			_, err = io.ReadFull(conn, buf)
+			require.NoError(t, err)
+			assert.Equal(t, "hello", string(buf))
+		})
+	}
+}
+
+func TestServeOtherListeners(t *testing.T) {
+	s, err := Listen("127.0.0.1:0", [20]byte{'s'})
+	require.NoError(t, err)
+	sock, err := utp.Listen("127.0.0.1:0")
+	require.NoError(t, err)
+	s.AddListener(sock)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(ctx) }()
+	infoHash := [20]byte{1}
+	h := &echoHandler{served: make(chan struct{}, 1)}
+	s.Register(infoHash, h)
+
+	peer, err := utp.Listen("127.0.0.1:0")
+	require.NoError(t, err)
+	defer peer.Close()
+	conn, err := peer.DialContext(ctx, sock.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = handshake.CompleteHandshake(conn, infoHash, [20]byte{'c'})
+	require.NoError(t, err)
+	<-h.served
+
+	cancel()
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(time.Second):
+		t.Fatal("Serve did not return after cancel")
+	}
+	_, err = sock.Accept()
+	assert.ErrorIs(t, err, net.ErrClosed, "the uTP socket closes with the server")
+}
@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// Blob stores the torrent data in a single file, preallocated to the full
+// length of the torrent, regardless of the files the torrent describes
+type Blob struct {
+	layout
+	f *os.File
+}
+
+// CreateBlob creates or opens the blob at path for reading and writing and
+// sizes it to length bytes
+func CreateBlob(path string, pieceLength, length int) (*Blob, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if info.Size() != int64(length) {
+		if err := f.Truncate(int64(length)); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to allocate %s: %w", path, err)
+		}
+	}
+	return &Blob{layout: layout{pieceLength: pieceLength, length: length}, f: f}, nil
+}
+
+// OpenBlob opens an existing blob for reading
+func OpenBlob(path string, pieceLength, length int) (*Blob, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Blob{layout: layout{pieceLength: pieceLength, length: length}, f: f}, nil
+}
+
+// ReadAt reads piece data starting at off
+func (b *Blob) ReadAt(index int, p []byte, off int64) (int, error) {
+	begin, err := b.offset(index, off, len(p))
+	if err != nil {
+		return 0, err
+	}
+	return b.f.ReadAt(p, begin)
+}
+
+// WriteAt writes piece data starting at off
+func (b *Blob) WriteAt(index int, p []byte, off int64) (int, error) {
+	begin, err := b.offset(index, off, len(p))
+	if err != nil {
+		return 0, err
+	}
+	n, err := b.f.WriteAt(p, begin)
+	if err == nil && n < len(p) {
+		err = io.ErrShortWrite
+	}
+	return n, err
+}
+
+// MarkComplete does nothing; the data is already in place
+func (b *Blob) MarkComplete(index int) error {
+	return nil
+}
+
+// Sync flushes the blob to disk
+func (b *Blob) Sync() error {
+	return b.f.Sync()
+}
+
+// Close closes the blob
+func (b *Blob) Close() error {
+	return b.f.Close()
+}
@@ -0,0 +1,180 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"Torrentasaurus_Rex/internal/torrent"
+)
+
+// Files stores the torrent data in the files of a torrent under a download
+// directory, the way other clients lay them out. Pieces that cross file
+// boundaries are split across the files they belong to.
+type Files struct {
+	layout
+	entries []torrent.FileEntry
+	files   []*os.File
+}
+
+// CreateFiles creates or opens every file of a torrent under root for
+// reading and writing and sizes them to their final length. Files that
+// already have the right size are left untouched so they can be resumed.
+// Padding files aren't created; they read as zeros.
+func CreateFiles(root string, pieceLength int, entries []torrent.FileEntry) (*Files, error) {
+	fset := newFiles(pieceLength, entries)
+	for i, entry := range entries {
+		if entry.Padding {
+			continue
+		}
+		path := entry.LocalPath(root)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			fset.Close()
+			return nil, fmt.Errorf("failed to create directory for %s: %w", path, err)
+		}
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+		if err != nil {
+			fset.Close()
+			return nil, fmt.Errorf("failed to open %s: %w", path, err)
+		}
+		fset.files[i] = f
+		info, err := f.Stat()
+		if err != nil {
+			fset.Close()
+			return nil, err
+		}
+		if info.Size() == int64(entry.Length) {
+			continue
+		}
+		if err := f.Truncate(int64(entry.Length)); err != nil {
+			fset.Close()
+			return nil, fmt.Errorf("failed to allocate %s: %w", path, err)
+		}
+	}
+	return fset, nil
+}
+
+// OpenFiles opens the existing files of a torrent under root for reading.
+// Missing files are tolerated and read as if they were empty.
+func OpenFiles(root string, pieceLength int, entries []torrent.FileEntry) (*Files, error) {
+	fset := newFiles(pieceLength, entries)
+	for i, entry := range entries {
+		if entry.Padding {
+			continue
+		}
+		f, err := os.Open(entry.LocalPath(root))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			fset.Close()
+			return nil, err
+		}
+		fset.files[i] = f
+	}
+	return fset, nil
+}
+
+func newFiles(pieceLength int, entries []torrent.FileEntry) *Files {
+	length := 0
+	if len(entries) > 0 {
+		last := entries[len(entries)-1]
+		length = last.Offset + last.Length
+	}
+	return &Files{
+		layout:  layout{pieceLength: pieceLength, length: length},
+		entries: entries,
+		files:   make([]*os.File, len(entries)),
+	}
+}
+
+// ReadAt reads piece data starting at off. It returns io.EOF when the data
+// ends early because a file is missing or shorter than expected.
+func (fset *Files) ReadAt(index int, p []byte, off int64) (int, error) {
+	begin, err := fset.offset(index, off, len(p))
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, span := range torrent.FileSpans(fset.entries, int(begin), len(p)) {
+		if fset.entries[span.File].Padding {
+			clear(p[n : n+span.Length])
+			n += span.Length
+			continue
+		}
+		f := fset.files[span.File]
+		if f == nil {
+			return n, io.EOF
+		}
+		read, err := f.ReadAt(p[n:n+span.Length], int64(span.Offset))
+		n += read
+		if err != nil {
+			return n, err
+		}
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// WriteAt writes piece data starting at off into the files it belongs to.
+// Data falling in padding files is dropped.
+func (fset *Files) WriteAt(index int, p []byte, off int64) (int, error) {
+	begin, err := fset.offset(index, off, len(p))
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, span := range torrent.FileSpans(fset.entries, int(begin), len(p)) {
+		if fset.entries[span.File].Padding {
+			n += span.Length
+			continue
+		}
+		f := fset.files[span.File]
+		if f == nil {
+			return n, fmt.Errorf("file %s is not open for writing", filepath.Join(fset.entries[span.File].Path...))
+		}
+		written, err := f.WriteAt(p[n:n+span.Length], int64(span.Offset))
+		n += written
+		if err != nil {
+			return n, err
+		}
+	}
+	if n < len(p) {
+		return n, io.ErrShortWrite
+	}
+	return n, nil
+}
+
+// MarkComplete does nothing; the data is already in place
+func (fset *Files) MarkComplete(index int) error {
+	return nil
+}
+
+// Sync flushes every file to disk
+func (fset *Files) Sync() error {
+	for _, f := range fset.files {
+		if f == nil {
+			continue
+		}
+		if err := f.Sync(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes every open file
+func (fset *Files) Close() error {
+	var errs []error
+	for _, f := range fset.files {
+		if f != nil {
+			errs = append(errs, f.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"Torrentasaurus_Rex/internal/torrent"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testEntries = []torrent.FileEntry{
+	{Path: []string{"album", "a.flac"}, Length: 12, Offset: 0},
+	{Path: []string{"album", "empty"}, Length: 0, Offset: 12},
+	{Path: []string{"album", "cover", "c.jpg"}, Length: 8, Offset: 12},
+}
+
+func testData(size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	return data
+}
+
+func TestFilesWriteAcrossFiles(t *testing.T) {
+	root := t.TempDir()
+	fset, err := CreateFiles(root, 8, testEntries)
+	require.NoError(t, err)
+
+	// Piece 1 covers bytes 8 to 16 and crosses from a.flac into c.jpg
+	data := testData(20)
+	n, err := fset.WriteAt(1, data[8:16], 0)
+	require.NoError(t, err)
+	assert.Equal(t, 8, n)
+	_, err = fset.WriteAt(0, data[:8], 0)
+	require.NoError(t, err)
+	_, err = fset.WriteAt(2, data[16:], 0)
+	require.NoError(t, err)
+	require.NoError(t, fset.Close())
+
+	a, err := os.ReadFile(filepath.Join(root, "album", "a.flac"))
+	require.NoError(t, err)
+	assert.Equal(t, data[:12], a)
+
+	c, err := os.ReadFile(filepath.Join(root, "album", "cover", "c.jpg"))
+	require.NoError(t, err)
+	assert.Equal(t, data[12:], c)
+
+	info, err := os.Stat(filepath.Join(root, "album", "empty"))
+	require.NoError(t, err)
+	assert.Zero(t, info.Size())
+}
+
+func TestFilesReadMissingFile(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "album"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "album", "a.flac"), testData(12), 0o644))
+
+	fset, err := OpenFiles(root, 8, testEntries)
+	require.NoError(t, err)
+	defer fset.Close()
+
+	buf := make([]byte, 8)
+	n, err := fset.ReadAt(1, buf, 0)
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Equal(t, 4, n)
+	assert.Equal(t, testData(12)[8:], buf[:4])
+}
+
+func TestFilesKeepsExistingData(t *testing.T) {
+	root := t.TempDir()
+	fset, err := CreateFiles(root, 8, testEntries)
+	require.NoError(t, err)
+	_, err = fset.WriteAt(0, testData(8), 0)
+	require.NoError(t, err)
+	require.NoError(t, fset.Close())
+
+	fset, err = CreateFiles(root, 8, testEntries)
+	require.NoError(t, err)
+	defer fset.Close()
+	buf := make([]byte, 8)
+	_, err = fset.ReadAt(0, buf, 0)
+	require.NoError(t, err)
+	assert.Equal(t, testData(8), buf)
+}
+
+func TestFilesPadding(t *testing.T) {
+	root := t.TempDir()
+	entries := []torrent.FileEntry{
+		{Path: []string{"set", "a"}, Length: 5},
+		{Path: []string{"set", ".pad", "3"}, Length: 3, Offset: 5, Padding: true},
+		{Path: []string{"set", "b"}, Length: 4, Offset: 8},
+	}
+	fset, err := CreateFiles(root, 8, entries)
+	require.NoError(t, err)
+	defer fset.Close()
+
+	data := testData(8)
+	_, err = fset.WriteAt(0, data, 0)
+	require.NoError(t, err)
+	_, err = os.Stat(filepath.Join(root, "set", ".pad"))
+	assert.ErrorIs(t, err, os.ErrNotExist, "padding isn't stored")
+
+	buf := make([]byte, 8)
+	_, err = fset.ReadAt(0, buf, 0)
+	require.NoError(t, err)
+	assert.Equal(t, append(data[:5:5], 0, 0, 0), buf)
+}
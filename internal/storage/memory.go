@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"io"
+	"sync"
+)
+
+// Memory keeps the torrent data in memory. It is meant for tests and small
+// torrents.
+type Memory struct {
+	layout
+
+	mu       sync.Mutex
+	data     []byte
+	complete map[int]bool
+}
+
+// NewMemory returns an empty in-memory backend for length bytes of data
+func NewMemory(pieceLength, length int) *Memory {
+	return &Memory{
+		layout:   layout{pieceLength: pieceLength, length: length},
+		data:     make([]byte, length),
+		complete: make(map[int]bool),
+	}
+}
+
+// ReadAt reads piece data starting at off
+func (m *Memory) ReadAt(index int, p []byte, off int64) (int, error) {
+	begin, err := m.offset(index, off, len(p))
+	if err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := copy(p, m.data[begin:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// WriteAt writes piece data starting at off
+func (m *Memory) WriteAt(index int, p []byte, off int64) (int, error) {
+	begin, err := m.offset(index, off, len(p))
+	if err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return copy(m.data[begin:], p), nil
+}
+
+// MarkComplete records that a piece was verified
+func (m *Memory) MarkComplete(index int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.complete[index] = true
+	return nil
+}
+
+// Completed tells whether MarkComplete was called for a piece
+func (m *Memory) Completed(index int) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.complete[index]
+}
+
+// Bytes returns the stored data. The slice is shared with the backend.
+func (m *Memory) Bytes() []byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.data
+}
+
+// Close does nothing
+func (m *Memory) Close() error {
+	return nil
+}
@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrOutOfRange is returned for reads and writes that don't fit in a piece
+var ErrOutOfRange = errors.New("access outside piece")
+
+// Backend stores the piece data of a torrent. Offsets are relative to the
+// start of a piece, so backends are free to lay the data out however they
+// like.
+type Backend interface {
+	// ReadAt reads len(p) bytes of piece index starting at off. It returns
+	// io.EOF when the data ends early, for example because it was never
+	// written.
+	ReadAt(index int, p []byte, off int64) (int, error)
+	// WriteAt writes p into piece index starting at off
+	WriteAt(index int, p []byte, off int64) (int, error)
+	// MarkComplete is called once a piece was written and verified
+	MarkComplete(index int) error
+	// Close releases the resources held by the backend
+	Close() error
+}
+
+// layout maps piece-relative offsets onto offsets within the torrent data
+type layout struct {
+	pieceLength int
+	length      int
+}
+
+// numPieces returns the number of pieces in the torrent
+func (l layout) numPieces() int {
+	return (l.length + l.pieceLength - 1) / l.pieceLength
+}
+
+// offset returns the position of a byte range of a piece within the torrent
+// data, checking that the range fits in the piece
+func (l layout) offset(index int, off int64, n int) (int64, error) {
+	if index < 0 || index >= l.numPieces() {
+		return 0, fmt.Errorf("%w: piece index %d out of range", ErrOutOfRange, index)
+	}
+	begin := int64(index) * int64(l.pieceLength)
+	size := min(int64(l.pieceLength), int64(l.length)-begin)
+	if off < 0 || off+int64(n) > size {
+		return 0, fmt.Errorf("%w: [%d:%d] of piece #%d with %d bytes", ErrOutOfRange, off, off+int64(n), index, size)
+	}
+	return begin + off, nil
+}
@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// backends returns every backend sized for 20 bytes in pieces of 8
+func backends(t *testing.T) map[string]Backend {
+	root := t.TempDir()
+	files, err := CreateFiles(root, 8, testEntries)
+	require.NoError(t, err)
+	blob, err := CreateBlob(filepath.Join(root, "blob"), 8, 20)
+	require.NoError(t, err)
+	all := map[string]Backend{
+		"files":  files,
+		"blob":   blob,
+		"memory": NewMemory(8, 20),
+	}
+	t.Cleanup(func() {
+		for _, b := range all {
+			b.Close()
+		}
+	})
+	return all
+}
+
+func TestBackendsRoundTrip(t *testing.T) {
+	data := testData(20)
+	for name, b := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			_, err := b.WriteAt(0, data[:8], 0)
+			require.NoError(t, err)
+			_, err = b.WriteAt(1, data[10:16], 2)
+			require.NoError(t, err)
+			_, err = b.WriteAt(1, data[8:10], 0)
+			require.NoError(t, err)
+			_, err = b.WriteAt(2, data[16:], 0)
+			require.NoError(t, err)
+			require.NoError(t, b.MarkComplete(1))
+
+			for index, want := range [][]byte{data[:8], data[8:16], data[16:]} {
+				buf := make([]byte, len(want))
+				n, err := b.ReadAt(index, buf, 0)
+				require.NoError(t, err)
+				assert.Equal(t, len(want), n)
+				assert.Equal(t, want, buf)
+			}
+		})
+	}
+}
+
+func TestBackendsRejectOutOfRange(t *testing.T) {
+	for name, b := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			_, err := b.WriteAt(3, []byte{1}, 0)
+			assert.ErrorIs(t, err, ErrOutOfRange)
+			_, err = b.WriteAt(0, make([]byte, 8), 1)
+			assert.ErrorIs(t, err, ErrOutOfRange)
+			_, err = b.ReadAt(2, make([]byte, 5), 0)
+			assert.ErrorIs(t, err, ErrOutOfRange)
+			_, err = b.ReadAt(-1, make([]byte, 1), 0)
+			assert.ErrorIs(t, err, ErrOutOfRange)
+		})
+	}
+}
+
+func TestMemoryCompleted(t *testing.T) {
+	m := NewMemory(8, 20)
+	assert.False(t, m.Completed(2))
+	require.NoError(t, m.MarkComplete(2))
+	assert.True(t, m.Completed(2))
+}
+
+func TestOpenBlobShort(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blob")
+	b, err := CreateBlob(path, 8, 12)
+	require.NoError(t, err)
+	require.NoError(t, b.Close())
+
+	// Opened with the full length, the blob created for 12 bytes is short
+	b, err = OpenBlob(path, 8, 20)
+	require.NoError(t, err)
+	defer b.Close()
+	n, err := b.ReadAt(1, make([]byte, 8), 0)
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Equal(t, 4, n)
+}
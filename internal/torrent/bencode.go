@@ -1,32 +1,59 @@
 package torrent
 
 import (
-	"bytes"
 	"crypto/sha1"
 	"fmt"
-	"github.com/jackpal/bencode-go"
+	"strings"
+
+	"Torrentasaurus_Rex/internal/bencode"
 )
 
 // bencodeInfo represents the information contained in the "info" section of the bencode torrent file.
 type bencodeInfo struct {
-	Pieces      string `bencode:"pieces"`
-	PieceLength int    `bencode:"piece length"`
-	Length      int    `bencode:"length"`
-	Name        string `bencode:"name"`
+	Pieces      string        `bencode:"pieces"`
+	PieceLength int           `bencode:"piece length"`
+	Length      int           `bencode:"length,omitempty"`
+	Files       []bencodeFile `bencode:"files,omitempty"`
+	Name        string        `bencode:"name"`
+	Private     int           `bencode:"private,omitempty"`
+	Source      string        `bencode:"source,omitempty"`
+
+	// MetaVersion is 2 for v2 and hybrid torrents (BEP 52)
+	MetaVersion int `bencode:"meta version,omitempty"`
+	// FileTree holds the files of a v2 torrent as nested dictionaries
+	FileTree map[string]any `bencode:"file tree,omitempty"`
+}
+
+// bencodeFile represents a single entry of the "files" list of a multi-file torrent.
+type bencodeFile struct {
+	Length int      `bencode:"length"`
+	Path   []string `bencode:"path"`
+	// Attr holds the file attributes; "p" marks a padding file (BEP 47)
+	Attr string `bencode:"attr,omitempty"`
 }
 
 type BencodeTorrentFile struct {
-	Announce string      `bencode:"announce"`
-	Info     bencodeInfo `bencode:"info"`
+	Announce     string      `bencode:"announce"`
+	AnnounceList [][]string  `bencode:"announce-list"`
+	Info         bencodeInfo `bencode:"info"`
+
+	// URLList holds the web seeds (BEP 19), either a single URL or a list
+	URLList any `bencode:"url-list,omitempty"`
+	// PieceLayers maps the pieces root of each file of a v2 torrent larger
+	// than a piece to the concatenated hashes of its piece layer
+	PieceLayers map[string]string `bencode:"piece layers,omitempty"`
+
+	// rawInfo holds the exact bytes of the "info" dictionary as found in the file
+	rawInfo bencode.RawMessage
 }
 
-// hash computes the SHA-1 hash for BencodeInfo.
-func (i *bencodeInfo) hash() ([20]byte, error) {
-	var buf bytes.Buffer
-	if err := bencode.Marshal(&buf, *i); err != nil {
-		return [20]byte{}, fmt.Errorf("failed to marshal bencode info: %w", err)
+// infoHash computes the SHA-1 hash of the original "info" dictionary bytes.
+// Hashing the raw bytes keeps keys that bencodeInfo doesn't model.
+func (btf *BencodeTorrentFile) infoHash() ([20]byte, error) {
+	if len(btf.rawInfo) == 0 {
+		return [20]byte{}, fmt.Errorf("missing info dictionary")
 	}
-	return sha1.Sum(buf.Bytes()), nil
+	return sha1.Sum(btf.rawInfo), nil
 }
 
 // splitPieceHashes splits the pieces string into individual SHA-1 hashes.
@@ -44,3 +71,83 @@ func (i *bencodeInfo) splitPieceHashes() ([][20]byte, error) {
 	}
 	return hashes, nil
 }
+
+// fileEntries lists the files of the torrent. A single-file torrent yields one
+// entry named after the torrent, a multi-file torrent yields its files nested
+// in a directory named after the torrent.
+func (i *bencodeInfo) fileEntries() ([]FileEntry, error) {
+	if err := validatePathComponent(i.Name); err != nil {
+		return nil, fmt.Errorf("invalid name: %w", err)
+	}
+	if len(i.Files) == 0 {
+		if i.Length < 0 {
+			return nil, fmt.Errorf("negative length %d", i.Length)
+		}
+		return []FileEntry{{Path: []string{i.Name}, Length: i.Length}}, nil
+	}
+
+	entries := make([]FileEntry, len(i.Files))
+	offset := 0
+	for n, f := range i.Files {
+		if len(f.Path) == 0 {
+			return nil, fmt.Errorf("file #%d has an empty path", n)
+		}
+		for _, component := range f.Path {
+			if err := validatePathComponent(component); err != nil {
+				return nil, fmt.Errorf("invalid path of file #%d: %w", n, err)
+			}
+		}
+		if f.Length < 0 {
+			return nil, fmt.Errorf("file #%d has negative length %d", n, f.Length)
+		}
+		entries[n] = FileEntry{
+			Path:    append([]string{i.Name}, f.Path...),
+			Length:  f.Length,
+			Offset:  offset,
+			Padding: strings.Contains(f.Attr, "p"),
+		}
+		offset += f.Length
+	}
+	return entries, nil
+}
+
+// validatePathComponent rejects path components that could escape the download directory.
+func validatePathComponent(component string) error {
+	if component == "" || component == "." || component == ".." || strings.ContainsAny(component, "/\\\x00") {
+		return fmt.Errorf("unsafe path component %q", component)
+	}
+	return nil
+}
+
+// webSeeds returns the URLs of the url-list with empty and malformed entries
+// removed
+func (btf *BencodeTorrentFile) webSeeds() []string {
+	list, ok := btf.URLList.([]any)
+	if !ok {
+		list = []any{btf.URLList}
+	}
+	var seeds []string
+	for _, u := range list {
+		if s, ok := u.(string); ok && s != "" {
+			seeds = append(seeds, s)
+		}
+	}
+	return seeds
+}
+
+// announceTiers returns the non-empty tiers of the announce-list with empty URLs removed.
+func (btf *BencodeTorrentFile) announceTiers() [][]string {
+	var tiers [][]string
+	for _, tier := range btf.AnnounceList {
+		var urls []string
+		for _, u := range tier {
+			if u != "" {
+				urls = append(urls, u)
+			}
+		}
+		if len(urls) > 0 {
+			tiers = append(tiers, urls)
+		}
+	}
+	return tiers
+}
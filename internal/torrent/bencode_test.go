@@ -3,24 +3,29 @@ package torrent
 import (
 	"testing"
 
+	"Torrentasaurus_Rex/internal/bencode"
+
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
-func TestBencodeInfo_Hash(t *testing.T) {
-	info := bencodeInfo{
-		Pieces:      "12345678901234567890",
-		PieceLength: 262144,
-		Length:      123456,
-		Name:        "testfile.txt",
+func TestBencodeTorrentFile_InfoHash(t *testing.T) {
+	btf := BencodeTorrentFile{
+		rawInfo: []byte("d6:lengthi123456e4:name12:testfile.txt12:piece lengthi262144e6:pieces20:12345678901234567890e"),
 	}
-	hash, err := info.hash()
+	hash, err := btf.infoHash()
 	require.NoError(t, err)
 
 	expectedHash := [20]uint8{0xa4, 0x76, 0xf7, 0xce, 0xf2, 0xa4, 0x2b, 0x54, 0xf2, 0xc9, 0x7d, 0x49, 0x3a, 0x4a, 0x4b, 0xfb, 0xf7, 0xb3, 0x21, 0x19}
 	assert.Equal(t, expectedHash, hash)
 }
 
+func TestBencodeTorrentFile_InfoHashMissing(t *testing.T) {
+	btf := BencodeTorrentFile{}
+	_, err := btf.infoHash()
+	assert.Error(t, err)
+}
+
 func TestBencodeInfo_SplitPieceHashes(t *testing.T) {
 	info := bencodeInfo{
 		Pieces: "1234567890123456789012345678901234567890",
@@ -34,3 +39,66 @@ func TestBencodeInfo_SplitPieceHashes(t *testing.T) {
 	}
 	assert.Equal(t, expectedHashes, hashes)
 }
+
+func TestBencodeInfo_FileEntriesSingleFile(t *testing.T) {
+	info := bencodeInfo{Name: "testfile.txt", Length: 123456}
+	files, err := info.fileEntries()
+	require.NoError(t, err)
+	assert.Equal(t, []FileEntry{{Path: []string{"testfile.txt"}, Length: 123456}}, files)
+}
+
+func TestBencodeInfo_FileEntriesMultiFile(t *testing.T) {
+	info := bencodeInfo{
+		Name: "album",
+		Files: []bencodeFile{
+			{Length: 100, Path: []string{"a.flac"}},
+			{Length: 50, Path: []string{"cover", "c.jpg"}},
+		},
+	}
+	files, err := info.fileEntries()
+	require.NoError(t, err)
+	expected := []FileEntry{
+		{Path: []string{"album", "a.flac"}, Length: 100, Offset: 0},
+		{Path: []string{"album", "cover", "c.jpg"}, Length: 50, Offset: 100},
+	}
+	assert.Equal(t, expected, files)
+}
+
+func TestBencodeInfo_FileEntriesUnsafePath(t *testing.T) {
+	tests := map[string]bencodeInfo{
+		"dot dot component": {Name: "album", Files: []bencodeFile{{Length: 1, Path: []string{"..", "etc", "passwd"}}}},
+		"separator":         {Name: "album", Files: []bencodeFile{{Length: 1, Path: []string{"a/b"}}}},
+		"empty path":        {Name: "album", Files: []bencodeFile{{Length: 1}}},
+		"empty name":        {Name: "", Length: 1},
+	}
+	for name, info := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := info.fileEntries()
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestBencodeTorrentFile_AnnounceTiers(t *testing.T) {
+	btf := BencodeTorrentFile{AnnounceList: [][]string{{"udp://a", ""}, {}, {"http://b"}}}
+	assert.Equal(t, [][]string{{"udp://a"}, {"http://b"}}, btf.announceTiers())
+}
+
+func TestBencodeTorrentFile_WebSeeds(t *testing.T) {
+	tests := map[string]struct {
+		data     string
+		expected []string
+	}{
+		"single url": {"d8:url-list19:http://example.com/e", []string{"http://example.com/"}},
+		"list":       {"d8:url-listl19:http://example.com/0:17:http://mirror.orgee", []string{"http://example.com/", "http://mirror.org"}},
+		"missing":    {"de", nil},
+		"malformed":  {"d8:url-listi1ee", nil},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var btf BencodeTorrentFile
+			require.NoError(t, bencode.Unmarshal([]byte(test.data), &btf))
+			assert.Equal(t, test.expected, btf.webSeeds())
+		})
+	}
+}
@@ -0,0 +1,297 @@
+package torrent
+
+import (
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"Torrentasaurus_Rex/internal/bencode"
+)
+
+const (
+	// MinPieceLength is the smallest piece length Create accepts, the size
+	// of a block requested from peers
+	MinPieceLength = 16 << 10
+	// maxAutoPieceLength caps the piece length picked from the total size
+	maxAutoPieceLength = 16 << 20
+	// targetPieces is the number of pieces the automatic piece length aims
+	// for, trading the size of the metainfo against the piece size
+	targetPieces = 1500
+)
+
+// ErrNoData is returned when creating a torrent for a path without data
+var ErrNoData = errors.New("no data to create a torrent from")
+
+// CreateOptions holds the optional fields of a torrent created by Create
+type CreateOptions struct {
+	// PieceLength is the size of the pieces, a power of two of at least
+	// MinPieceLength. Zero picks one from the total size.
+	PieceLength int
+	Announce    string
+	// AnnounceList holds tiers of tracker URLs (BEP 12). Announce defaults
+	// to the first URL of the first tier.
+	AnnounceList [][]string
+	// WebSeeds holds the URLs of HTTP mirrors of the data (BEP 19)
+	WebSeeds  []string
+	Comment   string
+	CreatedBy string
+	// CreationDate is left out when zero
+	CreationDate time.Time
+	// Private asks clients to find peers through the trackers only (BEP 27)
+	Private bool
+	// Source is stored in the info dictionary, giving the torrent a
+	// different info hash than the same data published elsewhere
+	Source string
+	// Workers is the number of pieces hashed in parallel. Zero uses one per
+	// CPU.
+	Workers int
+}
+
+// Create hashes the file or directory at root and returns the encoded
+// metainfo of a torrent named after it. The files of a directory are added
+// in lexical order of their paths; anything but regular files is skipped.
+func Create(root string, opts CreateOptions) ([]byte, error) {
+	root = filepath.Clean(root)
+	files, err := collectFiles(root)
+	if err != nil {
+		return nil, err
+	}
+	total := 0
+	for _, f := range files {
+		total += f.Length
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNoData, root)
+	}
+
+	pieceLength := opts.PieceLength
+	if pieceLength == 0 {
+		pieceLength = choosePieceLength(total)
+	}
+	if pieceLength < MinPieceLength || pieceLength&(pieceLength-1) != 0 {
+		return nil, fmt.Errorf("invalid piece length %d: must be a power of two of at least %d", pieceLength, MinPieceLength)
+	}
+
+	hashes, err := hashPieces(filepath.Dir(root), files, total, pieceLength, opts.Workers)
+	if err != nil {
+		return nil, err
+	}
+
+	info := bencodeInfo{
+		Pieces:      string(hashes),
+		PieceLength: pieceLength,
+		Name:        files[0].Path[0],
+		Source:      opts.Source,
+	}
+	if opts.Private {
+		info.Private = 1
+	}
+	if len(files) == 1 && len(files[0].Path) == 1 {
+		info.Length = total
+	} else {
+		for _, f := range files {
+			info.Files = append(info.Files, bencodeFile{Length: f.Length, Path: f.Path[1:]})
+		}
+	}
+
+	announce := opts.Announce
+	if announce == "" && len(opts.AnnounceList) > 0 && len(opts.AnnounceList[0]) > 0 {
+		announce = opts.AnnounceList[0][0]
+	}
+	var urlList any
+	if len(opts.WebSeeds) > 0 {
+		urlList = opts.WebSeeds
+	}
+	var creationDate int64
+	if !opts.CreationDate.IsZero() {
+		creationDate = opts.CreationDate.Unix()
+	}
+	return bencode.Marshal(struct {
+		Announce     string      `bencode:"announce,omitempty"`
+		AnnounceList [][]string  `bencode:"announce-list,omitempty"`
+		Comment      string      `bencode:"comment,omitempty"`
+		CreatedBy    string      `bencode:"created by,omitempty"`
+		CreationDate int64       `bencode:"creation date,omitempty"`
+		Info         bencodeInfo `bencode:"info"`
+		URLList      any         `bencode:"url-list,omitempty"`
+	}{announce, opts.AnnounceList, opts.Comment, opts.CreatedBy, creationDate, info, urlList})
+}
+
+// choosePieceLength doubles the piece length until the data fits in about
+// targetPieces pieces
+func choosePieceLength(total int) int {
+	length := MinPieceLength
+	for length < maxAutoPieceLength && total/length > targetPieces {
+		length *= 2
+	}
+	return length
+}
+
+// collectFiles lists the regular files at root in lexical order of their
+// paths. Their paths start with the name of root, like those of the files of
+// a torrent.
+func collectFiles(root string) ([]FileEntry, error) {
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	name := filepath.Base(root)
+	if err := validateCreatedComponent(name); err != nil {
+		return nil, fmt.Errorf("invalid name: %w", err)
+	}
+	if !info.IsDir() {
+		return []FileEntry{{Path: []string{name}, Length: int(info.Size())}}, nil
+	}
+
+	var files []FileEntry
+	offset := 0
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		components := strings.Split(filepath.ToSlash(rel), "/")
+		for _, component := range components {
+			if err := validateCreatedComponent(component); err != nil {
+				return fmt.Errorf("invalid path %s: %w", path, err)
+			}
+		}
+		files = append(files, FileEntry{
+			Path:   append([]string{name}, components...),
+			Length: int(info.Size()),
+			Offset: offset,
+		})
+		offset += int(info.Size())
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("%w: %s has no files", ErrNoData, root)
+	}
+	return files, nil
+}
+
+// validateCreatedComponent rejects the path components Open would reject,
+// and those that aren't UTF-8 as BEP 3 requires
+func validateCreatedComponent(component string) error {
+	if err := validatePathComponent(component); err != nil {
+		return err
+	}
+	if !utf8.ValidString(component) {
+		return fmt.Errorf("path component %q is not UTF-8", component)
+	}
+	return nil
+}
+
+// hashPieces returns the concatenated SHA-1 hashes of the pieces of the
+// files under dir, hashing several pieces at once
+func hashPieces(dir string, files []FileEntry, total, pieceLength, workers int) ([]byte, error) {
+	numPieces := (total + pieceLength - 1) / pieceLength
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	workers = min(workers, numPieces)
+
+	hashes := make([]byte, numPieces*sha1.Size)
+	indexes := make(chan int)
+	done := make(chan struct{})
+	var (
+		wg       sync.WaitGroup
+		failOnce sync.Once
+		failure  error
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := &pieceReader{dir: dir, files: files}
+			defer r.close()
+			buf := make([]byte, pieceLength)
+			for index := range indexes {
+				begin := index * pieceLength
+				n := min(pieceLength, total-begin)
+				if err := r.read(buf[:n], begin); err != nil {
+					failOnce.Do(func() {
+						failure = err
+						close(done)
+					})
+					continue
+				}
+				sum := sha1.Sum(buf[:n])
+				copy(hashes[index*sha1.Size:], sum[:])
+			}
+		}()
+	}
+
+feed:
+	for index := 0; index < numPieces; index++ {
+		select {
+		case indexes <- index:
+		case <-done:
+			break feed
+		}
+	}
+	close(indexes)
+	wg.Wait()
+	if failure != nil {
+		return nil, failure
+	}
+	return hashes, nil
+}
+
+// pieceReader reads ranges of the torrent data from the files, keeping the
+// file it read last open
+type pieceReader struct {
+	dir   string
+	files []FileEntry
+
+	file  *os.File
+	index int
+}
+
+func (r *pieceReader) read(buf []byte, offset int) error {
+	pos := 0
+	for _, span := range FileSpans(r.files, offset, len(buf)) {
+		if r.file == nil || r.index != span.File {
+			r.close()
+			f, err := os.Open(r.files[span.File].LocalPath(r.dir))
+			if err != nil {
+				return err
+			}
+			r.file, r.index = f, span.File
+		}
+		if _, err := r.file.ReadAt(buf[pos:pos+span.Length], int64(span.Offset)); err != nil {
+			if errors.Is(err, io.EOF) {
+				return fmt.Errorf("%s shrank while hashing", r.file.Name())
+			}
+			return err
+		}
+		pos += span.Length
+	}
+	return nil
+}
+
+func (r *pieceReader) close() {
+	if r.file != nil {
+		r.file.Close()
+		r.file = nil
+	}
+}
@@ -0,0 +1,171 @@
+package torrent
+
+import (
+	"crypto/sha1"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"Torrentasaurus_Rex/internal/bencode"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeFile creates a file with parent directories and returns its contents
+func writeFile(t *testing.T, path string, size int) []byte {
+	t.Helper()
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i*31 + size)
+	}
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	require.NoError(t, os.WriteFile(path, data, 0o644))
+	return data
+}
+
+// pieceHashes hashes data the way Create should
+func pieceHashes(data []byte, pieceLength int) [][20]byte {
+	var hashes [][20]byte
+	for begin := 0; begin < len(data); begin += pieceLength {
+		hashes = append(hashes, sha1.Sum(data[begin:min(begin+pieceLength, len(data))]))
+	}
+	return hashes
+}
+
+// createAndOpen creates a torrent for root and reads it back with Open
+func createAndOpen(t *testing.T, root string, opts CreateOptions) ([]byte, TorrentFile) {
+	t.Helper()
+	data, err := Create(root, opts)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "created.torrent")
+	require.NoError(t, os.WriteFile(path, data, 0o644))
+	tf, err := Open(path)
+	require.NoError(t, err)
+	return data, tf
+}
+
+func TestCreateSingleFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "image.iso")
+	content := writeFile(t, path, 5*MinPieceLength+123)
+
+	data, tf := createAndOpen(t, path, CreateOptions{PieceLength: 2 * MinPieceLength, Workers: 3})
+	assert.Equal(t, "image.iso", tf.Name)
+	assert.Equal(t, len(content), tf.Length)
+	assert.Equal(t, 2*MinPieceLength, tf.PieceLength)
+	assert.Equal(t, pieceHashes(content, 2*MinPieceLength), tf.PieceHashes)
+	assert.Equal(t, []FileEntry{{Path: []string{"image.iso"}, Length: len(content)}}, tf.Files)
+
+	rawInfo, err := bencode.DictValue(data, "info")
+	require.NoError(t, err)
+	assert.Equal(t, sha1.Sum(rawInfo), tf.InfoHash)
+}
+
+func TestCreateDirectory(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "album")
+	var content []byte
+	// Files are added in path order, and pieces span file boundaries
+	content = append(content, writeFile(t, filepath.Join(root, "a.flac"), MinPieceLength+1)...)
+	content = append(content, writeFile(t, filepath.Join(root, "art", "cover.jpg"), 100)...)
+	content = append(content, writeFile(t, filepath.Join(root, "b.flac"), 2*MinPieceLength)...)
+	writeFile(t, filepath.Join(root, "empty"), 0)
+	require.NoError(t, os.Symlink("a.flac", filepath.Join(root, "link")))
+
+	_, tf := createAndOpen(t, root, CreateOptions{})
+	assert.Equal(t, "album", tf.Name)
+	assert.Equal(t, MinPieceLength, tf.PieceLength)
+	assert.Equal(t, pieceHashes(content, MinPieceLength), tf.PieceHashes)
+	var paths [][]string
+	for _, f := range tf.Files {
+		paths = append(paths, f.Path)
+	}
+	assert.Equal(t, [][]string{
+		{"album", "a.flac"},
+		{"album", "art", "cover.jpg"},
+		{"album", "b.flac"},
+		{"album", "empty"},
+	}, paths)
+}
+
+func TestCreateOptions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notes.txt")
+	writeFile(t, path, 1000)
+	opts := CreateOptions{
+		AnnounceList: [][]string{{"http://a.example/announce"}, {"udp://b.example:6969"}},
+		WebSeeds:     []string{"http://mirror.example/notes.txt"},
+		Comment:      "release notes",
+		CreatedBy:    "test",
+		CreationDate: time.Unix(1700000000, 0),
+		Private:      true,
+		Source:       "example",
+	}
+	data, tf := createAndOpen(t, path, opts)
+	assert.Equal(t, "http://a.example/announce", tf.Announce)
+	assert.Equal(t, opts.AnnounceList, tf.AnnounceList)
+	assert.Equal(t, opts.WebSeeds, tf.WebSeeds)
+
+	var decoded map[string]any
+	require.NoError(t, bencode.Unmarshal(data, &decoded))
+	assert.Equal(t, "release notes", decoded["comment"])
+	assert.Equal(t, "test", decoded["created by"])
+	assert.EqualValues(t, 1700000000, decoded["creation date"])
+	info := decoded["info"].(map[string]any)
+	assert.EqualValues(t, 1, info["private"])
+	assert.Equal(t, "example", info["source"])
+
+	// The encoding is canonical, so decoding and encoding it again is lossless
+	again, err := bencode.Marshal(decoded)
+	require.NoError(t, err)
+	assert.Equal(t, data, again)
+
+	// The source alone changes the info hash
+	opts.Source = "elsewhere"
+	_, other := createAndOpen(t, path, opts)
+	assert.NotEqual(t, tf.InfoHash, other.InfoHash)
+}
+
+func TestCreateErrors(t *testing.T) {
+	dir := t.TempDir()
+	_, err := Create(dir, CreateOptions{})
+	assert.ErrorIs(t, err, ErrNoData)
+
+	path := filepath.Join(dir, "file")
+	writeFile(t, path, 10)
+	_, err = Create(path, CreateOptions{PieceLength: 3 * MinPieceLength})
+	assert.Error(t, err)
+	_, err = Create(path, CreateOptions{PieceLength: MinPieceLength / 2})
+	assert.Error(t, err)
+	_, err = Create(filepath.Join(dir, "missing"), CreateOptions{})
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestCreateRejectsBadPathComponents(t *testing.T) {
+	tests := map[string]string{
+		"backslash": `a\b`,
+		"not UTF-8": "a\xffb",
+	}
+	for name, file := range tests {
+		t.Run(name, func(t *testing.T) {
+			root := filepath.Join(t.TempDir(), "data")
+			writeFile(t, filepath.Join(root, "sub", file), 10)
+			_, err := Create(root, CreateOptions{})
+			assert.ErrorContains(t, err, "invalid path")
+		})
+	}
+
+	// Names Open accepts survive the round trip
+	root := filepath.Join(t.TempDir(), "data")
+	writeFile(t, filepath.Join(root, "sub", "naïve file.txt"), 10)
+	_, tf := createAndOpen(t, root, CreateOptions{})
+	require.Len(t, tf.Files, 1)
+	assert.Equal(t, []string{"data", "sub", "naïve file.txt"}, tf.Files[0].Path)
+}
+
+func TestChoosePieceLength(t *testing.T) {
+	assert.Equal(t, MinPieceLength, choosePieceLength(1))
+	assert.Equal(t, MinPieceLength, choosePieceLength(targetPieces*MinPieceLength))
+	assert.Equal(t, 2*MinPieceLength, choosePieceLength(targetPieces*MinPieceLength+MinPieceLength))
+	assert.Equal(t, 512<<10, choosePieceLength(700<<20))
+	assert.Equal(t, maxAutoPieceLength, choosePieceLength(1<<40))
+}
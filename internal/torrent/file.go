@@ -2,51 +2,139 @@ package torrent
 
 import (
 	"fmt"
-	"github.com/jackpal/bencode-go"
 	"os"
+
+	"Torrentasaurus_Rex/internal/bencode"
+	"Torrentasaurus_Rex/internal/merkle"
 )
 
 type TorrentFile struct {
-	Announce    string
-	InfoHash    [20]byte
-	PieceHashes [][20]byte
-	PieceLength int
-	Length      int
-	Name        string
+	Announce string
+	// AnnounceList holds tiers of tracker URLs (BEP 12)
+	AnnounceList [][]string
+	InfoHash     [20]byte
+	PieceHashes  [][20]byte
+	PieceLength  int
+	Length       int
+	Name         string
+	Files        []FileEntry
+	// WebSeeds holds the URLs of HTTP mirrors of the data (BEP 19)
+	WebSeeds []string
+	// Private torrents only get peers from their trackers (BEP 27)
+	Private bool
+
+	// MetaVersion is 2 for v2 and hybrid torrents (BEP 52). InfoHash of a
+	// v2-only torrent is InfoHashV2 truncated to 20 bytes, as it appears in
+	// handshakes and tracker requests.
+	MetaVersion int
+	// InfoHashV2 is the SHA-256 hash of the info dictionary of a v2 torrent
+	InfoHashV2 [32]byte
+	// PiecesV2 tells how to verify the pieces against the hash trees of
+	// their files. Hybrid torrents opened without piece layers only have
+	// PieceHashes.
+	PiecesV2 []merkle.Piece
 }
 
 func Open(path string) (TorrentFile, error) {
-	file, err := os.Open(path)
+	data, err := os.ReadFile(path)
 	if err != nil {
 		return TorrentFile{}, fmt.Errorf("failed to open file: %w", err)
 	}
-	defer file.Close()
 
 	btf := &BencodeTorrentFile{}
-	if err := bencode.Unmarshal(file, &btf); err != nil {
+	if err := bencode.Unmarshal(data, btf); err != nil {
 		return TorrentFile{}, fmt.Errorf("failed to unmarshal bencode: %w", err)
 	}
+	btf.rawInfo, err = bencode.DictValue(data, "info")
+	if err != nil {
+		return TorrentFile{}, fmt.Errorf("failed to locate info dictionary: %w", err)
+	}
 
 	return btf.toTorrentFile()
 }
 
 func (btf *BencodeTorrentFile) toTorrentFile() (TorrentFile, error) {
-	infoHash, err := btf.Info.hash()
+	infoHash, err := btf.infoHash()
 	if err != nil {
 		return TorrentFile{}, fmt.Errorf("failed to hash info: %w", err)
 	}
 
-	pieceHashes, err := btf.Info.splitPieceHashes()
-	if err != nil {
-		return TorrentFile{}, fmt.Errorf("failed to split piece hashes: %w", err)
+	tf := TorrentFile{
+		Announce:     btf.Announce,
+		AnnounceList: btf.announceTiers(),
+		InfoHash:     infoHash,
+		PieceLength:  btf.Info.PieceLength,
+		Name:         btf.Info.Name,
+		WebSeeds:     btf.webSeeds(),
+		Private:      btf.Info.Private != 0,
+		MetaVersion:  btf.Info.MetaVersion,
+	}
+	// Pieces are located by dividing by the piece length
+	if tf.PieceLength <= 0 {
+		return TorrentFile{}, fmt.Errorf("invalid piece length %d", tf.PieceLength)
+	}
+	if btf.Info.MetaVersion > 1 {
+		if err := btf.readV2(&tf); err != nil {
+			return TorrentFile{}, fmt.Errorf("failed to read v2 metadata: %w", err)
+		}
+	} else {
+		tf.PieceHashes, err = btf.Info.splitPieceHashes()
+		if err != nil {
+			return TorrentFile{}, fmt.Errorf("failed to split piece hashes: %w", err)
+		}
+		tf.Files, err = btf.Info.fileEntries()
+		if err != nil {
+			return TorrentFile{}, fmt.Errorf("failed to read files: %w", err)
+		}
+	}
+	for _, f := range tf.Files {
+		tf.Length += f.Length
+	}
+	// Every piece of the data needs a hash, and no hash may point past it
+	if len(tf.PieceHashes) > 0 || tf.MetaVersion <= 1 {
+		numPieces := (tf.Length + tf.PieceLength - 1) / tf.PieceLength
+		if len(tf.PieceHashes) != numPieces {
+			return TorrentFile{}, fmt.Errorf("%d piece hashes for %d bytes in pieces of %d", len(tf.PieceHashes), tf.Length, tf.PieceLength)
+		}
+	}
+	return tf, nil
+}
+
+// Hybrid tells whether the torrent has both v1 and v2 metadata, so it is
+// shared in the swarms of both info hashes
+func (tf *TorrentFile) Hybrid() bool {
+	return tf.MetaVersion == 2 && len(tf.PieceHashes) > 0
+}
+
+// InfoHashes returns the info hashes peers may use for the torrent: the
+// SHA-1 info hash, and for a hybrid torrent the truncated SHA-256 one too
+func (tf *TorrentFile) InfoHashes() [][20]byte {
+	hashes := [][20]byte{tf.InfoHash}
+	if tf.Hybrid() {
+		var v2 [20]byte
+		copy(v2[:], tf.InfoHashV2[:])
+		hashes = append(hashes, v2)
+	}
+	return hashes
+}
+
+// NumPieces returns the number of pieces of the torrent
+func (tf *TorrentFile) NumPieces() int {
+	if len(tf.PieceHashes) > 0 {
+		return len(tf.PieceHashes)
 	}
+	return len(tf.PiecesV2)
+}
 
-	return TorrentFile{
-		Announce:    btf.Announce,
-		InfoHash:    infoHash,
-		PieceHashes: pieceHashes,
-		PieceLength: btf.Info.PieceLength,
-		Length:      btf.Info.Length,
-		Name:        btf.Info.Name,
-	}, nil
+// Tiers returns the tracker tiers to announce to. The announce-list takes
+// precedence; without one the single announce URL forms the only tier.
+func (tf *TorrentFile) Tiers() [][]string {
+	var tiers [][]string
+	for _, tier := range tf.AnnounceList {
+		tiers = append(tiers, append([]string(nil), tier...))
+	}
+	if len(tiers) == 0 && tf.Announce != "" {
+		tiers = [][]string{{tf.Announce}}
+	}
+	return tiers
 }
@@ -1,10 +1,12 @@
 package torrent
 
 import (
+	"crypto/sha1"
 	"encoding/json"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -23,3 +25,74 @@ func TestOpen(t *testing.T) {
 
 	assert.Equal(t, expected, torrent)
 }
+
+func TestToTorrentFileMultiFile(t *testing.T) {
+	btf := BencodeTorrentFile{
+		Announce: "http://example.com/announce",
+		Info: bencodeInfo{
+			Pieces:      "12345678901234567890",
+			PieceLength: 262144,
+			Name:        "album",
+			Files: []bencodeFile{
+				{Length: 100, Path: []string{"a.flac"}},
+				{Length: 50, Path: []string{"b.flac"}},
+			},
+		},
+		rawInfo: []byte("d5:filesld6:lengthi100e4:pathl6:a.flaceed6:lengthi50e4:pathl6:b.flaceee4:name5:album12:piece lengthi262144e6:pieces20:12345678901234567890e"),
+	}
+
+	tf, err := btf.toTorrentFile()
+	require.NoError(t, err)
+	assert.Equal(t, 150, tf.Length)
+	assert.Len(t, tf.Files, 2)
+	assert.Equal(t, []string{"album", "b.flac"}, tf.Files[1].Path)
+	assert.False(t, tf.Private)
+}
+
+func TestOpenHashesUnmodeledInfoKeys(t *testing.T) {
+	info := "d6:lengthi5e6:md5sum32:00000000000000000000000000000000" +
+		"4:name4:file12:piece lengthi16384e6:pieces20:123456789012345678907:privatei1e6:source3:abce"
+	path := filepath.Join(t.TempDir(), "private.torrent")
+	require.NoError(t, os.WriteFile(path, []byte("d8:announce3:url4:info"+info+"e"), 0o644))
+
+	tf, err := Open(path)
+	require.NoError(t, err)
+	assert.Equal(t, sha1.Sum([]byte(info)), tf.InfoHash)
+	assert.Equal(t, 5, tf.Length)
+	assert.True(t, tf.Private)
+}
+
+func TestToTorrentFileRejectsBadPieces(t *testing.T) {
+	tests := map[string]struct {
+		pieceLength int
+		length      int
+		pieces      string
+		err         string
+	}{
+		"zero piece length":     {0, 5, "12345678901234567890", "invalid piece length 0"},
+		"negative piece length": {-1, 5, "12345678901234567890", "invalid piece length -1"},
+		"missing hash":          {16384, 16385, "12345678901234567890", "1 piece hashes for 16385 bytes"},
+		"extra hash":            {16384, 5, "1234567890123456789012345678901234567890", "2 piece hashes for 5 bytes"},
+		"no hashes":             {16384, 5, "", "0 piece hashes for 5 bytes"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			btf := BencodeTorrentFile{
+				Info:    bencodeInfo{Pieces: tt.pieces, PieceLength: tt.pieceLength, Length: tt.length, Name: "file"},
+				rawInfo: []byte("d4:name4:filee"),
+			}
+			_, err := btf.toTorrentFile()
+			assert.ErrorContains(t, err, tt.err)
+		})
+	}
+}
+
+func TestTiers(t *testing.T) {
+	tf := TorrentFile{Announce: "http://a/announce"}
+	assert.Equal(t, [][]string{{"http://a/announce"}}, tf.Tiers())
+
+	tf.AnnounceList = [][]string{{"udp://b", "udp://c"}, {"http://d"}}
+	assert.Equal(t, [][]string{{"udp://b", "udp://c"}, {"http://d"}}, tf.Tiers())
+
+	assert.Nil(t, (&TorrentFile{}).Tiers())
+}
@@ -0,0 +1,86 @@
+package torrent
+
+import (
+	"path/filepath"
+	"sort"
+)
+
+// FileEntry describes a single file of a torrent
+type FileEntry struct {
+	// Path holds the path components relative to the download directory.
+	// Files of a multi-file torrent are nested in a directory named after the torrent.
+	Path []string
+	// Length is the size of the file in bytes
+	Length int
+	// Offset is the position of the first byte of the file within the torrent data
+	Offset int
+	// PiecesRoot is the root of the hash tree of the file in a v2 torrent
+	PiecesRoot [32]byte
+	// Padding marks filler between files that aligns the next file to a
+	// piece boundary. It holds zeros and isn't stored on disk.
+	Padding bool
+}
+
+// FileSpan is the part of a byte range of the torrent data that falls within a single file
+type FileSpan struct {
+	// File is the index of the file in TorrentFile.Files
+	File int
+	// Offset is the position of the span within the file
+	Offset int
+	// Length is the number of bytes in the span
+	Length int
+}
+
+// LocalPath returns the location of the file under a download directory
+func (f FileEntry) LocalPath(root string) string {
+	return filepath.Join(append([]string{root}, f.Path...)...)
+}
+
+// PieceBounds returns the byte range of a piece within the torrent data. A
+// piece of a v2-only torrent ends with its file, before any padding.
+func (tf *TorrentFile) PieceBounds(index int) (begin int, end int) {
+	begin = index * tf.PieceLength
+	end = begin + tf.PieceLength
+	if len(tf.PieceHashes) == 0 && index < len(tf.PiecesV2) {
+		end = begin + tf.PiecesV2[index].Length
+	}
+	if end > tf.Length {
+		end = tf.Length
+	}
+	return begin, end
+}
+
+// PieceSpans maps a piece onto the files it is stored in
+func (tf *TorrentFile) PieceSpans(index int) []FileSpan {
+	begin, end := tf.PieceBounds(index)
+	return FileSpans(tf.Files, begin, end-begin)
+}
+
+// FileSpans maps a byte range of the torrent data onto the files laid out
+// back to back. Spans are returned in order and empty files are skipped.
+func FileSpans(files []FileEntry, offset, length int) []FileSpan {
+	// Find the last file that starts at or before the offset
+	first := sort.Search(len(files), func(i int) bool {
+		return files[i].Offset > offset
+	}) - 1
+	if first < 0 {
+		first = 0
+	}
+
+	var spans []FileSpan
+	end := offset + length
+	for i := first; i < len(files) && offset < end; i++ {
+		f := files[i]
+		fileEnd := f.Offset + f.Length
+		if fileEnd <= offset {
+			continue
+		}
+		n := fileEnd - offset
+		if n > end-offset {
+			n = end - offset
+		}
+		spans = append(spans, FileSpan{File: i, Offset: offset - f.Offset, Length: n})
+		offset += n
+	}
+	return spans
+}
@@ -0,0 +1,67 @@
+package torrent
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func multiFileTorrent() *TorrentFile {
+	return &TorrentFile{
+		Name:        "album",
+		PieceLength: 10,
+		Length:      35,
+		Files: []FileEntry{
+			{Path: []string{"album", "a.flac"}, Length: 12, Offset: 0},
+			{Path: []string{"album", "empty"}, Length: 0, Offset: 12},
+			{Path: []string{"album", "b.flac"}, Length: 5, Offset: 12},
+			{Path: []string{"album", "cover", "c.jpg"}, Length: 18, Offset: 17},
+		},
+	}
+}
+
+func TestPieceBounds(t *testing.T) {
+	tf := multiFileTorrent()
+	begin, end := tf.PieceBounds(1)
+	assert.Equal(t, 10, begin)
+	assert.Equal(t, 20, end)
+
+	begin, end = tf.PieceBounds(3)
+	assert.Equal(t, 30, begin)
+	assert.Equal(t, 35, end)
+}
+
+func TestPieceSpans(t *testing.T) {
+	tf := multiFileTorrent()
+	tests := []struct {
+		name     string
+		index    int
+		expected []FileSpan
+	}{
+		{"Within first file", 0, []FileSpan{{File: 0, Offset: 0, Length: 10}}},
+		{"Across three files", 1, []FileSpan{
+			{File: 0, Offset: 10, Length: 2},
+			{File: 2, Offset: 0, Length: 5},
+			{File: 3, Offset: 0, Length: 3},
+		}},
+		{"Last partial piece", 3, []FileSpan{{File: 3, Offset: 13, Length: 5}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tf.PieceSpans(tt.index))
+		})
+	}
+}
+
+func TestFileSpansSingleFile(t *testing.T) {
+	files := []FileEntry{{Path: []string{"file.iso"}, Length: 100}}
+	assert.Equal(t, []FileSpan{{File: 0, Offset: 40, Length: 20}}, FileSpans(files, 40, 20))
+	assert.Nil(t, FileSpans(files, 100, 0))
+}
+
+func TestFileEntryLocalPath(t *testing.T) {
+	entry := FileEntry{Path: []string{"album", "cover", "c.jpg"}}
+	assert.Equal(t, filepath.Join("downloads", "album", "cover", "c.jpg"), entry.LocalPath("downloads"))
+}
@@ -0,0 +1,153 @@
+package torrent
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"Torrentasaurus_Rex/internal/bencode"
+)
+
+// ErrInfoHashMismatch is returned for metadata that doesn't hash to the info
+// hash of a magnet link
+var ErrInfoHashMismatch = errors.New("metadata does not match info hash")
+
+// Magnet holds the fields of a magnet URI
+type Magnet struct {
+	InfoHash [20]byte
+	// Name is the display name suggested by the link, if any
+	Name string
+	// Trackers are the tracker URLs from the tr parameters
+	Trackers []string
+	// Peers are host:port addresses from the x.pe parameters
+	Peers []string
+}
+
+// ParseMagnet parses a magnet URI. The info hash may be given in hex or in
+// base32.
+func ParseMagnet(uri string) (Magnet, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return Magnet{}, fmt.Errorf("invalid magnet URI: %w", err)
+	}
+	if u.Scheme != "magnet" {
+		return Magnet{}, fmt.Errorf("invalid magnet URI: unexpected scheme %q", u.Scheme)
+	}
+	query, err := url.ParseQuery(u.RawQuery)
+	if err != nil {
+		return Magnet{}, fmt.Errorf("invalid magnet URI: %w", err)
+	}
+
+	var m Magnet
+	found := false
+	for _, xt := range query["xt"] {
+		encoded, ok := strings.CutPrefix(xt, "urn:btih:")
+		if !ok {
+			continue
+		}
+		if m.InfoHash, err = decodeInfoHash(encoded); err != nil {
+			return Magnet{}, err
+		}
+		found = true
+		break
+	}
+	if !found {
+		return Magnet{}, fmt.Errorf("invalid magnet URI: no urn:btih exact topic")
+	}
+
+	m.Name = query.Get("dn")
+	for _, tr := range query["tr"] {
+		if tr != "" {
+			m.Trackers = append(m.Trackers, tr)
+		}
+	}
+	for _, pe := range query["x.pe"] {
+		if pe != "" {
+			m.Peers = append(m.Peers, pe)
+		}
+	}
+	return m, nil
+}
+
+// decodeInfoHash decodes a 40 character hex or 32 character base32 info hash
+func decodeInfoHash(encoded string) ([20]byte, error) {
+	var hash [20]byte
+	var decoded []byte
+	var err error
+	switch len(encoded) {
+	case 40:
+		decoded, err = hex.DecodeString(encoded)
+	case 32:
+		decoded, err = base32.StdEncoding.DecodeString(strings.ToUpper(encoded))
+	default:
+		return hash, fmt.Errorf("invalid info hash %q: unexpected length %d", encoded, len(encoded))
+	}
+	if err != nil {
+		return hash, fmt.Errorf("invalid info hash %q: %w", encoded, err)
+	}
+	copy(hash[:], decoded)
+	return hash, nil
+}
+
+// Tiers returns the trackers of the link, each in a tier of its own
+func (m *Magnet) Tiers() [][]string {
+	var tiers [][]string
+	for _, tr := range m.Trackers {
+		tiers = append(tiers, []string{tr})
+	}
+	return tiers
+}
+
+// TorrentFile builds the torrent the link points to from the info
+// dictionary fetched from peers
+func (m *Magnet) TorrentFile(rawInfo []byte) (TorrentFile, error) {
+	btf, err := m.bencodeTorrentFile(rawInfo)
+	if err != nil {
+		return TorrentFile{}, err
+	}
+	return btf.toTorrentFile()
+}
+
+// MarshalTorrent encodes a .torrent file for the link with the info
+// dictionary fetched from peers. The dictionary is stored unchanged so the
+// file has the same info hash.
+func (m *Magnet) MarshalTorrent(rawInfo []byte) ([]byte, error) {
+	btf, err := m.bencodeTorrentFile(rawInfo)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	err = bencode.NewEncoder(&buf).Encode(struct {
+		Announce     string             `bencode:"announce,omitempty"`
+		AnnounceList [][]string         `bencode:"announce-list,omitempty"`
+		Info         bencode.RawMessage `bencode:"info"`
+	}{btf.Announce, btf.AnnounceList, btf.rawInfo})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// bencodeTorrentFile checks the info dictionary against the info hash and
+// combines it with the trackers of the link
+func (m *Magnet) bencodeTorrentFile(rawInfo []byte) (*BencodeTorrentFile, error) {
+	if sha1.Sum(rawInfo) != m.InfoHash {
+		return nil, ErrInfoHashMismatch
+	}
+	btf := &BencodeTorrentFile{
+		AnnounceList: m.Tiers(),
+		rawInfo:      bencode.RawMessage(rawInfo),
+	}
+	if len(m.Trackers) > 0 {
+		btf.Announce = m.Trackers[0]
+	}
+	if err := bencode.Unmarshal(rawInfo, &btf.Info); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal info: %w", err)
+	}
+	return btf, nil
+}
@@ -0,0 +1,78 @@
+package torrent
+
+import (
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const magnetInfo = "d6:lengthi5e4:name4:file12:piece lengthi16384e6:pieces20:12345678901234567890e"
+
+func TestParseMagnet(t *testing.T) {
+	hash := sha1.Sum([]byte(magnetInfo))
+	b32 := base32.StdEncoding.EncodeToString(hash[:])
+
+	tests := map[string]struct {
+		uri   string
+		fails bool
+	}{
+		"hex":              {uri: "magnet:?xt=urn:btih:" + hex.EncodeToString(hash[:]) + "&dn=file&tr=http%3A%2F%2Fa%2Fannounce&tr=udp%3A%2F%2Fb%3A80&x.pe=10.0.0.1%3A6881"},
+		"base32":           {uri: "magnet:?xt=urn:btih:" + b32 + "&dn=file&tr=http%3A%2F%2Fa%2Fannounce&tr=udp%3A%2F%2Fb%3A80&x.pe=10.0.0.1%3A6881"},
+		"lowercase base32": {uri: "magnet:?dn=file&xt=urn:btih:" + strings.ToLower(b32) + "&tr=http%3A%2F%2Fa%2Fannounce&tr=udp%3A%2F%2Fb%3A80&x.pe=10.0.0.1%3A6881"},
+		"short hash":       {uri: "magnet:?xt=urn:btih:" + b32[:16], fails: true},
+		"wrong scheme":     {uri: "http://example.com/?xt=urn:btih:" + hex.EncodeToString(hash[:]), fails: true},
+		"missing topic":    {uri: "magnet:?dn=file", fails: true},
+		"bad hex":          {uri: "magnet:?xt=urn:btih:" + "zz" + hex.EncodeToString(hash[:])[2:], fails: true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			m, err := ParseMagnet(test.uri)
+			if test.fails {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, hash, m.InfoHash)
+			assert.Equal(t, "file", m.Name)
+			assert.Equal(t, []string{"http://a/announce", "udp://b:80"}, m.Trackers)
+			assert.Equal(t, []string{"10.0.0.1:6881"}, m.Peers)
+			assert.Equal(t, [][]string{{"http://a/announce"}, {"udp://b:80"}}, m.Tiers())
+		})
+	}
+}
+
+func TestMagnetTorrentFile(t *testing.T) {
+	m := Magnet{InfoHash: sha1.Sum([]byte(magnetInfo)), Trackers: []string{"http://a/announce"}}
+
+	tf, err := m.TorrentFile([]byte(magnetInfo))
+	require.NoError(t, err)
+	assert.Equal(t, m.InfoHash, tf.InfoHash)
+	assert.Equal(t, "file", tf.Name)
+	assert.Equal(t, 5, tf.Length)
+	assert.Equal(t, "http://a/announce", tf.Announce)
+
+	_, err = m.TorrentFile([]byte("d4:name5:othere"))
+	assert.ErrorIs(t, err, ErrInfoHashMismatch)
+}
+
+func TestMagnetMarshalTorrent(t *testing.T) {
+	m := Magnet{InfoHash: sha1.Sum([]byte(magnetInfo)), Trackers: []string{"http://a/announce", "http://b/announce"}}
+
+	data, err := m.MarshalTorrent([]byte(magnetInfo))
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "file.torrent")
+	require.NoError(t, os.WriteFile(path, data, 0o644))
+
+	tf, err := Open(path)
+	require.NoError(t, err)
+	assert.Equal(t, m.InfoHash, tf.InfoHash)
+	assert.Equal(t, [][]string{{"http://a/announce"}, {"http://b/announce"}}, tf.Tiers())
+}
@@ -0,0 +1,228 @@
+package torrent
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"sort"
+	"strconv"
+
+	"Torrentasaurus_Rex/internal/merkle"
+)
+
+// errNoPieceLayer is returned when the piece layer of a file is missing,
+// which is expected from metadata fetched from peers since piece layers
+// aren't part of the info dictionary
+var errNoPieceLayer = errors.New("missing piece layer")
+
+// readV2 fills in the files and pieces of a v2 or hybrid torrent (BEP 52).
+// A hybrid torrent keeps its SHA-1 info hash and piece hashes, and the
+// files listed for v1 must match the file tree.
+func (btf *BencodeTorrentFile) readV2(tf *TorrentFile) error {
+	if btf.Info.MetaVersion != 2 {
+		return fmt.Errorf("unsupported meta version %d", btf.Info.MetaVersion)
+	}
+	pieceLength := btf.Info.PieceLength
+	if pieceLength < merkle.BlockSize || pieceLength&(pieceLength-1) != 0 {
+		return fmt.Errorf("invalid piece length %d: must be a power of two of at least %d", pieceLength, merkle.BlockSize)
+	}
+	tf.InfoHashV2 = sha256.Sum256(btf.rawInfo)
+
+	tree, err := btf.Info.treeEntries()
+	if err != nil {
+		return err
+	}
+	hybrid := btf.Info.Pieces != ""
+	if hybrid {
+		if tf.PieceHashes, err = btf.Info.splitPieceHashes(); err != nil {
+			return fmt.Errorf("failed to split piece hashes: %w", err)
+		}
+		if tf.Files, err = btf.Info.fileEntries(); err != nil {
+			return err
+		}
+		if err := matchTree(tf.Files, tree); err != nil {
+			return err
+		}
+	} else {
+		copy(tf.InfoHash[:], tf.InfoHashV2[:])
+		tf.Files = layoutV2(btf.Info.Name, tree, pieceLength)
+	}
+
+	tf.PiecesV2, err = btf.piecesV2(tf.Files, pieceLength)
+	switch {
+	case hybrid && errors.Is(err, errNoPieceLayer):
+		// The SHA-1 piece hashes are enough to verify the data
+		tf.PiecesV2 = nil
+	case err != nil:
+		return err
+	case hybrid && len(tf.PiecesV2) != len(tf.PieceHashes):
+		return fmt.Errorf("%d v2 pieces but %d piece hashes", len(tf.PiecesV2), len(tf.PieceHashes))
+	}
+	return nil
+}
+
+// treeEntries lists the files of the file tree in the order of the torrent
+// data, without offsets. Paths are built like those of v1 torrents: a single
+// file at the top of the tree is named after the torrent, other files are
+// nested in a directory named after it.
+func (i *bencodeInfo) treeEntries() ([]FileEntry, error) {
+	if err := validatePathComponent(i.Name); err != nil {
+		return nil, fmt.Errorf("invalid name: %w", err)
+	}
+	var entries []FileEntry
+	if err := walkFileTree(i.FileTree, nil, &entries); err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("empty file tree")
+	}
+	for n := range entries {
+		if len(entries) == 1 && len(entries[n].Path) == 1 {
+			entries[n].Path = []string{i.Name}
+		} else {
+			entries[n].Path = append([]string{i.Name}, entries[n].Path...)
+		}
+	}
+	return entries, nil
+}
+
+// walkFileTree appends the files below a directory of the file tree in key
+// order. A file is a dictionary holding a single empty key that maps to its
+// length and pieces root.
+func walkFileTree(dir map[string]any, parents []string, entries *[]FileEntry) error {
+	keys := make([]string, 0, len(dir))
+	for k := range dir {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		p := append(slices.Clip(parents), k)
+		if err := validatePathComponent(k); err != nil {
+			return fmt.Errorf("invalid path %q: %w", path.Join(p...), err)
+		}
+		node, ok := dir[k].(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s is not a dictionary", path.Join(p...))
+		}
+		leaf, ok := node[""]
+		if !ok {
+			if err := walkFileTree(node, p, entries); err != nil {
+				return err
+			}
+			continue
+		}
+		file, ok := leaf.(map[string]any)
+		if !ok || len(node) != 1 {
+			return fmt.Errorf("malformed file %s", path.Join(p...))
+		}
+		length, ok := file["length"].(int64)
+		if !ok || length < 0 {
+			return fmt.Errorf("file %s has an invalid length", path.Join(p...))
+		}
+		entry := FileEntry{Path: p, Length: int(length)}
+		if length > 0 {
+			root, ok := file["pieces root"].(string)
+			if !ok || len(root) != len(entry.PiecesRoot) {
+				return fmt.Errorf("file %s has an invalid pieces root", path.Join(p...))
+			}
+			copy(entry.PiecesRoot[:], root)
+		}
+		*entries = append(*entries, entry)
+	}
+	return nil
+}
+
+// matchTree checks that the files of a hybrid torrent other than padding
+// are those of the file tree and copies their pieces roots
+func matchTree(files, tree []FileEntry) error {
+	n := 0
+	for i := range files {
+		if files[i].Padding {
+			continue
+		}
+		if n == len(tree) || !slices.Equal(files[i].Path, tree[n].Path) || files[i].Length != tree[n].Length {
+			return fmt.Errorf("file %s doesn't match the file tree", path.Join(files[i].Path...))
+		}
+		files[i].PiecesRoot = tree[n].PiecesRoot
+		n++
+	}
+	if n != len(tree) {
+		return fmt.Errorf("%d files missing from the file list", len(tree)-n)
+	}
+	return nil
+}
+
+// layoutV2 lays the files of a v2 torrent out back to back, inserting
+// padding so that every file starts on a piece boundary
+func layoutV2(name string, tree []FileEntry, pieceLength int) []FileEntry {
+	files := make([]FileEntry, 0, len(tree))
+	offset := 0
+	for _, f := range tree {
+		if gap := (pieceLength - offset%pieceLength) % pieceLength; gap > 0 && f.Length > 0 {
+			files = append(files, FileEntry{
+				Path:    []string{name, ".pad", strconv.Itoa(gap)},
+				Length:  gap,
+				Offset:  offset,
+				Padding: true,
+			})
+			offset += gap
+		}
+		f.Offset = offset
+		files = append(files, f)
+		offset += f.Length
+	}
+	return files
+}
+
+// piecesV2 checks the piece layers against the pieces roots of the files
+// and lists the pieces of the torrent. Every piece lies within a file.
+func (btf *BencodeTorrentFile) piecesV2(files []FileEntry, pieceLength int) ([]merkle.Piece, error) {
+	var pieces []merkle.Piece
+	leaves := pieceLength / merkle.BlockSize
+	for _, f := range files {
+		if f.Padding || f.Length == 0 {
+			continue
+		}
+		name := path.Join(f.Path...)
+		if f.Offset != len(pieces)*pieceLength {
+			return nil, fmt.Errorf("file %s doesn't start on a piece boundary", name)
+		}
+		if f.Length <= pieceLength {
+			pieces = append(pieces, merkle.Piece{
+				Root:   f.PiecesRoot,
+				Hash:   f.PiecesRoot,
+				Leaves: merkle.NumLeaves(f.Length),
+				Length: f.Length,
+			})
+			continue
+		}
+
+		layer, ok := btf.PieceLayers[string(f.PiecesRoot[:])]
+		if !ok {
+			return nil, fmt.Errorf("%w of file %s", errNoPieceLayer, name)
+		}
+		numPieces := (f.Length + pieceLength - 1) / pieceLength
+		if len(layer) != numPieces*merkle.HashSize {
+			return nil, fmt.Errorf("piece layer of file %s has length %d, expected %d", name, len(layer), numPieces*merkle.HashSize)
+		}
+		hashes := make([][merkle.HashSize]byte, numPieces)
+		for i := range hashes {
+			copy(hashes[i][:], layer[i*merkle.HashSize:])
+		}
+		if merkle.PieceLayerRoot(hashes, pieceLength) != f.PiecesRoot {
+			return nil, fmt.Errorf("piece layer of file %s doesn't match its pieces root", name)
+		}
+		for i, h := range hashes {
+			pieces = append(pieces, merkle.Piece{
+				Root:   f.PiecesRoot,
+				Hash:   h,
+				Block:  i * leaves,
+				Leaves: leaves,
+				Length: min(pieceLength, f.Length-i*pieceLength),
+			})
+		}
+	}
+	return pieces, nil
+}
@@ -0,0 +1,219 @@
+package torrent
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"Torrentasaurus_Rex/internal/bencode"
+	"Torrentasaurus_Rex/internal/merkle"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// v2File is a file of a v2 torrent built by v2Metainfo
+type v2File struct {
+	path []string
+	data []byte
+}
+
+// v2Metainfo encodes a v2 torrent of files with pieces of pieceLength. With
+// hybrid set, the v1 keys are added too, with padding files between files.
+func v2Metainfo(t *testing.T, name string, files []v2File, pieceLength int, hybrid bool) []byte {
+	t.Helper()
+	tree := map[string]any{}
+	layers := map[string]any{}
+	var v1Files []any
+	var v1Data []byte
+	for _, f := range files {
+		root := merkle.Root(f.data)
+		leaf := map[string]any{"length": len(f.data)}
+		if len(f.data) > 0 {
+			leaf["pieces root"] = string(root[:])
+		}
+		dir := tree
+		for _, c := range f.path[:len(f.path)-1] {
+			if dir[c] == nil {
+				dir[c] = map[string]any{}
+			}
+			dir = dir[c].(map[string]any)
+		}
+		dir[f.path[len(f.path)-1]] = map[string]any{"": leaf}
+
+		if len(f.data) > pieceLength {
+			var layer []byte
+			for begin := 0; begin < len(f.data); begin += pieceLength {
+				blocks := merkle.BlockHashes(f.data[begin:min(begin+pieceLength, len(f.data))])
+				h := merkle.RootOf(blocks, 0, pieceLength/merkle.BlockSize)
+				layer = append(layer, h[:]...)
+			}
+			layers[string(root[:])] = string(layer)
+		}
+
+		if gap := (pieceLength - len(v1Data)%pieceLength) % pieceLength; gap > 0 && len(f.data) > 0 {
+			v1Files = append(v1Files, map[string]any{"length": gap, "path": []string{".pad", "x"}, "attr": "p"})
+			v1Data = append(v1Data, make([]byte, gap)...)
+		}
+		v1Files = append(v1Files, map[string]any{"length": len(f.data), "path": f.path})
+		v1Data = append(v1Data, f.data...)
+	}
+
+	info := map[string]any{
+		"name":         name,
+		"piece length": pieceLength,
+		"meta version": 2,
+		"file tree":    tree,
+	}
+	if hybrid {
+		var pieces []byte
+		for _, h := range pieceHashes(v1Data, pieceLength) {
+			pieces = append(pieces, h[:]...)
+		}
+		info["pieces"] = string(pieces)
+		if len(files) == 1 && len(files[0].path) == 1 {
+			info["length"] = len(v1Data)
+		} else {
+			info["files"] = v1Files
+		}
+	}
+	data, err := bencode.Marshal(map[string]any{"info": info, "piece layers": layers})
+	require.NoError(t, err)
+	return data
+}
+
+func openData(t *testing.T, data []byte) (TorrentFile, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "v2.torrent")
+	require.NoError(t, os.WriteFile(path, data, 0o644))
+	return Open(path)
+}
+
+func v2TestData(size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i*7 + i/253)
+	}
+	return data
+}
+
+func TestOpenV2(t *testing.T) {
+	pieceLength := 2 * merkle.BlockSize
+	files := []v2File{
+		{[]string{"b.bin"}, v2TestData(2*pieceLength + 100)},
+		{[]string{"a", "empty"}, nil},
+		{[]string{"a", "small"}, v2TestData(10)},
+	}
+	data := v2Metainfo(t, "set", files, pieceLength, false)
+	tf, err := openData(t, data)
+	require.NoError(t, err)
+
+	rawInfo, err := bencode.DictValue(data, "info")
+	require.NoError(t, err)
+	v2Hash := sha256.Sum256(rawInfo)
+	assert.Equal(t, 2, tf.MetaVersion)
+	assert.Equal(t, v2Hash, tf.InfoHashV2)
+	assert.Equal(t, v2Hash[:20], tf.InfoHash[:])
+	assert.False(t, tf.Hybrid())
+	assert.Empty(t, tf.PieceHashes)
+
+	// Files are in key order, each starting on a piece boundary
+	small, big := files[2].data, files[0].data
+	assert.Equal(t, []FileEntry{
+		{Path: []string{"set", "a", "empty"}},
+		{Path: []string{"set", "a", "small"}, Length: 10, PiecesRoot: merkle.Root(small)},
+		{Path: []string{"set", ".pad", "32758"}, Length: pieceLength - 10, Offset: 10, Padding: true},
+		{Path: []string{"set", "b.bin"}, Length: len(big), Offset: pieceLength, PiecesRoot: merkle.Root(big)},
+	}, tf.Files)
+	assert.Equal(t, pieceLength+len(big), tf.Length)
+
+	require.Equal(t, 4, tf.NumPieces())
+	assert.True(t, tf.PiecesV2[0].Verify(small))
+	for i := 1; i < 4; i++ {
+		begin, end := tf.PieceBounds(i)
+		assert.True(t, tf.PiecesV2[i].Verify(big[begin-pieceLength:end-pieceLength]), "piece %d", i)
+	}
+	begin, end := tf.PieceBounds(0)
+	assert.Equal(t, 10, end-begin, "pieces end with their file")
+}
+
+func TestOpenHybrid(t *testing.T) {
+	pieceLength := merkle.BlockSize
+	files := []v2File{
+		{[]string{"one"}, v2TestData(pieceLength + 1)},
+		{[]string{"two"}, v2TestData(3 * pieceLength)},
+	}
+	data := v2Metainfo(t, "pair", files, pieceLength, true)
+	tf, err := openData(t, data)
+	require.NoError(t, err)
+
+	rawInfo, err := bencode.DictValue(data, "info")
+	require.NoError(t, err)
+	assert.Equal(t, sha1.Sum(rawInfo), tf.InfoHash)
+	assert.True(t, tf.Hybrid())
+	v2Hash := sha256.Sum256(rawInfo)
+	assert.Equal(t, [][20]byte{tf.InfoHash, [20]byte(v2Hash[:20])}, tf.InfoHashes())
+
+	require.Len(t, tf.Files, 3)
+	assert.True(t, tf.Files[1].Padding)
+	assert.Equal(t, merkle.Root(files[1].data), tf.Files[2].PiecesRoot)
+	assert.Len(t, tf.PieceHashes, 5)
+	assert.Len(t, tf.PiecesV2, 5)
+	begin, end := tf.PieceBounds(1)
+	assert.Equal(t, pieceLength, end-begin, "v1 pieces include padding")
+	assert.Equal(t, 1, tf.PiecesV2[1].Length)
+}
+
+func TestOpenHybridMismatch(t *testing.T) {
+	data := v2Metainfo(t, "file", []v2File{{[]string{"file"}, v2TestData(100)}}, merkle.BlockSize, true)
+	var decoded map[string]any
+	require.NoError(t, bencode.Unmarshal(data, &decoded))
+	decoded["info"].(map[string]any)["length"] = 99
+	data, err := bencode.Marshal(decoded)
+	require.NoError(t, err)
+
+	_, err = openData(t, data)
+	assert.ErrorContains(t, err, "doesn't match the file tree")
+}
+
+func TestOpenV2BadPieceLayer(t *testing.T) {
+	data := v2Metainfo(t, "file", []v2File{{[]string{"file"}, v2TestData(3 * merkle.BlockSize)}}, merkle.BlockSize, false)
+	var decoded map[string]any
+	require.NoError(t, bencode.Unmarshal(data, &decoded))
+	for root, layer := range decoded["piece layers"].(map[string]any) {
+		corrupt := []byte(layer.(string))
+		corrupt[0]++
+		decoded["piece layers"].(map[string]any)[root] = string(corrupt)
+	}
+	data, err := bencode.Marshal(decoded)
+	require.NoError(t, err)
+
+	_, err = openData(t, data)
+	assert.ErrorContains(t, err, "doesn't match its pieces root")
+
+	delete(decoded, "piece layers")
+	data, err = bencode.Marshal(decoded)
+	require.NoError(t, err)
+	_, err = openData(t, data)
+	assert.ErrorIs(t, err, errNoPieceLayer)
+}
+
+func TestOpenV2Invalid(t *testing.T) {
+	for name, info := range map[string]map[string]any{
+		"meta version": {"name": "x", "piece length": merkle.BlockSize, "meta version": 3},
+		"piece length": {"name": "x", "piece length": 3 * merkle.BlockSize, "meta version": 2,
+			"file tree": map[string]any{"x": map[string]any{"": map[string]any{"length": 0}}}},
+		"empty tree": {"name": "x", "piece length": merkle.BlockSize, "meta version": 2},
+		"unsafe path": {"name": "x", "piece length": merkle.BlockSize, "meta version": 2,
+			"file tree": map[string]any{"..": map[string]any{"": map[string]any{"length": 0}}}},
+		"pieces root": {"name": "x", "piece length": merkle.BlockSize, "meta version": 2,
+			"file tree": map[string]any{"x": map[string]any{"": map[string]any{"length": 5, "pieces root": "short"}}}},
+	} {
+		data, err := bencode.Marshal(map[string]any{"info": info})
+		require.NoError(t, err)
+		_, err = openData(t, data)
+		assert.Error(t, err, name)
+	}
+}
@@ -0,0 +1,83 @@
+package tracker
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// Event tells the tracker why an announce is sent. The values match the UDP
+// tracker protocol (BEP 15).
+type Event uint32
+
+const (
+	// EventNone is a regular announce sent on the tracker interval
+	EventNone Event = 0
+	// EventCompleted is sent once when the download finishes
+	EventCompleted Event = 1
+	// EventStarted is sent with the first announce
+	EventStarted Event = 2
+	// EventStopped is sent when the client shuts down gracefully
+	EventStopped Event = 3
+)
+
+func (e Event) String() string {
+	switch e {
+	case EventCompleted:
+		return "completed"
+	case EventStarted:
+		return "started"
+	case EventStopped:
+		return "stopped"
+	default:
+		return ""
+	}
+}
+
+// AnnounceRequest holds the parameters sent to a tracker on announce
+type AnnounceRequest struct {
+	InfoHash   [20]byte
+	PeerID     [20]byte
+	Port       uint16
+	Uploaded   int64
+	Downloaded int64
+	Left       int64
+	Event      Event
+	// NumWant is the number of peers requested; zero leaves it to the tracker
+	NumWant int
+	// Key identifies the client across IP address changes
+	Key uint32
+	// TrackerID is the tracker id returned by a previous announce
+	TrackerID string
+}
+
+// BuildAnnounceURL adds the announce parameters to the query of an HTTP announce URL
+func BuildAnnounceURL(announce string, req AnnounceRequest) (string, error) {
+	base, err := url.Parse(announce)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse announce URL: %w", err)
+	}
+	params := url.Values{
+		"info_hash":  {string(req.InfoHash[:])},
+		"peer_id":    {string(req.PeerID[:])},
+		"port":       {strconv.Itoa(int(req.Port))},
+		"uploaded":   {strconv.FormatInt(req.Uploaded, 10)},
+		"downloaded": {strconv.FormatInt(req.Downloaded, 10)},
+		"compact":    {"1"},
+		"left":       {strconv.FormatInt(req.Left, 10)},
+	}
+	if req.Event != EventNone {
+		params.Set("event", req.Event.String())
+	}
+	if req.NumWant > 0 {
+		params.Set("numwant", strconv.Itoa(req.NumWant))
+	}
+	if req.Key != 0 {
+		params.Set("key", fmt.Sprintf("%08x", req.Key))
+	}
+	if req.TrackerID != "" {
+		params.Set("trackerid", req.TrackerID)
+	}
+	base.RawQuery = params.Encode()
+	return base.String(), nil
+}
@@ -0,0 +1,55 @@
+package tracker
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildAnnounceURLLifecycleParams(t *testing.T) {
+	req := AnnounceRequest{
+		InfoHash:   [20]byte{1},
+		PeerID:     [20]byte{2},
+		Port:       6881,
+		Uploaded:   100,
+		Downloaded: 200,
+		Left:       300,
+		Event:      EventCompleted,
+		NumWant:    80,
+		Key:        0xdeadbeef,
+		TrackerID:  "abc",
+	}
+	result, err := BuildAnnounceURL("http://example.com/announce?passkey=x", req)
+	require.NoError(t, err)
+
+	u, err := url.Parse(result)
+	require.NoError(t, err)
+	q := u.Query()
+	assert.Equal(t, "100", q.Get("uploaded"))
+	assert.Equal(t, "200", q.Get("downloaded"))
+	assert.Equal(t, "300", q.Get("left"))
+	assert.Equal(t, "completed", q.Get("event"))
+	assert.Equal(t, "80", q.Get("numwant"))
+	assert.Equal(t, "deadbeef", q.Get("key"))
+	assert.Equal(t, "abc", q.Get("trackerid"))
+}
+
+func TestBuildAnnounceURLOmitsDefaults(t *testing.T) {
+	result, err := BuildAnnounceURL("http://example.com/announce", AnnounceRequest{})
+	require.NoError(t, err)
+
+	u, err := url.Parse(result)
+	require.NoError(t, err)
+	for _, key := range []string{"event", "numwant", "key", "trackerid"} {
+		assert.False(t, u.Query().Has(key), key)
+	}
+}
+
+func TestEventString(t *testing.T) {
+	assert.Equal(t, "started", EventStarted.String())
+	assert.Equal(t, "stopped", EventStopped.String())
+	assert.Equal(t, "completed", EventCompleted.String())
+	assert.Equal(t, "", EventNone.String())
+}
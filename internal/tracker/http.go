@@ -0,0 +1,45 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"Torrentasaurus_Rex/internal/bencode"
+)
+
+// maxResponseSize bounds the size of an HTTP tracker response
+const maxResponseSize = 4 << 20
+
+var httpClient = &http.Client{Timeout: 15 * time.Second}
+
+// FetchHTTP sends an announce to an HTTP tracker and decodes its response.
+// A response carrying a failure reason is returned as a *FailureError.
+func FetchHTTP(ctx context.Context, trackerURL string) (*BencodeTrackerResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, trackerURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch URL: %s, status code: %d", trackerURL, resp.StatusCode)
+	}
+
+	trackerResponse := &BencodeTrackerResponse{}
+	d := bencode.NewDecoder(io.LimitReader(resp.Body, maxResponseSize))
+	d.AllowNonCanonical()
+	if err := d.Decode(trackerResponse); err != nil {
+		return nil, err
+	}
+	if trackerResponse.FailureReason != "" {
+		return nil, &FailureError{Reason: trackerResponse.FailureReason}
+	}
+	return trackerResponse, nil
+}
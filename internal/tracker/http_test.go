@@ -0,0 +1,69 @@
+package tracker
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"Torrentasaurus_Rex/internal/bencode"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFetchHTTP(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("d8:completei3e10:incompletei1e8:intervali1800e12:min intervali900e5:peers0:" +
+			"10:tracker id2:id15:warning message4:note"))
+		w.Write([]byte("e"))
+	}))
+	defer server.Close()
+
+	resp, err := FetchHTTP(context.Background(), server.URL)
+	require.NoError(t, err)
+	assert.Equal(t, &BencodeTrackerResponse{
+		WarningMessage: "note",
+		Interval:       1800,
+		MinInterval:    900,
+		TrackerID:      "id",
+		Complete:       3,
+		Incomplete:     1,
+		Peers:          bencode.RawMessage("0:"),
+	}, resp)
+}
+
+func TestFetchHTTPUnsortedKeys(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("d8:intervali1800e5:peers0:8:completei3ee"))
+	}))
+	defer server.Close()
+
+	resp, err := FetchHTTP(context.Background(), server.URL)
+	require.NoError(t, err)
+	assert.Equal(t, 1800, resp.Interval)
+	assert.Equal(t, 3, resp.Complete)
+}
+
+func TestFetchHTTPFailureReason(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("d14:failure reason7:invalide"))
+	}))
+	defer server.Close()
+
+	_, err := FetchHTTP(context.Background(), server.URL)
+	var failure *FailureError
+	require.ErrorAs(t, err, &failure)
+	assert.Equal(t, "invalid", failure.Reason)
+	assert.Equal(t, "tracker failure: invalid", err.Error())
+}
+
+func TestFetchHTTPContextCancelled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := FetchHTTP(ctx, server.URL)
+	assert.ErrorIs(t, err, context.Canceled)
+}
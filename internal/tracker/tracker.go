@@ -1,34 +1,37 @@
 package tracker
 
 import (
+	"Torrentasaurus_Rex/internal/bencode"
 	"Torrentasaurus_Rex/internal/torrent"
-	"fmt"
-	"net/url"
-	"strconv"
 )
 
 // Port to listen on
 const Port uint16 = 6881
 
 type BencodeTrackerResponse struct {
-	Interval int    `bencode:"interval"`
-	Peers    string `bencode:"peers"`
+	FailureReason  string `bencode:"failure reason"`
+	WarningMessage string `bencode:"warning message"`
+	Interval       int    `bencode:"interval"`
+	MinInterval    int    `bencode:"min interval"`
+	TrackerID      string `bencode:"tracker id"`
+	Complete       int    `bencode:"complete"`
+	Incomplete     int    `bencode:"incomplete"`
+	// Peers is either a compact string of IPv4 peers or a list of dictionaries
+	Peers  bencode.RawMessage `bencode:"peers"`
+	Peers6 string             `bencode:"peers6"`
 }
 
 func BuildTrackerURL(tf *torrent.TorrentFile, peerID [20]byte) (string, error) {
-	base, err := url.Parse(tf.Announce)
-	if err != nil {
-		return "", fmt.Errorf("failed to parse announce URL: %w", err)
-	}
-	params := url.Values{
-		"info_hash":  {string(tf.InfoHash[:])},
-		"peer_id":    {string(peerID[:])},
-		"port":       {strconv.Itoa(int(Port))},
-		"uploaded":   {"0"},
-		"downloaded": {"0"},
-		"compact":    {"1"},
-		"left":       {strconv.Itoa(tf.Length)},
+	return BuildAnnounceURL(tf.Announce, NewAnnounceRequest(tf, peerID))
+}
+
+// NewAnnounceRequest creates the announce parameters for a torrent that
+// hasn't transferred any data yet
+func NewAnnounceRequest(tf *torrent.TorrentFile, peerID [20]byte) AnnounceRequest {
+	return AnnounceRequest{
+		InfoHash: tf.InfoHash,
+		PeerID:   peerID,
+		Port:     Port,
+		Left:     int64(tf.Length),
 	}
-	base.RawQuery = params.Encode()
-	return base.String(), nil
 }
@@ -0,0 +1,269 @@
+package tracker
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// UDP tracker protocol constants (BEP 15)
+const (
+	udpProtocolID     uint64 = 0x41727101980
+	udpActionConnect  uint32 = 0
+	udpActionAnnounce uint32 = 1
+	udpActionError    uint32 = 3
+
+	// udpConnectionIDLifetime is how long a connection ID may be reused
+	udpConnectionIDLifetime = time.Minute
+	// udpMaxPacketSize bounds announce responses we are willing to read
+	udpMaxPacketSize = 2048
+)
+
+var (
+	ErrUDPTimeout  = errors.New("udp tracker did not respond")
+	ErrUDPResponse = errors.New("malformed udp tracker response")
+)
+
+// FailureError is returned when a tracker rejects an announce and gives a reason
+type FailureError struct {
+	Reason string
+}
+
+func (e *FailureError) Error() string {
+	return "tracker failure: " + e.Reason
+}
+
+// UDPAnnounceResponse is the reply of a UDP tracker to an announce
+type UDPAnnounceResponse struct {
+	Interval int
+	Leechers int
+	Seeders  int
+	// Peers holds the peers in compact form, 6 bytes per IPv4 peer or 18
+	// bytes per IPv6 peer when the tracker was reached over IPv6
+	Peers []byte
+	IPv6  bool
+}
+
+// connectionID is a connection ID handed out by a UDP tracker
+type connectionID struct {
+	id       uint64
+	obtained time.Time
+}
+
+// UDPClient announces to UDP trackers. It caches connection IDs per tracker
+// so repeated announces skip the connect exchange.
+type UDPClient struct {
+	// BaseTimeout is the first retransmission timeout. It doubles after
+	// every retransmission, as described in BEP 15.
+	BaseTimeout time.Duration
+	// MaxRetries is the number of retransmissions before giving up
+	MaxRetries int
+
+	mu    sync.Mutex
+	conns map[string]connectionID
+	now   func() time.Time
+}
+
+// NewUDPClient creates a UDP tracker client with the BEP 15 timeouts
+func NewUDPClient() *UDPClient {
+	return &UDPClient{
+		BaseTimeout: 15 * time.Second,
+		MaxRetries:  8,
+		conns:       make(map[string]connectionID),
+		now:         time.Now,
+	}
+}
+
+// Announce sends an announce to the UDP tracker at announceURL
+func (c *UDPClient) Announce(ctx context.Context, announceURL string, req AnnounceRequest) (*UDPAnnounceResponse, error) {
+	u, err := url.Parse(announceURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse announce URL: %w", err)
+	}
+	if u.Scheme != "udp" {
+		return nil, fmt.Errorf("unsupported scheme %q for udp tracker", u.Scheme)
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", u.Host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial udp tracker: %w", err)
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	for attempt := 0; attempt <= c.MaxRetries; attempt++ {
+		timeout := c.BaseTimeout << attempt
+
+		id, err := c.connectionID(conn, u.Host, timeout)
+		if errors.Is(err, ErrUDPTimeout) && ctx.Err() == nil {
+			continue
+		}
+		if err != nil {
+			return nil, c.contextError(ctx, err)
+		}
+
+		resp, err := c.announce(conn, id, req, timeout)
+		if errors.Is(err, ErrUDPTimeout) && ctx.Err() == nil {
+			continue
+		}
+		if err != nil {
+			if ctx.Err() == nil {
+				// The tracker may no longer accept the connection ID
+				c.forget(u.Host)
+			}
+			return nil, c.contextError(ctx, err)
+		}
+		return resp, nil
+	}
+	return nil, ErrUDPTimeout
+}
+
+// contextError prefers the context error when the context ended the exchange
+func (c *UDPClient) contextError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// connectionID returns a cached connection ID for host or obtains a new one
+func (c *UDPClient) connectionID(conn net.Conn, host string, timeout time.Duration) (uint64, error) {
+	c.mu.Lock()
+	cached, ok := c.conns[host]
+	c.mu.Unlock()
+	if ok && c.now().Sub(cached.obtained) < udpConnectionIDLifetime {
+		return cached.id, nil
+	}
+
+	txID, err := transactionID()
+	if err != nil {
+		return 0, err
+	}
+	packet := make([]byte, 16)
+	binary.BigEndian.PutUint64(packet[0:8], udpProtocolID)
+	binary.BigEndian.PutUint32(packet[8:12], udpActionConnect)
+	binary.BigEndian.PutUint32(packet[12:16], txID)
+
+	resp, err := roundTrip(conn, packet, txID, udpActionConnect, timeout)
+	if err != nil {
+		return 0, err
+	}
+	if len(resp) < 16 {
+		return 0, fmt.Errorf("%w: connect response of %d bytes", ErrUDPResponse, len(resp))
+	}
+
+	id := binary.BigEndian.Uint64(resp[8:16])
+	c.mu.Lock()
+	c.conns[host] = connectionID{id: id, obtained: c.now()}
+	c.mu.Unlock()
+	return id, nil
+}
+
+// forget drops the cached connection ID of host
+func (c *UDPClient) forget(host string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.conns, host)
+}
+
+// announce sends an announce request using a connection ID
+func (c *UDPClient) announce(conn net.Conn, id uint64, req AnnounceRequest, timeout time.Duration) (*UDPAnnounceResponse, error) {
+	txID, err := transactionID()
+	if err != nil {
+		return nil, err
+	}
+	packet := make([]byte, 98)
+	binary.BigEndian.PutUint64(packet[0:8], id)
+	binary.BigEndian.PutUint32(packet[8:12], udpActionAnnounce)
+	binary.BigEndian.PutUint32(packet[12:16], txID)
+	copy(packet[16:36], req.InfoHash[:])
+	copy(packet[36:56], req.PeerID[:])
+	binary.BigEndian.PutUint64(packet[56:64], uint64(req.Downloaded))
+	binary.BigEndian.PutUint64(packet[64:72], uint64(req.Left))
+	binary.BigEndian.PutUint64(packet[72:80], uint64(req.Uploaded))
+	binary.BigEndian.PutUint32(packet[80:84], uint32(req.Event))
+	binary.BigEndian.PutUint32(packet[84:88], 0) // IP address: default
+	binary.BigEndian.PutUint32(packet[88:92], req.Key)
+	numWant := int32(-1) // default
+	if req.NumWant > 0 {
+		numWant = int32(req.NumWant)
+	}
+	binary.BigEndian.PutUint32(packet[92:96], uint32(numWant))
+	binary.BigEndian.PutUint16(packet[96:98], req.Port)
+
+	resp, err := roundTrip(conn, packet, txID, udpActionAnnounce, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	// BEP 15: trackers reached over IPv6 send 18 byte IPv6 peer entries
+	ipv6 := false
+	if addr, ok := conn.RemoteAddr().(*net.UDPAddr); ok && addr.IP.To4() == nil {
+		ipv6 = true
+	}
+	peerSize := 6
+	if ipv6 {
+		peerSize = 18
+	}
+	if len(resp) < 20 || (len(resp)-20)%peerSize != 0 {
+		return nil, fmt.Errorf("%w: announce response of %d bytes", ErrUDPResponse, len(resp))
+	}
+
+	return &UDPAnnounceResponse{
+		Interval: int(binary.BigEndian.Uint32(resp[8:12])),
+		Leechers: int(binary.BigEndian.Uint32(resp[12:16])),
+		Seeders:  int(binary.BigEndian.Uint32(resp[16:20])),
+		Peers:    resp[20:],
+		IPv6:     ipv6,
+	}, nil
+}
+
+// roundTrip sends a packet and waits for the response with the same
+// transaction ID. Responses to other transactions are ignored.
+func roundTrip(conn net.Conn, packet []byte, txID, action uint32, timeout time.Duration) ([]byte, error) {
+	if _, err := conn.Write(packet); err != nil {
+		return nil, fmt.Errorf("failed to send udp tracker request: %w", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(timeout))
+
+	buf := make([]byte, udpMaxPacketSize)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				return nil, ErrUDPTimeout
+			}
+			return nil, fmt.Errorf("failed to read udp tracker response: %w", err)
+		}
+		if n < 8 || binary.BigEndian.Uint32(buf[4:8]) != txID {
+			continue
+		}
+
+		switch got := binary.BigEndian.Uint32(buf[0:4]); got {
+		case action:
+			return append([]byte(nil), buf[:n]...), nil
+		case udpActionError:
+			return nil, &FailureError{Reason: string(buf[8:n])}
+		default:
+			return nil, fmt.Errorf("%w: unexpected action %d", ErrUDPResponse, got)
+		}
+	}
+}
+
+// transactionID generates a random transaction ID
+func transactionID() (uint32, error) {
+	var buf [4]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return 0, fmt.Errorf("failed to generate transaction ID: %w", err)
+	}
+	return binary.BigEndian.Uint32(buf[:]), nil
+}
@@ -0,0 +1,235 @@
+package tracker
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// udpStandIn is a minimal UDP tracker used by the tests
+type udpStandIn struct {
+	conn *net.UDPConn
+
+	mu        sync.Mutex
+	connects  int
+	announces int
+	// drop is the number of incoming packets to ignore before answering
+	drop int
+	// failure makes the tracker answer announces with an error
+	failure  string
+	lastSeen []byte
+}
+
+const standInConnectionID uint64 = 0x1122334455667788
+
+func startUDPStandIn(t *testing.T, configure ...func(*udpStandIn)) *udpStandIn {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	s := &udpStandIn{conn: conn}
+	for _, f := range configure {
+		f(s)
+	}
+	go s.serve()
+	t.Cleanup(func() { conn.Close() })
+	return s
+}
+
+func (s *udpStandIn) url() string {
+	return "udp://" + s.conn.LocalAddr().String() + "/announce"
+}
+
+func (s *udpStandIn) serve() {
+	buf := make([]byte, 2048)
+	for {
+		n, addr, err := s.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		packet := buf[:n]
+
+		s.mu.Lock()
+		if s.drop > 0 {
+			s.drop--
+			s.mu.Unlock()
+			continue
+		}
+		s.lastSeen = append([]byte(nil), packet...)
+		s.mu.Unlock()
+
+		action := binary.BigEndian.Uint32(packet[8:12])
+		txID := packet[12:16]
+		switch action {
+		case udpActionConnect:
+			s.mu.Lock()
+			s.connects++
+			s.mu.Unlock()
+			resp := make([]byte, 16)
+			copy(resp[4:8], txID)
+			binary.BigEndian.PutUint64(resp[8:16], standInConnectionID)
+			s.conn.WriteToUDP(resp, addr)
+		case udpActionAnnounce:
+			s.mu.Lock()
+			s.announces++
+			failure := s.failure
+			s.mu.Unlock()
+			if binary.BigEndian.Uint64(packet[0:8]) != standInConnectionID {
+				continue
+			}
+			if failure != "" {
+				resp := make([]byte, 8, 8+len(failure))
+				binary.BigEndian.PutUint32(resp[0:4], udpActionError)
+				copy(resp[4:8], txID)
+				s.conn.WriteToUDP(append(resp, failure...), addr)
+				continue
+			}
+			resp := make([]byte, 20)
+			binary.BigEndian.PutUint32(resp[0:4], udpActionAnnounce)
+			copy(resp[4:8], txID)
+			binary.BigEndian.PutUint32(resp[8:12], 1800)
+			binary.BigEndian.PutUint32(resp[12:16], 3)
+			binary.BigEndian.PutUint32(resp[16:20], 7)
+			resp = append(resp, 10, 0, 0, 1, 0x1A, 0xE1)
+			s.conn.WriteToUDP(resp, addr)
+		}
+	}
+}
+
+func testAnnounceRequest() AnnounceRequest {
+	return AnnounceRequest{
+		InfoHash: [20]byte{1, 2, 3},
+		PeerID:   [20]byte{4, 5, 6},
+		Port:     6881,
+		Left:     1000,
+	}
+}
+
+func newTestUDPClient() *UDPClient {
+	c := NewUDPClient()
+	c.BaseTimeout = 50 * time.Millisecond
+	c.MaxRetries = 3
+	return c
+}
+
+func TestUDPAnnounce(t *testing.T) {
+	s := startUDPStandIn(t)
+	c := newTestUDPClient()
+
+	resp, err := c.Announce(context.Background(), s.url(), testAnnounceRequest())
+	require.NoError(t, err)
+	assert.Equal(t, &UDPAnnounceResponse{
+		Interval: 1800,
+		Leechers: 3,
+		Seeders:  7,
+		Peers:    []byte{10, 0, 0, 1, 0x1A, 0xE1},
+	}, resp)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	assert.Equal(t, []byte{1, 2, 3}, s.lastSeen[16:19])                       // info hash
+	assert.Equal(t, uint64(1000), binary.BigEndian.Uint64(s.lastSeen[64:72])) // left
+	assert.Equal(t, uint16(6881), binary.BigEndian.Uint16(s.lastSeen[96:98])) // port
+}
+
+func TestUDPAnnounceLifecycleFields(t *testing.T) {
+	s := startUDPStandIn(t)
+	c := newTestUDPClient()
+	req := testAnnounceRequest()
+	req.Event = EventStopped
+	req.Key = 0xcafe
+	req.NumWant = 30
+
+	_, err := c.Announce(context.Background(), s.url(), req)
+	require.NoError(t, err)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	assert.Equal(t, uint32(EventStopped), binary.BigEndian.Uint32(s.lastSeen[80:84]))
+	assert.Equal(t, uint32(0xcafe), binary.BigEndian.Uint32(s.lastSeen[88:92]))
+	assert.Equal(t, uint32(30), binary.BigEndian.Uint32(s.lastSeen[92:96]))
+}
+
+func TestUDPAnnounceCachesConnectionID(t *testing.T) {
+	s := startUDPStandIn(t)
+	c := newTestUDPClient()
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		_, err := c.Announce(context.Background(), s.url(), testAnnounceRequest())
+		require.NoError(t, err)
+	}
+	s.mu.Lock()
+	assert.Equal(t, 1, s.connects)
+	assert.Equal(t, 3, s.announces)
+	s.mu.Unlock()
+
+	// The connection ID expires after a minute
+	now = now.Add(udpConnectionIDLifetime)
+	_, err := c.Announce(context.Background(), s.url(), testAnnounceRequest())
+	require.NoError(t, err)
+	s.mu.Lock()
+	assert.Equal(t, 2, s.connects)
+	s.mu.Unlock()
+}
+
+func TestUDPAnnounceRetransmits(t *testing.T) {
+	s := startUDPStandIn(t, func(s *udpStandIn) { s.drop = 2 })
+	c := newTestUDPClient()
+
+	resp, err := c.Announce(context.Background(), s.url(), testAnnounceRequest())
+	require.NoError(t, err)
+	assert.Equal(t, 1800, resp.Interval)
+}
+
+func TestUDPAnnounceTimeout(t *testing.T) {
+	s := startUDPStandIn(t, func(s *udpStandIn) { s.drop = 100 })
+	c := newTestUDPClient()
+	c.BaseTimeout = 5 * time.Millisecond
+	c.MaxRetries = 2
+
+	_, err := c.Announce(context.Background(), s.url(), testAnnounceRequest())
+	assert.ErrorIs(t, err, ErrUDPTimeout)
+}
+
+func TestUDPAnnounceFailure(t *testing.T) {
+	s := startUDPStandIn(t, func(s *udpStandIn) { s.failure = "torrent not registered" })
+	c := newTestUDPClient()
+
+	_, err := c.Announce(context.Background(), s.url(), testAnnounceRequest())
+	var failure *FailureError
+	require.ErrorAs(t, err, &failure)
+	assert.Equal(t, "torrent not registered", failure.Reason)
+
+	// The connection ID is obtained again after a failure
+	s.mu.Lock()
+	s.failure = ""
+	s.mu.Unlock()
+	_, err = c.Announce(context.Background(), s.url(), testAnnounceRequest())
+	require.NoError(t, err)
+	s.mu.Lock()
+	assert.Equal(t, 2, s.connects)
+	s.mu.Unlock()
+}
+
+func TestUDPAnnounceContextCancelled(t *testing.T) {
+	s := startUDPStandIn(t, func(s *udpStandIn) { s.drop = 100 })
+	c := newTestUDPClient()
+	c.BaseTimeout = time.Minute
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := c.Announce(ctx, s.url(), testAnnounceRequest())
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestUDPAnnounceWrongScheme(t *testing.T) {
+	c := newTestUDPClient()
+	_, err := c.Announce(context.Background(), "http://example.com/announce", testAnnounceRequest())
+	assert.Error(t, err)
+}
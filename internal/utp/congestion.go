@@ -0,0 +1,127 @@
+package utp
+
+import "time"
+
+const (
+	// targetDelay is the queuing delay LEDBAT aims for. Above it the window
+	// shrinks, so our bulk traffic yields to interactive traffic sharing
+	// the link.
+	targetDelay = 100 * time.Millisecond
+	// maxWindowIncrease is how much the window grows per round trip when
+	// there is no queuing delay at all
+	maxWindowIncrease = 3000
+	// minWindow is the smallest congestion window
+	minWindow = maxPayload
+	// maxWindowSize bounds the congestion window
+	maxWindowSize = 1 << 20
+	// baseDelayWindow is how long a delay sample may serve as the base
+	// delay, so a route change isn't mistaken for congestion forever
+	baseDelayWindow = 2 * time.Minute
+	// minRTO is the shortest retransmission timeout
+	minRTO = 500 * time.Millisecond
+	// maxRTO is the longest retransmission timeout
+	maxRTO = 30 * time.Second
+	// initialRTO is the retransmission timeout before the first round trip
+	// was measured
+	initialRTO = time.Second
+)
+
+// ledbat is the LEDBAT congestion controller (RFC 6817) of a connection.
+// It tracks the one-way delay of our packets, as measured by the peer, and
+// keeps the queuing delay we cause around targetDelay.
+type ledbat struct {
+	// window is the number of bytes that may be in flight
+	window int
+	// history holds the lowest delay of each minute, oldest first
+	history []delaySample
+	// lastDecrease rate limits the reaction to packet loss
+	lastDecrease time.Time
+}
+
+type delaySample struct {
+	start time.Time
+	delay uint32
+}
+
+func newLedbat() *ledbat {
+	return &ledbat{window: 2 * minWindow}
+}
+
+// baseDelay returns the lowest delay seen recently
+func (l *ledbat) baseDelay() uint32 {
+	base := l.history[0].delay
+	for _, s := range l.history[1:] {
+		base = min(base, s.delay)
+	}
+	return base
+}
+
+// addDelay records a delay sample in microseconds. The samples include the
+// offset between both clocks, which cancels out against the base delay.
+func (l *ledbat) addDelay(now time.Time, delay uint32) {
+	if n := len(l.history); n > 0 && now.Sub(l.history[n-1].start) < time.Minute {
+		l.history[n-1].delay = min(l.history[n-1].delay, delay)
+	} else {
+		l.history = append(l.history, delaySample{start: now, delay: delay})
+	}
+	for len(l.history) > 1 && now.Sub(l.history[0].start) > baseDelayWindow {
+		l.history = l.history[1:]
+	}
+}
+
+// acked grows or shrinks the window after acked bytes arrived, given the
+// latest delay the peer measured
+func (l *ledbat) acked(now time.Time, acked int, delay uint32) {
+	l.addDelay(now, delay)
+	queuing := time.Duration(delay-l.baseDelay()) * time.Microsecond
+	offTarget := float64(targetDelay-queuing) / float64(targetDelay)
+	gain := maxWindowIncrease * offTarget * float64(acked) / float64(l.window)
+	l.window = max(minWindow, min(maxWindowSize, l.window+int(gain)))
+}
+
+// lost halves the window, at most once per round trip
+func (l *ledbat) lost(now time.Time, rtt time.Duration) {
+	if now.Sub(l.lastDecrease) < rtt {
+		return
+	}
+	l.lastDecrease = now
+	l.window = max(minWindow, l.window/2)
+}
+
+// timedOut shrinks the window to a single packet after a retransmission
+// timeout
+func (l *ledbat) timedOut(now time.Time) {
+	l.lastDecrease = now
+	l.window = minWindow
+}
+
+// rttEstimator computes the retransmission timeout as in RFC 6298
+type rttEstimator struct {
+	srtt, rttvar time.Duration
+	rto          time.Duration
+}
+
+func newRTTEstimator() *rttEstimator {
+	return &rttEstimator{rto: initialRTO}
+}
+
+// sample adds a round trip measured for a packet sent only once
+func (r *rttEstimator) sample(rtt time.Duration) {
+	if r.srtt == 0 {
+		r.srtt = rtt
+		r.rttvar = rtt / 2
+	} else {
+		diff := r.srtt - rtt
+		if diff < 0 {
+			diff = -diff
+		}
+		r.rttvar += (diff - r.rttvar) / 4
+		r.srtt += (rtt - r.srtt) / 8
+	}
+	r.rto = max(minRTO, min(maxRTO, r.srtt+4*r.rttvar))
+}
+
+// backoff doubles the timeout after it expired
+func (r *rttEstimator) backoff() {
+	r.rto = min(maxRTO, 2*r.rto)
+}
@@ -0,0 +1,560 @@
+package utp
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// maxPacketSize is the largest datagram we send, small enough to pass
+	// through tunnels without fragmentation
+	maxPacketSize = 1400
+	// maxPayload is the most data a packet carries
+	maxPayload = maxPacketSize - headerSize
+	// recvWindowSize is the receive buffer we advertise
+	recvWindowSize = 1 << 20
+	// maxReorder bounds how far past ack_nr out of order packets are kept
+	maxReorder = 1024
+	// maxInflight bounds the packets waiting for their ack
+	maxInflight = 1024
+	// maxTimeouts is the number of retransmission timeouts in a row after
+	// which the peer is considered gone
+	maxTimeouts = 7
+	// keepAliveInterval is how long a connection may stay silent before we
+	// send an ack to keep NAT mappings open
+	keepAliveInterval = 29 * time.Second
+	// idleTimeout is how long a connection may go without hearing from
+	// the peer
+	idleTimeout = 2 * time.Minute
+	// lingerTimeout is how long a closed connection waits for the FIN of
+	// the peer once its own FIN was acked
+	lingerTimeout = 10 * time.Second
+)
+
+var (
+	ErrReset   = errors.New("connection reset by peer")
+	ErrTimeout = errors.New("connection timed out")
+)
+
+// connState is the stage of a connection
+type connState int
+
+const (
+	stateSynSent connState = iota
+	stateConnected
+)
+
+// outPacket is a packet we sent and may have to send again
+type outPacket struct {
+	typ           packetType
+	seq           uint16
+	payload       []byte
+	sentAt        time.Time
+	transmissions int
+	// fastResent is set once the packet was retransmitted after later
+	// packets were acked
+	fastResent bool
+}
+
+// Conn is a uTP connection. It implements net.Conn.
+type Conn struct {
+	sock   *Socket
+	addr   *net.UDPAddr
+	recvID uint16
+	sendID uint16
+
+	mu sync.Mutex
+	// changed is closed and replaced whenever the state changes, waking
+	// blocked reads and writes
+	changed chan struct{}
+	state   connState
+	// err is set once the connection failed or finished
+	err error
+	// closed is set by Close
+	closed   bool
+	closedAt time.Time
+
+	// seqNr is the sequence number of the next packet we send
+	seqNr    uint16
+	inflight []*outPacket
+	peerWnd  int
+	cc       *ledbat
+	rtt      *rttEstimator
+	lastAck  uint16
+	dupAcks  int
+	timeouts int
+	lastSend time.Time
+
+	// ackNr is the last packet received in order
+	ackNr    uint16
+	reorder  map[uint16]*packet
+	readBuf  []byte
+	finRecv  bool
+	finSeq   uint16
+	eof      bool
+	lastRecv time.Time
+	// replyDiff is the delay of the last packet received, which the peer's
+	// congestion control feeds on
+	replyDiff uint32
+
+	readDeadline, writeDeadline time.Time
+}
+
+func newConn(s *Socket, addr *net.UDPAddr, recvID, sendID uint16, now time.Time) *Conn {
+	return &Conn{
+		sock:     s,
+		addr:     addr,
+		recvID:   recvID,
+		sendID:   sendID,
+		changed:  make(chan struct{}),
+		peerWnd:  recvWindowSize,
+		cc:       newLedbat(),
+		rtt:      newRTTEstimator(),
+		reorder:  make(map[uint16]*packet),
+		lastRecv: now,
+		lastSend: now,
+	}
+}
+
+// timestamp returns the low 32 bits of a time in microseconds
+func timestamp(t time.Time) uint32 {
+	return uint32(t.UnixMicro())
+}
+
+// broadcast wakes everything waiting for a state change; the caller must
+// hold c.mu
+func (c *Conn) broadcast() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// wait releases c.mu until the state changes or the deadline passes
+func (c *Conn) wait(deadline time.Time) error {
+	changed := c.changed
+	c.mu.Unlock()
+	defer c.mu.Lock()
+	if deadline.IsZero() {
+		<-changed
+		return nil
+	}
+	d := time.Until(deadline)
+	if d <= 0 {
+		return os.ErrDeadlineExceeded
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-changed:
+		return nil
+	case <-t.C:
+		return os.ErrDeadlineExceeded
+	}
+}
+
+// fail ends the connection with err; the caller must hold c.mu
+func (c *Conn) fail(err error) {
+	if c.err != nil {
+		return
+	}
+	c.err = err
+	c.inflight = nil
+	c.sock.remove(c)
+	c.broadcast()
+}
+
+// recvWindow returns the free space of the receive buffer
+func (c *Conn) recvWindow() int {
+	used := len(c.readBuf)
+	for _, p := range c.reorder {
+		used += len(p.payload)
+	}
+	return max(0, recvWindowSize-used)
+}
+
+// sendPacket writes a packet to the peer; the caller must hold c.mu
+func (c *Conn) sendPacket(typ packetType, seq uint16, payload, sack []byte, now time.Time) {
+	p := &packet{
+		header: header{
+			typ:           typ,
+			connID:        c.sendID,
+			timestamp:     timestamp(now),
+			timestampDiff: c.replyDiff,
+			wndSize:       uint32(c.recvWindow()),
+			seqNr:         seq,
+			ackNr:         c.ackNr,
+		},
+		sack:    sack,
+		payload: payload,
+	}
+	if typ == stSyn {
+		p.connID = c.recvID
+	}
+	c.lastSend = now
+	c.sock.writeTo(p.encode(), c.addr)
+}
+
+// sendState acks what we received, listing the packets that arrived out of
+// order; the caller must hold c.mu
+func (c *Conn) sendState(now time.Time) {
+	c.sendPacket(stState, c.seqNr, nil, c.selectiveAck(), now)
+}
+
+// selectiveAck returns the bitmask of packets received past ackNr+1, or
+// nil when none are
+func (c *Conn) selectiveAck() []byte {
+	if len(c.reorder) == 0 {
+		return nil
+	}
+	var last uint16
+	for seq := range c.reorder {
+		last = max(last, seq-c.ackNr-2)
+	}
+	sack := make([]byte, (int(last)/32+1)*4)
+	for seq := range c.reorder {
+		i := seq - c.ackNr - 2
+		sack[i/8] |= 1 << (i % 8)
+	}
+	return sack
+}
+
+// sendNew sends a packet with the next sequence number and keeps it until it
+// is acked; the caller must hold c.mu
+func (c *Conn) sendNew(typ packetType, payload []byte, now time.Time) {
+	op := &outPacket{typ: typ, seq: c.seqNr, payload: payload}
+	c.seqNr++
+	c.inflight = append(c.inflight, op)
+	c.transmit(op, now)
+}
+
+// transmit sends a packet kept in flight, again if need be
+func (c *Conn) transmit(op *outPacket, now time.Time) {
+	op.sentAt = now
+	op.transmissions++
+	c.sendPacket(op.typ, op.seq, op.payload, nil, now)
+}
+
+// bytesInFlight returns the payload sent and not acked yet
+func (c *Conn) bytesInFlight() int {
+	n := 0
+	for _, op := range c.inflight {
+		n += len(op.payload)
+	}
+	return n
+}
+
+// canSend tells whether n more bytes fit in the congestion window and the
+// receive window of the peer. A single packet is always allowed, so a
+// closed window gets probed.
+func (c *Conn) canSend(n int) bool {
+	if len(c.inflight) == 0 {
+		return true
+	}
+	return len(c.inflight) < maxInflight && c.bytesInFlight()+n <= min(c.cc.window, c.peerWnd)
+}
+
+// handle processes a packet that arrived for the connection
+func (c *Conn) handle(p *packet, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	c.lastRecv = now
+	c.replyDiff = timestamp(now) - p.timestamp
+	if p.typ == stReset {
+		c.fail(ErrReset)
+		return
+	}
+	if c.state == stateSynSent {
+		if p.typ == stSyn {
+			return
+		}
+		// The peer numbers its packets from the seq_nr of its first one
+		c.ackNr = p.seqNr - 1
+		c.state = stateConnected
+	}
+	c.peerWnd = int(p.wndSize)
+	c.processAck(p, now)
+
+	switch p.typ {
+	case stData, stFin:
+		c.receive(p)
+		c.sendState(now)
+	case stSyn:
+		// Our ack of the SYN was lost
+		c.sendState(now)
+	}
+	if c.closed && c.eof && len(c.inflight) == 0 {
+		c.fail(net.ErrClosed)
+		return
+	}
+	c.broadcast()
+}
+
+// processAck drops the packets acked by p and retransmits those it shows
+// lost
+func (c *Conn) processAck(p *packet, now time.Time) {
+	acked := 0
+	for len(c.inflight) > 0 && !seqLess(p.ackNr, c.inflight[0].seq) {
+		op := c.inflight[0]
+		c.inflight = c.inflight[1:]
+		acked += c.ackPacket(op, now)
+	}
+
+	var sacked []uint16
+	for i := 0; i < len(p.sack)*8; i++ {
+		if p.sack[i/8]&(1<<(i%8)) != 0 {
+			sacked = append(sacked, p.ackNr+2+uint16(i))
+		}
+	}
+	if len(sacked) > 0 {
+		kept := c.inflight[:0]
+		for _, op := range c.inflight {
+			if contains(sacked, op.seq) {
+				acked += c.ackPacket(op, now)
+			} else {
+				kept = append(kept, op)
+			}
+		}
+		c.inflight = kept
+	}
+
+	if acked > 0 {
+		c.timeouts = 0
+		c.dupAcks = 0
+		if p.timestampDiff != 0 {
+			c.cc.acked(now, acked, p.timestampDiff)
+		}
+	} else if p.typ == stState && p.ackNr == c.lastAck && len(c.inflight) > 0 {
+		c.dupAcks++
+	}
+	c.lastAck = p.ackNr
+
+	// A packet is lost once three packets sent after it were acked, or the
+	// peer acked the one before it three times
+	for i, op := range c.inflight {
+		later := 0
+		for _, seq := range sacked {
+			if seqLess(op.seq, seq) {
+				later++
+			}
+		}
+		if op.fastResent || (later < 3 && (i > 0 || c.dupAcks < 3)) {
+			continue
+		}
+		op.fastResent = true
+		c.cc.lost(now, c.rtt.srtt)
+		c.transmit(op, now)
+	}
+}
+
+// ackPacket accounts for an acked packet and returns its payload length
+func (c *Conn) ackPacket(op *outPacket, now time.Time) int {
+	if op.transmissions == 1 {
+		c.rtt.sample(now.Sub(op.sentAt))
+	}
+	return len(op.payload)
+}
+
+func contains(seqs []uint16, seq uint16) bool {
+	for _, s := range seqs {
+		if s == seq {
+			return true
+		}
+	}
+	return false
+}
+
+// receive stores the payload of a data or FIN packet, in order
+func (c *Conn) receive(p *packet) {
+	if !seqLess(c.ackNr, p.seqNr) || p.seqNr-c.ackNr > maxReorder {
+		return
+	}
+	if c.finRecv && seqLess(c.finSeq, p.seqNr) {
+		return
+	}
+	if p.typ == stFin {
+		c.finRecv = true
+		c.finSeq = p.seqNr
+	}
+	if p.seqNr != c.ackNr+1 {
+		if _, ok := c.reorder[p.seqNr]; !ok {
+			p.payload = append([]byte(nil), p.payload...)
+			c.reorder[p.seqNr] = p
+		}
+		return
+	}
+
+	c.deliver(p)
+	for {
+		next, ok := c.reorder[c.ackNr+1]
+		if !ok {
+			break
+		}
+		delete(c.reorder, c.ackNr+1)
+		c.deliver(next)
+	}
+	if c.finRecv && c.ackNr == c.finSeq {
+		c.eof = true
+	}
+}
+
+// deliver passes the next packet in order to the reader
+func (c *Conn) deliver(p *packet) {
+	c.ackNr = p.seqNr
+	if p.typ == stData && !c.closed {
+		c.readBuf = append(c.readBuf, p.payload...)
+	}
+}
+
+// tick retransmits the oldest packet once its timeout expired and keeps
+// an idle connection alive
+func (c *Conn) tick(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	if now.Sub(c.lastRecv) > idleTimeout {
+		c.fail(ErrTimeout)
+		return
+	}
+	if c.closed && len(c.inflight) == 0 && now.Sub(c.closedAt) > lingerTimeout {
+		c.fail(net.ErrClosed)
+		return
+	}
+	if len(c.inflight) > 0 {
+		op := c.inflight[0]
+		if now.Sub(op.sentAt) < c.rtt.rto {
+			return
+		}
+		c.timeouts++
+		if c.timeouts > maxTimeouts {
+			c.fail(ErrTimeout)
+			return
+		}
+		c.rtt.backoff()
+		c.cc.timedOut(now)
+		c.transmit(op, now)
+		return
+	}
+	if c.state == stateConnected && now.Sub(c.lastSend) > keepAliveInterval {
+		c.sendState(now)
+	}
+}
+
+// Read reads data received in order. It returns io.EOF once the peer closed
+// the connection and everything it sent was read.
+func (c *Conn) Read(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for {
+		switch {
+		case c.closed:
+			return 0, net.ErrClosed
+		case len(c.readBuf) > 0:
+			before := c.recvWindow()
+			n := copy(b, c.readBuf)
+			c.readBuf = c.readBuf[n:]
+			if len(c.readBuf) == 0 {
+				c.readBuf = nil
+			}
+			// Tell a peer that stopped on a full window it may go on
+			if before < recvWindowSize/2 && c.recvWindow() >= recvWindowSize/2 && c.err == nil {
+				c.sendState(time.Now())
+			}
+			return n, nil
+		case c.eof:
+			return 0, io.EOF
+		case c.err != nil:
+			return 0, c.err
+		}
+		if err := c.wait(c.readDeadline); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Write sends b, blocking while the congestion window or the receive window
+// of the peer is full
+func (c *Conn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for len(b) > 0 {
+		switch {
+		case c.closed:
+			return n, net.ErrClosed
+		case c.err != nil:
+			return n, c.err
+		}
+		size := min(len(b), maxPayload)
+		if c.canSend(size) {
+			c.sendNew(stData, append([]byte(nil), b[:size]...), time.Now())
+			b = b[size:]
+			n += size
+			continue
+		}
+		if err := c.wait(c.writeDeadline); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// Close sends a FIN after the data written so far. The connection lingers
+// until the peer acks it.
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	c.closedAt = time.Now()
+	c.readBuf = nil
+	if c.err == nil && c.state == stateConnected {
+		c.sendNew(stFin, nil, time.Now())
+	} else {
+		c.fail(net.ErrClosed)
+	}
+	c.broadcast()
+	return nil
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	return c.sock.Addr()
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	c.writeDeadline = t
+	c.broadcast()
+	return nil
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	c.broadcast()
+	return nil
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeDeadline = t
+	c.broadcast()
+	return nil
+}
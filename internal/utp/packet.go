@@ -0,0 +1,124 @@
+package utp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// packetType is the kind of a uTP packet
+type packetType uint8
+
+const (
+	stData packetType = iota
+	stFin
+	stState
+	stReset
+	stSyn
+)
+
+const (
+	// version is the uTP version we speak
+	version = 1
+	// headerSize is the length of the fixed packet header
+	headerSize = 20
+	// extSelectiveAck is the extension carrying a bitmask of received
+	// packets past ack_nr
+	extSelectiveAck = 1
+)
+
+var ErrInvalidPacket = errors.New("invalid uTP packet")
+
+// header is the fixed part of every packet
+type header struct {
+	typ    packetType
+	connID uint16
+	// timestamp is when the packet was sent, and timestampDiff the delay
+	// the sender last measured for our packets, both in microseconds
+	timestamp     uint32
+	timestampDiff uint32
+	// wndSize is the free space in the receive buffer of the sender
+	wndSize uint32
+	seqNr   uint16
+	ackNr   uint16
+}
+
+// packet is a decoded packet
+type packet struct {
+	header
+	// sack has a bit set for every packet past ackNr+1 that arrived, the
+	// lowest bit of the first byte standing for ackNr+2
+	sack    []byte
+	payload []byte
+}
+
+// isPacket tells whether a datagram looks like uTP rather than another
+// protocol sharing the socket
+func isPacket(b []byte) bool {
+	return len(b) >= headerSize && b[0]&0x0f == version && packetType(b[0]>>4) <= stSyn
+}
+
+// encode serializes a packet
+func (p *packet) encode() []byte {
+	n := headerSize + len(p.payload)
+	if len(p.sack) > 0 {
+		n += 2 + len(p.sack)
+	}
+	buf := make([]byte, n)
+	buf[0] = byte(p.typ)<<4 | version
+	binary.BigEndian.PutUint16(buf[2:4], p.connID)
+	binary.BigEndian.PutUint32(buf[4:8], p.timestamp)
+	binary.BigEndian.PutUint32(buf[8:12], p.timestampDiff)
+	binary.BigEndian.PutUint32(buf[12:16], p.wndSize)
+	binary.BigEndian.PutUint16(buf[16:18], p.seqNr)
+	binary.BigEndian.PutUint16(buf[18:20], p.ackNr)
+	off := headerSize
+	if len(p.sack) > 0 {
+		buf[1] = extSelectiveAck
+		buf[off] = 0
+		buf[off+1] = byte(len(p.sack))
+		copy(buf[off+2:], p.sack)
+		off += 2 + len(p.sack)
+	}
+	copy(buf[off:], p.payload)
+	return buf
+}
+
+// decodePacket parses a datagram. The payload aliases b.
+func decodePacket(b []byte) (*packet, error) {
+	if !isPacket(b) {
+		return nil, ErrInvalidPacket
+	}
+	p := &packet{header: header{
+		typ:           packetType(b[0] >> 4),
+		connID:        binary.BigEndian.Uint16(b[2:4]),
+		timestamp:     binary.BigEndian.Uint32(b[4:8]),
+		timestampDiff: binary.BigEndian.Uint32(b[8:12]),
+		wndSize:       binary.BigEndian.Uint32(b[12:16]),
+		seqNr:         binary.BigEndian.Uint16(b[16:18]),
+		ackNr:         binary.BigEndian.Uint16(b[18:20]),
+	}}
+	ext := b[1]
+	off := headerSize
+	for ext != 0 {
+		if len(b) < off+2 {
+			return nil, fmt.Errorf("%w: truncated extension %d", ErrInvalidPacket, ext)
+		}
+		next, n := b[off], int(b[off+1])
+		if len(b) < off+2+n {
+			return nil, fmt.Errorf("%w: truncated extension %d", ErrInvalidPacket, ext)
+		}
+		if ext == extSelectiveAck {
+			p.sack = b[off+2 : off+2+n]
+		}
+		ext = next
+		off += 2 + n
+	}
+	p.payload = b[off:]
+	return p, nil
+}
+
+// seqLess compares sequence numbers, which wrap around
+func seqLess(a, b uint16) bool {
+	return int16(a-b) < 0
+}
@@ -0,0 +1,381 @@
+// Package utp implements the Micro Transport Protocol (BEP 29): reliable,
+// ordered streams over UDP whose LEDBAT congestion control backs off as soon
+// as it notices queuing delay, so bulk transfers yield to other traffic.
+package utp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// tickInterval is how often retransmission timeouts are checked
+	tickInterval = 50 * time.Millisecond
+	// acceptBacklog is the number of connections waiting for Accept;
+	// further SYNs are ignored
+	acceptBacklog = 64
+	// packetBacklog is the number of datagrams of other protocols waiting
+	// to be read from PacketConn
+	packetBacklog = 256
+	// dialTimeout bounds the wait for a uTP peer before DialPeer falls back
+	// to TCP
+	dialTimeout = 2 * time.Second
+	// readBufferSize is the largest datagram we read
+	readBufferSize = 8192
+)
+
+// connKey identifies a connection by the peer and our connection ID
+type connKey struct {
+	addr   string
+	recvID uint16
+}
+
+// datagram is a datagram of another protocol sharing the socket
+type datagram struct {
+	data []byte
+	addr net.Addr
+}
+
+// Socket multiplexes uTP connections over a UDP socket. It implements
+// net.Listener, and other protocols such as the DHT may share the socket
+// through PacketConn.
+type Socket struct {
+	conn    net.PacketConn
+	backlog chan *Conn
+	packets chan datagram
+	done    chan struct{}
+	other   *packetConn
+
+	mu     sync.Mutex
+	conns  map[connKey]*Conn
+	closed bool
+}
+
+// Listen opens a UDP socket on addr
+func Listen(addr string) (*Socket, error) {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	return NewSocket(conn), nil
+}
+
+// NewSocket runs uTP over conn, which the socket reads from until it is
+// closed
+func NewSocket(conn net.PacketConn) *Socket {
+	s := &Socket{
+		conn:    conn,
+		backlog: make(chan *Conn, acceptBacklog),
+		packets: make(chan datagram, packetBacklog),
+		done:    make(chan struct{}),
+		conns:   make(map[connKey]*Conn),
+	}
+	s.other = &packetConn{sock: s, closed: make(chan struct{})}
+	go s.read()
+	go s.tick()
+	return s
+}
+
+// Addr returns the address the socket listens on
+func (s *Socket) Addr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+// Port returns the UDP port the socket listens on
+func (s *Socket) Port() int {
+	return s.conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+// PacketConn returns a packet connection that reads the datagrams that
+// aren't uTP and writes through the socket. Closing it leaves the socket
+// open.
+func (s *Socket) PacketConn() net.PacketConn {
+	return s.other
+}
+
+// Accept waits for the next incoming connection
+func (s *Socket) Accept() (net.Conn, error) {
+	select {
+	case c := <-s.backlog:
+		return c, nil
+	case <-s.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close closes the UDP socket and every connection on it
+func (s *Socket) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	conns := make([]*Conn, 0, len(s.conns))
+	for _, c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.mu.Unlock()
+
+	close(s.done)
+	for _, c := range conns {
+		c.mu.Lock()
+		c.fail(net.ErrClosed)
+		c.mu.Unlock()
+	}
+	return s.conn.Close()
+}
+
+// DialContext opens a uTP connection to addr
+func (s *Socket) DialContext(ctx context.Context, addr string) (*Conn, error) {
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	c, err := s.register(raddr)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seqNr = 1
+	c.sendNew(stSyn, nil, time.Now())
+	for c.state == stateSynSent && c.err == nil {
+		changed := c.changed
+		c.mu.Unlock()
+		select {
+		case <-changed:
+			c.mu.Lock()
+		case <-ctx.Done():
+			c.mu.Lock()
+			c.fail(fmt.Errorf("failed to connect to %s: %w", addr, ctx.Err()))
+		}
+	}
+	if c.err != nil {
+		return nil, c.err
+	}
+	return c, nil
+}
+
+// DialPeer connects to a peer over uTP, and over TCP when it doesn't answer
+// over uTP in time
+func (s *Socket) DialPeer(ctx context.Context, addr string) (net.Conn, error) {
+	utpCtx, cancel := context.WithTimeout(ctx, dialTimeout)
+	c, err := s.DialContext(utpCtx, addr)
+	cancel()
+	if err == nil {
+		return c, nil
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	d := net.Dialer{Timeout: dialTimeout}
+	return d.DialContext(ctx, "tcp", addr)
+}
+
+// register adds an outgoing connection with a free connection ID
+func (s *Socket) register(addr *net.UDPAddr) (*Conn, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, net.ErrClosed
+	}
+	var buf [2]byte
+	for {
+		if _, err := rand.Read(buf[:]); err != nil {
+			return nil, err
+		}
+		id := binary.BigEndian.Uint16(buf[:])
+		key := connKey{addr.String(), id}
+		if _, ok := s.conns[key]; ok {
+			continue
+		}
+		c := newConn(s, addr, id, id+1, time.Now())
+		s.conns[key] = c
+		return c, nil
+	}
+}
+
+// remove forgets a connection; further packets for it are ignored
+func (s *Socket) remove(c *Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := connKey{c.addr.String(), c.recvID}
+	if s.conns[key] == c {
+		delete(s.conns, key)
+	}
+}
+
+func (s *Socket) writeTo(b []byte, addr net.Addr) {
+	// Lost datagrams are recovered by retransmission
+	s.conn.WriteTo(b, addr)
+}
+
+// read passes the datagrams to their connections until the socket closes
+func (s *Socket) read() {
+	buf := make([]byte, readBufferSize)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				s.Close()
+				return
+			}
+			continue
+		}
+		udpAddr, ok := addr.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+		if !isPacket(buf[:n]) {
+			s.deliver(buf[:n], addr)
+			continue
+		}
+		p, err := decodePacket(buf[:n])
+		if err != nil {
+			continue
+		}
+		if c := s.lookup(p, udpAddr); c != nil {
+			c.handle(p, time.Now())
+		}
+	}
+}
+
+// lookup returns the connection a packet belongs to. A SYN for an unknown
+// connection creates one and queues it for Accept.
+func (s *Socket) lookup(p *packet, addr *net.UDPAddr) *Conn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if p.typ != stSyn {
+		return s.conns[connKey{addr.String(), p.connID}]
+	}
+	key := connKey{addr.String(), p.connID + 1}
+	if c, ok := s.conns[key]; ok {
+		return c
+	}
+	if s.closed || len(s.backlog) == cap(s.backlog) {
+		return nil
+	}
+
+	now := time.Now()
+	c := newConn(s, addr, p.connID+1, p.connID, now)
+	var buf [2]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return nil
+	}
+	c.state = stateConnected
+	c.seqNr = binary.BigEndian.Uint16(buf[:])
+	c.ackNr = p.seqNr
+	c.lastAck = c.seqNr - 1
+	c.peerWnd = int(p.wndSize)
+	c.replyDiff = timestamp(now) - p.timestamp
+	c.sendState(now)
+	s.conns[key] = c
+	s.backlog <- c
+	return nil
+}
+
+// deliver queues a datagram of another protocol for PacketConn, dropping it
+// when nobody keeps up with reading
+func (s *Socket) deliver(b []byte, addr net.Addr) {
+	select {
+	case s.packets <- datagram{data: append([]byte(nil), b...), addr: addr}:
+	default:
+	}
+}
+
+// tick drives the timers of the connections
+func (s *Socket) tick() {
+	t := time.NewTicker(tickInterval)
+	defer t.Stop()
+	for {
+		select {
+		case now := <-t.C:
+			s.mu.Lock()
+			conns := make([]*Conn, 0, len(s.conns))
+			for _, c := range s.conns {
+				conns = append(conns, c)
+			}
+			s.mu.Unlock()
+			for _, c := range conns {
+				c.tick(now)
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// packetConn is the view of the socket for other protocols
+type packetConn struct {
+	sock *Socket
+
+	mu           sync.Mutex
+	readDeadline time.Time
+	closed       chan struct{}
+	closeOnce    sync.Once
+}
+
+func (pc *packetConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	pc.mu.Lock()
+	deadline := pc.readDeadline
+	pc.mu.Unlock()
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		t := time.NewTimer(time.Until(deadline))
+		defer t.Stop()
+		timeout = t.C
+	}
+	select {
+	case d := <-pc.sock.packets:
+		return copy(b, d.data), d.addr, nil
+	case <-pc.closed:
+		return 0, nil, net.ErrClosed
+	case <-pc.sock.done:
+		return 0, nil, net.ErrClosed
+	case <-timeout:
+		return 0, nil, os.ErrDeadlineExceeded
+	}
+}
+
+func (pc *packetConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	select {
+	case <-pc.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+	return pc.sock.conn.WriteTo(b, addr)
+}
+
+// Close stops reading from the socket without closing it
+func (pc *packetConn) Close() error {
+	pc.closeOnce.Do(func() { close(pc.closed) })
+	return nil
+}
+
+func (pc *packetConn) LocalAddr() net.Addr {
+	return pc.sock.Addr()
+}
+
+func (pc *packetConn) SetDeadline(t time.Time) error {
+	return pc.SetReadDeadline(t)
+}
+
+func (pc *packetConn) SetReadDeadline(t time.Time) error {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	pc.readDeadline = t
+	return nil
+}
+
+func (pc *packetConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
@@ -0,0 +1,262 @@
+package utp
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPacketRoundTrip(t *testing.T) {
+	p := &packet{
+		header: header{
+			typ:           stState,
+			connID:        0x1234,
+			timestamp:     1,
+			timestampDiff: 2,
+			wndSize:       3,
+			seqNr:         4,
+			ackNr:         5,
+		},
+		sack:    []byte{0x05, 0, 0, 0},
+		payload: []byte("data"),
+	}
+	got, err := decodePacket(p.encode())
+	require.NoError(t, err)
+	assert.Equal(t, p, got)
+
+	_, err = decodePacket([]byte("d1:ad2:id20:abcdefghij0123456789e1:q4:ping1:t2:aa1:y1:qe"))
+	assert.ErrorIs(t, err, ErrInvalidPacket)
+	truncated := p.encode()[:headerSize+3]
+	_, err = decodePacket(truncated)
+	assert.ErrorIs(t, err, ErrInvalidPacket)
+}
+
+func TestSeqLess(t *testing.T) {
+	assert.True(t, seqLess(1, 2))
+	assert.False(t, seqLess(2, 2))
+	assert.True(t, seqLess(65535, 0))
+	assert.False(t, seqLess(0, 65535))
+}
+
+func TestLedbat(t *testing.T) {
+	now := time.Now()
+	l := newLedbat()
+	start := l.window
+	l.acked(now, maxPayload, 5000)
+	l.acked(now, maxPayload, 5000)
+	assert.Greater(t, l.window, start, "no queuing delay grows the window")
+
+	grown := l.window
+	l.acked(now, maxPayload, 5000+uint32(3*targetDelay/time.Microsecond))
+	assert.Less(t, l.window, grown, "delay over the target shrinks the window")
+
+	l.window = 10 * minWindow
+	l.lost(now, time.Second)
+	assert.Equal(t, 5*minWindow, l.window)
+	l.lost(now.Add(time.Millisecond), time.Second)
+	assert.Equal(t, 5*minWindow, l.window, "one decrease per round trip")
+	l.timedOut(now)
+	assert.Equal(t, minWindow, l.window)
+}
+
+// lossyConn drops every nth datagram it sends
+type lossyConn struct {
+	net.PacketConn
+	n int
+
+	mu   sync.Mutex
+	sent int
+}
+
+func (c *lossyConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	c.mu.Lock()
+	c.sent++
+	drop := c.sent%c.n == 0
+	c.mu.Unlock()
+	if drop {
+		return len(b), nil
+	}
+	return c.PacketConn.WriteTo(b, addr)
+}
+
+func listenLoopback(t *testing.T, dropEvery int) *Socket {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	if dropEvery > 0 {
+		pc = &lossyConn{PacketConn: pc, n: dropEvery}
+	}
+	s := NewSocket(pc)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// connPair connects two sockets and returns both ends
+func connPair(t *testing.T, dropEvery int) (client, server net.Conn) {
+	t.Helper()
+	a, b := listenLoopback(t, dropEvery), listenLoopback(t, dropEvery)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := a.DialContext(ctx, b.Addr().String())
+	require.NoError(t, err)
+	server, err = b.Accept()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		c.Close()
+		server.Close()
+	})
+	return c, server
+}
+
+// transfer sends random data both ways at once and checks it arrives intact
+func transfer(t *testing.T, a, b net.Conn, size int) {
+	t.Helper()
+	send := func(c net.Conn) ([]byte, <-chan error) {
+		data := make([]byte, size)
+		rand.Read(data)
+		done := make(chan error, 1)
+		go func() {
+			_, err := c.Write(data)
+			done <- err
+		}()
+		return data, done
+	}
+	fromA, doneA := send(a)
+	fromB, doneB := send(b)
+
+	got := make([]byte, size)
+	_, err := io.ReadFull(b, got)
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(fromA, got), "data from the initiator")
+	_, err = io.ReadFull(a, got)
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(fromB, got), "data from the receiver")
+	require.NoError(t, <-doneA)
+	require.NoError(t, <-doneB)
+}
+
+func TestTransfer(t *testing.T) {
+	a, b := connPair(t, 0)
+	transfer(t, a, b, 1<<20)
+}
+
+func TestTransferWithLoss(t *testing.T) {
+	a, b := connPair(t, 7)
+	transfer(t, a, b, 256<<10)
+}
+
+func TestCloseSendsEOF(t *testing.T) {
+	a, b := connPair(t, 0)
+	_, err := a.Write([]byte("last words"))
+	require.NoError(t, err)
+	require.NoError(t, a.Close())
+
+	data, err := io.ReadAll(b)
+	require.NoError(t, err)
+	assert.Equal(t, "last words", string(data))
+
+	_, err = a.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, net.ErrClosed)
+	_, err = a.Write([]byte("more"))
+	assert.ErrorIs(t, err, net.ErrClosed)
+}
+
+func TestReadDeadline(t *testing.T) {
+	a, _ := connPair(t, 0)
+	require.NoError(t, a.SetReadDeadline(time.Now().Add(50*time.Millisecond)))
+	_, err := a.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+}
+
+func TestSocketCloseFailsConnections(t *testing.T) {
+	a, _ := connPair(t, 0)
+	read := make(chan error, 1)
+	go func() {
+		_, err := a.Read(make([]byte, 1))
+		read <- err
+	}()
+	a.(*Conn).sock.Close()
+	assert.ErrorIs(t, <-read, net.ErrClosed)
+}
+
+func TestDialUnresponsivePeer(t *testing.T) {
+	s := listenLoopback(t, 0)
+	silent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer silent.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = s.DialContext(ctx, silent.LocalAddr().String())
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Empty(t, s.conns)
+}
+
+func TestDialPeerFallsBackToTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	// The UDP port of the peer is open but doesn't speak uTP
+	silent, err := net.ListenPacket("udp", ln.Addr().String())
+	require.NoError(t, err)
+	defer silent.Close()
+
+	s := listenLoopback(t, 0)
+	c, err := s.DialPeer(context.Background(), ln.Addr().String())
+	require.NoError(t, err)
+	defer c.Close()
+	assert.Equal(t, "tcp", c.RemoteAddr().Network())
+}
+
+func TestDialPeerUsesUTP(t *testing.T) {
+	a, b := listenLoopback(t, 0), listenLoopback(t, 0)
+	c, err := a.DialPeer(context.Background(), b.Addr().String())
+	require.NoError(t, err)
+	defer c.Close()
+	assert.IsType(t, &Conn{}, c)
+}
+
+func TestPacketConnSharesSocket(t *testing.T) {
+	s := listenLoopback(t, 0)
+	other, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer other.Close()
+
+	query := []byte("d1:ad2:id20:abcdefghij0123456789e1:q4:ping1:t2:aa1:y1:qe")
+	_, err = other.WriteTo(query, s.Addr())
+	require.NoError(t, err)
+
+	pc := s.PacketConn()
+	require.NoError(t, pc.SetReadDeadline(time.Now().Add(time.Second)))
+	buf := make([]byte, 100)
+	n, addr, err := pc.ReadFrom(buf)
+	require.NoError(t, err)
+	assert.Equal(t, query, buf[:n])
+	assert.Equal(t, other.LocalAddr().String(), addr.String())
+
+	_, err = pc.WriteTo([]byte("reply"), addr)
+	require.NoError(t, err)
+	require.NoError(t, other.SetReadDeadline(time.Now().Add(time.Second)))
+	n, _, err = other.ReadFrom(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "reply", string(buf[:n]))
+
+	// Closing the view leaves uTP running
+	require.NoError(t, pc.Close())
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	c, err := listenLoopback(t, 0).DialContext(ctx, s.Addr().String())
+	require.NoError(t, err)
+	c.Close()
+	_, _, err = pc.ReadFrom(buf)
+	assert.ErrorIs(t, err, net.ErrClosed)
+}
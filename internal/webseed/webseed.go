@@ -0,0 +1,152 @@
+// Package webseed downloads torrent data from HTTP mirrors listed in the
+// url-list of a torrent (BEP 19).
+package webseed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"Torrentasaurus_Rex/internal/torrent"
+)
+
+var (
+	ErrUnsupportedURL = errors.New("unsupported web seed URL")
+	ErrBadStatus      = errors.New("unexpected HTTP status")
+)
+
+var httpClient = &http.Client{Timeout: 2 * time.Minute}
+
+// Seed is an HTTP mirror of the data of a torrent. It is not safe for
+// concurrent use.
+type Seed struct {
+	URL string
+	// RetryDelay is the wait after the first failure. It doubles with every
+	// failure in a row.
+	RetryDelay time.Duration
+	// MaxFailures is the number of failures in a row after which the mirror
+	// is given up
+	MaxFailures int
+
+	files     []torrent.FileEntry
+	multiFile bool
+	failures  int
+}
+
+// New returns the mirror at rawURL of a torrent with the given files. Only
+// HTTP and HTTPS mirrors are supported.
+func New(rawURL string, files []torrent.FileEntry) (*Seed, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedURL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("%w: scheme %q", ErrUnsupportedURL, u.Scheme)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("%w: torrent has no files", ErrUnsupportedURL)
+	}
+	return &Seed{
+		URL:         rawURL,
+		RetryDelay:  10 * time.Second,
+		MaxFailures: 5,
+		files:       files,
+		// Files of a multi-file torrent are nested in a directory named
+		// after the torrent, even when there is only one
+		multiFile: len(files) > 1 || len(files[0].Path) > 1,
+	}, nil
+}
+
+// fileURL returns the URL of a file of the torrent. The URL of a single-file
+// torrent names the file unless it ends with a slash; for a multi-file
+// torrent it is the directory holding the torrent's directory.
+func (s *Seed) fileURL(f torrent.FileEntry) string {
+	base := s.URL
+	if !s.multiFile && !strings.HasSuffix(base, "/") {
+		return base
+	}
+	if !strings.HasSuffix(base, "/") {
+		base += "/"
+	}
+	escaped := make([]string, len(f.Path))
+	for i, component := range f.Path {
+		escaped[i] = url.PathEscape(component)
+	}
+	return base + strings.Join(escaped, "/")
+}
+
+// ReadAt fills buf with the torrent data starting at offset. A range that
+// spans several files takes a request per file. Padding files are zeros
+// and aren't requested.
+func (s *Seed) ReadAt(ctx context.Context, buf []byte, offset int) error {
+	pos := 0
+	for _, span := range torrent.FileSpans(s.files, offset, len(buf)) {
+		if s.files[span.File].Padding {
+			clear(buf[pos : pos+span.Length])
+			pos += span.Length
+			continue
+		}
+		if err := s.readSpan(ctx, s.files[span.File], span.Offset, buf[pos:pos+span.Length]); err != nil {
+			return err
+		}
+		pos += span.Length
+	}
+	if pos != len(buf) {
+		return fmt.Errorf("range %d-%d is past the end of the torrent", offset, offset+len(buf))
+	}
+	return nil
+}
+
+// readSpan fills buf with the data of a file starting at offset
+func (s *Seed) readSpan(ctx context.Context, f torrent.FileEntry, offset int, buf []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.fileURL(f), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+len(buf)-1))
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		var start int
+		if _, err := fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes %d-", &start); err != nil || start != offset {
+			return fmt.Errorf("unexpected Content-Range %q for offset %d", resp.Header.Get("Content-Range"), offset)
+		}
+	case http.StatusOK:
+		// The server ignored the range and sends the whole file
+		if _, err := io.CopyN(io.Discard, resp.Body, int64(offset)); err != nil {
+			return fmt.Errorf("failed to read %s: %w", req.URL, err)
+		}
+	default:
+		return fmt.Errorf("%w %s for %s", ErrBadStatus, resp.Status, req.URL)
+	}
+	if _, err := io.ReadFull(resp.Body, buf); err != nil {
+		return fmt.Errorf("failed to read %s: %w", req.URL, err)
+	}
+	return nil
+}
+
+// Failed records that the mirror failed, returning bad data or none at all,
+// and returns how long to wait before using it again. It returns false once
+// the mirror failed MaxFailures times in a row.
+func (s *Seed) Failed() (time.Duration, bool) {
+	s.failures++
+	if s.failures >= s.MaxFailures {
+		return 0, false
+	}
+	return s.RetryDelay << (s.failures - 1), true
+}
+
+// Succeeded records that the mirror returned good data
+func (s *Seed) Succeeded() {
+	s.failures = 0
+}
@@ -0,0 +1,126 @@
+package webseed
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"Torrentasaurus_Rex/internal/torrent"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewRejectsUnsupportedURLs(t *testing.T) {
+	files := []torrent.FileEntry{{Path: []string{"file.iso"}, Length: 10}}
+	_, err := New("ftp://mirror.example/file.iso", files)
+	assert.ErrorIs(t, err, ErrUnsupportedURL)
+	_, err = New("http://mirror.example/file.iso", nil)
+	assert.ErrorIs(t, err, ErrUnsupportedURL)
+}
+
+func TestFileURL(t *testing.T) {
+	single := []torrent.FileEntry{{Path: []string{"file.iso"}}}
+	multi := []torrent.FileEntry{
+		{Path: []string{"album", "a b.mp3"}},
+		{Path: []string{"album", "cover", "c.jpg"}},
+	}
+	tests := []struct {
+		name  string
+		url   string
+		files []torrent.FileEntry
+		file  int
+		want  string
+	}{
+		{"single file", "http://mirror.example/pub/file.iso", single, 0, "http://mirror.example/pub/file.iso"},
+		{"single file directory", "http://mirror.example/pub/", single, 0, "http://mirror.example/pub/file.iso"},
+		{"multi file", "http://mirror.example/pub", multi, 1, "http://mirror.example/pub/album/cover/c.jpg"},
+		{"escaped", "http://mirror.example/pub/", multi, 0, "http://mirror.example/pub/album/a%20b.mp3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := New(tt.url, tt.files)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, s.fileURL(tt.files[tt.file]))
+		})
+	}
+}
+
+// serveFiles serves the contents by path, honoring Range requests unless
+// ignoreRange is set
+func serveFiles(t *testing.T, contents map[string]string, ignoreRange bool) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, ok := contents[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if ignoreRange {
+			w.Write([]byte(data))
+			return
+		}
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(data))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestReadAtAcrossFiles(t *testing.T) {
+	files := []torrent.FileEntry{
+		{Path: []string{"dir", "a"}, Length: 5, Offset: 0},
+		{Path: []string{"dir", "empty"}, Length: 0, Offset: 5},
+		{Path: []string{"dir", "b"}, Length: 6, Offset: 5},
+	}
+	contents := map[string]string{"/dir/a": "hello", "/dir/b": " world"}
+
+	for _, ignoreRange := range []bool{false, true} {
+		server := serveFiles(t, contents, ignoreRange)
+		s, err := New(server.URL, files)
+		require.NoError(t, err)
+		buf := make([]byte, 7)
+		require.NoError(t, s.ReadAt(context.Background(), buf, 2))
+		assert.Equal(t, "llo wor", string(buf))
+	}
+}
+
+func TestReadAtErrors(t *testing.T) {
+	files := []torrent.FileEntry{{Path: []string{"file.iso"}, Length: 10}}
+	server := serveFiles(t, map[string]string{"/file.iso": "short"}, false)
+
+	s, err := New(server.URL+"/missing.iso", files)
+	require.NoError(t, err)
+	assert.ErrorIs(t, s.ReadAt(context.Background(), make([]byte, 4), 0), ErrBadStatus)
+
+	s, err = New(server.URL+"/file.iso", files)
+	require.NoError(t, err)
+	assert.Error(t, s.ReadAt(context.Background(), make([]byte, 4), 6), "range past the served data")
+	assert.Error(t, s.ReadAt(context.Background(), make([]byte, 4), 8), "range past the end of the torrent")
+	buf := make([]byte, 4)
+	require.NoError(t, s.ReadAt(context.Background(), buf, 1))
+	assert.True(t, bytes.Equal([]byte("hort"), buf))
+}
+
+func TestFailedBacksOff(t *testing.T) {
+	s, err := New("http://mirror.example/file.iso", []torrent.FileEntry{{Path: []string{"file.iso"}}})
+	require.NoError(t, err)
+	s.RetryDelay = time.Second
+	s.MaxFailures = 3
+
+	wait, ok := s.Failed()
+	assert.True(t, ok)
+	assert.Equal(t, time.Second, wait)
+	wait, ok = s.Failed()
+	assert.True(t, ok)
+	assert.Equal(t, 2*time.Second, wait)
+
+	s.Succeeded()
+	wait, _ = s.Failed()
+	assert.Equal(t, time.Second, wait, "success resets the backoff")
+	s.Failed()
+	_, ok = s.Failed()
+	assert.False(t, ok)
+}